@@ -0,0 +1,319 @@
+package plugin
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+type AggregationReducer string
+
+const (
+	ReducerAvg   AggregationReducer = "avg"
+	ReducerMin   AggregationReducer = "min"
+	ReducerMax   AggregationReducer = "max"
+	ReducerSum   AggregationReducer = "sum"
+	ReducerCount AggregationReducer = "count"
+	ReducerLast  AggregationReducer = "last"
+)
+
+// AggregationModel describes an optional server-side group-by-interval aggregation
+// applied to the query result before it is converted to frames.
+type AggregationModel struct {
+	TimeAttribute string              `json:"timeAttribute"`
+	Interval      string              `json:"interval,omitempty"` // e.g. "5m" or "$__interval"; defaults to the query interval
+	GroupBy       []string            `json:"groupBy,omitempty"`
+	Metrics       []AggregationMetric `json:"metrics"`
+}
+
+type AggregationMetric struct {
+	Attribute string             `json:"attribute"`
+	Reducer   AggregationReducer `json:"reducer"`
+}
+
+func (m AggregationMetric) fieldName() string {
+	if m.Attribute == "" {
+		return string(m.Reducer)
+	}
+	return fmt.Sprintf("%s(%s)", m.Reducer, m.Attribute)
+}
+
+type bucketState struct {
+	sum      float64
+	count    int64
+	min      float64
+	max      float64
+	last     float64
+	lastTime time.Time
+}
+
+func (b *bucketState) add(t time.Time, v float64) {
+	if b.count == 0 || v < b.min {
+		b.min = v
+	}
+	if b.count == 0 || v > b.max {
+		b.max = v
+	}
+	if b.count == 0 || !t.Before(b.lastTime) {
+		b.last = v
+		b.lastTime = t
+	}
+	b.sum += v
+	b.count++
+}
+
+func (b *bucketState) reduce(r AggregationReducer) *float64 {
+	if r == ReducerCount {
+		return aws.Float64(float64(b.count))
+	}
+	if b.count == 0 {
+		return nil
+	}
+	switch r {
+	case ReducerAvg:
+		return aws.Float64(b.sum / float64(b.count))
+	case ReducerMin:
+		return aws.Float64(b.min)
+	case ReducerMax:
+		return aws.Float64(b.max)
+	case ReducerSum:
+		return aws.Float64(b.sum)
+	case ReducerLast:
+		return aws.Float64(b.last)
+	}
+	return nil
+}
+
+// resolveAggregationInterval returns the bucket width, resolving $__interval (or an empty
+// interval) to the query interval.
+func resolveAggregationInterval(interval string, queryInterval time.Duration) (time.Duration, error) {
+	interval = strings.TrimSpace(interval)
+	if interval == "" || interval == "$__interval" {
+		if queryInterval <= 0 {
+			return 0, fmt.Errorf("aggregation interval is required when the query has no interval")
+		}
+		return queryInterval, nil
+	}
+	d, err := gtime.ParseInterval(interval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid aggregation interval %q: %w", interval, err)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("aggregation interval must be positive, got %q", interval)
+	}
+	return d, nil
+}
+
+func (a AggregationModel) validate() error {
+	if a.TimeAttribute == "" {
+		return fmt.Errorf("aggregation.timeAttribute is required")
+	}
+	if len(a.Metrics) == 0 {
+		return fmt.Errorf("aggregation requires at least one metric")
+	}
+	for _, m := range a.Metrics {
+		switch m.Reducer {
+		case ReducerAvg, ReducerMin, ReducerMax, ReducerSum, ReducerLast:
+			if m.Attribute == "" {
+				return fmt.Errorf("reducer %q requires an attribute", m.Reducer)
+			}
+		case ReducerCount:
+		default:
+			return fmt.Errorf("unsupported reducer %q", m.Reducer)
+		}
+	}
+	return nil
+}
+
+// AggregateItems buckets items by the aggregation time attribute and reduces each metric per
+// bucket. It returns one wide time-series frame per group-by combination, with the group-by
+// values attached to the value fields as labels.
+func AggregateItems(refID string, items []map[string]*dynamodb.AttributeValue, agg AggregationModel, queryInterval time.Duration, datetimeAttributes map[string]string) ([]*data.Frame, error) {
+	if err := agg.validate(); err != nil {
+		return nil, err
+	}
+	interval, err := resolveAggregationInterval(agg.Interval, queryInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	type group struct {
+		labels  data.Labels
+		buckets map[int64][]*bucketState
+	}
+	groups := map[string]*group{}
+	timeFormat := datetimeAttributes[agg.TimeAttribute]
+
+	for _, item := range items {
+		t, ok := attributeValueToTime(item[agg.TimeAttribute], timeFormat)
+		if !ok {
+			continue
+		}
+		bucket := t.Truncate(interval).UnixNano()
+
+		labels := data.Labels{}
+		for _, name := range agg.GroupBy {
+			labels[name] = attributeValueToLabel(item[name])
+		}
+		key := labels.String()
+
+		g, ok := groups[key]
+		if !ok {
+			g = &group{labels: labels, buckets: map[int64][]*bucketState{}}
+			groups[key] = g
+		}
+		states, ok := g.buckets[bucket]
+		if !ok {
+			states = make([]*bucketState, len(agg.Metrics))
+			for i := range states {
+				states[i] = &bucketState{}
+			}
+			g.buckets[bucket] = states
+		}
+
+		for i, m := range agg.Metrics {
+			if m.Attribute == "" {
+				states[i].add(t, 0)
+				continue
+			}
+			v, ok := attributeValueToFloat(item[m.Attribute])
+			if !ok {
+				continue
+			}
+			states[i].add(t, v)
+		}
+	}
+
+	keys := make([]string, 0, len(groups))
+	for k := range groups {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	frames := make([]*data.Frame, 0, len(groups))
+	for _, k := range keys {
+		g := groups[k]
+		bucketKeys := make([]int64, 0, len(g.buckets))
+		for b := range g.buckets {
+			bucketKeys = append(bucketKeys, b)
+		}
+		sort.Slice(bucketKeys, func(i, j int) bool { return bucketKeys[i] < bucketKeys[j] })
+
+		times := make([]time.Time, len(bucketKeys))
+		values := make([][]*float64, len(agg.Metrics))
+		for i := range values {
+			values[i] = make([]*float64, len(bucketKeys))
+		}
+		for row, b := range bucketKeys {
+			times[row] = time.Unix(0, b).UTC()
+			for i, m := range agg.Metrics {
+				values[i][row] = g.buckets[b][i].reduce(m.Reducer)
+			}
+		}
+
+		frame := data.NewFrame(refID, data.NewField("time", nil, times))
+		for i, m := range agg.Metrics {
+			var labels data.Labels
+			if len(g.labels) > 0 {
+				labels = g.labels.Copy()
+			}
+			frame.Fields = append(frame.Fields, data.NewField(m.fieldName(), labels, values[i]))
+		}
+		frame.Meta = &data.FrameMeta{
+			Type:        data.FrameTypeTimeSeriesWide,
+			TypeVersion: data.FrameTypeVersion{0, 1},
+		}
+		frames = append(frames, frame)
+	}
+
+	return frames, nil
+}
+
+// attributeValueToTime interprets a DynamoDB attribute as a point in time using a
+// DatetimeAttribute format. Without a format, numbers are treated as epoch seconds (or
+// milliseconds when too large to be seconds) and strings as RFC 3339.
+func attributeValueToTime(av *dynamodb.AttributeValue, format string) (time.Time, bool) {
+	if av == nil {
+		return time.Time{}, false
+	}
+	if av.N != nil {
+		i, f, err := parseNumber(*av.N)
+		if err != nil {
+			return time.Time{}, false
+		}
+		var n int64
+		if i != nil {
+			n = *i
+		} else {
+			n = int64(math.Round(*f))
+		}
+		switch format {
+		case UnixTimestampSeconds:
+			return time.Unix(n, 0), true
+		case UnixTimestampMiniseconds:
+			return time.UnixMilli(n), true
+		case "":
+			if n > 1e11 || n < -1e11 {
+				return time.UnixMilli(n), true
+			}
+			return time.Unix(n, 0), true
+		}
+		return time.Time{}, false
+	}
+	if av.S != nil {
+		layout := format
+		if layout == "" || layout == UnixTimestampSeconds || layout == UnixTimestampMiniseconds {
+			layout = time.RFC3339Nano
+		}
+		t, err := time.Parse(layout, *av.S)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+func attributeValueToFloat(av *dynamodb.AttributeValue) (float64, bool) {
+	if av == nil {
+		return 0, false
+	}
+	switch {
+	case av.N != nil:
+		f, err := strconv.ParseFloat(*av.N, 64)
+		return f, err == nil
+	case av.S != nil:
+		f, err := strconv.ParseFloat(strings.TrimSpace(*av.S), 64)
+		return f, err == nil
+	case av.BOOL != nil:
+		if *av.BOOL {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// attributeValueToLabel renders a scalar attribute as a label value.
+func attributeValueToLabel(av *dynamodb.AttributeValue) string {
+	if av == nil {
+		return ""
+	}
+	switch {
+	case av.S != nil:
+		return *av.S
+	case av.N != nil:
+		return *av.N
+	case av.BOOL != nil:
+		return strconv.FormatBool(*av.BOOL)
+	}
+	return detectAttributeType(av)
+}
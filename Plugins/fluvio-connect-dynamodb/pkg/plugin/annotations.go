@@ -0,0 +1,186 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// QueryTypeAnnotations marks a query issued by a dashboard annotation.
+const QueryTypeAnnotations = "annotations"
+
+// AnnotationModel maps item attributes to annotation fields. Setting TimeEndAttribute turns
+// items with an end time after their start into region annotations.
+type AnnotationModel struct {
+	TimeAttribute    string   `json:"timeAttribute"`
+	TimeEndAttribute string   `json:"timeEndAttribute,omitempty"`
+	TitleAttribute   string   `json:"titleAttribute,omitempty"`
+	TextAttribute    string   `json:"textAttribute,omitempty"`
+	TagAttributes    []string `json:"tagAttributes,omitempty"` // sets and lists add one tag per element
+	Tags             []string `json:"tags,omitempty"`          // static tags added to every annotation
+}
+
+func (a AnnotationModel) validate() error {
+	if a.TimeAttribute == "" {
+		return fmt.Errorf("annotation.timeAttribute is required")
+	}
+	if a.TimeEndAttribute == a.TimeAttribute {
+		return fmt.Errorf("annotation.timeEndAttribute must differ from timeAttribute")
+	}
+	return nil
+}
+
+type annotationEvent struct {
+	time    time.Time
+	timeEnd *time.Time
+	title   string
+	text    string
+	tags    []string
+}
+
+// ItemsToAnnotations converts items into an annotation frame with time, timeEnd, title, text
+// and tags fields. Items without a usable start time are skipped and only annotations that
+// overlap the dashboard time range are returned, so the statement does not need its own time
+// filter. Annotations are ordered by start time.
+func ItemsToAnnotations(refID string, items []map[string]*dynamodb.AttributeValue, model AnnotationModel, timeRange backend.TimeRange, datetimeAttributes map[string]string) (*data.Frame, error) {
+	if err := model.validate(); err != nil {
+		return nil, err
+	}
+
+	from, to := timeRange.From, timeRange.To
+	if from.After(to) {
+		from, to = to, from
+	}
+	filterRange := !from.IsZero() && !to.IsZero()
+
+	events := make([]annotationEvent, 0, len(items))
+	for _, item := range items {
+		start, ok := attributeValueToTime(item[model.TimeAttribute], datetimeAttributes[model.TimeAttribute])
+		if !ok {
+			continue
+		}
+		event := annotationEvent{time: start.UTC()}
+
+		end := start
+		if model.TimeEndAttribute != "" {
+			if t, ok := attributeValueToTime(item[model.TimeEndAttribute], datetimeAttributes[model.TimeEndAttribute]); ok && t.After(start) {
+				t = t.UTC()
+				event.timeEnd = &t
+				end = t
+			}
+		}
+		if filterRange && (end.Before(from) || start.After(to)) {
+			continue
+		}
+
+		if model.TitleAttribute != "" {
+			event.title = attributeValueToText(item[model.TitleAttribute])
+		}
+		if model.TextAttribute != "" {
+			event.text = attributeValueToText(item[model.TextAttribute])
+		}
+		event.tags = annotationTags(item, model)
+		events = append(events, event)
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].time.Before(events[j].time) })
+
+	times := make([]time.Time, len(events))
+	timeEnds := make([]*time.Time, len(events))
+	titles := make([]string, len(events))
+	texts := make([]string, len(events))
+	tags := make([]json.RawMessage, len(events))
+	for i, e := range events {
+		times[i] = e.time
+		timeEnds[i] = e.timeEnd
+		titles[i] = e.title
+		texts[i] = e.text
+		raw, err := json.Marshal(e.tags)
+		if err != nil {
+			return nil, err
+		}
+		tags[i] = raw
+	}
+
+	frame := data.NewFrame(refID, data.NewField("time", nil, times))
+	if model.TimeEndAttribute != "" {
+		frame.Fields = append(frame.Fields, data.NewField("timeEnd", nil, timeEnds))
+	}
+	if model.TitleAttribute != "" {
+		frame.Fields = append(frame.Fields, data.NewField("title", nil, titles))
+	}
+	frame.Fields = append(frame.Fields,
+		data.NewField("text", nil, texts),
+		data.NewField("tags", nil, tags),
+	)
+	return frame, nil
+}
+
+// annotationTags collects the static tags and the values of the tag attributes, without
+// duplicates and in the order they were found.
+func annotationTags(item map[string]*dynamodb.AttributeValue, model AnnotationModel) []string {
+	tags := make([]string, 0, len(model.Tags)+len(model.TagAttributes))
+	seen := map[string]bool{}
+	add := func(tag string) {
+		if tag == "" || seen[tag] {
+			return
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+
+	for _, tag := range model.Tags {
+		add(tag)
+	}
+	for _, name := range model.TagAttributes {
+		av := item[name]
+		if av == nil {
+			continue
+		}
+		switch {
+		case av.SS != nil:
+			for _, s := range av.SS {
+				add(*s)
+			}
+		case av.NS != nil:
+			for _, n := range av.NS {
+				add(*n)
+			}
+		case av.L != nil:
+			for _, v := range av.L {
+				if v.S != nil || v.N != nil || v.BOOL != nil {
+					add(attributeValueToLabel(v))
+				}
+			}
+		case av.S != nil || av.N != nil || av.BOOL != nil:
+			add(attributeValueToLabel(av))
+		}
+	}
+	return tags
+}
+
+// attributeValueToText renders an attribute for annotation text: scalars as their value and
+// documents as JSON.
+func attributeValueToText(av *dynamodb.AttributeValue) string {
+	if av == nil || av.NULL != nil {
+		return ""
+	}
+	switch {
+	case av.S != nil, av.N != nil, av.BOOL != nil:
+		return attributeValueToLabel(av)
+	case av.M != nil:
+		if v, err := mapToJson(av); err == nil {
+			return string(*v)
+		}
+	case av.L != nil:
+		if v, err := listToJson(av); err == nil {
+			return string(*v)
+		}
+	}
+	return ""
+}
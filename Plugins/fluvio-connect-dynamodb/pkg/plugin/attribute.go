@@ -1,6 +1,7 @@
 package plugin
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -345,3 +346,185 @@ func (c *Attribute) Append(value *dynamodb.AttributeValue) error {
 
 	return nil
 }
+
+// AttributeMap is an item or key that is read and written as DynamoDB JSON, e.g.
+// {"PK": {"S": "STATION#1"}, "tags": {"SS": ["a"]}}, so sets, binary values and large numbers
+// keep their exact types.
+type AttributeMap map[string]*dynamodb.AttributeValue
+
+func (m AttributeMap) MarshalJSON() ([]byte, error) {
+	if m == nil {
+		return []byte("null"), nil
+	}
+	item := make(map[string]interface{}, len(m))
+	for name, value := range m {
+		if value == nil {
+			item[name] = nil
+			continue
+		}
+		encoded, err := encodeAttributeValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("attribute %q: %w", name, err)
+		}
+		item[name] = encoded
+	}
+	return json.Marshal(item)
+}
+
+// UnmarshalJSON reads an item in DynamoDB JSON. A null value is a missing attribute; plain
+// JSON such as {"PK": "x"} is rejected rather than guessed.
+func (m *AttributeMap) UnmarshalJSON(raw []byte) error {
+	if isJSONNull(raw) {
+		*m = nil
+		return nil
+	}
+	var attributes map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &attributes); err != nil {
+		return err
+	}
+	item := make(AttributeMap, len(attributes))
+	for name, value := range attributes {
+		if isJSONNull(value) {
+			item[name] = nil
+			continue
+		}
+		decoded, err := decodeAttributeValue(value)
+		if err != nil {
+			return fmt.Errorf("attribute %q: %w", name, err)
+		}
+		item[name] = decoded
+	}
+	*m = item
+	return nil
+}
+
+func isJSONNull(raw []byte) bool {
+	return bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+}
+
+// encodeAttributeValue returns the DynamoDB JSON form of a value, e.g. {"N": "1.5"}. Binary
+// values are base64 encoded by encoding/json.
+func encodeAttributeValue(value *dynamodb.AttributeValue) (map[string]interface{}, error) {
+	switch {
+	case value.S != nil:
+		return map[string]interface{}{"S": *value.S}, nil
+	case value.N != nil:
+		return map[string]interface{}{"N": *value.N}, nil
+	case value.B != nil:
+		return map[string]interface{}{"B": value.B}, nil
+	case value.BOOL != nil:
+		return map[string]interface{}{"BOOL": *value.BOOL}, nil
+	case value.NULL != nil:
+		return map[string]interface{}{"NULL": *value.NULL}, nil
+	case value.M != nil:
+		members := make(map[string]interface{}, len(value.M))
+		for name, member := range value.M {
+			if member == nil {
+				return nil, fmt.Errorf("map member %q has no value", name)
+			}
+			encoded, err := encodeAttributeValue(member)
+			if err != nil {
+				return nil, fmt.Errorf("map member %q: %w", name, err)
+			}
+			members[name] = encoded
+		}
+		return map[string]interface{}{"M": members}, nil
+	case value.L != nil:
+		elements := make([]interface{}, len(value.L))
+		for i, element := range value.L {
+			if element == nil {
+				return nil, fmt.Errorf("list element %d has no value", i)
+			}
+			encoded, err := encodeAttributeValue(element)
+			if err != nil {
+				return nil, fmt.Errorf("list element %d: %w", i, err)
+			}
+			elements[i] = encoded
+		}
+		return map[string]interface{}{"L": elements}, nil
+	case value.SS != nil:
+		return map[string]interface{}{"SS": aws.StringValueSlice(value.SS)}, nil
+	case value.NS != nil:
+		return map[string]interface{}{"NS": aws.StringValueSlice(value.NS)}, nil
+	case value.BS != nil:
+		return map[string]interface{}{"BS": value.BS}, nil
+	}
+	return nil, errors.New("attribute value has no type")
+}
+
+// decodeAttributeValue reads one value in DynamoDB JSON, which names exactly one type.
+func decodeAttributeValue(raw json.RawMessage) (*dynamodb.AttributeValue, error) {
+	var typed map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &typed); err != nil || len(typed) != 1 {
+		return nil, errors.New(`value is not in DynamoDB JSON, e.g. {"S": "value"}`)
+	}
+	value := &dynamodb.AttributeValue{}
+	for kind, payload := range typed {
+		var err error
+		switch kind {
+		case "S":
+			err = json.Unmarshal(payload, &value.S)
+		case "N":
+			err = json.Unmarshal(payload, &value.N)
+		case "B":
+			err = json.Unmarshal(payload, &value.B)
+		case "BOOL":
+			err = json.Unmarshal(payload, &value.BOOL)
+		case "NULL":
+			err = json.Unmarshal(payload, &value.NULL)
+		case "M":
+			var members map[string]json.RawMessage
+			if err = json.Unmarshal(payload, &members); err != nil {
+				break
+			}
+			value.M = make(map[string]*dynamodb.AttributeValue, len(members))
+			for name, member := range members {
+				if value.M[name], err = decodeAttributeValue(member); err != nil {
+					return nil, fmt.Errorf("map member %q: %w", name, err)
+				}
+			}
+		case "L":
+			var elements []json.RawMessage
+			if err = json.Unmarshal(payload, &elements); err != nil {
+				break
+			}
+			value.L = make([]*dynamodb.AttributeValue, len(elements))
+			for i, element := range elements {
+				if value.L[i], err = decodeAttributeValue(element); err != nil {
+					return nil, fmt.Errorf("list element %d: %w", i, err)
+				}
+			}
+		case "SS":
+			var members []string
+			err = json.Unmarshal(payload, &members)
+			value.SS = aws.StringSlice(members)
+		case "NS":
+			var members []string
+			err = json.Unmarshal(payload, &members)
+			value.NS = aws.StringSlice(members)
+		case "BS":
+			err = json.Unmarshal(payload, &value.BS)
+		default:
+			return nil, fmt.Errorf("unknown DynamoDB JSON type %q", kind)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s value: %w", kind, err)
+		}
+		if isJSONNull(payload) {
+			return nil, fmt.Errorf("%s value is null", kind)
+		}
+	}
+	return value, nil
+}
+
+// attributeValueJSON returns one attribute value in DynamoDB JSON, e.g. {"N": "1.5"}, or null.
+func attributeValueJSON(value *dynamodb.AttributeValue) (json.RawMessage, error) {
+	if value == nil {
+		return json.RawMessage("null"), nil
+	}
+	encoded, err := encodeAttributeValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(encoded)
+}
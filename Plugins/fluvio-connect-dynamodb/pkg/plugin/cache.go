@@ -0,0 +1,316 @@
+package plugin
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// CacheSettings enables the in-memory cache of query results. Results are kept for TTLSeconds,
+// or until evicted when the time range ended more than HistoricAfterHours ago, since such
+// ranges no longer change. Writes through uploads drop the entries of the written table.
+type CacheSettings struct {
+	Enabled            bool  `json:"enabled,omitempty"`
+	TTLSeconds         int64 `json:"ttlSeconds,omitempty"`         // defaults to 60
+	HistoricAfterHours int64 `json:"historicAfterHours,omitempty"` // 0 gives historic ranges the normal TTL
+	MaxEntries         int   `json:"maxEntries,omitempty"`         // defaults to 500
+	MaxSizeMB          int64 `json:"maxSizeMB,omitempty"`          // estimated item bytes; defaults to 64
+}
+
+const (
+	defaultCacheTTLSeconds = 60
+	defaultCacheMaxEntries = 500
+	defaultCacheMaxSizeMB  = 64
+)
+
+func (s CacheSettings) withDefaults() CacheSettings {
+	if s.TTLSeconds <= 0 {
+		s.TTLSeconds = defaultCacheTTLSeconds
+	}
+	if s.MaxEntries <= 0 {
+		s.MaxEntries = defaultCacheMaxEntries
+	}
+	if s.MaxSizeMB <= 0 {
+		s.MaxSizeMB = defaultCacheMaxSizeMB
+	}
+	return s
+}
+
+var (
+	queryCacheRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "grafana_plugin",
+		Subsystem: "dynamodb",
+		Name:      "query_cache_requests_total",
+		Help:      "Query result cache lookups by result (hit or miss).",
+	}, []string{"result"})
+	queryCacheEvictions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "grafana_plugin",
+		Subsystem: "dynamodb",
+		Name:      "query_cache_evictions_total",
+		Help:      "Query results removed from the cache by reason (expired, size or invalidated).",
+	}, []string{"reason"})
+	queryCacheEntries = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "grafana_plugin",
+		Subsystem: "dynamodb",
+		Name:      "query_cache_entries",
+		Help:      "Query results currently cached.",
+	})
+	queryCacheBytes = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "grafana_plugin",
+		Subsystem: "dynamodb",
+		Name:      "query_cache_bytes",
+		Help:      "Estimated size of the cached query results.",
+	})
+)
+
+type cacheEntry struct {
+	key     string
+	table   string
+	items   []map[string]*dynamodb.AttributeValue
+	size    int64
+	expires time.Time // zero for entries kept until evicted
+}
+
+// ResultCache is a least-recently-used cache of query results bounded by entry count and
+// estimated size. A nil cache stores nothing.
+type ResultCache struct {
+	mu       sync.Mutex
+	settings CacheSettings
+	now      func() time.Time
+	entries  map[string]*list.Element
+	lru      *list.List // front is the most recently used
+	size     int64
+}
+
+// NewResultCache returns the cache described by settings, or nil when it is disabled. now
+// defaults to time.Now.
+func NewResultCache(settings CacheSettings, now func() time.Time) *ResultCache {
+	if !settings.Enabled {
+		return nil
+	}
+	if now == nil {
+		now = time.Now
+	}
+	return &ResultCache{
+		settings: settings.withDefaults(),
+		now:      now,
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+// TTLFor returns how long the result of a query over a range ending at rangeEnd may be
+// cached; 0 keeps it until evicted. queryTTLSeconds overrides the default TTL and a negative
+// value bypasses the cache.
+func (c *ResultCache) TTLFor(rangeEnd time.Time, queryTTLSeconds int64) (time.Duration, bool) {
+	if c == nil || queryTTLSeconds < 0 {
+		return 0, false
+	}
+	if hours := c.settings.HistoricAfterHours; hours > 0 && !rangeEnd.IsZero() && rangeEnd.Before(c.now().Add(-time.Duration(hours)*time.Hour)) {
+		return 0, true
+	}
+	ttl := c.settings.TTLSeconds
+	if queryTTLSeconds > 0 {
+		ttl = queryTTLSeconds
+	}
+	return time.Duration(ttl) * time.Second, true
+}
+
+// Get returns the cached items of key. The slice is a copy, so callers may reorder it.
+func (c *ResultCache) Get(key string) ([]map[string]*dynamodb.AttributeValue, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if ok {
+		entry := element.Value.(*cacheEntry)
+		if entry.expires.IsZero() || c.now().Before(entry.expires) {
+			c.lru.MoveToFront(element)
+			queryCacheRequests.WithLabelValues("hit").Inc()
+			return append([]map[string]*dynamodb.AttributeValue(nil), entry.items...), true
+		}
+		c.removeLocked(element, "expired")
+	}
+	queryCacheRequests.WithLabelValues("miss").Inc()
+	return nil, false
+}
+
+// Put caches the items read from table under key for ttl, or until evicted when ttl is 0.
+// Results larger than a quarter of the cache are not stored.
+func (c *ResultCache) Put(key, table string, items []map[string]*dynamodb.AttributeValue, ttl time.Duration) {
+	if c == nil {
+		return
+	}
+	size := itemsSize(items)
+	maxBytes := c.settings.MaxSizeMB * 1024 * 1024
+	if size > maxBytes/4 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.removeLocked(element, "")
+	}
+	// The caller keeps sorting and trimming its own slice
+	items = append([]map[string]*dynamodb.AttributeValue(nil), items...)
+	entry := &cacheEntry{key: key, table: table, items: items, size: size}
+	if ttl > 0 {
+		entry.expires = c.now().Add(ttl)
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.size += size
+	queryCacheEntries.Inc()
+	queryCacheBytes.Add(float64(size))
+
+	for c.lru.Len() > c.settings.MaxEntries || c.size > maxBytes {
+		c.removeLocked(c.lru.Back(), "size")
+	}
+}
+
+// InvalidateTable drops the results read from table and returns how many were dropped.
+func (c *ResultCache) InvalidateTable(table string) int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	dropped := 0
+	for element := c.lru.Front(); element != nil; {
+		next := element.Next()
+		if element.Value.(*cacheEntry).table == table {
+			c.removeLocked(element, "invalidated")
+			dropped++
+		}
+		element = next
+	}
+	if dropped > 0 {
+		backend.Logger.Debug("Invalidated cached query results", "table", table, "entries", dropped)
+	}
+	return dropped
+}
+
+// Len returns the number of cached results.
+func (c *ResultCache) Len() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lru.Len()
+}
+
+// clear drops every entry, keeping the gauges of other datasource instances intact.
+func (c *ResultCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for c.lru.Len() > 0 {
+		c.removeLocked(c.lru.Back(), "")
+	}
+}
+
+// removeLocked drops an entry; reason labels the eviction metric and is empty for
+// replacements and clears.
+func (c *ResultCache) removeLocked(element *list.Element, reason string) {
+	entry := c.lru.Remove(element).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+	queryCacheEntries.Dec()
+	queryCacheBytes.Sub(float64(entry.size))
+	if reason != "" {
+		queryCacheEvictions.WithLabelValues(reason).Inc()
+	}
+}
+
+// resultCacheKey identifies a read by the datasource settings, the region and role, the
+// expanded statement or native request, the limits that shape the result and the time range.
+func resultCacheKey(pCtx backend.PluginContext, target AWSTarget, statement string, native *NativeRequest, userLimit int64, limits QueryLimits, timeRange backend.TimeRange) string {
+	key := struct {
+		Datasource string
+		Updated    time.Time
+		Target     *AWSTarget     `json:",omitempty"`
+		Statement  string         `json:",omitempty"`
+		Native     *NativeRequest `json:",omitempty"`
+		Limit      int64
+		Limits     QueryLimits
+		From, To   int64
+	}{
+		Statement: statement,
+		Native:    native,
+		Limit:     userLimit,
+		Limits:    limits,
+		From:      timeRange.From.UnixMilli(),
+		To:        timeRange.To.UnixMilli(),
+	}
+	if !target.isDefault() {
+		key.Target = &target
+	}
+	if settings := pCtx.DataSourceInstanceSettings; settings != nil {
+		key.Datasource = settings.UID
+		key.Updated = settings.Updated
+	}
+	raw, _ := json.Marshal(key)
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// itemsSize estimates the memory held by items from their attribute names and values.
+func itemsSize(items []map[string]*dynamodb.AttributeValue) int64 {
+	var size int64
+	for _, item := range items {
+		for name, value := range item {
+			size += int64(len(name)) + attributeSize(value)
+		}
+	}
+	return size
+}
+
+func attributeSize(av *dynamodb.AttributeValue) int64 {
+	if av == nil {
+		return 0
+	}
+	size := int64(1)
+	switch {
+	case av.S != nil:
+		size += int64(len(*av.S))
+	case av.N != nil:
+		size += int64(len(*av.N))
+	case av.B != nil:
+		size += int64(len(av.B))
+	case av.M != nil:
+		for k, v := range av.M {
+			size += int64(len(k)) + attributeSize(v)
+		}
+	case av.L != nil:
+		for _, v := range av.L {
+			size += attributeSize(v)
+		}
+	case av.SS != nil:
+		for _, s := range av.SS {
+			size += int64(len(*s))
+		}
+	case av.NS != nil:
+		for _, n := range av.NS {
+			size += int64(len(*n))
+		}
+	case av.BS != nil:
+		for _, b := range av.BS {
+			size += int64(len(b))
+		}
+	}
+	return size
+}
@@ -1,6 +1,8 @@
 package plugin
 
 import (
+	"sort"
+
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
@@ -9,12 +11,16 @@ import (
 func QueryResultToDataFrame(dataFrameName string, output *dynamodb.ExecuteStatementOutput, datetimeAttributes map[string]string) (*data.Frame, error) {
 	backend.Logger.Info("QueryResultToDataFrame called", "dataFrameName", dataFrameName, "itemCount", len(output.Items))
 
+	// Fields are ordered by the row an attribute first appears in, then by name, so the
+	// column order does not depend on map iteration and stays stable between refreshes.
 	attributes := make(map[string]*Attribute)
+	order := make([]string, 0)
 	for rowIndex, row := range output.Items {
 		if rowIndex == 0 {
 			backend.Logger.Debug("Processing first row", "attributeCount", len(row))
 		}
-		for name, value := range row {
+		for _, name := range sortedAttributeNames(row) {
+			value := row[name]
 			datetimeFormat := ""
 			if df, ok := datetimeAttributes[name]; ok {
 				datetimeFormat = df
@@ -34,6 +40,7 @@ func QueryResultToDataFrame(dataFrameName string, output *dynamodb.ExecuteStatem
 				}
 				if newAttribute != nil {
 					attributes[name] = newAttribute
+					order = append(order, name)
 				}
 			}
 		}
@@ -51,7 +58,8 @@ func QueryResultToDataFrame(dataFrameName string, output *dynamodb.ExecuteStatem
 
 	frame := data.NewFrame(dataFrameName)
 	fieldNames := make([]string, 0, len(attributes))
-	for _, c := range attributes {
+	for _, name := range order {
+		c := attributes[name]
 		frame.Fields = append(frame.Fields, c.Value)
 		fieldNames = append(fieldNames, c.Name)
 	}
@@ -74,3 +82,22 @@ func QueryResultToDataFrame(dataFrameName string, output *dynamodb.ExecuteStatem
 
 	return frame, nil
 }
+
+func sortedAttributeNames(row map[string]*dynamodb.AttributeValue) []string {
+	names := make([]string, 0, len(row))
+	for name := range row {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// appendFrameNotices attaches query notices (throttling, truncation, ...) to every frame.
+func appendFrameNotices(frames []*data.Frame, notices []data.Notice) {
+	if len(notices) == 0 {
+		return
+	}
+	for _, frame := range frames {
+		frame.AppendNotices(notices...)
+	}
+}
@@ -3,14 +3,17 @@ package plugin
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/grafana/grafana-aws-sdk/pkg/awsds"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -28,11 +31,13 @@ var (
 	_ backend.QueryDataHandler      = (*Datasource)(nil)
 	_ backend.CheckHealthHandler    = (*Datasource)(nil)
 	_ backend.CallResourceHandler   = (*Datasource)(nil)
+	_ backend.StreamHandler         = (*Datasource)(nil)
 	_ instancemgmt.InstanceDisposer = (*Datasource)(nil)
 )
 
 var (
 	fromClauseWithIndexQuoted    = regexp.MustCompile(`(?i)FROM\s+"([^"]+)"\s+INDEX\s+"([^"]+)"`)
+	fromClauseWithIndexDotted    = regexp.MustCompile(`(?i)FROM\s+"([^"]+)"\."([^"]+)"`)
 	fromClauseQuoted             = regexp.MustCompile(`(?i)FROM\s+"([^"]+)"`)
 	fromClauseWithIndexUnquoted  = regexp.MustCompile(`(?i)FROM\s+([^\s"]+)\s+INDEX\s+([^\s"]+)`)
 	fromClauseUnquoted           = regexp.MustCompile(`(?i)FROM\s+([^\s";]+)`)
@@ -55,11 +60,29 @@ func NewDatasource(ctx context.Context, settings backend.DataSourceInstanceSetti
 	authSettings := awsds.ReadAuthSettings(ctx)
 	sessionCache := awsds.NewSessionCache()
 
-	return &Datasource{
-		Settings:     dsSetting,
-		authSettings: *authSettings,
-		sessionCache: sessionCache,
-	}, nil
+	extraSettings, err := loadExtraPluginSettings(settings)
+	if err != nil {
+		backend.Logger.Warn("failed to load extra settings, using defaults", "error", err.Error())
+		extraSettings = &ExtraPluginSettings{}
+	}
+
+	ds := &Datasource{
+		Settings:      dsSetting,
+		authSettings:  *authSettings,
+		sessionCache:  sessionCache,
+		retrySettings: extraSettings.Retry.withDefaults(),
+		readLimiter:   NewCapacityLimiter(extraSettings.ReadCapacityUnitsPerSecond),
+		writeLimiter:  NewCapacityLimiter(extraSettings.WriteCapacityUnitsPerSecond),
+		scanGuard:     extraSettings.ScanGuard,
+		queryLimits:   extraSettings.QueryLimits.withDefaults(),
+		readBudget:    NewReadBudget(extraSettings.ReadBudget, nil),
+		parallelism:   extraSettings.Parallelism.withDefaults(),
+		resultCache:   NewResultCache(extraSettings.Cache, nil),
+		schemaCatalog: NewSchemaCatalog(extraSettings.SchemaCatalog, schemaCatalogPath(settings.UID), nil),
+		targets:       extraSettings.Targets,
+	}
+	ds.startSchemaRefresh(settings)
+	return ds, nil
 }
 
 // Datasource is an example datasource which can respond to data queries, reports
@@ -68,16 +91,65 @@ type Datasource struct {
 	Settings     awsds.AWSDatasourceSettings
 	sessionCache *awsds.SessionCache
 	authSettings awsds.AuthSettings
+
+	// Retries and rate limits shared by all reads and writes of this datasource
+	retrySettings RetrySettings
+	readLimiter   *CapacityLimiter
+	writeLimiter  *CapacityLimiter
+
+	// Full scan analysis of query statements
+	scanGuard ScanGuardSettings
+
+	// Admin pagination limits and the per-org read capacity budget of queries
+	queryLimits QueryLimits
+	readBudget  *ReadBudget
+
+	// Concurrent queries per request and segments of full-table scans
+	parallelism ParallelismSettings
+
+	// Cached query results; nil when caching is disabled
+	resultCache *ResultCache
+
+	// Sampled table schemas; nil when the catalog is disabled
+	schemaCatalog *SchemaCatalog
+
+	// Regions and roles queries may switch to
+	targets TargetSettings
 }
 
 // Dispose here tells plugin SDK that plugin wants to clean up resources when a new instance
 // created. As soon as datasource settings change detected by SDK old datasource instance will
 // be disposed and a new one will be created using NewSampleDatasource factory function.
 func (d *Datasource) Dispose() {
-	// Clean up datasource instance resources.
+	d.resultCache.clear()
+	d.schemaCatalog.stopRefresh()
+
+	// Stop the upload jobs started by this instance; they can be resumed on the new one.
+	if jobs, err := uploadJobs(); err == nil {
+		jobs.interrupt(d)
+	}
 }
 
 func (d *Datasource) getDynamoDBClient(ctx context.Context, settings *backend.DataSourceInstanceSettings) (*dynamodb.DynamoDB, error) {
+	sess, err := d.getSession(ctx, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	// The SDK's built-in retries are disabled so CallWithRetry controls attempts and backoff
+	// and can report throttle events.
+	return dynamodb.New(sess, aws.NewConfig().WithMaxRetries(0)), nil
+}
+
+// getSession returns the AWS session of the datasource, shared by the DynamoDB and
+// DynamoDB Streams clients.
+func (d *Datasource) getSession(ctx context.Context, settings *backend.DataSourceInstanceSettings) (*session.Session, error) {
+	return d.newSession(ctx, settings, d.Settings)
+}
+
+// newSession returns the cached session of the given AWS settings, which differ from the
+// datasource settings for queries with a region or role override.
+func (d *Datasource) newSession(ctx context.Context, settings *backend.DataSourceInstanceSettings, awsSettings awsds.AWSDatasourceSettings) (*session.Session, error) {
 	httpClientProvider := httpclient.NewProvider()
 	httpClientOptions, err := settings.HTTPClientOptions(ctx)
 	if err != nil {
@@ -91,17 +163,11 @@ func (d *Datasource) getDynamoDBClient(ctx context.Context, settings *backend.Da
 		return nil, err
 	}
 
-	session, err := d.sessionCache.GetSessionWithAuthSettings(awsds.GetSessionConfig{
-		Settings:      d.Settings,
+	return d.sessionCache.GetSessionWithAuthSettings(awsds.GetSessionConfig{
+		Settings:      awsSettings,
 		HTTPClient:    httpClient,
 		UserAgentName: aws.String("DynamoDB"),
 	}, d.authSettings)
-
-	if err != nil {
-		return nil, err
-	}
-
-	return dynamodb.New(session), nil
 }
 
 // QueryData handles multiple queries and returns multiple responses.
@@ -115,16 +181,42 @@ func (d *Datasource) QueryData(ctx context.Context, req *backend.QueryDataReques
 		return nil, err
 	}
 
+	// Queries run concurrently, at most MaxConcurrentQueries at a time
+	var (
+		mu  sync.Mutex
+		wg  sync.WaitGroup
+		sem = make(chan struct{}, d.parallelism.withDefaults().MaxConcurrentQueries)
+	)
 	for _, q := range req.Queries {
-		res := d.query(ctx, dynamoDBClient, q)
-		response.Responses[q.RefID] = res
+		wg.Add(1)
+		go func(q backend.DataQuery) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			res := d.runQuery(ctx, req.PluginContext, dynamoDBClient, q)
+			mu.Lock()
+			response.Responses[q.RefID] = res
+			mu.Unlock()
+		}(q)
 	}
+	wg.Wait()
 
 	return response, nil
 }
 
-func (d *Datasource) query(ctx context.Context, dynamoDBClient *dynamodb.DynamoDB, query backend.DataQuery) backend.DataResponse {
-	var response backend.DataResponse
+// runQuery runs one query of a request. A panic fails only that query instead of the plugin.
+func (d *Datasource) runQuery(ctx context.Context, pCtx backend.PluginContext, dynamoDBClient *dynamodb.DynamoDB, query backend.DataQuery) (res backend.DataResponse) {
+	defer func() {
+		if r := recover(); r != nil {
+			backend.Logger.Error("Query panicked", "refId", query.RefID, "panic", fmt.Sprint(r))
+			res = backend.ErrDataResponse(backend.StatusInternal, "query failed unexpectedly")
+		}
+	}()
+	return d.query(ctx, pCtx, dynamoDBClient, query)
+}
+
+func (d *Datasource) query(ctx context.Context, pCtx backend.PluginContext, dynamoDBClient *dynamodb.DynamoDB, query backend.DataQuery) (response backend.DataResponse) {
 
 	var qm QueryModel
 
@@ -135,117 +227,212 @@ func (d *Datasource) query(ctx context.Context, dynamoDBClient *dynamodb.DynamoD
 
 	backend.Logger.Debug("Query model", qm)
 
-	// Validate query text is not empty
-	if qm.QueryText == "" {
-		return backend.ErrDataResponse(backend.StatusBadRequest, "query text cannot be empty")
+	// Queries may read another region or account; their results carry the region as a label
+	target := qm.target()
+	if !target.isDefault() {
+		dynamoDBClient, err = d.getTargetClient(ctx, pCtx.DataSourceInstanceSettings, target)
+		if errors.Is(err, errTargetNotAllowed) {
+			return backend.ErrDataResponse(backend.StatusForbidden, err.Error())
+		}
+		if err != nil {
+			return backend.ErrDataResponse(backend.StatusInternal, fmt.Sprintf("failed to get DynamoDB client: %v", err.Error()))
+		}
+		defer func() {
+			LabelFrames(response.Frames, RegionLabel, d.targetRegion(target))
+		}()
 	}
 
-	// Modify query to add ORDER BY if ScanIndexForward is set and no ORDER BY exists
-	finalQuery := qm.QueryText
-	nativeSortApplied := false
-	nativeSortField := ""
-	if qm.ScanIndexForward != nil {
-		finalQuery, nativeSortApplied, nativeSortField = d.prepareNativeOrderBy(ctx, dynamoDBClient, qm)
+	if query.QueryType == QueryTypeVariables && qm.Variable == nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, "variable queries require a variable definition")
 	}
-
-	input := &dynamodb.ExecuteStatementInput{
-		Statement: aws.String(finalQuery),
+	if qm.Variable != nil {
+		uid := ""
+		if pCtx.DataSourceInstanceSettings != nil {
+			uid = pCtx.DataSourceInstanceSettings.UID
+		}
+		return d.variableQuery(ctx, dynamoDBClient, target, pCtx.OrgID, uid, query.RefID, *qm.Variable)
 	}
 
-	if qm.Limit > 0 {
-		input.Limit = aws.Int64(qm.Limit)
+	// Validate query text is not empty
+	if qm.QueryText == "" && qm.Native == nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, "query text cannot be empty")
 	}
 
-	backend.Logger.Info("Executing PartiQL query", "statement", finalQuery, "limit", qm.Limit, "scanIndexForward", qm.ScanIndexForward, "nativeSortApplied", nativeSortApplied)
+	if query.QueryType == QueryTypeAnnotations && qm.Annotation == nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, "annotation queries require an annotation attribute mapping")
+	}
 
-	// Safety limits to prevent infinite loops and memory exhaustion
-	const maxPages = 1000                // Maximum number of pages to fetch (secondary guard)
-	const maxItems = 1000000             // Maximum total items to accumulate (1 million)
-	const maxQueryDuration = time.Minute // Time-based guard for long-running pagination
+	if qm.Stream && (qm.Aggregation != nil || qm.TimeSeries != nil || qm.Annotation != nil) {
+		return backend.ErrDataResponse(backend.StatusBadRequest, "stream is only supported for table results")
+	}
 
-	// Collect all items by handling pagination with NextToken
-	var allItems []map[string]*dynamodb.AttributeValue
-	var pageCount int
-	startTime := time.Now()
+	if err := qm.resolveDatetimeLayouts(); err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	datetimeAttributes := make(map[string]string)
+	for _, k := range qm.DatetimeAttributes {
+		datetimeAttributes[k.Name] = k.Format
+	}
 
-	for {
-		// Check if context is cancelled (timeout or user cancelled)
-		select {
-		case <-ctx.Done():
-			backend.Logger.Warn("Query cancelled or timed out", "pageCount", pageCount, "itemsFetched", len(allItems))
-			// Return partial results if we have any
-			if len(allItems) > 0 {
-				backend.Logger.Info("Returning partial results due to cancellation", "totalItems", len(allItems))
-				break
-			}
-			return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("query cancelled: %v", ctx.Err()))
-		default:
-			// Continue with query execution
+	// Expand time-range and interval macros server-side so alerting and recording rules
+	// see the same statement as the panel
+	if qm.Native != nil {
+		err = qm.Native.interpolate(query.TimeRange, query.Interval)
+	} else {
+		qm.QueryText, err = InterpolateMacros(qm.QueryText, query.TimeRange, query.Interval, datetimeAttributes)
+	}
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("macro expansion: %v", err.Error()))
+	}
+
+	var (
+		analysis          *StatementAnalysis
+		notices           []data.Notice
+		finalQuery        string
+		input             *dynamodb.ExecuteStatementInput
+		nativeRequest     NativeRequest
+		nativeSortApplied bool
+		nativeSortField   string
+	)
+	if qm.Native != nil {
+		// Native reads are built from their structure; a Query sorts by the sort key itself
+		nativeRequest, err = BuildNativeRequest(*qm.Native, qm.ScanIndexForward, qm.Limit)
+		if err != nil {
+			return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+		}
+		analysis, notices, err = d.analyzeNativeRequest(ctx, dynamoDBClient, target, *qm.Native, nativeRequest, qm.AllowFullScan)
+		if err != nil {
+			return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+		}
+		nativeSortApplied = nativeRequest.Query != nil && qm.ScanIndexForward != nil
+		if nativeRequest.Scan != nil {
+			nativeSortField = qm.SortKey
+		}
+		backend.Logger.Info("Executing native request", "request", nativeRequest.describe(), "limit", qm.Limit)
+	} else {
+		// Detect full scans before running the statement; this may switch it to a matching index
+		analysis, notices, err = d.analyzeQuery(ctx, dynamoDBClient, target, &qm)
+		if err != nil {
+			return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
 		}
 
-		pageCount++
-
-		// Time-based guard to prevent long-running loops
-		if time.Since(startTime) > maxQueryDuration {
-			backend.Logger.Warn("Maximum query duration reached", "maxQueryDuration", maxQueryDuration, "pagesFetched", pageCount, "itemsFetched", len(allItems))
-			if len(allItems) > 0 {
-				backend.Logger.Info("Returning partial results due to duration limit", "totalItems", len(allItems))
-				break
-			}
-			return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("query exceeded maximum duration of %s", maxQueryDuration))
+		// Modify query to add ORDER BY if ScanIndexForward is set and no ORDER BY exists
+		finalQuery = qm.QueryText
+		if qm.ScanIndexForward != nil {
+			finalQuery, nativeSortApplied, nativeSortField = d.prepareNativeOrderBy(ctx, dynamoDBClient, qm)
 		}
 
-		// Safety check: prevent infinite loops
-		if pageCount > maxPages {
-			backend.Logger.Error("Maximum page limit reached", "maxPages", maxPages, "totalItems", len(allItems))
-			// Return partial results with warning
-			if len(allItems) > 0 {
-				backend.Logger.Warn("Returning partial results due to page limit", "totalItems", len(allItems))
-				break
-			}
-			return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("query exceeded maximum page limit of %d", maxPages))
+		input = &dynamodb.ExecuteStatementInput{
+			Statement:              aws.String(finalQuery),
+			ReturnConsumedCapacity: aws.String(dynamodb.ReturnConsumedCapacityTotal),
 		}
 
-		backend.Logger.Debug("Fetching page", "page", pageCount)
+		if qm.Limit > 0 {
+			input.Limit = aws.Int64(qm.Limit)
+		}
 
-		output, err := dynamoDBClient.ExecuteStatementWithContext(ctx, input)
-		if err != nil {
-			backend.Logger.Error("Query execution error", "error", err.Error(), "page", pageCount)
-			// Return partial results if we have any data from previous pages
-			if len(allItems) > 0 {
-				backend.Logger.Warn("Returning partial results due to error", "totalItems", len(allItems))
-				break
-			}
-			return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("executes statement: %v", err.Error()))
+		backend.Logger.Info("Executing PartiQL query", "statement", finalQuery, "limit", qm.Limit, "scanIndexForward", qm.ScanIndexForward, "nativeSortApplied", nativeSortApplied)
+	}
+
+	// Admin limits, optionally tightened by the query, prevent runaway pagination and
+	// memory exhaustion
+	limits := EffectiveQueryLimits(d.queryLimits, qm.Limits)
+	orgID := pCtx.OrgID
+
+	// Repeated reads are served from the result cache; uploads to the table invalidate it.
+	// The key is taken before reading, since native reads advance their request.
+	var (
+		cacheKey    string
+		cacheTable  string
+		cachedItems []map[string]*dynamodb.AttributeValue
+		fromCache   bool
+	)
+	cacheTTL, cacheable := d.resultCache.TTLFor(query.TimeRange.To, qm.CacheTTLSeconds)
+	if cacheable {
+		var native *NativeRequest
+		if qm.Native != nil {
+			native = &nativeRequest
+			cacheTable = qm.Native.Table
+		} else {
+			cacheTable, _ = extractTableAndIndex(finalQuery)
 		}
+		cacheKey = resultCacheKey(pCtx, target, finalQuery, native, qm.Limit, limits, query.TimeRange)
+		cachedItems, fromCache = d.resultCache.Get(cacheKey)
+	}
+
+	if !fromCache && d.readBudget.Exhausted(orgID) {
+		return backend.ErrDataResponse(backend.StatusTooManyRequests, fmt.Sprintf("read budget exhausted: %s", d.readBudget.describe(orgID)))
+	}
+
+	// Collect all items by handling pagination. When a guard stops reading early, truncatedBy
+	// explains why and the partial result carries a warning.
+	stats := &RetryStats{}
+	var read pageRead
+	if fromCache {
+		backend.Logger.Debug("Serving query from the result cache", "table", cacheTable, "items", len(cachedItems))
+		read.items = cachedItems
+	} else if segments, ok := d.nativeParallelScan(nativeRequest, qm.Limit); ok {
+		backend.Logger.Info("Running native scan as a parallel scan", "table", qm.Native.Table, "index", qm.Native.Index, "segments", segments)
+		read, err = d.parallelScan(ctx, dynamoDBClient, nativeRequest.Scan, segments, limits, orgID, stats)
+	} else if qm.Native != nil {
+		read, err = d.readNative(ctx, dynamoDBClient, nativeRequest, qm.Limit, limits, orgID, stats)
+	} else if plan, ok := d.parallelScanPlan(finalQuery, qm); ok {
+		backend.Logger.Info("Running full-table read as a parallel scan", "table", plan.Table, "index", plan.Index, "segments", plan.Segments)
+		read, err = d.parallelScan(ctx, dynamoDBClient, plan.ScanInput(), plan.Segments, limits, orgID, stats)
+	} else {
+		read, err = d.readStatementPages(ctx, dynamoDBClient, input, qm.Limit, limits, orgID, stats)
+	}
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+	}
+	// Only complete results are cached
+	if cacheable && !fromCache && read.truncatedBy == "" && read.lastEvaluatedKey == nil {
+		d.resultCache.Put(cacheKey, cacheTable, read.items, cacheTTL)
+	}
+	allItems, pageCount, consumedReadUnits, truncatedBy := read.items, read.pages, read.consumedUnits, read.truncatedBy
 
-		// Append items from this page to the accumulated results
-		allItems = append(allItems, output.Items...)
+	// finishFrames adds the notices and, for native reads, the executed request and resume key
+	finishFrames := func(frames []*data.Frame, notices []data.Notice) {
+		appendFrameNotices(frames, notices)
+		if qm.Native != nil {
+			appendNativeMeta(frames, nativeRequest, read.lastEvaluatedKey)
+		}
+	}
 
-		backend.Logger.Debug("Page results", "page", pageCount, "itemsInPage", len(output.Items), "totalItems", len(allItems))
+	if truncatedBy != "" {
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("Results are incomplete: %s after %d item(s) in %d page(s)", truncatedBy, len(allItems), pageCount),
+		})
+	}
 
-		// Safety check: prevent memory exhaustion
-		if len(allItems) >= maxItems {
-			backend.Logger.Warn("Maximum item limit reached", "maxItems", maxItems, "totalItems", len(allItems))
-			backend.Logger.Info("Returning results (item limit reached)", "totalItems", len(allItems))
-			break
+	if d.readBudget != nil {
+		severity := data.NoticeSeverityInfo
+		if d.readBudget.Remaining(orgID) <= 0 {
+			severity = data.NoticeSeverityWarning
 		}
+		notices = append(notices, data.Notice{Severity: severity, Text: "Read budget: " + d.readBudget.describe(orgID)})
+	}
 
-		// Check if we've reached the user's requested limit
-		if qm.Limit > 0 && int64(len(allItems)) >= qm.Limit {
-			backend.Logger.Info("Reached user's requested limit", "limit", qm.Limit, "totalItems", len(allItems))
-			break
-		}
+	if throttleEvents, retries := stats.Snapshot(); throttleEvents > 0 || retries > 0 {
+		backend.Logger.Warn("Query was throttled or retried", "throttleEvents", throttleEvents, "retries", retries)
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("DynamoDB throttled %d request(s); %d retry attempt(s) with backoff were needed", throttleEvents, retries),
+		})
+	}
 
-		// Check if there are more results to fetch
-		if output.NextToken == nil || *output.NextToken == "" {
-			backend.Logger.Info("Query complete", "totalPages", pageCount, "totalItems", len(allItems))
-			break
+	if fromCache {
+		notices = append(notices, data.Notice{Severity: data.NoticeSeverityInfo, Text: "Result served from the query cache"})
+	} else if analysis != nil {
+		access := "Query"
+		if analysis.Access == AccessScan {
+			access = "Scan"
 		}
-
-		// Set the NextToken for the next iteration
-		input.NextToken = output.NextToken
-		backend.Logger.Debug("More results available, fetching next page", "nextToken", *output.NextToken)
+		notices = append(notices, data.Notice{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("%s consumed %.1f read capacity units in %d page(s)", access, consumedReadUnits, pageCount),
+		})
 	}
 
 	// Handle empty results
@@ -253,6 +440,7 @@ func (d *Datasource) query(ctx context.Context, dynamoDBClient *dynamodb.DynamoD
 		backend.Logger.Debug("Query returned no results")
 		// Return empty frame instead of error
 		frame := data.NewFrame(query.RefID)
+		finishFrames([]*data.Frame{frame}, notices)
 		response.Frames = append(response.Frames, frame)
 		return response
 	}
@@ -304,26 +492,211 @@ func (d *Datasource) query(ctx context.Context, dynamoDBClient *dynamodb.DynamoD
 		allItems = allItems[:qm.Limit]
 	}
 
+	allItems, err = ReshapeItems(allItems, qm.Flatten, qm.Projections)
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("projections: %v", err.Error()))
+	}
+
+	if qm.Annotation != nil {
+		frame, err := ItemsToAnnotations(query.RefID, allItems, *qm.Annotation, query.TimeRange, datetimeAttributes)
+		if err != nil {
+			return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("annotation: %v", err.Error()))
+		}
+		backend.Logger.Info("Converted query results to annotations", "items", len(allItems), "annotations", frame.Rows())
+		finishFrames([]*data.Frame{frame}, notices)
+		response.Frames = append(response.Frames, frame)
+		return response
+	}
+
+	if qm.Aggregation != nil && qm.TimeSeries != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, "aggregation and timeSeries cannot be combined; aggregation already returns labelled series")
+	}
+
+	if qm.TimeSeries != nil {
+		frames, err := ItemsToTimeSeries(query.RefID, allItems, *qm.TimeSeries, datetimeAttributes)
+		if err != nil {
+			return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("timeSeries: %v", err.Error()))
+		}
+		backend.Logger.Info("Converted query results to time series", "items", len(allItems), "frames", len(frames))
+		finishFrames(frames, notices)
+		response.Frames = append(response.Frames, frames...)
+		return response
+	}
+
+	if qm.Aggregation != nil {
+		frames, err := AggregateItems(query.RefID, allItems, *qm.Aggregation, query.Interval, datetimeAttributes)
+		if err != nil {
+			return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("aggregation: %v", err.Error()))
+		}
+		backend.Logger.Info("Aggregated query results", "items", len(allItems), "groups", len(frames))
+		finishFrames(frames, notices)
+		response.Frames = append(response.Frames, frames...)
+		return response
+	}
+
 	// Create a combined output with all accumulated items
 	combinedOutput := &dynamodb.ExecuteStatementOutput{
 		Items: allItems,
 	}
 
-	datetimeAttributes := make(map[string]string)
-	for _, k := range qm.DatetimeAttributes {
-		datetimeAttributes[k.Name] = k.Format
-	}
-
 	frame, err := QueryResultToDataFrame(query.RefID, combinedOutput, datetimeAttributes)
 	if err != nil {
 		response.Error = err
 		return response
 	}
 
+	if qm.Stream {
+		channel, err := streamChannel(pCtx, qm)
+		if err != nil {
+			return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("stream: %v", err.Error()))
+		}
+		frame.SetMeta(&data.FrameMeta{Channel: channel})
+	}
+
+	finishFrames([]*data.Frame{frame}, notices)
 	response.Frames = append(response.Frames, frame)
 	return response
 }
 
+// pageRead is the outcome of reading all pages of a statement or scan. truncatedBy explains
+// why reading stopped before the last page; the caller reports it as a warning.
+type pageRead struct {
+	items         []map[string]*dynamodb.AttributeValue
+	pages         int
+	consumedUnits float64
+	truncatedBy   string
+	// lastEvaluatedKey is where a native read that stopped early can resume
+	lastEvaluatedKey map[string]*dynamodb.AttributeValue
+}
+
+// pageOutput is one page returned by a statement, Query or Scan.
+type pageOutput struct {
+	items    []map[string]*dynamodb.AttributeValue
+	consumed *dynamodb.ConsumedCapacity
+	more     bool
+}
+
+// readStatementPages follows the NextToken pointers of a statement until all pages are read,
+// the user's limit is reached or a guard stops it. It fails only when no item was read.
+func (d *Datasource) readStatementPages(ctx context.Context, client *dynamodb.DynamoDB, input *dynamodb.ExecuteStatementInput, userLimit int64, limits QueryLimits, orgID int64, stats *RetryStats) (pageRead, error) {
+	return d.readPages(ctx, "executes statement", userLimit, limits, orgID, stats, func(int64) (pageOutput, error) {
+		output, err := client.ExecuteStatementWithContext(ctx, input)
+		if err != nil {
+			return pageOutput{}, err
+		}
+		// Only a successful page moves the statement on, so retries repeat the same page
+		input.NextToken = output.NextToken
+		return pageOutput{items: output.Items, consumed: output.ConsumedCapacity, more: aws.StringValue(output.NextToken) != ""}, nil
+	})
+}
+
+// readPages calls fetch for one page after another until all pages are read, the user's
+// limit is reached or a guard stops it. fetch receives the number of items still wanted and
+// operation names it in errors. It fails only when no item was read.
+func (d *Datasource) readPages(ctx context.Context, operation string, userLimit int64, limits QueryLimits, orgID int64, stats *RetryStats, fetch func(remaining int64) (pageOutput, error)) (pageRead, error) {
+	var read pageRead
+	startTime := time.Now()
+
+pagination:
+	for {
+		// Check if context is cancelled (timeout or user cancelled)
+		if ctx.Err() != nil {
+			backend.Logger.Warn("Query cancelled or timed out", "pageCount", read.pages, "itemsFetched", len(read.items))
+			if len(read.items) == 0 {
+				return read, fmt.Errorf("query cancelled: %v", ctx.Err())
+			}
+			read.truncatedBy = fmt.Sprintf("the query was cancelled: %v", ctx.Err())
+			break
+		}
+
+		// Time-based guard to prevent long-running loops
+		if time.Since(startTime) > limits.maxDuration() {
+			backend.Logger.Warn("Maximum query duration reached", "maxQueryDuration", limits.maxDuration(), "pagesFetched", read.pages, "itemsFetched", len(read.items))
+			if len(read.items) == 0 {
+				return read, fmt.Errorf("query exceeded maximum duration of %s", limits.maxDuration())
+			}
+			read.truncatedBy = fmt.Sprintf("the query reached its time limit of %s", limits.maxDuration())
+			break
+		}
+
+		// Safety check: prevent infinite loops
+		if read.pages >= limits.MaxPages {
+			backend.Logger.Warn("Maximum page limit reached", "maxPages", limits.MaxPages, "totalItems", len(read.items))
+			read.truncatedBy = fmt.Sprintf("the query reached its limit of %d page(s)", limits.MaxPages)
+			break
+		}
+
+		if read.pages > 0 && d.readBudget.Exhausted(orgID) {
+			backend.Logger.Warn("Read budget exhausted", "orgID", orgID, "pagesFetched", read.pages)
+			read.truncatedBy = "the org's read capacity budget is used up"
+			break
+		}
+
+		read.pages++
+		backend.Logger.Debug("Fetching page", "page", read.pages)
+
+		// Each page is reserved as one read unit and settled with the consumed capacity
+		if err := d.readLimiter.Wait(ctx, 1); err != nil {
+			if len(read.items) == 0 {
+				return read, fmt.Errorf("query cancelled: %v", err)
+			}
+			read.truncatedBy = fmt.Sprintf("the query was cancelled: %v", err)
+			break
+		}
+		remaining := int64(limits.MaxItems - len(read.items))
+		if userLimit > 0 && userLimit-int64(len(read.items)) < remaining {
+			remaining = userLimit - int64(len(read.items))
+		}
+		output, err := CallWithRetry(ctx, d.retrySettings, stats, func() (pageOutput, error) {
+			return fetch(remaining)
+		})
+		units := capacityUnits(1, output.consumed)
+		d.readLimiter.Settle(1, units)
+		d.readBudget.Record(orgID, units)
+		read.consumedUnits += units
+		if err != nil {
+			backend.Logger.Error("Query execution error", "error", err.Error(), "page", read.pages)
+			// Return partial results if we have any data from previous pages
+			if len(read.items) == 0 {
+				return read, fmt.Errorf("%s: %v", operation, err.Error())
+			}
+			read.truncatedBy = fmt.Sprintf("page %d failed: %v", read.pages, err)
+			break
+		}
+
+		// Append items from this page to the accumulated results
+		read.items = append(read.items, output.items...)
+
+		backend.Logger.Debug("Page results", "page", read.pages, "itemsInPage", len(output.items), "totalItems", len(read.items))
+
+		moreResults := output.more
+		switch {
+		case userLimit > 0 && int64(len(read.items)) >= userLimit:
+			// The user's own limit is not a truncation
+			backend.Logger.Info("Reached user's requested limit", "limit", userLimit, "totalItems", len(read.items))
+			break pagination
+		case len(read.items) >= limits.MaxItems:
+			// Safety check: prevent memory exhaustion
+			backend.Logger.Warn("Maximum item limit reached", "maxItems", limits.MaxItems, "totalItems", len(read.items))
+			if len(read.items) > limits.MaxItems {
+				read.items = read.items[:limits.MaxItems]
+				moreResults = true
+			}
+			if moreResults {
+				read.truncatedBy = fmt.Sprintf("the query reached its limit of %d item(s)", limits.MaxItems)
+			}
+			break pagination
+		case !moreResults:
+			backend.Logger.Info("Query complete", "totalPages", read.pages, "totalItems", len(read.items))
+			break pagination
+		}
+
+		backend.Logger.Debug("More results available, fetching next page", "page", read.pages)
+	}
+
+	return read, nil
+}
+
 // prepareNativeOrderBy attempts to inject a DynamoDB-native ORDER BY clause when possible.
 // It returns the potentially modified query string, whether native sorting was applied,
 // and the field that should be used for client-side fallback sorting if needed.
@@ -342,7 +715,7 @@ func (d *Datasource) prepareNativeOrderBy(ctx context.Context, dynamoDBClient *d
 		return query, false, strings.TrimSpace(qm.SortKey)
 	}
 
-	schema, err := getKeySchema(ctx, dynamoDBClient, tableName, indexName)
+	schema, err := d.getKeySchema(ctx, dynamoDBClient, tableName, indexName)
 	if err != nil {
 		backend.Logger.Warn("Failed to describe table for native sort", "table", tableName, "index", indexName, "error", err.Error())
 		return query, false, strings.TrimSpace(qm.SortKey)
@@ -404,8 +777,10 @@ func (d *Datasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRe
 		return res, err
 	}
 
-	_, err = client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
-		TableName: aws.String(extraSettings.ConnectionTestTable),
+	_, err = CallWithRetry(ctx, d.retrySettings, nil, func() (*dynamodb.DescribeTableOutput, error) {
+		return client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+			TableName: aws.String(extraSettings.ConnectionTestTable),
+		})
 	})
 
 	if err != nil {
@@ -425,13 +800,12 @@ func (d *Datasource) CallResource(ctx context.Context, req *backend.CallResource
 	backend.Logger.Debug("CallResource", "path", req.Path, "method", req.Method)
 
 	// Handle preset management routes
-	if strings.HasPrefix(req.Path, "presets/") {
-		presetID := strings.TrimPrefix(req.Path, "presets/")
-		if req.Method == "GET" {
-			return d.handleGetPreset(ctx, req, sender, presetID)
-		} else if req.Method == "DELETE" {
-			return d.handleDeletePreset(ctx, req, sender, presetID)
-		}
+	if req.Path == "presets" || strings.HasPrefix(req.Path, "presets/") {
+		return d.handlePresetResource(ctx, req, sender)
+	}
+
+	if strings.HasPrefix(req.Path, "upload/") {
+		return d.handleUploadResource(ctx, req, sender)
 	}
 
 	switch req.Path {
@@ -439,25 +813,20 @@ func (d *Datasource) CallResource(ctx context.Context, req *backend.CallResource
 		return d.handleListTables(ctx, req, sender)
 	case "table-attributes":
 		return d.handleTableAttributes(ctx, req, sender)
-	case "presets":
-		if req.Method == "GET" {
-			return d.handleListPresets(ctx, req, sender)
-		} else if req.Method == "POST" || req.Method == "PUT" {
-			return d.handleSavePreset(ctx, req, sender)
-		}
-	case "upload/presets", "upload/preview", "upload/execute", "upload/schema":
-		return d.handleUploadResource(ctx, req, sender)
+	case "variables":
+		return d.handleVariables(ctx, req, sender)
+	case "query-analysis":
+		return d.handleQueryAnalysis(ctx, req, sender)
+	case "schema":
+		return d.handleSchema(ctx, req, sender)
+	case "items":
+		return d.handleItems(ctx, req, sender)
 	default:
 		return sender.Send(&backend.CallResourceResponse{
 			Status: http.StatusNotFound,
 			Body:   []byte(`{"error": "endpoint not found"}`),
 		})
 	}
-
-	return sender.Send(&backend.CallResourceResponse{
-		Status: http.StatusMethodNotAllowed,
-		Body:   []byte(`{"error": "method not allowed"}`),
-	})
 }
 
 // handleListTables returns a list of DynamoDB tables
@@ -471,7 +840,40 @@ func (d *Datasource) handleListTables(ctx context.Context, req *backend.CallReso
 		})
 	}
 
-	// List all tables (with pagination support)
+	tableNames, err := d.listTableNames(ctx, client)
+	if err != nil {
+		backend.Logger.Error("Failed to list tables", "error", err.Error())
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusInternalServerError,
+			Body:   []byte(fmt.Sprintf(`{"error": "failed to list tables: %s"}`, err.Error())),
+		})
+	}
+
+	backend.Logger.Info("Listed tables", "count", len(tableNames))
+
+	// Return as JSON
+	response := struct {
+		Tables []string `json:"tables"`
+	}{
+		Tables: tableNames,
+	}
+
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusInternalServerError,
+			Body:   []byte(fmt.Sprintf(`{"error": "failed to marshal response: %s"}`, err.Error())),
+		})
+	}
+
+	return sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusOK,
+		Body:   responseJSON,
+	})
+}
+
+// listTableNames returns the names of all tables, following ListTables pagination.
+func (d *Datasource) listTableNames(ctx context.Context, client *dynamodb.DynamoDB) ([]string, error) {
 	var tableNames []string
 	var lastEvaluatedTableName *string
 
@@ -483,13 +885,11 @@ func (d *Datasource) handleListTables(ctx context.Context, req *backend.CallReso
 			input.ExclusiveStartTableName = lastEvaluatedTableName
 		}
 
-		output, err := client.ListTablesWithContext(ctx, input)
+		output, err := CallWithRetry(ctx, d.retrySettings, nil, func() (*dynamodb.ListTablesOutput, error) {
+			return client.ListTablesWithContext(ctx, input)
+		})
 		if err != nil {
-			backend.Logger.Error("Failed to list tables", "error", err.Error())
-			return sender.Send(&backend.CallResourceResponse{
-				Status: http.StatusInternalServerError,
-				Body:   []byte(fmt.Sprintf(`{"error": "failed to list tables: %s"}`, err.Error())),
-			})
+			return nil, err
 		}
 
 		// Convert []*string to []string
@@ -505,31 +905,11 @@ func (d *Datasource) handleListTables(ctx context.Context, req *backend.CallReso
 		}
 		lastEvaluatedTableName = output.LastEvaluatedTableName
 	}
-
-	backend.Logger.Info("Listed tables", "count", len(tableNames))
-
-	// Return as JSON
-	response := struct {
-		Tables []string `json:"tables"`
-	}{
-		Tables: tableNames,
-	}
-
-	responseJSON, err := json.Marshal(response)
-	if err != nil {
-		return sender.Send(&backend.CallResourceResponse{
-			Status: http.StatusInternalServerError,
-			Body:   []byte(fmt.Sprintf(`{"error": "failed to marshal response: %s"}`, err.Error())),
-		})
-	}
-
-	return sender.Send(&backend.CallResourceResponse{
-		Status: http.StatusOK,
-		Body:   responseJSON,
-	})
+	return tableNames, nil
 }
 
-// handleTableAttributes returns the attributes (column names) for a specific DynamoDB table
+// handleTableAttributes returns the attributes (column names) for a specific DynamoDB table,
+// served from the schema catalog
 func (d *Datasource) handleTableAttributes(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
 	// Parse URL to get query parameters
 	parsedURL, err := url.Parse(req.URL)
@@ -558,93 +938,23 @@ func (d *Datasource) handleTableAttributes(ctx context.Context, req *backend.Cal
 		})
 	}
 
-	// Describe the table to get its schema
-	describeInput := &dynamodb.DescribeTableInput{
-		TableName: aws.String(tableName),
-	}
-
-	describeOutput, err := client.DescribeTableWithContext(ctx, describeInput)
+	schema, err := d.tableSchema(ctx, client, tableName, false)
 	if err != nil {
-		backend.Logger.Error("Failed to describe table", "table", tableName, "error", err.Error())
+		backend.Logger.Error("Failed to get table schema", "table", tableName, "error", err.Error())
 		return sender.Send(&backend.CallResourceResponse{
 			Status: http.StatusInternalServerError,
-			Body:   []byte(fmt.Sprintf(`{"error": "failed to describe table: %s"}`, err.Error())),
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
 		})
 	}
 
-	// Extract attribute names and types from table schema
-	var attributes []string
-	attributeTypes := make(map[string]string)
-
-	// Build type map from attribute definitions
-	backend.Logger.Info("Building type map from AttributeDefinitions", "table", tableName, "count", len(describeOutput.Table.AttributeDefinitions))
-	for _, attrDef := range describeOutput.Table.AttributeDefinitions {
-		if attrDef.AttributeName != nil && attrDef.AttributeType != nil {
-			backend.Logger.Info("Type from AttributeDefinition", "name", *attrDef.AttributeName, "type", *attrDef.AttributeType)
-			attributeTypes[*attrDef.AttributeName] = *attrDef.AttributeType
-		}
+	// Keys come first in the catalog, so the attribute order is unchanged for the editor
+	attributes := make([]string, 0, len(schema.Attributes))
+	attributeTypes := make(map[string]string, len(schema.Attributes))
+	for _, attribute := range schema.Attributes {
+		attributes = append(attributes, attribute.Name)
+		attributeTypes[attribute.Name] = attribute.Type
 	}
 
-	// Add key schema attributes (partition key and sort key)
-	for _, keyElement := range describeOutput.Table.KeySchema {
-		if keyElement.AttributeName != nil {
-			attributes = append(attributes, *keyElement.AttributeName)
-		}
-	}
-
-	// Add other attributes from attribute definitions
-	for _, attrDef := range describeOutput.Table.AttributeDefinitions {
-		if attrDef.AttributeName != nil {
-			// Avoid duplicates (key attributes are already added)
-			found := false
-			for _, existing := range attributes {
-				if existing == *attrDef.AttributeName {
-					found = true
-					break
-				}
-			}
-			if !found {
-				attributes = append(attributes, *attrDef.AttributeName)
-			}
-		}
-	}
-
-	// Also scan a few items to discover additional attributes and their types
-	scanInput := &dynamodb.ScanInput{
-		TableName: aws.String(tableName),
-		Limit:     aws.Int64(5), // Just scan a few items to discover attributes
-	}
-
-	scanOutput, err := client.ScanWithContext(ctx, scanInput)
-	if err == nil && len(scanOutput.Items) > 0 {
-		// Extract attribute names and types from actual items
-		attributeSet := make(map[string]bool)
-
-		// Add existing attributes to set
-		for _, attr := range attributes {
-			attributeSet[attr] = true
-		}
-
-		// Discover new attributes from items
-		for _, item := range scanOutput.Items {
-			for attrName, attrValue := range item {
-				if !attributeSet[attrName] {
-					attributes = append(attributes, attrName)
-					attributeSet[attrName] = true
-					// Detect type from value
-					if _, exists := attributeTypes[attrName]; !exists {
-						detectedType := detectAttributeType(attrValue)
-						backend.Logger.Info("Type detected from scan", "name", attrName, "type", detectedType)
-						attributeTypes[attrName] = detectedType
-					}
-				}
-			}
-		}
-	}
-
-	backend.Logger.Info("Final discovered table attributes", "table", tableName, "attributes", attributes)
-	backend.Logger.Info("Final type map", "table", tableName, "types", attributeTypes)
-
 	// Return as JSON with types
 	response := struct {
 		Attributes []string          `json:"attributes"`
@@ -822,9 +1132,19 @@ type keySchemaInfo struct {
 	SortKey      string
 }
 
-func getKeySchema(ctx context.Context, client *dynamodb.DynamoDB, tableName, indexName string) (*keySchemaInfo, error) {
-	output, err := client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
-		TableName: aws.String(tableName),
+// names returns the key attribute names, the sort key only when the table has one.
+func (k *keySchemaInfo) names() []string {
+	if k.SortKey == "" {
+		return []string{k.PartitionKey}
+	}
+	return []string{k.PartitionKey, k.SortKey}
+}
+
+func (d *Datasource) getKeySchema(ctx context.Context, client *dynamodb.DynamoDB, tableName, indexName string) (*keySchemaInfo, error) {
+	output, err := CallWithRetry(ctx, d.retrySettings, nil, func() (*dynamodb.DescribeTableOutput, error) {
+		return client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{
+			TableName: aws.String(tableName),
+		})
 	})
 	if err != nil {
 		return nil, err
@@ -886,6 +1206,9 @@ func extractTableAndIndex(query string) (string, string) {
 	if matches := fromClauseWithIndexQuoted.FindStringSubmatch(query); len(matches) == 3 {
 		return matches[1], matches[2]
 	}
+	if matches := fromClauseWithIndexDotted.FindStringSubmatch(query); len(matches) == 3 {
+		return matches[1], matches[2]
+	}
 	if matches := fromClauseQuoted.FindStringSubmatch(query); len(matches) == 2 {
 		return matches[1], ""
 	}
@@ -0,0 +1,203 @@
+package plugin
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	defaultFlattenDepth = 3
+	maxFlattenDepth     = 10
+)
+
+// FlattenModel expands nested map (and optionally list) attributes into dotted-path columns
+// such as "readings.level" or "sensors[0].temp". Values nested deeper than MaxDepth stay
+// JSON.
+type FlattenModel struct {
+	MaxDepth int  `json:"maxDepth,omitempty"` // levels of nesting to expand; defaults to 3
+	Arrays   bool `json:"arrays,omitempty"`   // also expand lists into indexed columns
+}
+
+// Projection adds a column holding the value found at a JSONPath-style path inside each
+// item, e.g. "$.readings.level", "$.sensors[0].temp" or "$['sensor block'].level".
+type Projection struct {
+	Path  string `json:"path"`
+	Alias string `json:"alias,omitempty"` // column name; defaults to the path without "$."
+}
+
+func (f FlattenModel) depth() int {
+	switch {
+	case f.MaxDepth <= 0:
+		return defaultFlattenDepth
+	case f.MaxDepth > maxFlattenDepth:
+		return maxFlattenDepth
+	}
+	return f.MaxDepth
+}
+
+// pathSegment is one step of a projection path: a map key, or a list index when key is
+// empty.
+type pathSegment struct {
+	key   string
+	index int
+}
+
+// parseProjectionPath parses the supported JSONPath subset: an optional leading "$", dotted
+// names, quoted names in brackets and non-negative list indexes. Wildcards, filters and
+// recursive descent are rejected.
+func parseProjectionPath(path string) ([]pathSegment, error) {
+	p := strings.TrimSpace(path)
+	p = strings.TrimPrefix(p, "$")
+	if strings.HasPrefix(p, "..") {
+		return nil, fmt.Errorf("recursive descent is not supported in projection path %q", path)
+	}
+	p = strings.TrimPrefix(p, ".")
+	if p == "" {
+		return nil, fmt.Errorf("projection path %q is empty", path)
+	}
+
+	var segments []pathSegment
+	for i := 0; i < len(p); {
+		switch p[i] {
+		case '.':
+			if i+1 >= len(p) || p[i+1] == '.' || p[i+1] == '[' {
+				return nil, fmt.Errorf("invalid projection path %q", path)
+			}
+			i++
+		case '[':
+			end := strings.IndexByte(p[i:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated bracket in projection path %q", path)
+			}
+			inner := p[i+1 : i+end]
+			i += end + 1
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				segments = append(segments, pathSegment{key: inner[1 : len(inner)-1]})
+				continue
+			}
+			n, err := strconv.Atoi(inner)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("unsupported selector [%s] in projection path %q", inner, path)
+			}
+			segments = append(segments, pathSegment{index: n})
+		default:
+			end := strings.IndexAny(p[i:], ".[")
+			if end < 0 {
+				end = len(p) - i
+			}
+			name := p[i : i+end]
+			if name == "*" {
+				return nil, fmt.Errorf("wildcards are not supported in projection path %q", path)
+			}
+			segments = append(segments, pathSegment{key: name})
+			i += end
+		}
+	}
+	if len(segments) == 0 || segments[0].key == "" {
+		return nil, fmt.Errorf("projection path %q must start with an attribute name", path)
+	}
+	return segments, nil
+}
+
+// resolvePath walks segments through nested maps and lists and returns nil when any step
+// is missing.
+func resolvePath(item map[string]*dynamodb.AttributeValue, segments []pathSegment) *dynamodb.AttributeValue {
+	current := item[segments[0].key]
+	for _, seg := range segments[1:] {
+		if current == nil {
+			return nil
+		}
+		if seg.key != "" {
+			if current.M == nil {
+				return nil
+			}
+			current = current.M[seg.key]
+			continue
+		}
+		if current.L == nil || seg.index >= len(current.L) {
+			return nil
+		}
+		current = current.L[seg.index]
+	}
+	return current
+}
+
+// projectionName is the column name of a projection.
+func (p Projection) projectionName() string {
+	if p.Alias != "" {
+		return p.Alias
+	}
+	name := strings.TrimPrefix(strings.TrimSpace(p.Path), "$")
+	return strings.TrimPrefix(name, ".")
+}
+
+// ReshapeItems applies flattening and projections to query items. The result still holds
+// DynamoDB attribute values, so the new columns get their types from Attribute like any
+// top-level attribute and can be used for datetime attributes, aggregation and time series.
+func ReshapeItems(items []map[string]*dynamodb.AttributeValue, flatten *FlattenModel, projections []Projection) ([]map[string]*dynamodb.AttributeValue, error) {
+	if flatten == nil && len(projections) == 0 {
+		return items, nil
+	}
+
+	paths := make([][]pathSegment, len(projections))
+	for i, p := range projections {
+		segments, err := parseProjectionPath(p.Path)
+		if err != nil {
+			return nil, err
+		}
+		paths[i] = segments
+	}
+
+	out := make([]map[string]*dynamodb.AttributeValue, len(items))
+	for i, item := range items {
+		row := item
+		if flatten != nil {
+			row = make(map[string]*dynamodb.AttributeValue, len(item))
+			for name, value := range item {
+				flattenAttribute(row, name, value, flatten.depth(), flatten.Arrays)
+			}
+		}
+		if len(projections) > 0 {
+			if flatten == nil {
+				row = make(map[string]*dynamodb.AttributeValue, len(item)+len(projections))
+				for name, value := range item {
+					row[name] = value
+				}
+			}
+			for j, p := range projections {
+				if v := resolvePath(item, paths[j]); v != nil {
+					row[p.projectionName()] = v
+				}
+			}
+		}
+		out[i] = row
+	}
+	return out, nil
+}
+
+// flattenAttribute writes value under name, expanding maps (and lists when arrays is set)
+// while depth allows. Empty maps and lists are kept as JSON so the column is not lost.
+func flattenAttribute(row map[string]*dynamodb.AttributeValue, name string, value *dynamodb.AttributeValue, depth int, arrays bool) {
+	if value == nil {
+		return
+	}
+	if depth <= 0 {
+		row[name] = value
+		return
+	}
+	switch {
+	case value.M != nil && len(value.M) > 0:
+		for key, child := range value.M {
+			flattenAttribute(row, name+"."+key, child, depth-1, arrays)
+		}
+	case arrays && value.L != nil && len(value.L) > 0:
+		for i, child := range value.L {
+			flattenAttribute(row, name+"["+strconv.Itoa(i)+"]", child, depth-1, arrays)
+		}
+	default:
+		row[name] = value
+	}
+}
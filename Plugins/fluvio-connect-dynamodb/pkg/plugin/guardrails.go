@@ -0,0 +1,152 @@
+package plugin
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// QueryLimits bounds the pagination of one query. The datasource settings hold the admin
+// limits; a query may only lower them.
+type QueryLimits struct {
+	MaxPages           int   `json:"maxPages,omitempty"`
+	MaxItems           int   `json:"maxItems,omitempty"`
+	MaxDurationSeconds int64 `json:"maxDurationSeconds,omitempty"`
+}
+
+const (
+	defaultMaxPages           = 1000
+	defaultMaxItems           = 1000000
+	defaultMaxDurationSeconds = 60
+)
+
+func (l QueryLimits) withDefaults() QueryLimits {
+	if l.MaxPages <= 0 {
+		l.MaxPages = defaultMaxPages
+	}
+	if l.MaxItems <= 0 {
+		l.MaxItems = defaultMaxItems
+	}
+	if l.MaxDurationSeconds <= 0 {
+		l.MaxDurationSeconds = defaultMaxDurationSeconds
+	}
+	return l
+}
+
+// EffectiveQueryLimits applies the per-query overrides to the admin limits. Overrides can
+// only tighten a limit; larger or unset values keep the admin value.
+func EffectiveQueryLimits(admin QueryLimits, override *QueryLimits) QueryLimits {
+	limits := admin.withDefaults()
+	if override == nil {
+		return limits
+	}
+	if override.MaxPages > 0 && override.MaxPages < limits.MaxPages {
+		limits.MaxPages = override.MaxPages
+	}
+	if override.MaxItems > 0 && override.MaxItems < limits.MaxItems {
+		limits.MaxItems = override.MaxItems
+	}
+	if override.MaxDurationSeconds > 0 && override.MaxDurationSeconds < limits.MaxDurationSeconds {
+		limits.MaxDurationSeconds = override.MaxDurationSeconds
+	}
+	return limits
+}
+
+func (l QueryLimits) maxDuration() time.Duration {
+	return time.Duration(l.MaxDurationSeconds) * time.Second
+}
+
+// ReadBudgetSettings caps the read capacity the queries of one org may consume through this
+// datasource over a rolling window. The budget is tracked in memory per plugin instance.
+type ReadBudgetSettings struct {
+	CapacityUnits float64 `json:"capacityUnits,omitempty"` // 0 disables the budget
+	WindowMinutes int     `json:"windowMinutes,omitempty"` // defaults to 60
+}
+
+const defaultReadBudgetWindow = time.Hour
+
+type budgetEntry struct {
+	at    time.Time
+	units float64
+}
+
+// ReadBudget tracks consumed read capacity per org over a rolling window. A nil budget is
+// unlimited.
+type ReadBudget struct {
+	mu     sync.Mutex
+	limit  float64
+	window time.Duration
+	now    func() time.Time
+	usage  map[int64][]budgetEntry
+}
+
+// NewReadBudget returns the budget described by settings, or nil when it is disabled. now
+// defaults to time.Now.
+func NewReadBudget(settings ReadBudgetSettings, now func() time.Time) *ReadBudget {
+	if settings.CapacityUnits <= 0 {
+		return nil
+	}
+	if now == nil {
+		now = time.Now
+	}
+	window := time.Duration(settings.WindowMinutes) * time.Minute
+	if window <= 0 {
+		window = defaultReadBudgetWindow
+	}
+	return &ReadBudget{
+		limit:  settings.CapacityUnits,
+		window: window,
+		now:    now,
+		usage:  map[int64][]budgetEntry{},
+	}
+}
+
+// Remaining returns the capacity units the org may still consume in the current window.
+func (b *ReadBudget) Remaining(orgID int64) float64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.limit - b.usedLocked(orgID)
+}
+
+func (b *ReadBudget) usedLocked(orgID int64) float64 {
+	cutoff := b.now().Add(-b.window)
+	entries := b.usage[orgID]
+	i := 0
+	for i < len(entries) && !entries[i].at.After(cutoff) {
+		i++
+	}
+	entries = entries[i:]
+	if len(entries) == 0 {
+		delete(b.usage, orgID)
+	} else {
+		b.usage[orgID] = entries
+	}
+
+	used := 0.0
+	for _, e := range entries {
+		used += e.units
+	}
+	return used
+}
+
+// Record adds consumed units to the org's window.
+func (b *ReadBudget) Record(orgID int64, units float64) {
+	if b == nil || units <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.usage[orgID] = append(b.usage[orgID], budgetEntry{at: b.now(), units: units})
+}
+
+// Exhausted reports whether the org has used its whole budget.
+func (b *ReadBudget) Exhausted(orgID int64) bool {
+	return b != nil && b.Remaining(orgID) <= 0
+}
+
+func (b *ReadBudget) describe(orgID int64) string {
+	return fmt.Sprintf("%.1f of %.1f read capacity units left in the rolling %s window", b.Remaining(orgID), b.limit, b.window)
+}
@@ -0,0 +1,729 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// ItemEditSettings lists the tables whose items may be read and edited one at a time through
+// the items resource. Other tables are only written by upload presets.
+type ItemEditSettings struct {
+	Tables []ItemEditTable `json:"tables,omitempty"`
+}
+
+// ItemEditTable enables single-item edits of a table.
+type ItemEditTable struct {
+	Table string `json:"table"`
+	// Numeric attribute incremented by every edit. Edits then name the version they read;
+	// without it they name the attribute values they read.
+	VersionAttribute string              `json:"versionAttribute,omitempty"`
+	Access           *UploadPresetAccess `json:"access,omitempty"` // defaults to the Editor role
+}
+
+// Single-item edit operations.
+const (
+	ItemEditPut    = "put"
+	ItemEditUpdate = "update"
+	ItemEditDelete = "delete"
+)
+
+const auditActionEdit = "edit"
+
+// editableTable returns the edit settings of a table.
+func (s ItemEditSettings) editableTable(table string) (ItemEditTable, bool) {
+	for _, t := range s.Tables {
+		if t.Table == table {
+			return t, true
+		}
+	}
+	return ItemEditTable{}, false
+}
+
+func (t ItemEditTable) allows(user *backend.User, teams map[string][]string) bool {
+	access := t.Access
+	if access == nil {
+		access = &UploadPresetAccess{Roles: []string{"Editor"}}
+	}
+	return UploadPreset{Access: access}.allows(user, teams)
+}
+
+// ItemEdit is a conditional write of one item. Every edit is guarded: by ExpectedVersion when
+// the table has a version attribute, otherwise by Expected, the attributes as the editor read
+// them. An edit whose guard no longer holds is refused with the current item.
+//
+// Attribute values are in DynamoDB JSON, e.g. {"tags": {"SS": ["a"]}}, so sets, binary values
+// and numbers are written with the types they are stored with.
+type ItemEdit struct {
+	Table     string       `json:"table"`
+	Operation string       `json:"operation"`        // put, update or delete
+	Key       AttributeMap `json:"key,omitempty"`    // update and delete; put takes the key from Item
+	Item      AttributeMap `json:"item,omitempty"`   // put: the whole new item
+	Set       AttributeMap `json:"set,omitempty"`    // update: attributes to set
+	Remove    []string     `json:"remove,omitempty"` // update: attributes to remove
+	// Version read by the editor; 0 expects an item without a version, such as a new one
+	ExpectedVersion *int64 `json:"expectedVersion,omitempty"`
+	// Attribute values read by the editor; null expects the attribute to be missing, and an
+	// empty object expects the item not to exist
+	Expected AttributeMap `json:"expected,omitempty"`
+	DryRun   bool         `json:"dryRun,omitempty"` // check the guard and return the changes without writing
+}
+
+// ItemChange is the difference of one attribute between two images of an item. A nil value is
+// a missing attribute.
+type ItemChange struct {
+	Attribute string
+	Before    *dynamodb.AttributeValue
+	After     *dynamodb.AttributeValue
+}
+
+// MarshalJSON writes the values in DynamoDB JSON.
+func (c ItemChange) MarshalJSON() ([]byte, error) {
+	before, err := attributeValueJSON(c.Before)
+	if err != nil {
+		return nil, err
+	}
+	after, err := attributeValueJSON(c.After)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(struct {
+		Attribute string          `json:"attribute"`
+		Before    json.RawMessage `json:"before"`
+		After     json.RawMessage `json:"after"`
+	}{c.Attribute, before, after})
+}
+
+// ItemWrite is the DynamoDB request of an edit. Exactly one of Put, Update and Delete is set;
+// all of them return the prior image and, on a failed guard, the current one.
+type ItemWrite struct {
+	Key    map[string]*dynamodb.AttributeValue
+	Put    *dynamodb.PutItemInput
+	Update *dynamodb.UpdateItemInput
+	Delete *dynamodb.DeleteItemInput
+
+	versionAttribute string
+	expectedVersion  *int64
+	expected         map[string]*dynamodb.AttributeValue // nil when the edit is guarded by version
+	set              map[string]*dynamodb.AttributeValue
+	remove           []string
+}
+
+// BuildItemWrite validates an edit of a table keyed by partitionKey and sortKey and builds its
+// conditional request.
+func BuildItemWrite(edit ItemEdit, config ItemEditTable, partitionKey, sortKey string) (*ItemWrite, error) {
+	keyNames := []string{partitionKey}
+	if sortKey != "" {
+		keyNames = append(keyNames, sortKey)
+	}
+	isKey := func(name string) bool { return name == partitionKey || name == sortKey }
+	versionAttribute := config.VersionAttribute
+
+	w := &ItemWrite{versionAttribute: versionAttribute}
+	names := map[string]*string{}
+	values := map[string]*dynamodb.AttributeValue{}
+	var conditions []string
+
+	// Guard
+	switch {
+	case versionAttribute != "":
+		if edit.ExpectedVersion == nil {
+			return nil, fmt.Errorf("edits of table %s must set expectedVersion", config.Table)
+		}
+		w.expectedVersion = edit.ExpectedVersion
+		names["#v"] = aws.String(versionAttribute)
+		if *edit.ExpectedVersion == 0 {
+			conditions = append(conditions, "attribute_not_exists(#v)")
+		} else {
+			values[":v"] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(*edit.ExpectedVersion, 10))}
+			conditions = append(conditions, "#v = :v")
+		}
+	case edit.Expected == nil:
+		return nil, fmt.Errorf("edits of table %s must set expected, the attributes as they were read", config.Table)
+	default:
+		expected := edit.Expected
+		w.expected = expected
+		names["#pk"] = aws.String(partitionKey)
+		if len(expected) == 0 {
+			conditions = append(conditions, "attribute_not_exists(#pk)")
+			break
+		}
+		conditions = append(conditions, "attribute_exists(#pk)")
+		for i, name := range sortedAttributeNames(expected) {
+			placeholder := fmt.Sprintf("#e%d", i)
+			names[placeholder] = aws.String(name)
+			if expected[name] == nil {
+				conditions = append(conditions, fmt.Sprintf("attribute_not_exists(%s)", placeholder))
+				continue
+			}
+			values[fmt.Sprintf(":e%d", i)] = expected[name]
+			conditions = append(conditions, fmt.Sprintf("%s = :e%d", placeholder, i))
+		}
+	}
+
+	nextVersion := func() *dynamodb.AttributeValue {
+		return &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(*w.expectedVersion+1, 10))}
+	}
+
+	keySource := edit.Key
+	if edit.Operation == ItemEditPut {
+		keySource = edit.Item
+	}
+	key, err := editKey(keySource, partitionKey, sortKey)
+	if err != nil {
+		return nil, err
+	}
+	w.Key = key
+
+	switch edit.Operation {
+	case ItemEditPut:
+		if err := requireValues(edit.Item, "item"); err != nil {
+			return nil, err
+		}
+		item := make(map[string]*dynamodb.AttributeValue, len(edit.Item)+1)
+		for name, value := range edit.Item {
+			item[name] = value
+		}
+		if versionAttribute != "" {
+			item[versionAttribute] = nextVersion()
+		}
+		w.Put = &dynamodb.PutItemInput{TableName: aws.String(config.Table), Item: item}
+
+	case ItemEditUpdate:
+		if len(edit.Set) == 0 && len(edit.Remove) == 0 {
+			return nil, errors.New("update must set or remove at least one attribute")
+		}
+		if err := requireValues(edit.Set, "set"); err != nil {
+			return nil, err
+		}
+		set := edit.Set
+		var setClauses, removeClauses []string
+		for i, name := range sortedAttributeNames(set) {
+			if isKey(name) || name == versionAttribute {
+				return nil, fmt.Errorf("attribute %q cannot be set by an update", name)
+			}
+			names[fmt.Sprintf("#s%d", i)] = aws.String(name)
+			values[fmt.Sprintf(":s%d", i)] = set[name]
+			setClauses = append(setClauses, fmt.Sprintf("#s%d = :s%d", i, i))
+		}
+		for i, name := range edit.Remove {
+			if isKey(name) || name == versionAttribute {
+				return nil, fmt.Errorf("attribute %q cannot be removed by an update", name)
+			}
+			if _, ok := set[name]; ok {
+				return nil, fmt.Errorf("attribute %q is both set and removed", name)
+			}
+			names[fmt.Sprintf("#r%d", i)] = aws.String(name)
+			removeClauses = append(removeClauses, fmt.Sprintf("#r%d", i))
+		}
+		if versionAttribute != "" {
+			values[":nv"] = nextVersion()
+			setClauses = append(setClauses, "#v = :nv")
+		}
+
+		var expression []string
+		if len(setClauses) > 0 {
+			expression = append(expression, "SET "+strings.Join(setClauses, ", "))
+		}
+		if len(removeClauses) > 0 {
+			expression = append(expression, "REMOVE "+strings.Join(removeClauses, ", "))
+		}
+		w.set, w.remove = set, edit.Remove
+		w.Update = &dynamodb.UpdateItemInput{
+			TableName:        aws.String(config.Table),
+			Key:              key,
+			UpdateExpression: aws.String(strings.Join(expression, " ")),
+		}
+
+	case ItemEditDelete:
+		w.Delete = &dynamodb.DeleteItemInput{TableName: aws.String(config.Table), Key: key}
+
+	default:
+		return nil, fmt.Errorf("unsupported operation %q (expected put, update or delete)", edit.Operation)
+	}
+
+	condition := aws.String(strings.Join(conditions, " AND "))
+	if len(values) == 0 {
+		values = nil
+	}
+	returnOld := aws.String(dynamodb.ReturnValueAllOld)
+	onFailure := aws.String(dynamodb.ReturnValuesOnConditionCheckFailureAllOld)
+	switch {
+	case w.Put != nil:
+		w.Put.ConditionExpression, w.Put.ExpressionAttributeNames, w.Put.ExpressionAttributeValues = condition, names, values
+		w.Put.ReturnValues, w.Put.ReturnValuesOnConditionCheckFailure = returnOld, onFailure
+	case w.Update != nil:
+		w.Update.ConditionExpression, w.Update.ExpressionAttributeNames, w.Update.ExpressionAttributeValues = condition, names, values
+		w.Update.ReturnValues, w.Update.ReturnValuesOnConditionCheckFailure = returnOld, onFailure
+	case w.Delete != nil:
+		w.Delete.ConditionExpression, w.Delete.ExpressionAttributeNames, w.Delete.ExpressionAttributeValues = condition, names, values
+		w.Delete.ReturnValues, w.Delete.ReturnValuesOnConditionCheckFailure = returnOld, onFailure
+	}
+	return w, nil
+}
+
+// editKey returns the key attributes of an item.
+func editKey(item AttributeMap, partitionKey, sortKey string) (map[string]*dynamodb.AttributeValue, error) {
+	key := map[string]*dynamodb.AttributeValue{}
+	for _, name := range []string{partitionKey, sortKey} {
+		if name == "" {
+			continue
+		}
+		value := item[name]
+		if value == nil {
+			return nil, fmt.Errorf("key attribute %q missing", name)
+		}
+		key[name] = value
+	}
+	return key, nil
+}
+
+// requireValues rejects null attribute values, which only expected images may use.
+func requireValues(item AttributeMap, field string) error {
+	for _, name := range sortedAttributeNames(item) {
+		if item[name] == nil {
+			return fmt.Errorf("%s attribute %q has no value; remove it with an update instead", field, name)
+		}
+	}
+	return nil
+}
+
+// Check reports whether the guard of the write holds for the current image of the item, nil
+// when the item does not exist.
+func (w *ItemWrite) Check(current map[string]*dynamodb.AttributeValue) bool {
+	return len(w.Conflicts(current)) == 0
+}
+
+// Conflicts returns the guarded attributes whose current value differs from the one the
+// editor expected.
+func (w *ItemWrite) Conflicts(current map[string]*dynamodb.AttributeValue) []ItemChange {
+	var conflicts []ItemChange
+	if w.expectedVersion != nil {
+		var expected *dynamodb.AttributeValue
+		if *w.expectedVersion != 0 {
+			expected = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(*w.expectedVersion, 10))}
+		}
+		if actual := current[w.versionAttribute]; !sameAttributeValue(expected, actual) {
+			conflicts = append(conflicts, ItemChange{Attribute: w.versionAttribute, Before: expected, After: actual})
+		}
+		return conflicts
+	}
+
+	if len(w.expected) == 0 {
+		if current != nil {
+			for _, name := range sortedAttributeNames(w.Key) {
+				conflicts = append(conflicts, ItemChange{Attribute: name, After: current[name]})
+			}
+		}
+		return conflicts
+	}
+	for _, name := range sortedAttributeNames(w.expected) {
+		expected := w.expected[name]
+		var actual *dynamodb.AttributeValue
+		if current != nil {
+			actual = current[name]
+		}
+		if current == nil || !sameAttributeValue(expected, actual) {
+			conflicts = append(conflicts, ItemChange{Attribute: name, Before: expected, After: actual})
+		}
+	}
+	return conflicts
+}
+
+// After returns the image of the item once the write is applied to prior; nil for deletes.
+func (w *ItemWrite) After(prior map[string]*dynamodb.AttributeValue) map[string]*dynamodb.AttributeValue {
+	switch {
+	case w.Put != nil:
+		return w.Put.Item
+	case w.Delete != nil:
+		return nil
+	}
+	after := make(map[string]*dynamodb.AttributeValue, len(prior)+len(w.set)+len(w.Key))
+	for name, value := range prior {
+		after[name] = value
+	}
+	for name, value := range w.Key {
+		after[name] = value
+	}
+	for name, value := range w.set {
+		after[name] = value
+	}
+	for _, name := range w.remove {
+		delete(after, name)
+	}
+	if w.versionAttribute != "" {
+		after[w.versionAttribute] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(*w.expectedVersion+1, 10))}
+	}
+	return after
+}
+
+// DiffItems returns the attributes that differ between two images of an item, by name. A nil
+// image is a missing item.
+func DiffItems(before, after map[string]*dynamodb.AttributeValue) []ItemChange {
+	names := map[string]bool{}
+	for name := range before {
+		names[name] = true
+	}
+	for name := range after {
+		names[name] = true
+	}
+	changes := []ItemChange{}
+	for name := range names {
+		if sameAttributeValue(before[name], after[name]) {
+			continue
+		}
+		changes = append(changes, ItemChange{Attribute: name, Before: before[name], After: after[name]})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Attribute < changes[j].Attribute })
+	return changes
+}
+
+// sameAttributeValue compares two attribute values as DynamoDB does: numbers by value and
+// sets regardless of order.
+func sameAttributeValue(a, b *dynamodb.AttributeValue) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	switch {
+	case a.N != nil && b.N != nil:
+		return sameNumber(*a.N, *b.N)
+	case a.NS != nil && b.NS != nil:
+		return sameSet(aws.StringValueSlice(a.NS), aws.StringValueSlice(b.NS), canonicalNumber)
+	case a.SS != nil && b.SS != nil:
+		return sameSet(aws.StringValueSlice(a.SS), aws.StringValueSlice(b.SS), func(s string) string { return s })
+	case a.BS != nil && b.BS != nil:
+		return sameSet(a.BS, b.BS, func(b []byte) string { return string(b) })
+	case a.L != nil && b.L != nil:
+		if len(a.L) != len(b.L) {
+			return false
+		}
+		for i := range a.L {
+			if !sameAttributeValue(a.L[i], b.L[i]) {
+				return false
+			}
+		}
+		return true
+	case a.M != nil && b.M != nil:
+		if len(a.M) != len(b.M) {
+			return false
+		}
+		for name, value := range a.M {
+			if !sameAttributeValue(value, b.M[name]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// sameNumber compares two DynamoDB numbers exactly; they have up to 38 significant digits,
+// more than a float64 holds.
+func sameNumber(a, b string) bool {
+	return canonicalNumber(a) == canonicalNumber(b)
+}
+
+func canonicalNumber(n string) string {
+	r, ok := new(big.Rat).SetString(n)
+	if !ok {
+		return n
+	}
+	return r.RatString()
+}
+
+func sameSet[T any](a, b []T, canonical func(T) string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := map[string]int{}
+	for _, v := range a {
+		counts[canonical(v)]++
+	}
+	for _, v := range b {
+		key := canonical(v)
+		if counts[key] == 0 {
+			return false
+		}
+		counts[key]--
+	}
+	return true
+}
+
+// itemVersion returns the version attribute of an item, 0 when it has none.
+func itemVersion(item map[string]*dynamodb.AttributeValue, versionAttribute string) int64 {
+	if versionAttribute == "" || item[versionAttribute] == nil || item[versionAttribute].N == nil {
+		return 0
+	}
+	version, _ := strconv.ParseInt(*item[versionAttribute].N, 10, 64)
+	return version
+}
+
+// itemResponse is the body of items responses. Conflicts carry the current item and the
+// guarded attributes that changed.
+type itemResponse struct {
+	Item      AttributeMap `json:"item"`
+	Version   *int64       `json:"version,omitempty"` // tables with a version attribute
+	Changes   []ItemChange `json:"changes,omitempty"`
+	Conflicts []ItemChange `json:"conflicts,omitempty"`
+	DryRun    bool         `json:"dryRun,omitempty"`
+	AuditID   string       `json:"auditId,omitempty"`
+	Error     string       `json:"error,omitempty"`
+}
+
+func newItemResponse(item map[string]*dynamodb.AttributeValue, config ItemEditTable) itemResponse {
+	response := itemResponse{Item: item}
+	if config.VersionAttribute != "" && item != nil {
+		version := itemVersion(item, config.VersionAttribute)
+		response.Version = &version
+	}
+	return response
+}
+
+// handleItems reads and edits single items of the tables enabled in ItemEditSettings:
+//
+//	GET  items?table=name&key={"PK":{"S":"..."}}   returns the item and its version
+//	POST items                                applies an ItemEdit
+func (d *Datasource) handleItems(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	extraSettings, err := loadExtraPluginSettings(*req.PluginContext.DataSourceInstanceSettings)
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusInternalServerError,
+			Body:   []byte(fmt.Sprintf(`{"error": "failed to load settings: %s"}`, sanitizeError(err))),
+		})
+	}
+
+	var edit ItemEdit
+	switch req.Method {
+	case http.MethodGet, "":
+		parsedURL, err := url.Parse(req.URL)
+		if err != nil {
+			return sendUploadJSON(sender, http.StatusBadRequest, map[string]string{"error": "invalid URL"})
+		}
+		edit.Table = parsedURL.Query().Get("table")
+		if err := json.Unmarshal([]byte(parsedURL.Query().Get("key")), &edit.Key); err != nil || len(edit.Key) == 0 {
+			return sendUploadJSON(sender, http.StatusBadRequest, map[string]string{"error": "key must be a JSON object of the key attributes in DynamoDB JSON"})
+		}
+	case http.MethodPost:
+		if err := json.Unmarshal(req.Body, &edit); err != nil {
+			return sender.Send(&backend.CallResourceResponse{
+				Status: http.StatusBadRequest,
+				Body:   []byte(fmt.Sprintf(`{"error": "invalid request body: %s"}`, sanitizeError(err))),
+			})
+		}
+	default:
+		return sendUploadJSON(sender, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+
+	config, ok := extraSettings.ItemEditing.editableTable(edit.Table)
+	if !ok {
+		return sendUploadJSON(sender, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("table %q is not enabled for item editing", edit.Table)})
+	}
+	if !config.allows(req.PluginContext.User, extraSettings.Teams) {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusForbidden,
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(errUploadAccessDenied))),
+		})
+	}
+
+	client, err := d.getDynamoDBClient(ctx, req.PluginContext.DataSourceInstanceSettings)
+	if err != nil {
+		backend.Logger.Error("Failed to get DynamoDB client", "error", err.Error())
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusInternalServerError,
+			Body:   []byte(fmt.Sprintf(`{"error": "failed to get client: %s"}`, sanitizeError(err))),
+		})
+	}
+	meta, err := d.tableMetadata(ctx, client, AWSTarget{}, config.Table)
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusInternalServerError,
+			Body:   []byte(fmt.Sprintf(`{"error": "failed to describe table: %s"}`, sanitizeError(err))),
+		})
+	}
+
+	if req.Method == http.MethodPost {
+		return d.applyItemEdit(ctx, req, sender, client, edit, config, meta)
+	}
+
+	key, err := editKey(edit.Key, meta.PartitionKey, meta.SortKey)
+	if err != nil {
+		return sendUploadJSON(sender, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	item, err := d.getItem(ctx, client, config.Table, key)
+	if err != nil {
+		backend.Logger.Error("Failed to read item", "table", config.Table, "error", err.Error())
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusInternalServerError,
+			Body:   []byte(fmt.Sprintf(`{"error": "failed to read item: %s"}`, sanitizeError(err))),
+		})
+	}
+	if item == nil {
+		return sendUploadJSON(sender, http.StatusNotFound, map[string]string{"error": "item not found"})
+	}
+	return sendUploadJSON(sender, http.StatusOK, newItemResponse(item, config))
+}
+
+// getItem reads the current image of an item with a consistent read; nil when it does not exist.
+func (d *Datasource) getItem(ctx context.Context, client *dynamodb.DynamoDB, table string, key map[string]*dynamodb.AttributeValue) (map[string]*dynamodb.AttributeValue, error) {
+	if err := d.readLimiter.Wait(ctx, 1); err != nil {
+		return nil, err
+	}
+	output, err := CallWithRetry(ctx, d.retrySettings, nil, func() (*dynamodb.GetItemOutput, error) {
+		return client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(table),
+			Key:            key,
+			ConsistentRead: aws.Bool(true),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	d.readLimiter.Settle(1, 1)
+	if len(output.Item) == 0 {
+		return nil, nil
+	}
+	return output.Item, nil
+}
+
+func (d *Datasource) applyItemEdit(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, client *dynamodb.DynamoDB, edit ItemEdit, config ItemEditTable, meta TableMetadata) error {
+	write, err := BuildItemWrite(edit, config, meta.PartitionKey, meta.SortKey)
+	if err != nil {
+		return sendUploadJSON(sender, http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+
+	if edit.DryRun {
+		current, err := d.getItem(ctx, client, config.Table, write.Key)
+		if err != nil {
+			return sender.Send(&backend.CallResourceResponse{
+				Status: http.StatusInternalServerError,
+				Body:   []byte(fmt.Sprintf(`{"error": "failed to read item: %s"}`, sanitizeError(err))),
+			})
+		}
+		if conflicts := write.Conflicts(current); len(conflicts) > 0 {
+			return sendItemConflict(sender, write, current, conflicts, config)
+		}
+		response := newItemResponse(write.After(current), config)
+		response.Changes = DiffItems(current, write.After(current))
+		response.DryRun = true
+		return sendUploadJSON(sender, http.StatusOK, response)
+	}
+
+	prior, err := d.executeItemWrite(ctx, client, write)
+	var conflict *dynamodb.ConditionalCheckFailedException
+	if errors.As(err, &conflict) {
+		current := conflict.Item
+		if len(current) == 0 {
+			current = nil
+		}
+		backend.Logger.Info("Item edit conflict", "table", config.Table, "user", userLogin(req.PluginContext.User))
+		return sendItemConflict(sender, write, current, write.Conflicts(current), config)
+	}
+	if err != nil {
+		backend.Logger.Error("Item edit failed", "table", config.Table, "operation", edit.Operation, "error", err.Error())
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusInternalServerError,
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
+		})
+	}
+	d.resultCache.InvalidateTable(config.Table)
+
+	after := write.After(prior)
+	response := newItemResponse(after, config)
+	response.Changes = DiffItems(prior, after)
+
+	audit := newAuditEntry(req, auditActionEdit, &UploadPreset{Table: config.Table, Operation: UploadOperation(edit.Operation)})
+	audit.ItemCount = 1
+	audit.Items = []map[string]interface{}{auditItemOf(after)}
+	audit.ItemResults = []uploadItemResult{{Index: 0, Status: uploadItemSucceeded}}
+	audit.Images = []auditItemImage{{Index: 0, Key: write.Key, Prior: prior, After: after, HasAfter: true}}
+	audit.Undoable = true
+	if err := writeAuditEntry(audit); err != nil {
+		backend.Logger.Error("Failed to write item edit audit entry", "table", config.Table, "error", err.Error())
+	} else {
+		response.AuditID = audit.ID
+	}
+
+	backend.Logger.Info("Item edited", "table", config.Table, "operation", edit.Operation, "changes", len(response.Changes), "user", userLogin(req.PluginContext.User))
+	return sendUploadJSON(sender, http.StatusOK, response)
+}
+
+// auditItemOf returns an edited item for the audit log in DynamoDB JSON, like its images.
+func auditItemOf(item map[string]*dynamodb.AttributeValue) map[string]interface{} {
+	entry := map[string]interface{}{}
+	for name, value := range item {
+		raw, err := attributeValueJSON(value)
+		if err != nil {
+			continue
+		}
+		entry[name] = raw
+	}
+	return entry
+}
+
+// executeItemWrite runs a write within the write rate limit and returns the prior image.
+func (d *Datasource) executeItemWrite(ctx context.Context, client *dynamodb.DynamoDB, write *ItemWrite) (map[string]*dynamodb.AttributeValue, error) {
+	if err := d.writeLimiter.Wait(ctx, 1); err != nil {
+		return nil, err
+	}
+	var prior map[string]*dynamodb.AttributeValue
+	var consumed *dynamodb.ConsumedCapacity
+	var err error
+	switch {
+	case write.Put != nil:
+		var output *dynamodb.PutItemOutput
+		output, err = CallWithRetry(ctx, d.retrySettings, nil, func() (*dynamodb.PutItemOutput, error) {
+			return client.PutItemWithContext(ctx, write.Put)
+		})
+		if output != nil {
+			prior, consumed = output.Attributes, output.ConsumedCapacity
+		}
+	case write.Update != nil:
+		var output *dynamodb.UpdateItemOutput
+		output, err = CallWithRetry(ctx, d.retrySettings, nil, func() (*dynamodb.UpdateItemOutput, error) {
+			return client.UpdateItemWithContext(ctx, write.Update)
+		})
+		if output != nil {
+			prior, consumed = output.Attributes, output.ConsumedCapacity
+		}
+	case write.Delete != nil:
+		var output *dynamodb.DeleteItemOutput
+		output, err = CallWithRetry(ctx, d.retrySettings, nil, func() (*dynamodb.DeleteItemOutput, error) {
+			return client.DeleteItemWithContext(ctx, write.Delete)
+		})
+		if output != nil {
+			prior, consumed = output.Attributes, output.ConsumedCapacity
+		}
+	}
+	d.writeLimiter.Settle(1, capacityUnits(1, consumed))
+	if len(prior) == 0 {
+		prior = nil
+	}
+	return prior, err
+}
+
+// sendItemConflict refuses an edit whose guard failed with the current item.
+func sendItemConflict(sender backend.CallResourceResponseSender, write *ItemWrite, current map[string]*dynamodb.AttributeValue, conflicts []ItemChange, config ItemEditTable) error {
+	response := newItemResponse(current, config)
+	response.Conflicts = conflicts
+	switch {
+	case current == nil:
+		response.Error = "the item does not exist"
+	case write.expectedVersion == nil && len(write.expected) == 0:
+		response.Error = "the item already exists"
+	default:
+		response.Error = "the item was changed since it was read"
+	}
+	return sendUploadJSON(sender, http.StatusConflict, response)
+}
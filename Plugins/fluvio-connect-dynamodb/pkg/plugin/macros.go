@@ -0,0 +1,191 @@
+package plugin
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+)
+
+// Macro time formats accepted as the optional format argument, e.g. $__timeFilter(ts, ms).
+// When no format is given the DatetimeAttribute format of the attribute is used,
+// falling back to epoch seconds (the frontend's historical behaviour).
+const (
+	macroFormatSeconds      = "s"
+	macroFormatMilliseconds = "ms"
+	macroFormatISO          = "iso"
+)
+
+var (
+	timeFilterMacro = regexp.MustCompile(`\$__timeFilter\(\s*([^),]*?)\s*(?:,\s*([^)]*?)\s*)?\)`)
+	timeFromMacro   = regexp.MustCompile(`\$__timeFrom\(\s*([^)]*?)\s*\)`)
+	timeToMacro     = regexp.MustCompile(`\$__timeTo\(\s*([^)]*?)\s*\)`)
+	intervalMsMacro = regexp.MustCompile(`\$__interval_ms\b`)
+	intervalMacro   = regexp.MustCompile(`\$__interval\b`)
+	legacyFromMacro = regexp.MustCompile(`\$from\b`)
+	legacyToMacro   = regexp.MustCompile(`\$to\b`)
+)
+
+// InterpolateMacros expands the time-range and interval macros of a PartiQL statement
+// using the query's time range, so that backend-only callers (alerting, recording rules)
+// produce the same statement as the panel. datetimeAttributes maps attribute names to
+// their DatetimeAttribute format.
+//
+// Supported macros:
+//
+//	$__timeFilter(attr[, s|ms|iso])  "attr" BETWEEN <from> AND <to>
+//	$__timeFrom([s|ms|iso])          start of the time range
+//	$__timeTo([s|ms|iso])            end of the time range
+//	$__interval                      query interval, e.g. 30s
+//	$__interval_ms                   query interval in milliseconds
+//	$from / $to                      start / end of the time range in epoch seconds
+func InterpolateMacros(query string, timeRange backend.TimeRange, interval time.Duration, datetimeAttributes map[string]string) (string, error) {
+	from, to := timeRange.From, timeRange.To
+	if from.After(to) {
+		from, to = to, from
+	}
+
+	var expandErr error
+	fail := func(err error) string {
+		if expandErr == nil {
+			expandErr = err
+		}
+		return ""
+	}
+
+	query = timeFilterMacro.ReplaceAllStringFunc(query, func(match string) string {
+		args := timeFilterMacro.FindStringSubmatch(match)
+		attr := strings.Trim(args[1], "'\"`")
+		if attr == "" {
+			return fail(fmt.Errorf("%s: attribute name is required", match))
+		}
+
+		format := args[2]
+		if format == "" {
+			format = datetimeAttributes[attr]
+		}
+
+		fromValue, err := formatMacroTime(from, format)
+		if err != nil {
+			return fail(fmt.Errorf("%s: %w", match, err))
+		}
+		toValue, err := formatMacroTime(to, format)
+		if err != nil {
+			return fail(fmt.Errorf("%s: %w", match, err))
+		}
+
+		return fmt.Sprintf("%s BETWEEN %s AND %s", quoteIdentifier(attr), fromValue, toValue)
+	})
+
+	query = timeFromMacro.ReplaceAllStringFunc(query, func(match string) string {
+		value, err := formatMacroTime(from, timeFromMacro.FindStringSubmatch(match)[1])
+		if err != nil {
+			return fail(fmt.Errorf("%s: %w", match, err))
+		}
+		return value
+	})
+
+	query = timeToMacro.ReplaceAllStringFunc(query, func(match string) string {
+		value, err := formatMacroTime(to, timeToMacro.FindStringSubmatch(match)[1])
+		if err != nil {
+			return fail(fmt.Errorf("%s: %w", match, err))
+		}
+		return value
+	})
+
+	// $__interval_ms must be replaced before $__interval, which is its prefix.
+	query = intervalMsMacro.ReplaceAllString(query, strconv.FormatInt(interval.Milliseconds(), 10))
+	query = intervalMacro.ReplaceAllString(query, gtime.FormatInterval(interval))
+	query = legacyFromMacro.ReplaceAllString(query, strconv.FormatInt(from.Unix(), 10))
+	query = legacyToMacro.ReplaceAllString(query, strconv.FormatInt(to.Unix(), 10))
+
+	if expandErr != nil {
+		return "", expandErr
+	}
+	return query, nil
+}
+
+// formatMacroTime renders t as a PartiQL literal in the given format. The format is either
+// a macro format (s, ms, iso), a DatetimeAttribute format or a Go time layout.
+func formatMacroTime(t time.Time, format string) (string, error) {
+	format = strings.Trim(strings.TrimSpace(format), "'\"")
+	switch strings.ToLower(format) {
+	case "", macroFormatSeconds, UnixTimestampSeconds:
+		return strconv.FormatInt(t.Unix(), 10), nil
+	case macroFormatMilliseconds, UnixTimestampMiniseconds:
+		return strconv.FormatInt(t.UnixMilli(), 10), nil
+	case macroFormatISO:
+		return quoteStringLiteral(t.UTC().Format(time.RFC3339)), nil
+	}
+
+	// Anything else must be a Go layout, as resolved from the custom datetime format of the query.
+	formatted := t.UTC().Format(format)
+	if formatted == format {
+		return "", fmt.Errorf("unsupported time format %q", format)
+	}
+	return quoteStringLiteral(formatted), nil
+}
+
+func quoteStringLiteral(value string) string {
+	return fmt.Sprintf("'%s'", strings.ReplaceAll(value, "'", "''"))
+}
+
+// dayjsLayouts maps the dayjs format tokens that have a Go equivalent to their Go layout.
+var dayjsLayouts = map[string]string{
+	"YYYY": "2006", "YY": "06",
+	"MMMM": "January", "MMM": "Jan", "MM": "01", "M": "1",
+	"DD": "02", "D": "2",
+	"dddd": "Monday", "ddd": "Mon",
+	"HH": "15", "H": "15", "hh": "03", "h": "3",
+	"mm": "04", "m": "4",
+	"ss": "05", "s": "5",
+	"SSS": "000", "A": "PM", "a": "pm",
+	"ZZ": "-0700", "Z": "-07:00", "z": "MST",
+}
+
+// dayjsToken matches bracketed literals and the format tokens of dayjs with its advancedFormat
+// plugin, the same way dayjs splits a format.
+var dayjsToken = regexp.MustCompile(`\[([^\]]*)]|Y{1,4}|M{1,4}|Do|D{1,2}|d{1,4}|H{1,2}|h{1,2}|k{1,2}|a|A|m{1,2}|s{1,2}|Z{1,2}|SSS|Q|wo|w{1,2}|gggg|GGGG|X|x|zzz|z`)
+
+// DayjsLayout converts a custom datetime format, written in dayjs syntax in the query editor,
+// to a Go time layout. Formats that already contain the Go reference year are returned
+// unchanged, since older frontends converted them before sending the query.
+func DayjsLayout(format string) (string, error) {
+	if format == UnixTimestampSeconds || format == UnixTimestampMiniseconds || (strings.Contains(format, "2006") && !strings.Contains(format, "[")) {
+		return format, nil
+	}
+	var unsupported string
+	layout := dayjsToken.ReplaceAllStringFunc(format, func(token string) string {
+		if strings.HasPrefix(token, "[") {
+			return token[1 : len(token)-1]
+		}
+		if layout, ok := dayjsLayouts[token]; ok {
+			return layout
+		}
+		if unsupported == "" {
+			unsupported = token
+		}
+		return token
+	})
+	if unsupported != "" {
+		return "", fmt.Errorf("datetime format %q: token %q is not supported", format, unsupported)
+	}
+	return layout, nil
+}
+
+// resolveDatetimeLayouts converts the custom datetime formats of the query to Go layouts, so
+// panels, alerts and API calls read and filter datetime attributes the same way.
+func (qm *QueryModel) resolveDatetimeLayouts() error {
+	for i, attribute := range qm.DatetimeAttributes {
+		layout, err := DayjsLayout(attribute.Format)
+		if err != nil {
+			return fmt.Errorf("datetime attribute %q: %w", attribute.Name, err)
+		}
+		qm.DatetimeAttributes[i].Format = layout
+	}
+	return nil
+}
@@ -0,0 +1,455 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// NativeQueryModel is a structured read run with the DynamoDB Query API when the partition
+// key is given, or with the Scan API otherwise. Query results are ordered by the sort key
+// according to QueryModel.ScanIndexForward without rewriting any statement.
+type NativeQueryModel struct {
+	Table          string            `json:"table"`
+	Index          string            `json:"index,omitempty"`
+	PartitionKey   *NativeCondition  `json:"partitionKey,omitempty"` // equality on the partition key; without it the table is scanned
+	SortKey        *NativeCondition  `json:"sortKey,omitempty"`      // range condition on the sort key; requires partitionKey
+	Filters        []NativeCondition `json:"filters,omitempty"`      // applied by DynamoDB after the key condition, all must match
+	Projection     []string          `json:"projection,omitempty"`   // top-level attributes to return; empty returns all
+	ConsistentRead bool              `json:"consistentRead,omitempty"`
+	// ExclusiveStartKey resumes after the lastEvaluatedKey reported by a previous result, in
+	// DynamoDB JSON
+	ExclusiveStartKey AttributeMap `json:"exclusiveStartKey,omitempty"`
+}
+
+// NativeCondition compares an attribute with its values. Values may use the time macros,
+// e.g. "$__timeFrom(ms)".
+type NativeCondition struct {
+	Attribute string   `json:"attribute"`
+	Operator  string   `json:"operator,omitempty"` // =, <>, <, <=, >, >=, between, begins_with, contains, in, exists, not_exists; defaults to =
+	Values    []string `json:"values,omitempty"`
+	Type      string   `json:"type,omitempty"` // "S" (default), "N" or "BOOL"
+}
+
+// NativeRequest is the request built from a NativeQueryModel; exactly one of Query and Scan
+// is set.
+type NativeRequest struct {
+	Query *dynamodb.QueryInput
+	Scan  *dynamodb.ScanInput
+}
+
+const (
+	nativeOperatorBetween    = "between"
+	nativeOperatorBeginsWith = "begins_with"
+	nativeOperatorContains   = "contains"
+	nativeOperatorIn         = "in"
+	nativeOperatorExists     = "exists"
+	nativeOperatorNotExists  = "not_exists"
+
+	maxNativeInValues = 100
+)
+
+var sortKeyOperators = map[string]bool{
+	"=": true, "<": true, "<=": true, ">": true, ">=": true,
+	nativeOperatorBetween: true, nativeOperatorBeginsWith: true,
+}
+
+func (c NativeCondition) operator() string {
+	op := strings.ToLower(strings.TrimSpace(c.Operator))
+	switch op {
+	case "":
+		return "="
+	case "!=":
+		return "<>"
+	}
+	return op
+}
+
+// expressionBuilder collects the placeholders of one request's expressions.
+type expressionBuilder struct {
+	names  map[string]*string
+	values map[string]*dynamodb.AttributeValue
+	byName map[string]string
+}
+
+func newExpressionBuilder() *expressionBuilder {
+	return &expressionBuilder{
+		names:  map[string]*string{},
+		values: map[string]*dynamodb.AttributeValue{},
+		byName: map[string]string{},
+	}
+}
+
+func (b *expressionBuilder) name(attribute string) string {
+	if placeholder, ok := b.byName[attribute]; ok {
+		return placeholder
+	}
+	placeholder := fmt.Sprintf("#n%d", len(b.byName))
+	b.byName[attribute] = placeholder
+	b.names[placeholder] = aws.String(attribute)
+	return placeholder
+}
+
+func (b *expressionBuilder) value(av *dynamodb.AttributeValue) string {
+	placeholder := fmt.Sprintf(":v%d", len(b.values))
+	b.values[placeholder] = av
+	return placeholder
+}
+
+// condition renders c as a key condition or filter expression.
+func (b *expressionBuilder) condition(c NativeCondition) (string, error) {
+	if strings.TrimSpace(c.Attribute) == "" {
+		return "", fmt.Errorf("attribute is required")
+	}
+	op := c.operator()
+
+	wantValues := 1
+	switch op {
+	case "=", "<>", "<", "<=", ">", ">=", nativeOperatorBeginsWith, nativeOperatorContains:
+	case nativeOperatorBetween:
+		wantValues = 2
+	case nativeOperatorExists, nativeOperatorNotExists:
+		wantValues = 0
+	case nativeOperatorIn:
+		if len(c.Values) == 0 || len(c.Values) > maxNativeInValues {
+			return "", fmt.Errorf("%s in needs 1 to %d values", c.Attribute, maxNativeInValues)
+		}
+		wantValues = len(c.Values)
+	default:
+		return "", fmt.Errorf("unsupported operator %q", c.Operator)
+	}
+	if len(c.Values) != wantValues {
+		return "", fmt.Errorf("%s %s needs %d value(s), got %d", c.Attribute, op, wantValues, len(c.Values))
+	}
+
+	placeholders := make([]string, len(c.Values))
+	for i, v := range c.Values {
+		av, err := nativeAttributeValue(v, c.Type)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", c.Attribute, err)
+		}
+		placeholders[i] = b.value(av)
+	}
+
+	name := b.name(c.Attribute)
+	switch op {
+	case nativeOperatorBetween:
+		return fmt.Sprintf("%s BETWEEN %s AND %s", name, placeholders[0], placeholders[1]), nil
+	case nativeOperatorBeginsWith, nativeOperatorContains:
+		return fmt.Sprintf("%s(%s, %s)", op, name, placeholders[0]), nil
+	case nativeOperatorIn:
+		return fmt.Sprintf("%s IN (%s)", name, strings.Join(placeholders, ", ")), nil
+	case nativeOperatorExists:
+		return fmt.Sprintf("attribute_exists(%s)", name), nil
+	case nativeOperatorNotExists:
+		return fmt.Sprintf("attribute_not_exists(%s)", name), nil
+	}
+	return fmt.Sprintf("%s %s %s", name, op, placeholders[0]), nil
+}
+
+func nativeAttributeValue(value, typ string) (*dynamodb.AttributeValue, error) {
+	switch strings.ToUpper(typ) {
+	case "", "S":
+		return &dynamodb.AttributeValue{S: aws.String(value)}, nil
+	case "N":
+		if _, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err != nil {
+			return nil, fmt.Errorf("%q is not a number", value)
+		}
+		return &dynamodb.AttributeValue{N: aws.String(strings.TrimSpace(value))}, nil
+	case "BOOL":
+		b, err := strconv.ParseBool(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a boolean", value)
+		}
+		return &dynamodb.AttributeValue{BOOL: aws.Bool(b)}, nil
+	}
+	return nil, fmt.Errorf("unsupported value type %q", typ)
+}
+
+// BuildNativeRequest turns a native query into a Query request when the partition key is
+// given and a Scan request otherwise. limit is the page size; 0 leaves it to DynamoDB.
+func BuildNativeRequest(model NativeQueryModel, scanIndexForward *bool, limit int64) (NativeRequest, error) {
+	if strings.TrimSpace(model.Table) == "" {
+		return NativeRequest{}, fmt.Errorf("native.table is required")
+	}
+	if model.SortKey != nil && model.PartitionKey == nil {
+		return NativeRequest{}, fmt.Errorf("native.sortKey requires a partitionKey")
+	}
+
+	b := newExpressionBuilder()
+
+	var keyCondition string
+	if model.PartitionKey != nil {
+		if model.PartitionKey.operator() != "=" {
+			return NativeRequest{}, fmt.Errorf("native.partitionKey only supports =")
+		}
+		expr, err := b.condition(*model.PartitionKey)
+		if err != nil {
+			return NativeRequest{}, fmt.Errorf("native.partitionKey: %w", err)
+		}
+		keyCondition = expr
+		if model.SortKey != nil {
+			if !sortKeyOperators[model.SortKey.operator()] {
+				return NativeRequest{}, fmt.Errorf("native.sortKey does not support %q", model.SortKey.Operator)
+			}
+			expr, err := b.condition(*model.SortKey)
+			if err != nil {
+				return NativeRequest{}, fmt.Errorf("native.sortKey: %w", err)
+			}
+			keyCondition += " AND " + expr
+		}
+	}
+
+	filters := make([]string, 0, len(model.Filters))
+	for i, f := range model.Filters {
+		expr, err := b.condition(f)
+		if err != nil {
+			return NativeRequest{}, fmt.Errorf("native.filters[%d]: %w", i, err)
+		}
+		filters = append(filters, expr)
+	}
+
+	projection := make([]string, 0, len(model.Projection))
+	for _, attribute := range model.Projection {
+		if attribute = strings.TrimSpace(attribute); attribute != "" {
+			projection = append(projection, b.name(attribute))
+		}
+	}
+
+	var startKey map[string]*dynamodb.AttributeValue
+	if len(model.ExclusiveStartKey) > 0 {
+		startKey = model.ExclusiveStartKey
+	}
+	for name, value := range startKey {
+		if value == nil {
+			return NativeRequest{}, fmt.Errorf("native.exclusiveStartKey: attribute %q has no value", name)
+		}
+	}
+
+	var (
+		index    *string
+		filter   *string
+		proj     *string
+		names    map[string]*string
+		values   map[string]*dynamodb.AttributeValue
+		pageSize *int64
+	)
+	if model.Index != "" {
+		index = aws.String(model.Index)
+	}
+	if len(filters) > 0 {
+		filter = aws.String(strings.Join(filters, " AND "))
+	}
+	if len(projection) > 0 {
+		proj = aws.String(strings.Join(projection, ", "))
+	}
+	if len(b.names) > 0 {
+		names = b.names
+	}
+	if len(b.values) > 0 {
+		values = b.values
+	}
+	if limit > 0 {
+		pageSize = aws.Int64(limit)
+	}
+	if len(startKey) == 0 {
+		startKey = nil
+	}
+
+	if keyCondition == "" {
+		return NativeRequest{Scan: &dynamodb.ScanInput{
+			TableName:                 aws.String(model.Table),
+			IndexName:                 index,
+			FilterExpression:          filter,
+			ProjectionExpression:      proj,
+			ExpressionAttributeNames:  names,
+			ExpressionAttributeValues: values,
+			ConsistentRead:            aws.Bool(model.ConsistentRead),
+			ExclusiveStartKey:         startKey,
+			Limit:                     pageSize,
+			ReturnConsumedCapacity:    aws.String(dynamodb.ReturnConsumedCapacityTotal),
+		}}, nil
+	}
+
+	forward := true
+	if scanIndexForward != nil {
+		forward = *scanIndexForward
+	}
+	return NativeRequest{Query: &dynamodb.QueryInput{
+		TableName:                 aws.String(model.Table),
+		IndexName:                 index,
+		KeyConditionExpression:    aws.String(keyCondition),
+		FilterExpression:          filter,
+		ProjectionExpression:      proj,
+		ExpressionAttributeNames:  names,
+		ExpressionAttributeValues: values,
+		ConsistentRead:            aws.Bool(model.ConsistentRead),
+		ExclusiveStartKey:         startKey,
+		ScanIndexForward:          aws.Bool(forward),
+		Limit:                     pageSize,
+		ReturnConsumedCapacity:    aws.String(dynamodb.ReturnConsumedCapacityTotal),
+	}}, nil
+}
+
+// interpolate expands time macros in the condition values. A value that expands to a quoted
+// literal, such as $__timeFrom(iso), is unquoted since native values are not PartiQL.
+func (m *NativeQueryModel) interpolate(timeRange backend.TimeRange, interval time.Duration) error {
+	expand := func(c *NativeCondition) error {
+		for i, v := range c.Values {
+			expanded, err := InterpolateMacros(v, timeRange, interval, nil)
+			if err != nil {
+				return err
+			}
+			if expanded != v && len(expanded) >= 2 && strings.HasPrefix(expanded, "'") && strings.HasSuffix(expanded, "'") {
+				expanded = strings.ReplaceAll(expanded[1:len(expanded)-1], "''", "'")
+			}
+			c.Values[i] = expanded
+		}
+		return nil
+	}
+	if m.PartitionKey != nil {
+		if err := expand(m.PartitionKey); err != nil {
+			return err
+		}
+	}
+	if m.SortKey != nil {
+		if err := expand(m.SortKey); err != nil {
+			return err
+		}
+	}
+	for i := range m.Filters {
+		if err := expand(&m.Filters[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// describe renders the request for the query inspector.
+func (r NativeRequest) describe() string {
+	if r.Query != nil {
+		return r.Query.String()
+	}
+	return r.Scan.String()
+}
+
+// readNative pages through a native request. Reads stopped early report the
+// LastEvaluatedKey to resume from; each page asks for no more items than are still wanted,
+// so the key never skips items.
+func (d *Datasource) readNative(ctx context.Context, client *dynamodb.DynamoDB, request NativeRequest, userLimit int64, limits QueryLimits, orgID int64, stats *RetryStats) (pageRead, error) {
+	var lastKey map[string]*dynamodb.AttributeValue
+	operation := "runs Scan"
+	fetch := func(remaining int64) (pageOutput, error) {
+		var out pageOutput
+		if request.Query != nil {
+			request.Query.Limit = pageLimit(request.Query.Limit, remaining)
+			output, err := client.QueryWithContext(ctx, request.Query)
+			if err != nil {
+				return out, err
+			}
+			lastKey = output.LastEvaluatedKey
+			request.Query.ExclusiveStartKey = output.LastEvaluatedKey
+			out = pageOutput{items: output.Items, consumed: output.ConsumedCapacity}
+		} else {
+			request.Scan.Limit = pageLimit(request.Scan.Limit, remaining)
+			output, err := client.ScanWithContext(ctx, request.Scan)
+			if err != nil {
+				return out, err
+			}
+			lastKey = output.LastEvaluatedKey
+			request.Scan.ExclusiveStartKey = output.LastEvaluatedKey
+			out = pageOutput{items: output.Items, consumed: output.ConsumedCapacity}
+		}
+		out.more = len(lastKey) > 0
+		return out, nil
+	}
+	if request.Query != nil {
+		operation = "runs Query"
+	}
+
+	read, err := d.readPages(ctx, operation, userLimit, limits, orgID, stats, fetch)
+	if len(lastKey) > 0 {
+		read.lastEvaluatedKey = lastKey
+	}
+	return read, err
+}
+
+// pageLimit caps a page size at the number of items still wanted.
+func pageLimit(current *int64, remaining int64) *int64 {
+	if remaining <= 0 || (current != nil && *current <= remaining) {
+		return current
+	}
+	return aws.Int64(remaining)
+}
+
+// nativeParallelScan reports whether a native request can run as a parallel scan: a Scan
+// that reads to the end, starting from the beginning of the table.
+func (d *Datasource) nativeParallelScan(request NativeRequest, userLimit int64) (int, bool) {
+	segments := d.parallelism.withDefaults().ScanSegments
+	if request.Scan == nil || segments <= 1 || userLimit > 0 || request.Scan.ExclusiveStartKey != nil {
+		return 0, false
+	}
+	return segments, true
+}
+
+// analyzeNativeRequest applies the scan guard to a native request. A Query needs no
+// analysis; a Scan is reported, or refused when the table or index is larger than
+// maxScanSizeMB and the query does not allow full scans.
+func (d *Datasource) analyzeNativeRequest(ctx context.Context, client *dynamodb.DynamoDB, target AWSTarget, model NativeQueryModel, request NativeRequest, allowFullScan bool) (*StatementAnalysis, []data.Notice, error) {
+	analysis := &StatementAnalysis{Table: model.Table, Index: model.Index, Access: AccessQuery, Reason: "partition key is compared with ="}
+	if request.Query != nil || d.scanGuard.Disabled {
+		return analysis, nil, nil
+	}
+
+	analysis.Access = AccessScan
+	analysis.Reason = "no partition key condition"
+	meta, err := d.tableMetadata(ctx, client, target, model.Table)
+	if err != nil {
+		backend.Logger.Warn("Failed to describe table for scan analysis", "table", model.Table, "error", err.Error())
+		return analysis, nil, nil
+	}
+	analysis.ItemCount, analysis.SizeBytes = meta.ItemCount, meta.SizeBytes
+	for _, index := range meta.Indexes {
+		if strings.EqualFold(index.Name, model.Index) {
+			analysis.Index = index.Name
+			analysis.ItemCount, analysis.SizeBytes = index.ItemCount, index.SizeBytes
+		}
+	}
+	analysis.EstimatedReadUnits = scanReadUnits(analysis.SizeBytes)
+
+	scanned := fmt.Sprintf("table %q", analysis.Table)
+	if analysis.Index != "" {
+		scanned = fmt.Sprintf("index %q of table %q", analysis.Index, analysis.Table)
+	}
+	if limit := d.scanGuard.MaxScanSizeMB; limit > 0 && analysis.SizeBytes > limit*1024*1024 && !allowFullScan {
+		return analysis, nil, fmt.Errorf("refusing full scan of %s (%s, %d items): larger than maxScanSizeMB %d; set native.partitionKey, or set allowFullScan on the query", scanned, formatBytes(analysis.SizeBytes), analysis.ItemCount, limit)
+	}
+	text := fmt.Sprintf("Full scan of %s (%s, %d items), estimated %.1f read capacity units: %s", scanned, formatBytes(analysis.SizeBytes), analysis.ItemCount, analysis.EstimatedReadUnits, analysis.Reason)
+	return analysis, []data.Notice{{Severity: data.NoticeSeverityWarning, Text: text}}, nil
+}
+
+// appendNativeMeta records the executed request in the frames and, when reading stopped
+// before the end, the lastEvaluatedKey to pass as exclusiveStartKey to read on. The key is in
+// DynamoDB JSON so binary and numeric key attributes come back unchanged.
+func appendNativeMeta(frames []*data.Frame, request NativeRequest, lastKey map[string]*dynamodb.AttributeValue) {
+	var custom map[string]interface{}
+	if len(lastKey) > 0 {
+		custom = map[string]interface{}{"lastEvaluatedKey": AttributeMap(lastKey)}
+	}
+	for _, frame := range frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.ExecutedQueryString = request.describe()
+		if custom != nil {
+			frame.Meta.Custom = custom
+		}
+	}
+}
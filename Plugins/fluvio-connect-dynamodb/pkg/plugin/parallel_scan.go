@@ -0,0 +1,306 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// ParallelismSettings controls how much of a query request runs concurrently.
+type ParallelismSettings struct {
+	MaxConcurrentQueries int `json:"maxConcurrentQueries,omitempty"` // queries of one request run at once; defaults to 4
+	ScanSegments         int `json:"scanSegments,omitempty"`         // segments of full-table scans; defaults to 1, parallel scans need more than 1
+}
+
+const (
+	defaultMaxConcurrentQueries = 4
+	maxConcurrentQueries        = 32
+	defaultScanSegments         = 1
+	maxScanSegments             = 64
+)
+
+func (p ParallelismSettings) withDefaults() ParallelismSettings {
+	switch {
+	case p.MaxConcurrentQueries <= 0:
+		p.MaxConcurrentQueries = defaultMaxConcurrentQueries
+	case p.MaxConcurrentQueries > maxConcurrentQueries:
+		p.MaxConcurrentQueries = maxConcurrentQueries
+	}
+	switch {
+	case p.ScanSegments <= 0:
+		p.ScanSegments = defaultScanSegments
+	case p.ScanSegments > maxScanSegments:
+		p.ScanSegments = maxScanSegments
+	}
+	return p
+}
+
+// FullTableRead is a statement that reads every item of a table or index and can therefore
+// run as a segmented Scan instead of a serial PartiQL scan.
+type FullTableRead struct {
+	Table      string
+	Index      string
+	Attributes []string // projected attributes; empty selects all of them
+	Segments   int
+}
+
+var (
+	fullTableSelect     = regexp.MustCompile(`(?is)^\s*SELECT\s+(.+?)\s+FROM\s+("[^"]+"|[A-Za-z0-9_-]+)(?:\s*\.\s*("[^"]+"|[A-Za-z0-9_-]+))?\s*;?\s*$`)
+	plainAttributeName  = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	quotedAttributeName = regexp.MustCompile(`^"([^"]+)"$`)
+)
+
+// ParseFullTableRead recognizes a SELECT of all items of a table or index: a star or a list
+// of top-level attributes, and no WHERE, ORDER BY or other clause. Anything else is left to
+// PartiQL.
+func ParseFullTableRead(statement string) (FullTableRead, bool) {
+	matches := fullTableSelect.FindStringSubmatch(statement)
+	if matches == nil {
+		return FullTableRead{}, false
+	}
+	read := FullTableRead{
+		Table: strings.Trim(matches[2], `"`),
+		Index: strings.Trim(matches[3], `"`),
+	}
+
+	projection := strings.TrimSpace(matches[1])
+	if projection == "*" {
+		return read, true
+	}
+	for _, name := range strings.Split(projection, ",") {
+		name = strings.TrimSpace(name)
+		if m := quotedAttributeName.FindStringSubmatch(name); m != nil {
+			read.Attributes = append(read.Attributes, m[1])
+			continue
+		}
+		if !plainAttributeName.MatchString(name) {
+			// Nested paths, functions and keywords such as DISTINCT are PartiQL's job
+			return FullTableRead{}, false
+		}
+		read.Attributes = append(read.Attributes, name)
+	}
+	return read, true
+}
+
+// ScanInput builds the Scan request shared by all segments.
+func (r FullTableRead) ScanInput() *dynamodb.ScanInput {
+	input := &dynamodb.ScanInput{
+		TableName:              aws.String(r.Table),
+		ReturnConsumedCapacity: aws.String(dynamodb.ReturnConsumedCapacityTotal),
+	}
+	if r.Index != "" {
+		input.IndexName = aws.String(r.Index)
+	}
+	if len(r.Attributes) > 0 {
+		names := make(map[string]*string, len(r.Attributes))
+		placeholders := make([]string, len(r.Attributes))
+		for i, name := range r.Attributes {
+			placeholder := fmt.Sprintf("#p%d", i)
+			names[placeholder] = aws.String(name)
+			placeholders[i] = placeholder
+		}
+		input.ProjectionExpression = aws.String(strings.Join(placeholders, ", "))
+		input.ExpressionAttributeNames = names
+	}
+	return input
+}
+
+// parallelScanPlan decides whether a statement runs as a segmented Scan. Reads with a user
+// limit stay serial, since a parallel scan cannot stop at the first n items in table order.
+func (d *Datasource) parallelScanPlan(statement string, qm QueryModel) (FullTableRead, bool) {
+	segments := d.parallelism.withDefaults().ScanSegments
+	if segments <= 1 || qm.Limit > 0 {
+		return FullTableRead{}, false
+	}
+	read, ok := ParseFullTableRead(statement)
+	if !ok {
+		return FullTableRead{}, false
+	}
+	read.Segments = segments
+	return read, true
+}
+
+// scanProgress is the state shared by the segments of one parallel scan. The query limits
+// apply to the scan as a whole.
+type scanProgress struct {
+	mu       sync.Mutex
+	limits   QueryLimits
+	segments [][]map[string]*dynamodb.AttributeValue
+	active   int
+	pages    int
+	items    int
+	units    float64
+	stopped  string // why reading stopped before the last page
+	ctxErr   error  // set when the stop was caused by cancellation or the time limit
+}
+
+func (p *scanProgress) stopContext(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped != "" {
+		return
+	}
+	p.ctxErr = err
+	if errors.Is(err, context.DeadlineExceeded) {
+		p.stopped = fmt.Sprintf("the query reached its time limit of %s", p.limits.maxDuration())
+	} else {
+		p.stopped = fmt.Sprintf("the query was cancelled: %v", err)
+	}
+}
+
+// startPage reserves the next page, or returns false when a limit stops reading.
+func (p *scanProgress) startPage(budget *ReadBudget, orgID int64) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case p.stopped != "":
+		return false
+	case p.pages >= p.limits.MaxPages:
+		p.stopped = fmt.Sprintf("the query reached its limit of %d page(s)", p.limits.MaxPages)
+		return false
+	case p.pages > 0 && budget.Exhausted(orgID):
+		p.stopped = "the org's read capacity budget is used up"
+		return false
+	}
+	p.pages++
+	return true
+}
+
+// addPage stores the items of a page and returns false once the item limit is reached.
+func (p *scanProgress) addPage(segment int, items []map[string]*dynamodb.AttributeValue, units float64, more bool) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.segments[segment] = append(p.segments[segment], items...)
+	p.items += len(items)
+	p.units += units
+	if p.items < p.limits.MaxItems {
+		return true
+	}
+	if p.stopped == "" && (p.items > p.limits.MaxItems || more || p.active > 1) {
+		p.stopped = fmt.Sprintf("the query reached its limit of %d item(s)", p.limits.MaxItems)
+	}
+	return false
+}
+
+func (p *scanProgress) finish() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.active--
+}
+
+// parallelScan runs base with one Scan worker per segment. The workers share the query
+// limits, the rate limiter and the read budget; items are returned in segment order.
+func (d *Datasource) parallelScan(ctx context.Context, client *dynamodb.DynamoDB, base *dynamodb.ScanInput, segments int, limits QueryLimits, orgID int64, stats *RetryStats) (pageRead, error) {
+	ctx, cancel := context.WithTimeout(ctx, limits.maxDuration())
+	defer cancel()
+
+	progress := &scanProgress{
+		limits:   limits,
+		segments: make([][]map[string]*dynamodb.AttributeValue, segments),
+		active:   segments,
+	}
+	segmentErrs := make([]error, segments)
+
+	var wg sync.WaitGroup
+	for segment := 0; segment < segments; segment++ {
+		wg.Add(1)
+		go func(segment int) {
+			defer wg.Done()
+			defer progress.finish()
+			segmentErrs[segment] = d.scanSegment(ctx, cancel, client, base, segment, segments, progress, orgID, stats)
+		}(segment)
+	}
+	wg.Wait()
+
+	read := pageRead{
+		pages:         progress.pages,
+		consumedUnits: progress.units,
+		truncatedBy:   progress.stopped,
+	}
+	for _, items := range progress.segments {
+		read.items = append(read.items, items...)
+	}
+	if len(read.items) > limits.MaxItems {
+		read.items = read.items[:limits.MaxItems]
+	}
+
+	for segment, err := range segmentErrs {
+		if err == nil {
+			continue
+		}
+		backend.Logger.Error("Scan segment error", "error", err.Error(), "segment", segment)
+		if len(read.items) == 0 {
+			return read, fmt.Errorf("scans table: %v", err.Error())
+		}
+		if read.truncatedBy == "" {
+			read.truncatedBy = fmt.Sprintf("segment %d failed: %v", segment, err)
+		}
+	}
+	if len(read.items) == 0 && progress.ctxErr != nil {
+		if errors.Is(progress.ctxErr, context.DeadlineExceeded) {
+			return read, fmt.Errorf("query exceeded maximum duration of %s", limits.maxDuration())
+		}
+		return read, fmt.Errorf("query cancelled: %v", progress.ctxErr)
+	}
+
+	backend.Logger.Info("Parallel scan complete", "segments", segments, "totalPages", read.pages, "totalItems", len(read.items))
+	return read, nil
+}
+
+// scanSegment pages through one segment. It returns an error only when a page fails for a
+// reason other than the scan being stopped.
+func (d *Datasource) scanSegment(ctx context.Context, cancel context.CancelFunc, client *dynamodb.DynamoDB, base *dynamodb.ScanInput, segment, segments int, progress *scanProgress, orgID int64, stats *RetryStats) error {
+	input := *base
+	input.Segment = aws.Int64(int64(segment))
+	input.TotalSegments = aws.Int64(int64(segments))
+
+	for {
+		if err := ctx.Err(); err != nil {
+			progress.stopContext(err)
+			return nil
+		}
+		if !progress.startPage(d.readBudget, orgID) {
+			return nil
+		}
+
+		// Each page is reserved as one read unit and settled with the consumed capacity
+		if err := d.readLimiter.Wait(ctx, 1); err != nil {
+			progress.stopContext(err)
+			return nil
+		}
+		output, err := CallWithRetry(ctx, d.retrySettings, stats, func() (*dynamodb.ScanOutput, error) {
+			return client.ScanWithContext(ctx, &input)
+		})
+		units := 0.0
+		if output != nil {
+			units = capacityUnits(1, output.ConsumedCapacity)
+			d.readLimiter.Settle(1, units)
+			d.readBudget.Record(orgID, units)
+		}
+		if err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				progress.stopContext(ctxErr)
+				return nil
+			}
+			return err
+		}
+
+		more := len(output.LastEvaluatedKey) > 0
+		if !progress.addPage(segment, output.Items, units, more) {
+			// The item limit applies to all segments, so the others stop too
+			cancel()
+			return nil
+		}
+		if !more {
+			return nil
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
@@ -0,0 +1,78 @@
+package plugin
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// errUploadAccessDenied is returned when the requesting user may not use a preset.
+var errUploadAccessDenied = errors.New("access denied")
+
+// orgRoleRank orders the Grafana org roles; a role grants access to presets allowed for lower roles.
+var orgRoleRank = map[string]int{
+	"none":   0,
+	"viewer": 1,
+	"editor": 2,
+	"admin":  3,
+}
+
+// allows reports whether user may see and use the preset. Team membership comes from the
+// datasource settings because plugin requests do not carry the user's teams.
+func (p UploadPreset) allows(user *backend.User, teams map[string][]string) bool {
+	access := p.Access
+	if access == nil || (len(access.Roles) == 0 && len(access.Teams) == 0 && len(access.Users) == 0) {
+		return true
+	}
+	if user == nil {
+		return false
+	}
+
+	if rank, ok := orgRoleRank[strings.ToLower(user.Role)]; ok {
+		for _, role := range access.Roles {
+			if required, ok := orgRoleRank[strings.ToLower(role)]; ok && rank >= required {
+				return true
+			}
+		}
+	}
+
+	for _, allowed := range access.Users {
+		if matchesUser(user, allowed) {
+			return true
+		}
+	}
+
+	for _, team := range access.Teams {
+		for name, members := range teams {
+			if !strings.EqualFold(name, team) {
+				continue
+			}
+			for _, member := range members {
+				if matchesUser(user, member) {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+func matchesUser(user *backend.User, login string) bool {
+	login = strings.TrimSpace(login)
+	return login != "" && (strings.EqualFold(user.Login, login) || strings.EqualFold(user.Email, login))
+}
+
+// isOrgAdmin reports whether the user has the Admin org role, required to manage presets.
+func isOrgAdmin(user *backend.User) bool {
+	return user != nil && strings.EqualFold(user.Role, "Admin")
+}
+
+// userLogin returns the login used in logs for the requesting user.
+func userLogin(user *backend.User) string {
+	if user == nil {
+		return "anonymous"
+	}
+	return user.Login
+}
@@ -3,12 +3,15 @@ package plugin
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 )
@@ -23,134 +26,224 @@ func getPresetsDir() string {
 	return filepath.Join(dataDir, "dynamodb-presets")
 }
 
-// ensurePresetsDir creates the presets directory if it doesn't exist
-func ensurePresetsDir() error {
-	dir := getPresetsDir()
-	return os.MkdirAll(dir, 0755)
+// storedPresetResponse is a preset with the metadata of its version.
+type storedPresetResponse struct {
+	UploadPreset
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	UpdatedBy string    `json:"updatedBy,omitempty"`
 }
 
-// getPresetFilePath returns the file path for a preset
-func getPresetFilePath(presetID string) string {
-	// Sanitize preset ID to prevent path traversal
-	sanitized := strings.ReplaceAll(presetID, "..", "")
-	sanitized = strings.ReplaceAll(sanitized, "/", "_")
-	sanitized = strings.ReplaceAll(sanitized, "\\", "_")
-	return filepath.Join(getPresetsDir(), sanitized+".json")
+type storedPresetSummary struct {
+	uploadPresetSummary
+	Version   int       `json:"version"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	UpdatedBy string    `json:"updatedBy,omitempty"`
 }
 
-// handleListPresets lists all saved presets
-func (d *Datasource) handleListPresets(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
-	backend.Logger.Info("Listing presets")
+func storedPresetFrom(v presetVersion) storedPresetResponse {
+	return storedPresetResponse{UploadPreset: v.Preset, Version: v.Version, UpdatedAt: v.UpdatedAt, UpdatedBy: v.UpdatedBy}
+}
 
-	if err := ensurePresetsDir(); err != nil {
-		backend.Logger.Error("Failed to create presets directory", "error", err.Error())
+// handlePresetResource routes the saved preset endpoints:
+//
+//	GET    presets                     list presets
+//	POST   presets                     create or update a preset
+//	GET    presets/{id}                get the current version
+//	DELETE presets/{id}                delete a preset
+//	GET    presets/{id}/versions       version history
+//	GET    presets/{id}/versions/{n}   one version
+//	GET    presets/{id}/diff           changes between two versions
+//	POST   presets/{id}/rollback       save an earlier version as the current one
+//	POST   presets/_legacy/import      import presets saved by earlier plugin versions
+func (d *Datasource) handlePresetResource(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	extraSettings, err := loadExtraPluginSettings(*req.PluginContext.DataSourceInstanceSettings)
+	if err != nil {
 		return sender.Send(&backend.CallResourceResponse{
 			Status: http.StatusInternalServerError,
-			Body:   []byte(fmt.Sprintf(`{"error": "failed to create presets directory: %s"}`, err.Error())),
+			Body:   []byte(fmt.Sprintf(`{"error": "failed to load settings: %s"}`, sanitizeError(err))),
 		})
 	}
 
-	files, err := ioutil.ReadDir(getPresetsDir())
+	store, err := d.presetStoreFor(ctx, req, extraSettings)
 	if err != nil {
-		backend.Logger.Error("Failed to read presets directory", "error", err.Error())
 		return sender.Send(&backend.CallResourceResponse{
 			Status: http.StatusInternalServerError,
-			Body:   []byte(fmt.Sprintf(`{"error": "failed to read presets: %s"}`, err.Error())),
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
 		})
 	}
-
-	var presets []uploadPresetSummary
-	for _, file := range files {
-		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
-			continue
+	scope := presetScopeOf(req)
+
+	if req.Path == "presets" {
+		switch req.Method {
+		case http.MethodGet:
+			return d.handleListPresets(ctx, req, sender, extraSettings, store, scope)
+		case http.MethodPost, http.MethodPut:
+			return d.handleSavePreset(ctx, req, sender, store, scope)
 		}
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusMethodNotAllowed,
+			Body:   []byte(`{"error": "method not allowed"}`),
+		})
+	}
 
-		filePath := filepath.Join(getPresetsDir(), file.Name())
-		data, err := ioutil.ReadFile(filePath)
-		if err != nil {
-			backend.Logger.Warn("Failed to read preset file", "file", file.Name(), "error", err.Error())
-			continue
+	// Preset IDs start with a letter or digit, so this path cannot clash with a preset
+	if req.Path == "presets/_legacy/import" {
+		if req.Method != http.MethodPost {
+			return sender.Send(&backend.CallResourceResponse{
+				Status: http.StatusMethodNotAllowed,
+				Body:   []byte(`{"error": "method not allowed"}`),
+			})
 		}
+		return d.handleImportLegacyPresets(ctx, req, sender, store, scope)
+	}
+
+	parts := strings.Split(strings.TrimPrefix(req.Path, "presets/"), "/")
+	presetID := parts[0]
+
+	switch {
+	case len(parts) == 1 && req.Method == http.MethodGet:
+		return d.handleGetPreset(ctx, req, sender, extraSettings, store, scope, presetID)
+	case len(parts) == 1 && req.Method == http.MethodDelete:
+		return d.handleDeletePreset(ctx, req, sender, store, scope, presetID)
+	case len(parts) == 2 && parts[1] == "versions" && req.Method == http.MethodGet:
+		return d.handlePresetVersions(ctx, req, sender, extraSettings, store, scope, presetID)
+	case len(parts) == 3 && parts[1] == "versions" && req.Method == http.MethodGet:
+		return d.handlePresetVersion(ctx, req, sender, extraSettings, store, scope, presetID, parts[2])
+	case len(parts) == 2 && parts[1] == "diff" && req.Method == http.MethodGet:
+		return d.handlePresetDiff(ctx, req, sender, extraSettings, store, scope, presetID)
+	case len(parts) == 2 && parts[1] == "rollback" && req.Method == http.MethodPost:
+		return d.handleRollbackPreset(ctx, req, sender, store, scope, presetID)
+	case len(parts) <= 3:
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusMethodNotAllowed,
+			Body:   []byte(`{"error": "method not allowed"}`),
+		})
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusNotFound,
+		Body:   []byte(`{"error": "endpoint not found"}`),
+	})
+}
+
+// presetStoreErrorStatus returns the HTTP status for an error of the preset store.
+func presetStoreErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, errPresetNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, errPresetConflict):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
 
-		var preset UploadPreset
-		if err := json.Unmarshal(data, &preset); err != nil {
-			backend.Logger.Warn("Failed to parse preset file", "file", file.Name(), "error", err.Error())
+func sendPresetStoreError(sender backend.CallResourceResponseSender, err error) error {
+	return sender.Send(&backend.CallResourceResponse{
+		Status: presetStoreErrorStatus(err),
+		Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
+	})
+}
+
+func sendPresetForbidden(sender backend.CallResourceResponseSender, presetID string) error {
+	return sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusForbidden,
+		Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(fmt.Errorf("preset %q is not available to this user", presetID)))),
+	})
+}
+
+// handleListPresets lists the current version of all saved presets the user may use
+func (d *Datasource) handleListPresets(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, extraSettings *ExtraPluginSettings, store presetStore, scope presetScope) error {
+	backend.Logger.Info("Listing presets", "orgId", scope.OrgID, "datasourceUid", scope.DatasourceUID)
+
+	versions, err := store.list(ctx, scope)
+	if err != nil {
+		backend.Logger.Error("Failed to list presets", "error", err.Error())
+		return sendPresetStoreError(sender, err)
+	}
+
+	presets := []storedPresetSummary{}
+	for _, v := range versions {
+		if v.Deleted || !v.Preset.allows(req.PluginContext.User, extraSettings.Teams) {
 			continue
 		}
-
-		presets = append(presets, preset.summarize())
+		presets = append(presets, storedPresetSummary{uploadPresetSummary: v.Preset.summarize(), Version: v.Version, UpdatedAt: v.UpdatedAt, UpdatedBy: v.UpdatedBy})
 	}
 
 	backend.Logger.Info("Found presets", "count", len(presets))
 
-	responseJSON, err := json.Marshal(map[string]interface{}{
+	return sendUploadJSON(sender, http.StatusOK, map[string]interface{}{
 		"presets": presets,
 	})
-	if err != nil {
+}
+
+// handleImportLegacyPresets imports the unscoped presets of earlier plugin versions into the
+// scope of the requesting datasource.
+func (d *Datasource) handleImportLegacyPresets(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, store presetStore, scope presetScope) error {
+	if !isOrgAdmin(req.PluginContext.User) {
 		return sender.Send(&backend.CallResourceResponse{
-			Status: http.StatusInternalServerError,
-			Body:   []byte(fmt.Sprintf(`{"error": "failed to marshal response: %s"}`, err.Error())),
+			Status: http.StatusForbidden,
+			Body:   []byte(`{"error": "only organization admins can import presets"}`),
 		})
 	}
 
-	return sender.Send(&backend.CallResourceResponse{
-		Status: http.StatusOK,
-		Body:   responseJSON,
-	})
+	user := "legacy-import"
+	if req.PluginContext.User != nil {
+		user = req.PluginContext.User.Login
+	}
+	result, err := importLegacyPresets(ctx, store, scope, user)
+	if err != nil {
+		backend.Logger.Error("Failed to import legacy presets", "error", err.Error())
+		return sendPresetStoreError(sender, err)
+	}
+	return sendUploadJSON(sender, http.StatusOK, result)
 }
 
-// handleGetPreset gets a specific preset by ID
-func (d *Datasource) handleGetPreset(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, presetID string) error {
+// handleGetPreset gets the current version of a preset by ID
+func (d *Datasource) handleGetPreset(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, extraSettings *ExtraPluginSettings, store presetStore, scope presetScope, presetID string) error {
 	backend.Logger.Info("Getting preset", "id", presetID)
 
-	filePath := getPresetFilePath(presetID)
-	data, err := ioutil.ReadFile(filePath)
+	v, err := currentPreset(ctx, store, scope, presetID)
 	if err != nil {
-		if os.IsNotExist(err) {
+		if errors.Is(err, errPresetNotFound) {
 			return sender.Send(&backend.CallResourceResponse{
 				Status: http.StatusNotFound,
-				Body:   []byte(fmt.Sprintf(`{"error": "preset %q not found"}`, presetID)),
+				Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(fmt.Errorf("preset %q not found", presetID)))),
 			})
 		}
-		return sender.Send(&backend.CallResourceResponse{
-			Status: http.StatusInternalServerError,
-			Body:   []byte(fmt.Sprintf(`{"error": "failed to read preset: %s"}`, err.Error())),
-		})
+		return sendPresetStoreError(sender, err)
 	}
 
-	var preset UploadPreset
-	if err := json.Unmarshal(data, &preset); err != nil {
-		return sender.Send(&backend.CallResourceResponse{
-			Status: http.StatusInternalServerError,
-			Body:   []byte(fmt.Sprintf(`{"error": "failed to parse preset: %s"}`, err.Error())),
-		})
+	if !v.Preset.allows(req.PluginContext.User, extraSettings.Teams) {
+		return sendPresetForbidden(sender, presetID)
 	}
 
-	responseJSON, err := json.Marshal(preset)
-	if err != nil {
-		return sender.Send(&backend.CallResourceResponse{
-			Status: http.StatusInternalServerError,
-			Body:   []byte(fmt.Sprintf(`{"error": "failed to marshal response: %s"}`, err.Error())),
-		})
-	}
-
-	return sender.Send(&backend.CallResourceResponse{
-		Status: http.StatusOK,
-		Body:   responseJSON,
-	})
+	return sendUploadJSON(sender, http.StatusOK, storedPresetFrom(*v))
 }
 
-// handleSavePreset saves or updates a preset
-func (d *Datasource) handleSavePreset(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+// handleSavePreset saves a new version of a preset. The body is the preset with the "version"
+// the client last read; it is omitted (or 0) to create a preset. A stale version returns 409.
+func (d *Datasource) handleSavePreset(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, store presetStore, scope presetScope) error {
 	backend.Logger.Info("Saving preset")
 
-	var preset UploadPreset
-	if err := json.Unmarshal(req.Body, &preset); err != nil {
+	// Presets carry their own access rules, so only admins may change them
+	if !isOrgAdmin(req.PluginContext.User) {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusForbidden,
+			Body:   []byte(`{"error": "only organization admins can save presets"}`),
+		})
+	}
+
+	var body struct {
+		UploadPreset
+		Version *int `json:"version"`
+	}
+	if err := json.Unmarshal(req.Body, &body); err != nil {
 		return sender.Send(&backend.CallResourceResponse{
 			Status: http.StatusBadRequest,
-			Body:   []byte(fmt.Sprintf(`{"error": "invalid preset data: %s"}`, err.Error())),
+			Body:   []byte(fmt.Sprintf(`{"error": "invalid preset data: %s"}`, sanitizeError(err))),
 		})
 	}
+	preset := body.UploadPreset
 
 	if preset.ID == "" {
 		return sender.Send(&backend.CallResourceResponse{
@@ -159,6 +252,13 @@ func (d *Datasource) handleSavePreset(ctx context.Context, req *backend.CallReso
 		})
 	}
 
+	if err := validatePresetID(preset.ID); err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusBadRequest,
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
+		})
+	}
+
 	if preset.Name == "" {
 		return sender.Send(&backend.CallResourceResponse{
 			Status: http.StatusBadRequest,
@@ -173,78 +273,236 @@ func (d *Datasource) handleSavePreset(ctx context.Context, req *backend.CallReso
 		})
 	}
 
-	if err := ensurePresetsDir(); err != nil {
+	if err := preset.validateTransformations(); err != nil {
 		return sender.Send(&backend.CallResourceResponse{
-			Status: http.StatusInternalServerError,
-			Body:   []byte(fmt.Sprintf(`{"error": "failed to create presets directory: %s"}`, err.Error())),
+			Status: http.StatusBadRequest,
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
 		})
 	}
 
-	filePath := getPresetFilePath(preset.ID)
-	data, err := json.MarshalIndent(preset, "", "  ")
+	v, err := savePresetVersion(ctx, store, scope, preset, body.Version, userLogin(req.PluginContext.User), false)
 	if err != nil {
+		backend.Logger.Warn("Failed to save preset", "id", preset.ID, "error", err.Error())
+		return sendPresetStoreError(sender, err)
+	}
+
+	backend.Logger.Info("Preset saved successfully", "id", preset.ID, "version", v.Version, "user", userLogin(req.PluginContext.User))
+
+	return sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusOK,
+		Body:   []byte(fmt.Sprintf(`{"success": true, "id": %q, "version": %d, "message": "Preset saved successfully"}`, preset.ID, v.Version)),
+	})
+}
+
+// handleDeletePreset deletes a preset. The optional "version" query parameter makes the
+// deletion conditional on the current version. The history is kept.
+func (d *Datasource) handleDeletePreset(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, store presetStore, scope presetScope, presetID string) error {
+	backend.Logger.Info("Deleting preset", "id", presetID)
+
+	if !isOrgAdmin(req.PluginContext.User) {
 		return sender.Send(&backend.CallResourceResponse{
-			Status: http.StatusInternalServerError,
-			Body:   []byte(fmt.Sprintf(`{"error": "failed to marshal preset: %s"}`, err.Error())),
+			Status: http.StatusForbidden,
+			Body:   []byte(`{"error": "only organization admins can delete presets"}`),
 		})
 	}
 
-	if err := ioutil.WriteFile(filePath, data, 0644); err != nil {
+	expected, err := presetVersionParam(req, "version")
+	if err != nil {
 		return sender.Send(&backend.CallResourceResponse{
-			Status: http.StatusInternalServerError,
-			Body:   []byte(fmt.Sprintf(`{"error": "failed to write preset: %s"}`, err.Error())),
+			Status: http.StatusBadRequest,
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
 		})
 	}
 
-	backend.Logger.Info("Preset saved successfully", "id", preset.ID, "file", filePath)
+	if err := validatePresetID(presetID); err != nil {
+		return sendPresetStoreError(sender, fmt.Errorf("%w: %s", errPresetNotFound, err))
+	}
+	if _, err := savePresetVersion(ctx, store, scope, UploadPreset{ID: presetID}, expected, userLogin(req.PluginContext.User), true); err != nil {
+		if errors.Is(err, errPresetNotFound) {
+			return sender.Send(&backend.CallResourceResponse{
+				Status: http.StatusNotFound,
+				Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(fmt.Errorf("preset %q not found", presetID)))),
+			})
+		}
+		return sendPresetStoreError(sender, err)
+	}
+
+	backend.Logger.Info("Preset deleted successfully", "id", presetID, "user", userLogin(req.PluginContext.User))
 
 	return sender.Send(&backend.CallResourceResponse{
 		Status: http.StatusOK,
-		Body:   []byte(fmt.Sprintf(`{"success": true, "id": %q, "message": "Preset saved successfully"}`, preset.ID)),
+		Body:   []byte(fmt.Sprintf(`{"success": true, "id": %q, "message": "Preset deleted successfully"}`, presetID)),
 	})
 }
 
-// handleDeletePreset deletes a preset
-func (d *Datasource) handleDeletePreset(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, presetID string) error {
-	backend.Logger.Info("Deleting preset", "id", presetID)
+// presetHistory returns the versions of a preset after checking the user may use its latest version.
+func (d *Datasource) presetHistory(ctx context.Context, req *backend.CallResourceRequest, extraSettings *ExtraPluginSettings, store presetStore, scope presetScope, presetID string) ([]presetVersion, error) {
+	if err := validatePresetID(presetID); err != nil {
+		return nil, fmt.Errorf("%w: %s", errPresetNotFound, err)
+	}
+	versions, err := store.history(ctx, scope, presetID)
+	if err != nil {
+		return nil, err
+	}
+	if !versions[len(versions)-1].Preset.allows(req.PluginContext.User, extraSettings.Teams) {
+		return nil, errUploadAccessDenied
+	}
+	return versions, nil
+}
 
-	filePath := getPresetFilePath(presetID)
-	if err := os.Remove(filePath); err != nil {
-		if os.IsNotExist(err) {
-			return sender.Send(&backend.CallResourceResponse{
-				Status: http.StatusNotFound,
-				Body:   []byte(fmt.Sprintf(`{"error": "preset %q not found"}`, presetID)),
-			})
+func sendPresetHistoryError(sender backend.CallResourceResponseSender, err error, presetID string) error {
+	if errors.Is(err, errUploadAccessDenied) {
+		return sendPresetForbidden(sender, presetID)
+	}
+	return sendPresetStoreError(sender, err)
+}
+
+// handlePresetVersions lists the version history of a preset, oldest first
+func (d *Datasource) handlePresetVersions(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, extraSettings *ExtraPluginSettings, store presetStore, scope presetScope, presetID string) error {
+	versions, err := d.presetHistory(ctx, req, extraSettings, store, scope, presetID)
+	if err != nil {
+		return sendPresetHistoryError(sender, err, presetID)
+	}
+	return sendUploadJSON(sender, http.StatusOK, map[string]interface{}{
+		"id":       presetID,
+		"versions": versions,
+	})
+}
+
+// handlePresetVersion returns one version of a preset
+func (d *Datasource) handlePresetVersion(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, extraSettings *ExtraPluginSettings, store presetStore, scope presetScope, presetID string, rawVersion string) error {
+	version, err := strconv.Atoi(rawVersion)
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusBadRequest,
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(fmt.Errorf("invalid version %q", rawVersion)))),
+		})
+	}
+
+	versions, err := d.presetHistory(ctx, req, extraSettings, store, scope, presetID)
+	if err != nil {
+		return sendPresetHistoryError(sender, err, presetID)
+	}
+	for _, v := range versions {
+		if v.Version == version {
+			return sendUploadJSON(sender, http.StatusOK, v)
 		}
+	}
+	return sendPresetStoreError(sender, fmt.Errorf("%w: preset %q has no version %d", errPresetNotFound, presetID, version))
+}
+
+// handlePresetDiff compares two versions of a preset. "to" defaults to the latest version and
+// "from" to the version before it.
+func (d *Datasource) handlePresetDiff(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, extraSettings *ExtraPluginSettings, store presetStore, scope presetScope, presetID string) error {
+	from, err := presetVersionParam(req, "from")
+	var to *int
+	if err == nil {
+		to, err = presetVersionParam(req, "to")
+	}
+	if err != nil {
 		return sender.Send(&backend.CallResourceResponse{
-			Status: http.StatusInternalServerError,
-			Body:   []byte(fmt.Sprintf(`{"error": "failed to delete preset: %s"}`, err.Error())),
+			Status: http.StatusBadRequest,
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
 		})
 	}
 
-	backend.Logger.Info("Preset deleted successfully", "id", presetID)
+	versions, err := d.presetHistory(ctx, req, extraSettings, store, scope, presetID)
+	if err != nil {
+		return sendPresetHistoryError(sender, err, presetID)
+	}
 
-	return sender.Send(&backend.CallResourceResponse{
-		Status: http.StatusOK,
-		Body:   []byte(fmt.Sprintf(`{"success": true, "message": "Preset %q deleted successfully"}`, presetID)),
+	toVersion := versions[len(versions)-1].Version
+	if to != nil {
+		toVersion = *to
+	}
+	fromVersion := toVersion - 1
+	if from != nil {
+		fromVersion = *from
+	}
+
+	var a, b *presetVersion
+	for i := range versions {
+		switch versions[i].Version {
+		case fromVersion:
+			a = &versions[i]
+		case toVersion:
+			b = &versions[i]
+		}
+	}
+	if a == nil || b == nil {
+		return sendPresetStoreError(sender, fmt.Errorf("%w: preset %q has no versions %d and %d", errPresetNotFound, presetID, fromVersion, toVersion))
+	}
+
+	changes, err := diffPresets(a.Preset, b.Preset)
+	if err != nil {
+		return sendPresetStoreError(sender, err)
+	}
+	return sendUploadJSON(sender, http.StatusOK, map[string]interface{}{
+		"id":      presetID,
+		"from":    fromVersion,
+		"to":      toVersion,
+		"changes": changes,
 	})
 }
 
-// loadPresetFromFile loads a preset from file storage by ID
-func loadPresetFromFile(presetID string) (*UploadPreset, error) {
-	filePath := getPresetFilePath(presetID)
-	data, err := ioutil.ReadFile(filePath)
+// handleRollbackPreset saves the content of an earlier version as a new version. The body is
+// {"toVersion": n, "version": current}; "version" must match the current version.
+func (d *Datasource) handleRollbackPreset(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, store presetStore, scope presetScope, presetID string) error {
+	if !isOrgAdmin(req.PluginContext.User) {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusForbidden,
+			Body:   []byte(`{"error": "only organization admins can roll back presets"}`),
+		})
+	}
+
+	var body struct {
+		ToVersion int  `json:"toVersion"`
+		Version   *int `json:"version"`
+	}
+	if err := json.Unmarshal(req.Body, &body); err != nil || body.ToVersion <= 0 || body.Version == nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusBadRequest,
+			Body:   []byte(`{"error": "toVersion and the current version are required"}`),
+		})
+	}
+
+	target, err := findPresetVersion(ctx, store, scope, presetID, body.ToVersion)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, fmt.Errorf("preset %q not found", presetID)
-		}
-		return nil, fmt.Errorf("failed to read preset: %w", err)
+		return sendPresetStoreError(sender, err)
+	}
+	if target.Deleted {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusBadRequest,
+			Body:   []byte(fmt.Sprintf(`{"error": "version %d is a deletion"}`, body.ToVersion)),
+		})
 	}
 
-	var preset UploadPreset
-	if err := json.Unmarshal(data, &preset); err != nil {
-		return nil, fmt.Errorf("failed to parse preset: %w", err)
+	v, err := savePresetVersion(ctx, store, scope, target.Preset, body.Version, userLogin(req.PluginContext.User), false)
+	if err != nil {
+		return sendPresetStoreError(sender, err)
 	}
 
-	return &preset, nil
+	backend.Logger.Info("Preset rolled back", "id", presetID, "toVersion", body.ToVersion, "version", v.Version, "user", userLogin(req.PluginContext.User))
+
+	return sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusOK,
+		Body:   []byte(fmt.Sprintf(`{"success": true, "id": %q, "version": %d, "message": "Preset rolled back to version %d"}`, presetID, v.Version, body.ToVersion)),
+	})
+}
+
+// presetVersionParam reads an optional positive version number from the query string.
+func presetVersionParam(req *backend.CallResourceRequest, name string) (*int, error) {
+	parsed, err := url.Parse(req.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	raw := parsed.Query().Get(name)
+	if raw == "" {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid %s %q", name, raw)
+	}
+	return &n, nil
 }
@@ -0,0 +1,319 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+var (
+	errPresetNotFound = errors.New("preset not found")
+	errPresetConflict = errors.New("preset version conflict")
+)
+
+// presetIDPattern limits preset IDs to characters that are safe in file names and store keys.
+var presetIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]{0,127}$`)
+
+func validatePresetID(id string) error {
+	if !presetIDPattern.MatchString(id) {
+		return fmt.Errorf("invalid preset ID %q: use up to 128 letters, digits, '.', '_' or '-'", id)
+	}
+	return nil
+}
+
+// presetScope identifies the saved presets of one datasource in one Grafana org.
+type presetScope struct {
+	OrgID         int64
+	DatasourceUID string
+}
+
+func presetScopeOf(req *backend.CallResourceRequest) presetScope {
+	scope := presetScope{OrgID: req.PluginContext.OrgID}
+	if req.PluginContext.DataSourceInstanceSettings != nil {
+		scope.DatasourceUID = req.PluginContext.DataSourceInstanceSettings.UID
+	}
+	return scope
+}
+
+// presetVersion is one saved revision of a preset. Versions are never rewritten; a deletion is
+// recorded as a new version with Deleted set so the history survives it.
+type presetVersion struct {
+	Version   int          `json:"version"`
+	Preset    UploadPreset `json:"preset"`
+	Deleted   bool         `json:"deleted,omitempty"`
+	UpdatedAt time.Time    `json:"updatedAt"`
+	UpdatedBy string       `json:"updatedBy,omitempty"`
+}
+
+// presetStore persists preset versions. put must fail with errPresetConflict when the version
+// already exists; this is the only concurrency control, so it has to be atomic in the backend.
+type presetStore interface {
+	// list returns the latest version of every preset in the scope, including deleted ones.
+	list(ctx context.Context, scope presetScope) ([]presetVersion, error)
+	// history returns all versions of a preset, oldest first, or errPresetNotFound.
+	history(ctx context.Context, scope presetScope, id string) ([]presetVersion, error)
+	// latest returns the newest version of a preset, or errPresetNotFound.
+	latest(ctx context.Context, scope presetScope, id string) (*presetVersion, error)
+	put(ctx context.Context, scope presetScope, v presetVersion) error
+}
+
+// PresetStoreSettings selects where saved presets are kept. The file store is local to one
+// Grafana instance; use the DynamoDB store when several replicas serve the same org.
+type PresetStoreSettings struct {
+	Type  string `json:"type,omitempty"`  // "file" (default) or "dynamodb"
+	Table string `json:"table,omitempty"` // DynamoDB table with string keys "pk" and "sk"
+}
+
+const (
+	presetStoreFile     = "file"
+	presetStoreDynamoDB = "dynamodb"
+)
+
+// presetStoreFor returns the preset store configured for the datasource.
+func (d *Datasource) presetStoreFor(ctx context.Context, req *backend.CallResourceRequest, extraSettings *ExtraPluginSettings) (presetStore, error) {
+	var store presetStore
+	switch extraSettings.PresetStore.Type {
+	case "", presetStoreFile:
+		store = filePresetStore{dir: getPresetsDir()}
+	case presetStoreDynamoDB:
+		if extraSettings.PresetStore.Table == "" {
+			return nil, fmt.Errorf("presetStore.table is required for the dynamodb preset store")
+		}
+		client, err := d.getDynamoDBClient(ctx, req.PluginContext.DataSourceInstanceSettings)
+		if err != nil {
+			return nil, err
+		}
+		store = &dynamoPresetStore{client: client, table: extraSettings.PresetStore.Table, retry: d.retrySettings}
+	default:
+		return nil, fmt.Errorf("unknown preset store type %q", extraSettings.PresetStore.Type)
+	}
+	return store, nil
+}
+
+// legacyImportResult lists the legacy presets an import copied, the ones it left alone
+// because the scope already has a preset with the same ID, and the ones it rejected with the
+// reason. Rejected files stay in place so they can be fixed and imported again.
+type legacyImportResult struct {
+	Imported []string          `json:"imported"`
+	Skipped  []string          `json:"skipped"`
+	Rejected map[string]string `json:"rejected"`
+}
+
+var legacyImportMu sync.Mutex
+
+// importLegacyPresets copies the unscoped preset files of earlier plugin versions into one
+// scope as version 1. The files carry no org or datasource, so an admin triggers the import from
+// the datasource that should own them; each imported file is renamed to *.imported so it is not
+// copied into a second scope. Files of presets that already exist in the scope are kept.
+func importLegacyPresets(ctx context.Context, store presetStore, scope presetScope, user string) (*legacyImportResult, error) {
+	legacyImportMu.Lock()
+	defer legacyImportMu.Unlock()
+
+	result := &legacyImportResult{Imported: []string{}, Skipped: []string{}, Rejected: map[string]string{}}
+	dir := getPresetsDir()
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".json") {
+			continue
+		}
+		raw, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			backend.Logger.Warn("Failed to read legacy preset file", "file", entry.Name(), "error", err.Error())
+			continue
+		}
+		var preset UploadPreset
+		if err := json.Unmarshal(raw, &preset); err != nil || validatePresetID(preset.ID) != nil {
+			backend.Logger.Warn("Skipping invalid legacy preset file", "file", entry.Name())
+			continue
+		}
+		if err := preset.validateTransformations(); err != nil {
+			backend.Logger.Warn("Rejecting legacy preset", "id", preset.ID, "error", err.Error())
+			result.Rejected[preset.ID] = err.Error()
+			continue
+		}
+
+		err = store.put(ctx, scope, presetVersion{Version: 1, Preset: preset, UpdatedAt: time.Now().UTC(), UpdatedBy: user})
+		if errors.Is(err, errPresetConflict) {
+			result.Skipped = append(result.Skipped, preset.ID)
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to import legacy preset %q: %w", preset.ID, err)
+		}
+		backend.Logger.Info("Imported legacy preset", "id", preset.ID, "orgId", scope.OrgID, "datasourceUid", scope.DatasourceUID, "user", user)
+		result.Imported = append(result.Imported, preset.ID)
+
+		path := filepath.Join(dir, entry.Name())
+		if err := os.Rename(path, path+".imported"); err != nil {
+			return nil, fmt.Errorf("failed to mark legacy preset %q as imported: %w", preset.ID, err)
+		}
+	}
+	return result, nil
+}
+
+// currentPreset returns the latest live version of a preset.
+func currentPreset(ctx context.Context, store presetStore, scope presetScope, id string) (*presetVersion, error) {
+	if err := validatePresetID(id); err != nil {
+		return nil, fmt.Errorf("%w: %s", errPresetNotFound, err)
+	}
+	v, err := store.latest(ctx, scope, id)
+	if err != nil {
+		return nil, err
+	}
+	if v.Deleted {
+		return nil, errPresetNotFound
+	}
+	return v, nil
+}
+
+// savePresetVersion writes a new version of preset. expected is the version the caller last
+// read (0 for a preset that does not exist); when nil the preset must not exist yet, so an
+// existing preset is never overwritten blindly. A deletion with a nil expected version removes
+// the current version.
+func savePresetVersion(ctx context.Context, store presetStore, scope presetScope, preset UploadPreset, expected *int, user string, deleted bool) (*presetVersion, error) {
+	if err := validatePresetID(preset.ID); err != nil {
+		return nil, err
+	}
+
+	latest, err := store.latest(ctx, scope, preset.ID)
+	if err != nil && !errors.Is(err, errPresetNotFound) {
+		return nil, err
+	}
+	next, current := 1, 0
+	if latest != nil {
+		next = latest.Version + 1
+		if !latest.Deleted {
+			current = latest.Version
+		}
+	}
+
+	switch {
+	case deleted && current == 0:
+		return nil, errPresetNotFound
+	case expected != nil && *expected != current:
+		return nil, fmt.Errorf("%w: preset %q is at version %d, not %d", errPresetConflict, preset.ID, current, *expected)
+	case expected == nil && !deleted && current != 0:
+		return nil, fmt.Errorf("%w: preset %q already exists at version %d; pass its version to update it", errPresetConflict, preset.ID, current)
+	}
+	if deleted {
+		preset = latest.Preset
+	}
+
+	v := presetVersion{Version: next, Preset: preset, Deleted: deleted, UpdatedAt: time.Now().UTC(), UpdatedBy: user}
+	if err := store.put(ctx, scope, v); err != nil {
+		if errors.Is(err, errPresetConflict) {
+			return nil, fmt.Errorf("%w: preset %q was changed concurrently", errPresetConflict, preset.ID)
+		}
+		return nil, err
+	}
+	return &v, nil
+}
+
+// findPresetVersion returns one version from the history of a preset.
+func findPresetVersion(ctx context.Context, store presetStore, scope presetScope, id string, version int) (*presetVersion, error) {
+	if err := validatePresetID(id); err != nil {
+		return nil, fmt.Errorf("%w: %s", errPresetNotFound, err)
+	}
+	versions, err := store.history(ctx, scope, id)
+	if err != nil {
+		return nil, err
+	}
+	for i := range versions {
+		if versions[i].Version == version {
+			return &versions[i], nil
+		}
+	}
+	return nil, fmt.Errorf("%w: preset %q has no version %d", errPresetNotFound, id, version)
+}
+
+// presetChange is one differing field between two preset versions, addressed by a JSON path
+// such as "schema[1].transformation.factor". From or To is nil when the field was added or removed.
+type presetChange struct {
+	Path string      `json:"path"`
+	From interface{} `json:"from"`
+	To   interface{} `json:"to"`
+}
+
+// diffPresets compares the JSON form of two presets field by field.
+func diffPresets(from UploadPreset, to UploadPreset) ([]presetChange, error) {
+	a, err := presetJSONValue(from)
+	if err != nil {
+		return nil, err
+	}
+	b, err := presetJSONValue(to)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := []presetChange{}
+	diffJSONValues("", a, b, &changes)
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+func presetJSONValue(preset UploadPreset) (interface{}, error) {
+	raw, err := json.Marshal(preset)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	err = json.Unmarshal(raw, &v)
+	return v, err
+}
+
+func diffJSONValues(path string, a interface{}, b interface{}, changes *[]presetChange) {
+	switch av := a.(type) {
+	case map[string]interface{}:
+		if bv, ok := b.(map[string]interface{}); ok {
+			keys := map[string]bool{}
+			for k := range av {
+				keys[k] = true
+			}
+			for k := range bv {
+				keys[k] = true
+			}
+			for k := range keys {
+				child := k
+				if path != "" {
+					child = path + "." + k
+				}
+				diffJSONValues(child, av[k], bv[k], changes)
+			}
+			return
+		}
+	case []interface{}:
+		if bv, ok := b.([]interface{}); ok {
+			for i := 0; i < len(av) || i < len(bv); i++ {
+				var x, y interface{}
+				if i < len(av) {
+					x = av[i]
+				}
+				if i < len(bv) {
+					y = bv[i]
+				}
+				diffJSONValues(fmt.Sprintf("%s[%d]", path, i), x, y, changes)
+			}
+			return
+		}
+	}
+	if !reflect.DeepEqual(a, b) {
+		*changes = append(*changes, presetChange{Path: path, From: a, To: b})
+	}
+}
@@ -0,0 +1,171 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// dynamoPresetStore keeps preset versions in a DynamoDB table shared by all Grafana replicas.
+// Each scope is one partition ("pk" = org#<orgId>#ds#<datasourceUid>) and every version one
+// item ("sk" = preset#<presetId>#v#<version>, zero padded so versions sort numerically).
+// Versions are written with attribute_not_exists, so a concurrent save of the same version fails.
+type dynamoPresetStore struct {
+	client *dynamodb.DynamoDB
+	table  string
+	retry  RetrySettings
+}
+
+func presetPartitionKey(scope presetScope) string {
+	return fmt.Sprintf("org#%d#ds#%s", scope.OrgID, scope.DatasourceUID)
+}
+
+func presetSortKeyPrefix(id string) string {
+	return "preset#" + id + "#v#"
+}
+
+func (s *dynamoPresetStore) query(ctx context.Context, scope presetScope, prefix string, forward bool, limit int64) ([]presetVersion, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(s.table),
+		KeyConditionExpression: aws.String("pk = :pk AND begins_with(sk, :prefix)"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":pk":     {S: aws.String(presetPartitionKey(scope))},
+			":prefix": {S: aws.String(prefix)},
+		},
+		ScanIndexForward: aws.Bool(forward),
+	}
+	if limit > 0 {
+		input.Limit = aws.Int64(limit)
+	}
+
+	var versions []presetVersion
+	for {
+		output, err := CallWithRetry(ctx, s.retry, nil, func() (*dynamodb.QueryOutput, error) {
+			return s.client.QueryWithContext(ctx, input)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query preset store: %w", err)
+		}
+		for _, item := range output.Items {
+			v, err := presetVersionFromItem(item)
+			if err != nil {
+				return nil, err
+			}
+			versions = append(versions, *v)
+		}
+		if len(output.LastEvaluatedKey) == 0 || (limit > 0 && int64(len(versions)) >= limit) {
+			return versions, nil
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+}
+
+func (s *dynamoPresetStore) list(ctx context.Context, scope presetScope) ([]presetVersion, error) {
+	versions, err := s.query(ctx, scope, "preset#", true, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	// Items are sorted by preset then version, so the last item of each preset is its latest.
+	var presets []presetVersion
+	for i, v := range versions {
+		if i+1 < len(versions) && versions[i+1].Preset.ID == v.Preset.ID {
+			continue
+		}
+		presets = append(presets, v)
+	}
+	return presets, nil
+}
+
+func (s *dynamoPresetStore) history(ctx context.Context, scope presetScope, id string) ([]presetVersion, error) {
+	versions, err := s.query(ctx, scope, presetSortKeyPrefix(id), true, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, errPresetNotFound
+	}
+	return versions, nil
+}
+
+func (s *dynamoPresetStore) latest(ctx context.Context, scope presetScope, id string) (*presetVersion, error) {
+	versions, err := s.query(ctx, scope, presetSortKeyPrefix(id), false, 1)
+	if err != nil {
+		return nil, err
+	}
+	if len(versions) == 0 {
+		return nil, errPresetNotFound
+	}
+	return &versions[0], nil
+}
+
+func (s *dynamoPresetStore) put(ctx context.Context, scope presetScope, v presetVersion) error {
+	raw, err := json.Marshal(v.Preset)
+	if err != nil {
+		return fmt.Errorf("failed to marshal preset: %w", err)
+	}
+
+	item := map[string]*dynamodb.AttributeValue{
+		"pk":        {S: aws.String(presetPartitionKey(scope))},
+		"sk":        {S: aws.String(fmt.Sprintf("%s%010d", presetSortKeyPrefix(v.Preset.ID), v.Version))},
+		"presetId":  {S: aws.String(v.Preset.ID)},
+		"version":   {N: aws.String(strconv.Itoa(v.Version))},
+		"deleted":   {BOOL: aws.Bool(v.Deleted)},
+		"updatedAt": {S: aws.String(v.UpdatedAt.Format(time.RFC3339Nano))},
+		"preset":    {S: aws.String(string(raw))},
+	}
+	if v.UpdatedBy != "" {
+		item["updatedBy"] = &dynamodb.AttributeValue{S: aws.String(v.UpdatedBy)}
+	}
+
+	_, err = CallWithRetry(ctx, s.retry, nil, func() (*dynamodb.PutItemOutput, error) {
+		return s.client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName:           aws.String(s.table),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(pk)"),
+		})
+	})
+	if err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return errPresetConflict
+		}
+		return fmt.Errorf("failed to write preset: %w", err)
+	}
+	return nil
+}
+
+func presetVersionFromItem(item map[string]*dynamodb.AttributeValue) (*presetVersion, error) {
+	attr := func(name string) string {
+		if v, ok := item[name]; ok && v.S != nil {
+			return *v.S
+		}
+		return ""
+	}
+
+	var v presetVersion
+	if err := json.Unmarshal([]byte(attr("preset")), &v.Preset); err != nil {
+		return nil, fmt.Errorf("failed to parse stored preset %s: %w", attr("sk"), err)
+	}
+
+	sk := attr("sk")
+	n, err := strconv.Atoi(sk[strings.LastIndex(sk, "#")+1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid preset version key %q", sk)
+	}
+	v.Version = n
+	if deleted, ok := item["deleted"]; ok && deleted.BOOL != nil {
+		v.Deleted = *deleted.BOOL
+	}
+	v.UpdatedAt, _ = time.Parse(time.RFC3339Nano, attr("updatedAt"))
+	v.UpdatedBy = attr("updatedBy")
+	return &v, nil
+}
@@ -0,0 +1,154 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// filePresetStore keeps every version of a preset in its own file:
+// <dir>/org-<orgId>/ds-<datasourceUid>/<presetId>/v<version>.json
+// Versions are published with a hard link, which fails when the file exists, so concurrent
+// saves of the same version cannot overwrite each other even on a shared data directory.
+type filePresetStore struct {
+	dir string
+}
+
+func (s filePresetStore) scopeDir(scope presetScope) string {
+	return filepath.Join(s.dir, fmt.Sprintf("org-%d", scope.OrgID), "ds-"+url.PathEscape(scope.DatasourceUID))
+}
+
+func (s filePresetStore) versionPath(scope presetScope, id string, version int) string {
+	return filepath.Join(s.scopeDir(scope), id, fmt.Sprintf("v%06d.json", version))
+}
+
+func (s filePresetStore) list(ctx context.Context, scope presetScope) ([]presetVersion, error) {
+	entries, err := os.ReadDir(s.scopeDir(scope))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read presets: %w", err)
+	}
+
+	var presets []presetVersion
+	for _, entry := range entries {
+		if !entry.IsDir() || validatePresetID(entry.Name()) != nil {
+			continue
+		}
+		v, err := s.latest(ctx, scope, entry.Name())
+		if err != nil {
+			if errors.Is(err, errPresetNotFound) {
+				continue
+			}
+			return nil, err
+		}
+		presets = append(presets, *v)
+	}
+	return presets, nil
+}
+
+// versions returns the version numbers of a preset in ascending order.
+func (s filePresetStore) versions(scope presetScope, id string) ([]int, error) {
+	entries, err := os.ReadDir(filepath.Join(s.scopeDir(scope), id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errPresetNotFound
+		}
+		return nil, fmt.Errorf("failed to read preset versions: %w", err)
+	}
+
+	var versions []int
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, "v") || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "v"), ".json"))
+		if err != nil || n <= 0 {
+			continue
+		}
+		versions = append(versions, n)
+	}
+	if len(versions) == 0 {
+		return nil, errPresetNotFound
+	}
+	sort.Ints(versions)
+	return versions, nil
+}
+
+func (s filePresetStore) read(scope presetScope, id string, version int) (*presetVersion, error) {
+	raw, err := os.ReadFile(s.versionPath(scope, id, version))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read preset: %w", err)
+	}
+	var v presetVersion
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, fmt.Errorf("failed to parse preset %q version %d: %w", id, version, err)
+	}
+	return &v, nil
+}
+
+func (s filePresetStore) history(ctx context.Context, scope presetScope, id string) ([]presetVersion, error) {
+	versions, err := s.versions(scope, id)
+	if err != nil {
+		return nil, err
+	}
+	history := make([]presetVersion, 0, len(versions))
+	for _, n := range versions {
+		v, err := s.read(scope, id, n)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, *v)
+	}
+	return history, nil
+}
+
+func (s filePresetStore) latest(ctx context.Context, scope presetScope, id string) (*presetVersion, error) {
+	versions, err := s.versions(scope, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.read(scope, id, versions[len(versions)-1])
+}
+
+func (s filePresetStore) put(ctx context.Context, scope presetScope, v presetVersion) error {
+	path := s.versionPath(scope, v.Preset.ID, v.Version)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create presets directory: %w", err)
+	}
+
+	raw, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal preset: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return fmt.Errorf("failed to write preset: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write preset: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write preset: %w", err)
+	}
+
+	if err := os.Link(tmp.Name(), path); err != nil {
+		if os.IsExist(err) {
+			return errPresetConflict
+		}
+		return fmt.Errorf("failed to write preset: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,253 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// RetrySettings configures how throttled or transient DynamoDB errors are retried.
+type RetrySettings struct {
+	MaxAttempts int   `json:"maxAttempts,omitempty"` // total attempts including the first one
+	BaseDelayMs int64 `json:"baseDelayMs,omitempty"`
+	MaxDelayMs  int64 `json:"maxDelayMs,omitempty"`
+}
+
+const (
+	defaultRetryMaxAttempts = 8
+	defaultRetryBaseDelayMs = 50
+	defaultRetryMaxDelayMs  = 5000
+)
+
+func (r RetrySettings) withDefaults() RetrySettings {
+	if r.MaxAttempts <= 0 {
+		r.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if r.BaseDelayMs <= 0 {
+		r.BaseDelayMs = defaultRetryBaseDelayMs
+	}
+	if r.MaxDelayMs <= 0 {
+		r.MaxDelayMs = defaultRetryMaxDelayMs
+	}
+	if r.MaxDelayMs < r.BaseDelayMs {
+		r.MaxDelayMs = r.BaseDelayMs
+	}
+	return r
+}
+
+// Backoff returns the jittered delay before the given retry (1-based), using "full jitter":
+// a random duration between zero and the exponentially growing cap.
+func (r RetrySettings) Backoff(retry int) time.Duration {
+	ceiling := float64(r.BaseDelayMs) * math.Pow(2, float64(retry-1))
+	if ceiling > float64(r.MaxDelayMs) {
+		ceiling = float64(r.MaxDelayMs)
+	}
+	return time.Duration(rand.Int63n(int64(ceiling)+1)) * time.Millisecond
+}
+
+// RetryStats records what happened across the retried calls of one query or upload.
+type RetryStats struct {
+	mu             sync.Mutex
+	throttleEvents int64
+	retries        int64
+}
+
+func (s *RetryStats) record(throttled bool) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retries++
+	if throttled {
+		s.throttleEvents++
+	}
+}
+
+// Snapshot returns the throttles and retries recorded so far.
+func (s *RetryStats) Snapshot() (throttleEvents int64, retries int64) {
+	if s == nil {
+		return 0, 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.throttleEvents, s.retries
+}
+
+// isThrottleError reports whether err signals that DynamoDB throttled the request.
+func isThrottleError(err error) bool {
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) {
+		return false
+	}
+	if request.IsErrorThrottle(aerr) || isThrottleCode(aerr.Code()) {
+		return true
+	}
+	// A transaction cancelled because one of its statements was throttled.
+	var cancelled *dynamodb.TransactionCanceledException
+	if errors.As(err, &cancelled) {
+		for _, reason := range cancelled.CancellationReasons {
+			if reason != nil && isThrottleCode(aws.StringValue(reason.Code)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isThrottleCode reports whether a DynamoDB error or cancellation reason code is a throttle.
+func isThrottleCode(code string) bool {
+	switch code {
+	case dynamodb.ErrCodeProvisionedThroughputExceededException, dynamodb.ErrCodeRequestLimitExceeded,
+		"ThrottlingException", "ThrottlingError", "ProvisionedThroughputExceeded":
+		return true
+	}
+	return false
+}
+
+// isRetryableError reports whether a failed call may succeed when repeated.
+func isRetryableError(err error) bool {
+	if isThrottleError(err) {
+		return true
+	}
+	var aerr awserr.Error
+	if !errors.As(err, &aerr) || aerr.Code() == request.CanceledErrorCode {
+		return false
+	}
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) && reqErr.StatusCode() >= http.StatusInternalServerError {
+		return true
+	}
+	return request.IsErrorRetryable(aerr)
+}
+
+// CallWithRetry runs call until it succeeds, fails with a non-retryable error, the attempts are
+// exhausted or ctx is done. Throttles and retries are recorded in stats.
+func CallWithRetry[T any](ctx context.Context, settings RetrySettings, stats *RetryStats, call func() (T, error)) (T, error) {
+	settings = settings.withDefaults()
+
+	var result T
+	var err error
+	for attempt := 1; ; attempt++ {
+		result, err = call()
+		if err == nil || attempt >= settings.MaxAttempts || !isRetryableError(err) {
+			return result, err
+		}
+
+		throttled := isThrottleError(err)
+		stats.record(throttled)
+		delay := settings.Backoff(attempt)
+		backend.Logger.Warn("Retrying DynamoDB call", "attempt", attempt, "throttled", throttled, "delay", delay, "error", err.Error())
+
+		if err := sleepContext(ctx, delay); err != nil {
+			return result, err
+		}
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// CapacityLimiter is a token bucket of capacity units refilled at a fixed rate. Callers
+// reserve an estimate before a request and settle the difference once the consumed
+// capacity is known; the bucket may go into debt, which delays subsequent requests.
+type CapacityLimiter struct {
+	mu         sync.Mutex
+	ratePerSec float64
+	burst      float64
+	tokens     float64
+	last       time.Time
+}
+
+// NewCapacityLimiter returns a limiter allowing unitsPerSecond capacity units, or nil
+// (unlimited) when unitsPerSecond is not positive.
+func NewCapacityLimiter(unitsPerSecond float64) *CapacityLimiter {
+	if unitsPerSecond <= 0 {
+		return nil
+	}
+	return &CapacityLimiter{
+		ratePerSec: unitsPerSecond,
+		burst:      unitsPerSecond,
+		tokens:     unitsPerSecond,
+		last:       time.Now(),
+	}
+}
+
+func (l *CapacityLimiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * l.ratePerSec
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
+
+// Wait blocks until units can be taken from the bucket, then takes them.
+func (l *CapacityLimiter) Wait(ctx context.Context, units float64) error {
+	if l == nil || units <= 0 {
+		return nil
+	}
+	for {
+		l.mu.Lock()
+		l.refill(time.Now())
+		if l.tokens >= math.Min(units, l.burst) {
+			l.tokens -= units
+			l.mu.Unlock()
+			return nil
+		}
+		missing := math.Min(units, l.burst) - l.tokens
+		l.mu.Unlock()
+
+		if err := sleepContext(ctx, time.Duration(missing/l.ratePerSec*float64(time.Second))); err != nil {
+			return err
+		}
+	}
+}
+
+// Settle adjusts the bucket once the real consumption of a request is known.
+func (l *CapacityLimiter) Settle(reserved, consumed float64) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.tokens -= consumed - reserved
+}
+
+// capacityUnits returns the units consumed by a request, falling back to the estimate when
+// the response carries no consumed capacity.
+func capacityUnits(estimate float64, consumed ...*dynamodb.ConsumedCapacity) float64 {
+	total := 0.0
+	found := false
+	for _, c := range consumed {
+		if c != nil && c.CapacityUnits != nil {
+			total += aws.Float64Value(c.CapacityUnits)
+			found = true
+		}
+	}
+	if !found {
+		return estimate
+	}
+	return total
+}
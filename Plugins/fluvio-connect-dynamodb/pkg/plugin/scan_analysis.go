@@ -0,0 +1,385 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// ScanGuardSettings controls how statements that would scan a whole table or index are handled.
+type ScanGuardSettings struct {
+	Disabled        bool  `json:"disabled,omitempty"`        // skip the analysis and its DescribeTable call
+	AutoSelectIndex bool  `json:"autoSelectIndex,omitempty"` // rewrite scans to a matching index when that returns the same items
+	MaxScanSizeMB   int64 `json:"maxScanSizeMB,omitempty"`   // refuse scans of larger tables or indexes unless the query sets allowFullScan
+}
+
+const (
+	AccessQuery = "query"
+	AccessScan  = "scan"
+)
+
+// tableMetadataTTL bounds how long DescribeTable results are reused. DynamoDB itself only
+// refreshes item counts and sizes about every six hours.
+const tableMetadataTTL = 5 * time.Minute
+
+// readUnitBytes is the item size covered by one eventually consistent half read unit.
+const readUnitBytes = 4096
+
+// TableMetadata is the part of a DescribeTable result used to plan statements.
+type TableMetadata struct {
+	Name         string
+	PartitionKey string
+	SortKey      string
+	ItemCount    int64
+	SizeBytes    int64
+	Indexes      []IndexMetadata
+	// Types of the key attributes of the table and its indexes (S, N or B)
+	KeyTypes map[string]string
+}
+
+// IndexMetadata describes a global or local secondary index.
+type IndexMetadata struct {
+	Name         string
+	Global       bool
+	PartitionKey string
+	SortKey      string
+	Projection   string // ALL, KEYS_ONLY or INCLUDE
+	ItemCount    int64
+	SizeBytes    int64
+}
+
+// StatementAnalysis tells how DynamoDB will execute a PartiQL SELECT.
+type StatementAnalysis struct {
+	Table              string  `json:"table"`
+	Index              string  `json:"index,omitempty"`
+	Access             string  `json:"access"` // query or scan
+	Reason             string  `json:"reason"`
+	ItemCount          int64   `json:"itemCount,omitempty"`          // items in the scanned table or index
+	SizeBytes          int64   `json:"sizeBytes,omitempty"`          // bytes in the scanned table or index
+	EstimatedReadUnits float64 `json:"estimatedReadUnits,omitempty"` // read units of a full scan
+	SuggestedIndex     string  `json:"suggestedIndex,omitempty"`
+	// SuggestedQuery reads from SuggestedIndex and returns the same items; it is only set when
+	// the index projects all attributes and its sort key, if any, is constrained by the statement.
+	SuggestedQuery string `json:"suggestedQuery,omitempty"`
+}
+
+var (
+	whereClause    = regexp.MustCompile(`(?is)\bWHERE\b(.*)`)
+	fromTableToken = regexp.MustCompile(`(?i)(FROM\s+)("[^"]+"|[^\s";.]+)`)
+)
+
+// AnalyzeStatement decides whether query is a Query on a partition or a full scan of the table
+// or index it reads, and looks for an index whose partition key the WHERE clause fixes. A
+// partition key compared with = or IN makes a Query; anything else scans.
+func AnalyzeStatement(query string, table TableMetadata) StatementAnalysis {
+	_, indexName := extractTableAndIndex(query)
+
+	analysis := StatementAnalysis{Table: table.Name}
+	partitionKey, itemCount, sizeBytes := table.PartitionKey, table.ItemCount, table.SizeBytes
+	if indexName != "" {
+		for _, index := range table.Indexes {
+			if strings.EqualFold(index.Name, indexName) {
+				analysis.Index = index.Name
+				partitionKey, itemCount, sizeBytes = index.PartitionKey, index.ItemCount, index.SizeBytes
+			}
+		}
+	}
+
+	where := ""
+	if m := whereClause.FindStringSubmatch(query); len(m) == 2 {
+		where = m[1]
+	}
+
+	if partitionKey != "" && partitionKeyConstrained(where, partitionKey) {
+		analysis.Access = AccessQuery
+		analysis.Reason = fmt.Sprintf("partition key %q is compared with = or IN", partitionKey)
+		return analysis
+	}
+
+	analysis.Access = AccessScan
+	analysis.ItemCount = itemCount
+	analysis.SizeBytes = sizeBytes
+	analysis.EstimatedReadUnits = scanReadUnits(sizeBytes)
+	if where == "" {
+		analysis.Reason = "statement has no WHERE clause"
+	} else {
+		analysis.Reason = fmt.Sprintf("WHERE clause does not fix partition key %q", partitionKey)
+	}
+
+	if analysis.Index != "" {
+		return analysis
+	}
+	for _, index := range table.Indexes {
+		if !partitionKeyConstrained(where, index.PartitionKey) {
+			continue
+		}
+		sortKeyConstrained := index.SortKey == "" || attributeConstrained(where, index.SortKey)
+		if analysis.SuggestedIndex == "" || (sortKeyConstrained && analysis.SuggestedQuery == "") {
+			analysis.SuggestedIndex = index.Name
+			analysis.SuggestedQuery = ""
+			// Items without the index sort key are missing from the index, so the rewrite is
+			// only equivalent when the statement requires that attribute anyway.
+			if strings.EqualFold(index.Projection, dynamodb.ProjectionTypeAll) && sortKeyConstrained {
+				analysis.SuggestedQuery = withIndex(query, table.Name, index.Name)
+			}
+		}
+	}
+	return analysis
+}
+
+// partitionKeyConstrained reports whether the WHERE clause compares the key with = or IN.
+func partitionKeyConstrained(where string, key string) bool {
+	if key == "" || where == "" {
+		return false
+	}
+	for _, m := range partitionKeyOperatorDetector(key).FindAllStringSubmatch(where, -1) {
+		if op := strings.ToUpper(strings.TrimSpace(m[1])); op == "=" || op == "IN" {
+			return true
+		}
+	}
+	return false
+}
+
+// attributeConstrained reports whether the WHERE clause has any condition on the attribute.
+func attributeConstrained(where string, attr string) bool {
+	if partitionKeyOperatorDetector(attr).MatchString(where) {
+		return true
+	}
+	escaped := regexp.QuoteMeta(attr)
+	return regexp.MustCompile(fmt.Sprintf(`(?i)begins_with\s*\(\s*(?:"%s"|%s)\s*,`, escaped, escaped)).MatchString(where)
+}
+
+// withIndex rewrites the FROM clause to read the index.
+func withIndex(query string, table string, index string) string {
+	replaced := false
+	return fromTableToken.ReplaceAllStringFunc(query, func(match string) string {
+		if replaced {
+			return match
+		}
+		replaced = true
+		parts := fromTableToken.FindStringSubmatch(match)
+		return parts[1] + quoteIdentifier(table) + "." + quoteIdentifier(index)
+	})
+}
+
+// scanReadUnits estimates the eventually consistent read units of reading sizeBytes.
+func scanReadUnits(sizeBytes int64) float64 {
+	return math.Ceil(float64(sizeBytes)/readUnitBytes) / 2
+}
+
+// describeTableMetadata converts a DescribeTable result.
+func describeTableMetadata(table *dynamodb.TableDescription) TableMetadata {
+	meta := TableMetadata{
+		Name:      aws.StringValue(table.TableName),
+		ItemCount: aws.Int64Value(table.ItemCount),
+		SizeBytes: aws.Int64Value(table.TableSizeBytes),
+	}
+	meta.PartitionKey, meta.SortKey = keySchemaNames(table.KeySchema)
+	for _, def := range table.AttributeDefinitions {
+		if def == nil || def.AttributeName == nil {
+			continue
+		}
+		if meta.KeyTypes == nil {
+			meta.KeyTypes = map[string]string{}
+		}
+		meta.KeyTypes[aws.StringValue(def.AttributeName)] = aws.StringValue(def.AttributeType)
+	}
+
+	for _, gsi := range table.GlobalSecondaryIndexes {
+		index := IndexMetadata{
+			Name:      aws.StringValue(gsi.IndexName),
+			Global:    true,
+			ItemCount: aws.Int64Value(gsi.ItemCount),
+			SizeBytes: aws.Int64Value(gsi.IndexSizeBytes),
+		}
+		index.PartitionKey, index.SortKey = keySchemaNames(gsi.KeySchema)
+		if gsi.Projection != nil {
+			index.Projection = aws.StringValue(gsi.Projection.ProjectionType)
+		}
+		// Indexes that are still being created cannot serve reads.
+		if gsi.IndexStatus == nil || aws.StringValue(gsi.IndexStatus) == dynamodb.IndexStatusActive {
+			meta.Indexes = append(meta.Indexes, index)
+		}
+	}
+	for _, lsi := range table.LocalSecondaryIndexes {
+		index := IndexMetadata{
+			Name:      aws.StringValue(lsi.IndexName),
+			ItemCount: aws.Int64Value(lsi.ItemCount),
+			SizeBytes: aws.Int64Value(lsi.IndexSizeBytes),
+		}
+		index.PartitionKey, index.SortKey = keySchemaNames(lsi.KeySchema)
+		if lsi.Projection != nil {
+			index.Projection = aws.StringValue(lsi.Projection.ProjectionType)
+		}
+		meta.Indexes = append(meta.Indexes, index)
+	}
+	return meta
+}
+
+func keySchemaNames(elements []*dynamodb.KeySchemaElement) (string, string) {
+	var partitionKey, sortKey string
+	for _, elem := range elements {
+		if elem == nil {
+			continue
+		}
+		switch aws.StringValue(elem.KeyType) {
+		case dynamodb.KeyTypeHash:
+			partitionKey = aws.StringValue(elem.AttributeName)
+		case dynamodb.KeyTypeRange:
+			sortKey = aws.StringValue(elem.AttributeName)
+		}
+	}
+	return partitionKey, sortKey
+}
+
+type cachedTableMetadata struct {
+	meta      TableMetadata
+	fetchedAt time.Time
+}
+
+// tableMetadataCache holds DescribeTable results per account, region and table; shared by all
+// datasource instances that read the same tables.
+var tableMetadataCache sync.Map
+
+// tableMetadata returns the metadata of a table read with the client of target, describing it
+// at most once per TTL.
+func (d *Datasource) tableMetadata(ctx context.Context, client *dynamodb.DynamoDB, target AWSTarget, table string) (TableMetadata, error) {
+	key := d.targetIdentity(target) + "|" + table
+	if cached, ok := tableMetadataCache.Load(key); ok {
+		if c := cached.(cachedTableMetadata); time.Since(c.fetchedAt) < tableMetadataTTL {
+			return c.meta, nil
+		}
+	}
+
+	output, err := CallWithRetry(ctx, d.retrySettings, nil, func() (*dynamodb.DescribeTableOutput, error) {
+		return client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)})
+	})
+	if err != nil {
+		return TableMetadata{}, err
+	}
+	if output.Table == nil {
+		return TableMetadata{}, fmt.Errorf("table description not available for %s", table)
+	}
+
+	meta := describeTableMetadata(output.Table)
+	tableMetadataCache.Store(key, cachedTableMetadata{meta: meta, fetchedAt: time.Now()})
+	return meta, nil
+}
+
+// analyzeQuery analyses the statement of a query before it runs. It may switch the statement
+// to a matching index, and refuses scans over the configured size unless the query allows them.
+// The analysis is nil when it could not be made; queries then run unchanged.
+func (d *Datasource) analyzeQuery(ctx context.Context, client *dynamodb.DynamoDB, target AWSTarget, qm *QueryModel) (*StatementAnalysis, []data.Notice, error) {
+	if d.scanGuard.Disabled || !strings.HasPrefix(strings.ToUpper(strings.TrimSpace(qm.QueryText)), "SELECT") {
+		return nil, nil, nil
+	}
+	tableName, _ := extractTableAndIndex(qm.QueryText)
+	if tableName == "" {
+		return nil, nil, nil
+	}
+
+	meta, err := d.tableMetadata(ctx, client, target, tableName)
+	if err != nil {
+		backend.Logger.Warn("Failed to describe table for scan analysis", "table", tableName, "error", err.Error())
+		return nil, nil, nil
+	}
+
+	analysis := AnalyzeStatement(qm.QueryText, meta)
+	if analysis.Access == AccessQuery {
+		return &analysis, nil, nil
+	}
+
+	if d.scanGuard.AutoSelectIndex && analysis.SuggestedQuery != "" {
+		backend.Logger.Info("Full scan rewritten to index", "table", analysis.Table, "index", analysis.SuggestedIndex)
+		qm.QueryText = analysis.SuggestedQuery
+		analysis = AnalyzeStatement(qm.QueryText, meta)
+		return &analysis, []data.Notice{{
+			Severity: data.NoticeSeverityInfo,
+			Text:     fmt.Sprintf("Index %q was selected automatically to avoid a full scan of %q", analysis.Index, analysis.Table),
+		}}, nil
+	}
+
+	scanned := fmt.Sprintf("table %q", analysis.Table)
+	if analysis.Index != "" {
+		scanned = fmt.Sprintf("index %q of table %q", analysis.Index, analysis.Table)
+	}
+	if limit := d.scanGuard.MaxScanSizeMB; limit > 0 && analysis.SizeBytes > limit*1024*1024 && !qm.AllowFullScan {
+		msg := fmt.Sprintf("refusing full scan of %s (%s, %d items): larger than maxScanSizeMB %d; %s", scanned, formatBytes(analysis.SizeBytes), analysis.ItemCount, limit, analysis.Reason)
+		if analysis.SuggestedIndex != "" {
+			msg += fmt.Sprintf("; query index %q instead", analysis.SuggestedIndex)
+		}
+		return &analysis, nil, fmt.Errorf("%s, or set allowFullScan on the query", msg)
+	}
+
+	text := fmt.Sprintf("Full scan of %s (%s, %d items), estimated %.1f read capacity units: %s", scanned, formatBytes(analysis.SizeBytes), analysis.ItemCount, analysis.EstimatedReadUnits, analysis.Reason)
+	if analysis.SuggestedIndex != "" {
+		text += fmt.Sprintf(". Index %q matches the WHERE clause", analysis.SuggestedIndex)
+	}
+	backend.Logger.Warn("Query performs a full scan", "table", analysis.Table, "index", analysis.Index, "sizeBytes", analysis.SizeBytes, "suggestedIndex", analysis.SuggestedIndex)
+	return &analysis, []data.Notice{{Severity: data.NoticeSeverityWarning, Text: text}}, nil
+}
+
+func formatBytes(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	v := float64(n)
+	i := 0
+	for v >= 1024 && i < len(units)-1 {
+		v /= 1024
+		i++
+	}
+	if i == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.1f %s", v, units[i])
+}
+
+// handleQueryAnalysis analyses a statement without running it, so the query editor can warn
+// about full scans while the query is written. Body: {"queryText": "..."}.
+func (d *Datasource) handleQueryAnalysis(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	var body struct {
+		QueryText string `json:"queryText"`
+	}
+	if err := json.Unmarshal(req.Body, &body); err != nil || strings.TrimSpace(body.QueryText) == "" {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusBadRequest,
+			Body:   []byte(`{"error": "queryText is required"}`),
+		})
+	}
+
+	tableName, _ := extractTableAndIndex(body.QueryText)
+	if tableName == "" {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusBadRequest,
+			Body:   []byte(`{"error": "table name could not be determined from the statement"}`),
+		})
+	}
+
+	client, err := d.getDynamoDBClient(ctx, req.PluginContext.DataSourceInstanceSettings)
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusInternalServerError,
+			Body:   []byte(fmt.Sprintf(`{"error": "failed to get DynamoDB client: %s"}`, sanitizeError(err))),
+		})
+	}
+
+	meta, err := d.tableMetadata(ctx, client, AWSTarget{}, tableName)
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusBadRequest,
+			Body:   []byte(fmt.Sprintf(`{"error": "failed to describe table: %s"}`, sanitizeError(err))),
+		})
+	}
+
+	return sendUploadJSON(sender, http.StatusOK, AnalyzeStatement(body.QueryText, meta))
+}
@@ -0,0 +1,643 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// SchemaCatalogSettings controls the catalog of sampled table schemas. Tables are sampled
+// when first requested and then refreshed in the background every RefreshMinutes.
+type SchemaCatalogSettings struct {
+	Disabled       bool     `json:"disabled,omitempty"`       // sample on every request and keep nothing
+	RefreshMinutes int      `json:"refreshMinutes,omitempty"` // defaults to 60
+	SampleSize     int64    `json:"sampleSize,omitempty"`     // items read per sample; defaults to 200, at most 5000
+	Tables         []string `json:"tables,omitempty"`         // sampled on start even before they are requested
+}
+
+const (
+	defaultSchemaRefreshMinutes = 60
+	defaultSchemaSampleSize     = 200
+	maxSchemaSampleSize         = 5000
+	// Scan pages read per sample; pages hold at most 1 MB, so wide items may give fewer samples
+	maxSchemaSamplePages = 10
+	maxSchemaExamples    = 3
+	maxSchemaExampleLen  = 64
+	schemaSampleTimeout  = time.Minute
+)
+
+func (s SchemaCatalogSettings) withDefaults() SchemaCatalogSettings {
+	if s.RefreshMinutes <= 0 {
+		s.RefreshMinutes = defaultSchemaRefreshMinutes
+	}
+	switch {
+	case s.SampleSize <= 0:
+		s.SampleSize = defaultSchemaSampleSize
+	case s.SampleSize > maxSchemaSampleSize:
+		s.SampleSize = maxSchemaSampleSize
+	}
+	return s
+}
+
+// TableSchema is what a sample of a table tells about its attributes.
+type TableSchema struct {
+	Table        string            `json:"table"`
+	PartitionKey string            `json:"partitionKey,omitempty"`
+	SortKey      string            `json:"sortKey,omitempty"`
+	Indexes      []string          `json:"indexes,omitempty"`
+	ItemCount    int64             `json:"itemCount"` // approximate, from DescribeTable
+	SampledItems int               `json:"sampledItems"`
+	SampledAt    time.Time         `json:"sampledAt"`
+	Attributes   []AttributeSchema `json:"attributes"`
+}
+
+// AttributeSchema describes one attribute over the sampled items.
+type AttributeSchema struct {
+	Name  string         `json:"name"`
+	Type  string         `json:"type"`  // most frequent DynamoDB type, NULL when only nulls were seen
+	Types map[string]int `json:"types"` // items per observed type
+	// partition or sort for the table keys, index for index keys
+	Key string `json:"key,omitempty"`
+	// Share of sampled items where the attribute is missing or NULL
+	NullRatio float64  `json:"nullRatio"`
+	Min       string   `json:"min,omitempty"` // numeric order for N, lexical for S
+	Max       string   `json:"max,omitempty"`
+	Examples  []string `json:"examples,omitempty"`
+}
+
+// attributeStats accumulates the values of one attribute while a sample is read.
+type attributeStats struct {
+	types    map[string]int
+	present  int // items with a non-NULL value
+	minN     *float64
+	maxN     *float64
+	minNText string
+	maxNText string
+	minS     *string
+	maxS     *string
+	examples []string
+}
+
+func (s *attributeStats) add(av *dynamodb.AttributeValue) {
+	if av == nil {
+		return
+	}
+	valueType := detectAttributeType(av)
+	s.types[valueType]++
+	if valueType == "NULL" {
+		return
+	}
+	s.present++
+
+	switch {
+	case av.N != nil:
+		if f, err := strconv.ParseFloat(*av.N, 64); err == nil {
+			if s.minN == nil || f < *s.minN {
+				s.minN, s.minNText = &f, *av.N
+			}
+			if s.maxN == nil || f > *s.maxN {
+				s.maxN, s.maxNText = &f, *av.N
+			}
+		}
+	case av.S != nil:
+		if s.minS == nil || *av.S < *s.minS {
+			s.minS = av.S
+		}
+		if s.maxS == nil || *av.S > *s.maxS {
+			s.maxS = av.S
+		}
+	}
+
+	if len(s.examples) < maxSchemaExamples {
+		example := attributeValueToText(av)
+		if runes := []rune(example); len(runes) > maxSchemaExampleLen {
+			example = string(runes[:maxSchemaExampleLen]) + "…"
+		}
+		for _, existing := range s.examples {
+			if existing == example {
+				return
+			}
+		}
+		if example != "" {
+			s.examples = append(s.examples, example)
+		}
+	}
+}
+
+// BuildTableSchema infers the schema of a table from its metadata and a sample of its items.
+// Key attributes come first, followed by the other attributes by name.
+func BuildTableSchema(meta TableMetadata, items []map[string]*dynamodb.AttributeValue, sampledAt time.Time) TableSchema {
+	schema := TableSchema{
+		Table:        meta.Name,
+		PartitionKey: meta.PartitionKey,
+		SortKey:      meta.SortKey,
+		ItemCount:    meta.ItemCount,
+		SampledItems: len(items),
+		SampledAt:    sampledAt,
+		Attributes:   []AttributeSchema{},
+	}
+
+	keys := map[string]string{}
+	for _, index := range meta.Indexes {
+		schema.Indexes = append(schema.Indexes, index.Name)
+		for _, name := range []string{index.PartitionKey, index.SortKey} {
+			if name != "" {
+				keys[name] = "index"
+			}
+		}
+	}
+	if meta.SortKey != "" {
+		keys[meta.SortKey] = "sort"
+	}
+	if meta.PartitionKey != "" {
+		keys[meta.PartitionKey] = "partition"
+	}
+
+	stats := map[string]*attributeStats{}
+	statsFor := func(name string) *attributeStats {
+		if s, ok := stats[name]; ok {
+			return s
+		}
+		s := &attributeStats{types: map[string]int{}}
+		stats[name] = s
+		return s
+	}
+	for name := range keys {
+		statsFor(name)
+	}
+	for _, item := range items {
+		for name, value := range item {
+			statsFor(name).add(value)
+		}
+	}
+
+	for name, s := range stats {
+		attribute := AttributeSchema{
+			Name:     name,
+			Type:     dominantType(s.types),
+			Types:    s.types,
+			Key:      keys[name],
+			Examples: s.examples,
+		}
+		if attribute.Type == "" {
+			// Keys absent from the sample still have their declared type
+			attribute.Type = meta.KeyTypes[name]
+		}
+		if len(items) > 0 {
+			attribute.NullRatio = float64(len(items)-s.present) / float64(len(items))
+		}
+		switch attribute.Type {
+		case "N":
+			if s.minN != nil {
+				attribute.Min, attribute.Max = s.minNText, s.maxNText
+			}
+		case "S":
+			if s.minS != nil {
+				attribute.Min, attribute.Max = *s.minS, *s.maxS
+			}
+		}
+		schema.Attributes = append(schema.Attributes, attribute)
+	}
+
+	keyOrder := map[string]int{"partition": 0, "sort": 1, "index": 2, "": 3}
+	sort.Slice(schema.Attributes, func(i, j int) bool {
+		a, b := schema.Attributes[i], schema.Attributes[j]
+		if keyOrder[a.Key] != keyOrder[b.Key] {
+			return keyOrder[a.Key] < keyOrder[b.Key]
+		}
+		return a.Name < b.Name
+	})
+	return schema
+}
+
+// dominantType returns the most frequent non-NULL type, NULL when only nulls were seen, and
+// "" when nothing was seen. Ties go to the type that sorts first.
+func dominantType(types map[string]int) string {
+	best, count := "", 0
+	for valueType, n := range types {
+		if valueType == "NULL" {
+			continue
+		}
+		if n > count || (n == count && valueType < best) {
+			best, count = valueType, n
+		}
+	}
+	if best == "" && types["NULL"] > 0 {
+		return "NULL"
+	}
+	return best
+}
+
+// SchemaCatalog keeps the sampled schemas of the tables of one datasource, persisted under
+// the Grafana data directory so they survive restarts. A nil catalog keeps nothing.
+type SchemaCatalog struct {
+	mu       sync.Mutex
+	settings SchemaCatalogSettings
+	path     string // empty keeps the catalog in memory only
+	now      func() time.Time
+	tables   map[string]TableSchema
+	sampling map[string]chan struct{} // closed when the running sample of a table ends
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func getSchemaCatalogDir() string {
+	dataDir := os.Getenv("GF_PATHS_DATA")
+	if dataDir == "" {
+		dataDir = "data"
+	}
+	return filepath.Join(dataDir, "dynamodb-schema-catalog")
+}
+
+// schemaCatalogPath returns the file of the catalog of a datasource.
+func schemaCatalogPath(uid string) string {
+	if uid == "" {
+		return ""
+	}
+	sanitized := strings.ReplaceAll(uid, "..", "")
+	sanitized = strings.ReplaceAll(sanitized, "/", "_")
+	sanitized = strings.ReplaceAll(sanitized, "\\", "_")
+	return filepath.Join(getSchemaCatalogDir(), sanitized+".json")
+}
+
+// NewSchemaCatalog returns the catalog described by settings, loading the schemas stored at
+// path, or nil when the catalog is disabled. now defaults to time.Now.
+func NewSchemaCatalog(settings SchemaCatalogSettings, path string, now func() time.Time) *SchemaCatalog {
+	if settings.Disabled {
+		return nil
+	}
+	if now == nil {
+		now = time.Now
+	}
+	c := &SchemaCatalog{
+		settings: settings.withDefaults(),
+		path:     path,
+		now:      now,
+		tables:   map[string]TableSchema{},
+		sampling: map[string]chan struct{}{},
+		stop:     make(chan struct{}),
+	}
+	if path != "" {
+		raw, err := os.ReadFile(path)
+		switch {
+		case err == nil:
+			if err := json.Unmarshal(raw, &c.tables); err != nil {
+				backend.Logger.Warn("Ignoring unreadable schema catalog", "path", path, "error", err.Error())
+				c.tables = map[string]TableSchema{}
+			}
+		case !errors.Is(err, os.ErrNotExist):
+			backend.Logger.Warn("Failed to read schema catalog", "path", path, "error", err.Error())
+		}
+	}
+	return c
+}
+
+// Get returns the schema of a table and whether it is older than the refresh interval.
+func (c *SchemaCatalog) Get(table string) (TableSchema, bool, bool) {
+	if c == nil {
+		return TableSchema{}, false, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	schema, ok := c.tables[table]
+	return schema, ok, ok && c.staleLocked(schema)
+}
+
+func (c *SchemaCatalog) staleLocked(schema TableSchema) bool {
+	return c.now().Sub(schema.SampledAt) >= time.Duration(c.settings.RefreshMinutes)*time.Minute
+}
+
+// Put stores the schema of a table and persists the catalog.
+func (c *SchemaCatalog) Put(schema TableSchema) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.tables[schema.Table] = schema
+	c.persistLocked()
+}
+
+// Tables returns the cataloged schemas ordered by table name.
+func (c *SchemaCatalog) Tables() []TableSchema {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	schemas := make([]TableSchema, 0, len(c.tables))
+	for _, schema := range c.tables {
+		schemas = append(schemas, schema)
+	}
+	sort.Slice(schemas, func(i, j int) bool { return schemas[i].Table < schemas[j].Table })
+	return schemas
+}
+
+// staleTables returns the tables due for a refresh: cataloged ones past the refresh interval
+// and configured ones not sampled yet.
+func (c *SchemaCatalog) staleTables() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var tables []string
+	for name, schema := range c.tables {
+		if c.staleLocked(schema) {
+			tables = append(tables, name)
+		}
+	}
+	for _, name := range c.settings.Tables {
+		if _, ok := c.tables[name]; !ok {
+			tables = append(tables, name)
+		}
+	}
+	sort.Strings(tables)
+	return tables
+}
+
+func (c *SchemaCatalog) persistLocked() {
+	if c.path == "" {
+		return
+	}
+	raw, err := json.MarshalIndent(c.tables, "", "  ")
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(c.path), 0755); err == nil {
+			err = writeFileAtomic(c.path, raw)
+		}
+	}
+	if err != nil {
+		backend.Logger.Warn("Failed to persist schema catalog", "path", c.path, "error", err.Error())
+	}
+}
+
+// beginSample marks a table as being sampled. When a sample is already running it returns
+// a channel that is closed when that sample ends.
+func (c *SchemaCatalog) beginSample(table string) (<-chan struct{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if done, ok := c.sampling[table]; ok {
+		return done, false
+	}
+	c.sampling[table] = make(chan struct{})
+	return nil, true
+}
+
+func (c *SchemaCatalog) endSample(table string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if done, ok := c.sampling[table]; ok {
+		close(done)
+		delete(c.sampling, table)
+	}
+}
+
+// stopRefresh ends the background refresh.
+func (c *SchemaCatalog) stopRefresh() {
+	if c == nil {
+		return
+	}
+	c.stopOnce.Do(func() { close(c.stop) })
+}
+
+// startSchemaRefresh samples the stale and configured tables of the catalog in the
+// background, once now and then every refresh interval, until the instance is disposed.
+func (d *Datasource) startSchemaRefresh(settings backend.DataSourceInstanceSettings) {
+	catalog := d.schemaCatalog
+	if catalog == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Duration(catalog.settings.RefreshMinutes) * time.Minute)
+		defer ticker.Stop()
+		for {
+			d.refreshSchemas(catalog, &settings)
+			select {
+			case <-catalog.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (d *Datasource) refreshSchemas(catalog *SchemaCatalog, settings *backend.DataSourceInstanceSettings) {
+	tables := catalog.staleTables()
+	if len(tables) == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-catalog.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	client, err := d.getDynamoDBClient(ctx, settings)
+	if err != nil {
+		backend.Logger.Warn("Schema catalog refresh skipped", "error", err.Error())
+		return
+	}
+	for _, table := range tables {
+		if ctx.Err() != nil {
+			return
+		}
+		if _, err := d.sampleTableSchema(ctx, client, table); err != nil {
+			backend.Logger.Warn("Schema catalog refresh failed", "table", table, "error", err.Error())
+		}
+	}
+}
+
+// tableSchema returns the schema of a table from the catalog. Tables not cataloged yet, and
+// every table when force is set, are sampled before returning; stale schemas are returned as
+// they are while a refresh runs in the background.
+func (d *Datasource) tableSchema(ctx context.Context, client *dynamodb.DynamoDB, table string, force bool) (TableSchema, error) {
+	if !force {
+		schema, ok, stale := d.schemaCatalog.Get(table)
+		if ok {
+			if stale {
+				go func() {
+					ctx, cancel := context.WithTimeout(context.Background(), schemaSampleTimeout)
+					defer cancel()
+					if _, err := d.sampleTableSchema(ctx, client, table); err != nil {
+						backend.Logger.Warn("Schema catalog refresh failed", "table", table, "error", err.Error())
+					}
+				}()
+			}
+			return schema, nil
+		}
+	}
+	return d.sampleTableSchema(ctx, client, table)
+}
+
+// sampleTableSchema reads a sample of a table and stores its schema in the catalog. A table
+// is sampled once at a time; concurrent callers wait for the running sample.
+func (d *Datasource) sampleTableSchema(ctx context.Context, client *dynamodb.DynamoDB, table string) (TableSchema, error) {
+	catalog := d.schemaCatalog
+	if catalog != nil {
+		if done, ok := catalog.beginSample(table); !ok {
+			select {
+			case <-done:
+			case <-ctx.Done():
+				return TableSchema{}, ctx.Err()
+			}
+			if schema, ok, _ := catalog.Get(table); ok {
+				return schema, nil
+			}
+			return TableSchema{}, fmt.Errorf("sampling table %s failed", table)
+		}
+		defer catalog.endSample(table)
+	}
+
+	meta, err := d.tableMetadata(ctx, client, AWSTarget{}, table)
+	if err != nil {
+		return TableSchema{}, fmt.Errorf("failed to describe table: %w", err)
+	}
+	items, err := d.sampleItems(ctx, client, table)
+	if err != nil {
+		return TableSchema{}, fmt.Errorf("failed to sample table: %w", err)
+	}
+
+	now := time.Now()
+	if catalog != nil {
+		now = catalog.now()
+	}
+	schema := BuildTableSchema(meta, items, now)
+	catalog.Put(schema)
+	backend.Logger.Debug("Sampled table schema", "table", table, "items", len(items), "attributes", len(schema.Attributes))
+	return schema, nil
+}
+
+// sampleItems reads up to the configured sample size of items from the start of a table,
+// within the read rate limit of the datasource.
+func (d *Datasource) sampleItems(ctx context.Context, client *dynamodb.DynamoDB, table string) ([]map[string]*dynamodb.AttributeValue, error) {
+	sampleSize := d.schemaCatalog.sampleSize()
+	input := &dynamodb.ScanInput{
+		TableName:              aws.String(table),
+		ReturnConsumedCapacity: aws.String(dynamodb.ReturnConsumedCapacityTotal),
+	}
+
+	var items []map[string]*dynamodb.AttributeValue
+	for page := 0; page < maxSchemaSamplePages && int64(len(items)) < sampleSize; page++ {
+		input.Limit = aws.Int64(sampleSize - int64(len(items)))
+		if err := d.readLimiter.Wait(ctx, 1); err != nil {
+			return nil, err
+		}
+		output, err := CallWithRetry(ctx, d.retrySettings, nil, func() (*dynamodb.ScanOutput, error) {
+			return client.ScanWithContext(ctx, input)
+		})
+		if output != nil {
+			d.readLimiter.Settle(1, capacityUnits(1, output.ConsumedCapacity))
+		}
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, output.Items...)
+		if len(output.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+	}
+	return items, nil
+}
+
+func (c *SchemaCatalog) sampleSize() int64 {
+	if c == nil {
+		return SchemaCatalogSettings{}.withDefaults().SampleSize
+	}
+	return c.settings.SampleSize
+}
+
+// handleSchema serves the schema catalog:
+//
+//	GET  schema              lists the cataloged tables
+//	GET  schema?table=name   returns the schema of a table, sampling it when not cataloged
+//	POST schema {"table"}    samples a table again and returns its schema
+func (d *Datasource) handleSchema(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	var table string
+	force := false
+	switch req.Method {
+	case http.MethodGet, "":
+		parsedURL, err := url.Parse(req.URL)
+		if err != nil {
+			return sendUploadJSON(sender, http.StatusBadRequest, map[string]string{"error": "invalid URL"})
+		}
+		table = parsedURL.Query().Get("table")
+		if table == "" {
+			return d.sendSchemaList(sender)
+		}
+	case http.MethodPost:
+		var body struct {
+			Table string `json:"table"`
+		}
+		if err := json.Unmarshal(req.Body, &body); err != nil {
+			return sender.Send(&backend.CallResourceResponse{
+				Status: http.StatusBadRequest,
+				Body:   []byte(fmt.Sprintf(`{"error": "invalid request body: %s"}`, sanitizeError(err))),
+			})
+		}
+		if body.Table == "" {
+			return sendUploadJSON(sender, http.StatusBadRequest, map[string]string{"error": "table is required"})
+		}
+		table, force = body.Table, true
+	default:
+		return sendUploadJSON(sender, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+	}
+
+	client, err := d.getDynamoDBClient(ctx, req.PluginContext.DataSourceInstanceSettings)
+	if err != nil {
+		backend.Logger.Error("Failed to get DynamoDB client", "error", err.Error())
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusInternalServerError,
+			Body:   []byte(fmt.Sprintf(`{"error": "failed to get client: %s"}`, sanitizeError(err))),
+		})
+	}
+	schema, err := d.tableSchema(ctx, client, table, force)
+	if err != nil {
+		backend.Logger.Error("Failed to sample table schema", "table", table, "error", err.Error())
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusInternalServerError,
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
+		})
+	}
+	return sendUploadJSON(sender, http.StatusOK, schema)
+}
+
+// schemaSummary is a cataloged table in the schema list.
+type schemaSummary struct {
+	Table        string    `json:"table"`
+	SampledItems int       `json:"sampledItems"`
+	SampledAt    time.Time `json:"sampledAt"`
+	Attributes   int       `json:"attributes"`
+	Stale        bool      `json:"stale"`
+}
+
+func (d *Datasource) sendSchemaList(sender backend.CallResourceResponseSender) error {
+	summaries := []schemaSummary{}
+	for _, schema := range d.schemaCatalog.Tables() {
+		_, _, stale := d.schemaCatalog.Get(schema.Table)
+		summaries = append(summaries, schemaSummary{
+			Table:        schema.Table,
+			SampledItems: schema.SampledItems,
+			SampledAt:    schema.SampledAt,
+			Attributes:   len(schema.Attributes),
+			Stale:        stale,
+		})
+	}
+	return sendUploadJSON(sender, http.StatusOK, map[string]interface{}{"tables": summaries})
+}
@@ -0,0 +1,417 @@
+package plugin
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/live"
+)
+
+const (
+	streamPathPrefix     = "stream/"
+	streamPollInterval   = time.Second
+	streamShardRefresh   = 30 * time.Second
+	streamRecordsPerPoll = 1000
+
+	// Synthetic attributes added to every streamed item
+	StreamEventAttribute     = "_event"
+	StreamEventTimeAttribute = "_eventTime"
+)
+
+// StreamQueryModel is the configuration of a live stream of table changes. It is encoded in
+// the channel path, so every distinct configuration gets its own Grafana Live channel.
+type StreamQueryModel struct {
+	Table              string              `json:"table"`
+	DatetimeAttributes []DatetimeAttribute `json:"datetimeAttributes,omitempty"`
+	Flatten            *FlattenModel       `json:"flatten,omitempty"`
+	Projections        []Projection        `json:"projections,omitempty"`
+	EventTypes         []string            `json:"eventTypes,omitempty"` // INSERT, MODIFY, REMOVE; defaults to INSERT and MODIFY
+	Region             string              `json:"region,omitempty"`     // region and role overrides of the query
+	AssumeRoleARN      string              `json:"assumeRoleArn,omitempty"`
+}
+
+func (m StreamQueryModel) validate() error {
+	if m.Table == "" {
+		return fmt.Errorf("stream table is required")
+	}
+	for _, t := range m.EventTypes {
+		switch t {
+		case dynamodbstreams.OperationTypeInsert, dynamodbstreams.OperationTypeModify, dynamodbstreams.OperationTypeRemove:
+		default:
+			return fmt.Errorf("unsupported stream event type %q", t)
+		}
+	}
+	for _, p := range m.Projections {
+		if _, err := parseProjectionPath(p.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m StreamQueryModel) includes(eventName string) bool {
+	if len(m.EventTypes) == 0 {
+		return eventName == dynamodbstreams.OperationTypeInsert || eventName == dynamodbstreams.OperationTypeModify
+	}
+	for _, t := range m.EventTypes {
+		if t == eventName {
+			return true
+		}
+	}
+	return false
+}
+
+// StreamPath returns the channel path of a stream configuration: "stream/" followed by the
+// configuration as unpadded base64url JSON, which only uses characters allowed in channels.
+func StreamPath(m StreamQueryModel) (string, error) {
+	if err := m.validate(); err != nil {
+		return "", err
+	}
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return streamPathPrefix + base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// ParseStreamPath decodes a channel path produced by StreamPath.
+func ParseStreamPath(path string) (StreamQueryModel, error) {
+	var m StreamQueryModel
+	encoded, ok := strings.CutPrefix(path, streamPathPrefix)
+	if !ok || encoded == "" {
+		return m, fmt.Errorf("unknown stream path %q", path)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return m, fmt.Errorf("invalid stream path: %w", err)
+	}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return m, fmt.Errorf("invalid stream path: %w", err)
+	}
+	return m, m.validate()
+}
+
+// streamChannel returns the Grafana Live channel that streams the changes of the table a query
+// reads, with the query's datetime, flattening and projection settings.
+func streamChannel(pCtx backend.PluginContext, qm QueryModel) (string, error) {
+	if pCtx.DataSourceInstanceSettings == nil {
+		return "", fmt.Errorf("datasource settings are missing")
+	}
+	table, _ := extractTableAndIndex(qm.QueryText)
+	if qm.Native != nil {
+		table = qm.Native.Table
+	}
+	path, err := StreamPath(StreamQueryModel{
+		Table:              table,
+		DatetimeAttributes: qm.DatetimeAttributes,
+		Flatten:            qm.Flatten,
+		Projections:        qm.Projections,
+		Region:             qm.Region,
+		AssumeRoleARN:      qm.AssumeRoleARN,
+	})
+	if err != nil {
+		return "", err
+	}
+	return live.Channel{Scope: live.ScopeDatasource, Namespace: pCtx.DataSourceInstanceSettings.UID, Path: path}.String(), nil
+}
+
+// StreamRecordsToFrame converts stream records into a frame with the same rules as a table
+// query: the new image of each change (the old image or keys for removals) is flattened and
+// projected, and datetime attributes are typed. _event holds the event name and _eventTime the
+// approximate time of the change. It returns nil when no record matches the event types.
+func StreamRecordsToFrame(name string, records []*dynamodbstreams.Record, m StreamQueryModel) (*data.Frame, error) {
+	items := make([]map[string]*dynamodb.AttributeValue, 0, len(records))
+	for _, record := range records {
+		if record == nil || record.Dynamodb == nil || !m.includes(aws.StringValue(record.EventName)) {
+			continue
+		}
+		image := record.Dynamodb.NewImage
+		if image == nil {
+			image = record.Dynamodb.OldImage
+		}
+		if image == nil {
+			image = record.Dynamodb.Keys
+		}
+		item := make(map[string]*dynamodb.AttributeValue, len(image)+2)
+		for k, v := range image {
+			item[k] = v
+		}
+		item[StreamEventAttribute] = &dynamodb.AttributeValue{S: record.EventName}
+		if t := record.Dynamodb.ApproximateCreationDateTime; t != nil {
+			item[StreamEventTimeAttribute] = &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(t.UnixMilli(), 10))}
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	items, err := ReshapeItems(items, m.Flatten, m.Projections)
+	if err != nil {
+		return nil, err
+	}
+	datetimeAttributes := map[string]string{StreamEventTimeAttribute: UnixTimestampMiniseconds}
+	for _, a := range m.DatetimeAttributes {
+		datetimeAttributes[a.Name] = a.Format
+	}
+	return QueryResultToDataFrame(name, &dynamodb.ExecuteStatementOutput{Items: items}, datetimeAttributes)
+}
+
+// SubscribeStream accepts subscriptions to valid stream channels. The table stream itself is
+// checked by RunStream, which reports a missing stream as an error.
+func (d *Datasource) SubscribeStream(_ context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	if _, err := ParseStreamPath(req.Path); err != nil {
+		backend.Logger.Warn("Rejected stream subscription", "path", req.Path, "error", err.Error())
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+	return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusOK}, nil
+}
+
+// PublishStream rejects publishing; streams only flow from DynamoDB to panels.
+func (d *Datasource) PublishStream(_ context.Context, _ *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusPermissionDenied}, nil
+}
+
+// RunStream follows the table's latest DynamoDB Stream and sends the changes made after the
+// subscription as frames, one per poll with changes. It runs until the last subscriber leaves.
+func (d *Datasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	m, err := ParseStreamPath(req.Path)
+	if err != nil {
+		return err
+	}
+
+	// The path comes from the client, so the overrides are checked against the allow list again
+	target := AWSTarget{Region: m.Region, AssumeRoleARN: m.AssumeRoleARN}
+	sess, err := d.getTargetSession(ctx, req.PluginContext.DataSourceInstanceSettings, target)
+	if err != nil {
+		return err
+	}
+	client := dynamodb.New(sess, aws.NewConfig().WithMaxRetries(0))
+	streams := dynamodbstreams.New(sess, aws.NewConfig().WithMaxRetries(0))
+
+	described, err := CallWithRetry(ctx, d.retrySettings, nil, func() (*dynamodb.DescribeTableOutput, error) {
+		return client.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(m.Table)})
+	})
+	if err != nil {
+		return fmt.Errorf("describe table %s: %w", m.Table, err)
+	}
+	streamArn := aws.StringValue(described.Table.LatestStreamArn)
+	if streamArn == "" || described.Table.StreamSpecification == nil || !aws.BoolValue(described.Table.StreamSpecification.StreamEnabled) {
+		return fmt.Errorf("table %s has no DynamoDB Stream enabled", m.Table)
+	}
+
+	backend.Logger.Info("Starting table stream", "table", m.Table, "streamArn", streamArn)
+	reader := newStreamReader(streams, streamArn, d.retrySettings)
+	ticker := time.NewTicker(streamPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			backend.Logger.Info("Stopping table stream", "table", m.Table)
+			return nil
+		case <-ticker.C:
+		}
+
+		// Records read before an error are still sent; the failed shard is retried next poll
+		records, err := reader.poll(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			backend.Logger.Warn("Failed to read table stream", "table", m.Table, "error", err.Error())
+		}
+		frame, err := StreamRecordsToFrame(m.Table, records, m)
+		if err != nil {
+			backend.Logger.Warn("Failed to convert stream records", "table", m.Table, "error", err.Error())
+			continue
+		}
+		if frame == nil {
+			continue
+		}
+		if !target.isDefault() {
+			LabelFrames([]*data.Frame{frame}, RegionLabel, d.targetRegion(target))
+		}
+		if err := sender.SendFrame(frame, data.IncludeAll); err != nil {
+			return err
+		}
+	}
+}
+
+// streamShard is the read position in one shard.
+type streamShard struct {
+	iterator     *string
+	lastSequence string
+}
+
+// streamReader polls all shards of a stream. Shards open when the reader starts are read from
+// their latest record; shards discovered later (splits and rotations) from their beginning, so
+// no change after the start is missed.
+type streamReader struct {
+	client      *dynamodbstreams.DynamoDBStreams
+	streamArn   string
+	retry       RetrySettings
+	shards      map[string]*streamShard
+	finished    map[string]bool
+	started     bool
+	refreshedAt time.Time
+}
+
+func newStreamReader(client *dynamodbstreams.DynamoDBStreams, streamArn string, retry RetrySettings) *streamReader {
+	return &streamReader{
+		client:    client,
+		streamArn: streamArn,
+		retry:     retry,
+		shards:    map[string]*streamShard{},
+		finished:  map[string]bool{},
+	}
+}
+
+// refresh discovers new shards and opens an iterator for each of them.
+func (r *streamReader) refresh(ctx context.Context) error {
+	var exclusiveStart *string
+	for {
+		output, err := CallWithRetry(ctx, r.retry, nil, func() (*dynamodbstreams.DescribeStreamOutput, error) {
+			return r.client.DescribeStreamWithContext(ctx, &dynamodbstreams.DescribeStreamInput{
+				StreamArn:             aws.String(r.streamArn),
+				ExclusiveStartShardId: exclusiveStart,
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, shard := range output.StreamDescription.Shards {
+			id := aws.StringValue(shard.ShardId)
+			if _, known := r.shards[id]; known || r.finished[id] {
+				continue
+			}
+			closed := shard.SequenceNumberRange != nil && shard.SequenceNumberRange.EndingSequenceNumber != nil
+			if !r.started && closed {
+				// Closed before the subscription: holds only older changes
+				r.finished[id] = true
+				continue
+			}
+			iteratorType := dynamodbstreams.ShardIteratorTypeTrimHorizon
+			if !r.started {
+				iteratorType = dynamodbstreams.ShardIteratorTypeLatest
+			}
+			iterator, err := r.shardIterator(ctx, id, iteratorType, "")
+			if err != nil {
+				return err
+			}
+			r.shards[id] = &streamShard{iterator: iterator}
+		}
+
+		exclusiveStart = output.StreamDescription.LastEvaluatedShardId
+		if exclusiveStart == nil {
+			break
+		}
+	}
+	r.started = true
+	r.refreshedAt = time.Now()
+	return nil
+}
+
+func (r *streamReader) shardIterator(ctx context.Context, shardID string, iteratorType string, afterSequence string) (*string, error) {
+	input := &dynamodbstreams.GetShardIteratorInput{
+		StreamArn:         aws.String(r.streamArn),
+		ShardId:           aws.String(shardID),
+		ShardIteratorType: aws.String(iteratorType),
+	}
+	if afterSequence != "" {
+		input.SequenceNumber = aws.String(afterSequence)
+	}
+	output, err := CallWithRetry(ctx, r.retry, nil, func() (*dynamodbstreams.GetShardIteratorOutput, error) {
+		return r.client.GetShardIteratorWithContext(ctx, input)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return output.ShardIterator, nil
+}
+
+// poll reads the new records of every shard. Shards that reach their end are dropped and
+// trigger a refresh, which picks up their children.
+func (r *streamReader) poll(ctx context.Context) ([]*dynamodbstreams.Record, error) {
+	if !r.started || time.Since(r.refreshedAt) > streamShardRefresh {
+		if err := r.refresh(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	ids := make([]string, 0, len(r.shards))
+	for id := range r.shards {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var records []*dynamodbstreams.Record
+	shardEnded := false
+	for _, id := range ids {
+		shard := r.shards[id]
+		output, err := CallWithRetry(ctx, r.retry, nil, func() (*dynamodbstreams.GetRecordsOutput, error) {
+			return r.client.GetRecordsWithContext(ctx, &dynamodbstreams.GetRecordsInput{
+				ShardIterator: shard.iterator,
+				Limit:         aws.Int64(streamRecordsPerPoll),
+			})
+		})
+		if err != nil {
+			var awsErr awserr.Error
+			if errors.As(err, &awsErr) && awsErr.Code() == dynamodbstreams.ErrCodeExpiredIteratorException {
+				// Iterators expire after 15 minutes without a read; resume after the last record
+				iteratorType, after := dynamodbstreams.ShardIteratorTypeLatest, ""
+				if shard.lastSequence != "" {
+					iteratorType, after = dynamodbstreams.ShardIteratorTypeAfterSequenceNumber, shard.lastSequence
+				}
+				if shard.iterator, err = r.shardIterator(ctx, id, iteratorType, after); err == nil {
+					continue
+				}
+			}
+			return records, err
+		}
+
+		records = append(records, output.Records...)
+		if n := len(output.Records); n > 0 && output.Records[n-1].Dynamodb != nil {
+			shard.lastSequence = aws.StringValue(output.Records[n-1].Dynamodb.SequenceNumber)
+		}
+		shard.iterator = output.NextShardIterator
+		if shard.iterator == nil {
+			delete(r.shards, id)
+			r.finished[id] = true
+			shardEnded = true
+		}
+	}
+
+	// Changes to different items may sit in different shards; order them by time
+	sort.SliceStable(records, func(i, j int) bool {
+		return approximateTime(records[i]).Before(approximateTime(records[j]))
+	})
+
+	if shardEnded {
+		if err := r.refresh(ctx); err != nil {
+			return records, err
+		}
+	}
+	return records, nil
+}
+
+func approximateTime(record *dynamodbstreams.Record) time.Time {
+	if record.Dynamodb == nil || record.Dynamodb.ApproximateCreationDateTime == nil {
+		return time.Time{}
+	}
+	return *record.Dynamodb.ApproximateCreationDateTime
+}
@@ -0,0 +1,139 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/grafana/grafana-aws-sdk/pkg/awsds"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// RegionLabel is the label carried by the fields of queries that set a region or role, so the
+// results of several regions can be told apart once merged.
+const RegionLabel = "region"
+
+// errTargetNotAllowed is returned for a region or role missing from the allow list.
+var errTargetNotAllowed = errors.New("not allowed by the datasource")
+
+// TargetSettings is the allow list of regions and IAM roles queries may switch to. The region
+// and role of the datasource itself are always allowed.
+type TargetSettings struct {
+	Regions        []string `json:"regions,omitempty"`
+	AssumeRoleARNs []string `json:"assumeRoleArns,omitempty"`
+}
+
+// AWSTarget is the region and role a query reads with; empty fields keep the datasource
+// settings.
+type AWSTarget struct {
+	Region        string `json:"region,omitempty"`
+	AssumeRoleARN string `json:"assumeRoleArn,omitempty"`
+}
+
+func (t AWSTarget) isDefault() bool {
+	return t.Region == "" && t.AssumeRoleARN == ""
+}
+
+// ResolveTarget checks a target against the allow list and returns the datasource settings
+// with its overrides applied. The settings feed the session cache, which keeps one session per
+// region and role.
+func ResolveTarget(allowed TargetSettings, base awsds.AWSDatasourceSettings, target AWSTarget) (awsds.AWSDatasourceSettings, error) {
+	settings := base
+	if region := strings.TrimSpace(target.Region); region != "" && region != base.Region {
+		allowedRegion, ok := findFold(allowed.Regions, region)
+		if !ok {
+			return settings, fmt.Errorf("region %q is %w", region, errTargetNotAllowed)
+		}
+		settings.Region = allowedRegion
+	}
+	if arn := strings.TrimSpace(target.AssumeRoleARN); arn != "" && arn != base.AssumeRoleARN {
+		allowedARN, ok := findFold(allowed.AssumeRoleARNs, arn)
+		if !ok {
+			return settings, fmt.Errorf("role %q is %w", arn, errTargetNotAllowed)
+		}
+		settings.AssumeRoleARN = allowedARN
+	}
+	return settings, nil
+}
+
+// findFold returns the entry of values matching value case-insensitively, as configured.
+func findFold(values []string, value string) (string, bool) {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); strings.EqualFold(v, value) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+// getTargetSession returns the session of a region and role override; the zero target is the
+// datasource session.
+func (d *Datasource) getTargetSession(ctx context.Context, settings *backend.DataSourceInstanceSettings, target AWSTarget) (*session.Session, error) {
+	resolved, err := ResolveTarget(d.targets, d.Settings, target)
+	if err != nil {
+		return nil, err
+	}
+	return d.newSession(ctx, settings, resolved)
+}
+
+// getTargetClient returns the DynamoDB client of a query target.
+func (d *Datasource) getTargetClient(ctx context.Context, settings *backend.DataSourceInstanceSettings, target AWSTarget) (*dynamodb.DynamoDB, error) {
+	sess, err := d.getTargetSession(ctx, settings, target)
+	if err != nil {
+		return nil, err
+	}
+	return dynamodb.New(sess, aws.NewConfig().WithMaxRetries(0)), nil
+}
+
+// targetRegion returns the region a target reads from, as spelled in the allow list; the
+// datasource region for the default target.
+func (d *Datasource) targetRegion(target AWSTarget) string {
+	region := d.Settings.Region
+	if resolved, err := ResolveTarget(d.targets, d.Settings, target); err == nil {
+		region = resolved.Region
+	}
+	if region == "" {
+		region = d.Settings.DefaultRegion
+	}
+	return region
+}
+
+// targetIdentity names the account and region a target reads: the resolved region and role,
+// and the endpoint, profile or access key of the datasource credentials they start from. It
+// keys caches whose entries differ between regions and accounts.
+func (d *Datasource) targetIdentity(target AWSTarget) string {
+	resolved, err := ResolveTarget(d.targets, d.Settings, target)
+	if err != nil {
+		resolved = d.Settings
+	}
+	return strings.Join([]string{resolved.Region, resolved.AssumeRoleARN, resolved.Endpoint, resolved.Profile, resolved.AccessKey}, "|")
+}
+
+// LabelFrames adds a label to every non-time field of the frames, keeping existing labels. An
+// empty value adds no label.
+func LabelFrames(frames []*data.Frame, name string, value string) {
+	if value == "" {
+		return
+	}
+	for _, frame := range frames {
+		if frame == nil {
+			continue
+		}
+		for _, field := range frame.Fields {
+			if field.Type().Time() {
+				continue
+			}
+			labels := data.Labels{}
+			for k, v := range field.Labels {
+				labels[k] = v
+			}
+			labels[name] = value
+			field.Labels = labels
+		}
+	}
+}
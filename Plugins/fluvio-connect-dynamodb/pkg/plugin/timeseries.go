@@ -0,0 +1,198 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const (
+	TimeSeriesMulti = "multi"
+	TimeSeriesWide  = "wide"
+)
+
+// TimeSeriesModel turns raw items into labelled time series instead of a flat table: every
+// distinct combination of label attribute values becomes its own series for each value
+// attribute, which is what alert rules and server-side expressions expect.
+type TimeSeriesModel struct {
+	TimeAttribute   string   `json:"timeAttribute"`
+	ValueAttributes []string `json:"valueAttributes"`
+	LabelAttributes []string `json:"labelAttributes,omitempty"`
+	Format          string   `json:"format,omitempty"` // "multi" (default) or "wide"
+}
+
+func (m TimeSeriesModel) validate() error {
+	if m.TimeAttribute == "" {
+		return fmt.Errorf("timeSeries.timeAttribute is required")
+	}
+	if len(m.ValueAttributes) == 0 {
+		return fmt.Errorf("timeSeries requires at least one value attribute")
+	}
+	switch m.Format {
+	case "", TimeSeriesMulti, TimeSeriesWide:
+	default:
+		return fmt.Errorf("unsupported timeSeries format %q", m.Format)
+	}
+	seen := map[string]string{m.TimeAttribute: "time"}
+	for _, name := range m.ValueAttributes {
+		if role, ok := seen[name]; ok {
+			return fmt.Errorf("attribute %q is used as both value and %s", name, role)
+		}
+		seen[name] = "value"
+	}
+	for _, name := range m.LabelAttributes {
+		if role, ok := seen[name]; ok {
+			return fmt.Errorf("attribute %q is used as both label and %s", name, role)
+		}
+		seen[name] = "label"
+	}
+	return nil
+}
+
+type seriesPoint struct {
+	time  time.Time
+	value *float64
+}
+
+// series is one value attribute for one label set.
+type series struct {
+	labels    data.Labels
+	labelsKey string
+	attribute string
+	valueIdx  int
+	points    []seriesPoint
+}
+
+// ItemsToTimeSeries converts items into dataplane time-series frames. Items without a usable
+// time are skipped and values that are not numeric are returned as nulls. Series are ordered
+// by their labels and then by the order of ValueAttributes, and points are sorted by time.
+func ItemsToTimeSeries(refID string, items []map[string]*dynamodb.AttributeValue, model TimeSeriesModel, datetimeAttributes map[string]string) ([]*data.Frame, error) {
+	if err := model.validate(); err != nil {
+		return nil, err
+	}
+
+	index := map[string]*series{}
+	all := make([]*series, 0)
+	timeFormat := datetimeAttributes[model.TimeAttribute]
+
+	for _, item := range items {
+		t, ok := attributeValueToTime(item[model.TimeAttribute], timeFormat)
+		if !ok {
+			continue
+		}
+		t = t.UTC()
+
+		labels := data.Labels{}
+		for _, name := range model.LabelAttributes {
+			labels[name] = attributeValueToLabel(item[name])
+		}
+		labelsKey := labels.String()
+
+		for i, name := range model.ValueAttributes {
+			key := labelsKey + "\x00" + name
+			s, ok := index[key]
+			if !ok {
+				s = &series{labels: labels, labelsKey: labelsKey, attribute: name, valueIdx: i}
+				index[key] = s
+				all = append(all, s)
+			}
+			var value *float64
+			if v, ok := attributeValueToFloat(item[name]); ok {
+				value = &v
+			}
+			s.points = append(s.points, seriesPoint{time: t, value: value})
+		}
+	}
+
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].labelsKey != all[j].labelsKey {
+			return all[i].labelsKey < all[j].labelsKey
+		}
+		return all[i].valueIdx < all[j].valueIdx
+	})
+	for _, s := range all {
+		sort.SliceStable(s.points, func(i, j int) bool { return s.points[i].time.Before(s.points[j].time) })
+	}
+
+	if model.Format == TimeSeriesWide {
+		return []*data.Frame{wideTimeSeriesFrame(refID, all)}, nil
+	}
+	return multiTimeSeriesFrames(refID, all), nil
+}
+
+func seriesLabels(s *series) data.Labels {
+	if len(s.labels) == 0 {
+		return nil
+	}
+	return s.labels.Copy()
+}
+
+// multiTimeSeriesFrames returns one frame per series. An empty result is a single frame
+// without fields, as the dataplane contract requires.
+func multiTimeSeriesFrames(refID string, all []*series) []*data.Frame {
+	meta := func() *data.FrameMeta {
+		return &data.FrameMeta{Type: data.FrameTypeTimeSeriesMulti, TypeVersion: data.FrameTypeVersion{0, 1}}
+	}
+	if len(all) == 0 {
+		frame := data.NewFrame(refID)
+		frame.Meta = meta()
+		return []*data.Frame{frame}
+	}
+
+	frames := make([]*data.Frame, 0, len(all))
+	for _, s := range all {
+		times := make([]time.Time, len(s.points))
+		values := make([]*float64, len(s.points))
+		for i, p := range s.points {
+			times[i] = p.time
+			values[i] = p.value
+		}
+		frame := data.NewFrame(refID,
+			data.NewField("time", nil, times),
+			data.NewField(s.attribute, seriesLabels(s), values),
+		)
+		frame.Meta = meta()
+		frames = append(frames, frame)
+	}
+	return frames
+}
+
+// wideTimeSeriesFrame joins all series on the union of their timestamps. A series without a
+// point at a timestamp gets a null; when a series has several points at the same time the
+// last one wins.
+func wideTimeSeriesFrame(refID string, all []*series) *data.Frame {
+	stamps := map[int64]struct{}{}
+	for _, s := range all {
+		for _, p := range s.points {
+			stamps[p.time.UnixNano()] = struct{}{}
+		}
+	}
+	keys := make([]int64, 0, len(stamps))
+	for k := range stamps {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+	row := make(map[int64]int, len(keys))
+	times := make([]time.Time, len(keys))
+	for i, k := range keys {
+		row[k] = i
+		times[i] = time.Unix(0, k).UTC()
+	}
+
+	frame := data.NewFrame(refID, data.NewField("time", nil, times))
+	for _, s := range all {
+		values := make([]*float64, len(keys))
+		for _, p := range s.points {
+			values[row[p.time.UnixNano()]] = p.value
+		}
+		frame.Fields = append(frame.Fields, data.NewField(s.attribute, seriesLabels(s), values))
+	}
+	frame.Meta = &data.FrameMeta{
+		Type:        data.FrameTypeTimeSeriesWide,
+		TypeVersion: data.FrameTypeVersion{0, 1},
+	}
+	return frame
+}
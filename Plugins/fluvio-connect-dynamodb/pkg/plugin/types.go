@@ -10,6 +10,31 @@ type QueryModel struct {
 	SortDirection      string `json:"sortDirection"`    // "asc" or "desc" (client-side)
 	SortKey            string `json:"sortKey"`          // Sort key attribute for DynamoDB native sorting
 	ScanIndexForward   *bool  `json:"scanIndexForward"` // DynamoDB native sort order (Query API only)
+	AllowFullScan      bool   `json:"allowFullScan"`    // run full scans larger than ScanGuardSettings.MaxScanSizeMB
+	Stream             bool   `json:"stream"`           // follow the table's DynamoDB Stream after the initial result
+	CacheTTLSeconds    int64  `json:"cacheTTLSeconds"`  // overrides the cache TTL of the datasource; negative bypasses the cache
+	// Optional tighter pagination limits; capped by ExtraPluginSettings.QueryLimits
+	Limits *QueryLimits `json:"limits,omitempty"`
+	// Optional server-side group-by-interval aggregation
+	Aggregation *AggregationModel `json:"aggregation,omitempty"`
+	// Optional expansion of nested attributes into dotted-path columns
+	Flatten     *FlattenModel `json:"flatten,omitempty"`
+	Projections []Projection  `json:"projections,omitempty"`
+	// Variable definition for dashboard variable queries; QueryText is not used
+	Variable *VariableQueryModel `json:"variable,omitempty"`
+	// Attribute mapping for annotation queries
+	Annotation *AnnotationModel `json:"annotation,omitempty"`
+	// Optional labelled time-series output instead of a flat table
+	TimeSeries *TimeSeriesModel `json:"timeSeries,omitempty"`
+	// Structured Query/Scan run with the native API instead of QueryText
+	Native *NativeQueryModel `json:"native,omitempty"`
+	// Optional region and role overrides, limited to ExtraPluginSettings.Targets
+	Region        string `json:"region,omitempty"`
+	AssumeRoleARN string `json:"assumeRoleArn,omitempty"`
+}
+
+func (qm QueryModel) target() AWSTarget {
+	return AWSTarget{Region: qm.Region, AssumeRoleARN: qm.AssumeRoleARN}
 }
 
 type DatetimeAttribute struct {
@@ -41,26 +66,74 @@ const (
 )
 
 type ExtraPluginSettings struct {
-	ConnectionTestTable string         `json:"connectionTestTable"`
-	UploadPresets       []UploadPreset `json:"uploadPresets"`
-	MaxUploadPayloadKB  int64          `json:"maxUploadPayloadKB"`
+	ConnectionTestTable string                `json:"connectionTestTable"`
+	UploadPresets       []UploadPreset        `json:"uploadPresets"`
+	MaxUploadPayloadKB  int64                 `json:"maxUploadPayloadKB"`
+	MaxJobPayloadKB     int64                 `json:"maxJobPayloadKB,omitempty"` // limit of background upload jobs
+	Teams               map[string][]string   `json:"teams,omitempty"`           // team name -> member logins or emails
+	PresetStore         PresetStoreSettings   `json:"presetStore,omitempty"`
+	ScanGuard           ScanGuardSettings     `json:"scanGuard,omitempty"`
+	QueryLimits         QueryLimits           `json:"queryLimits,omitempty"`
+	ReadBudget          ReadBudgetSettings    `json:"readBudget,omitempty"`
+	Parallelism         ParallelismSettings   `json:"parallelism,omitempty"`
+	Cache               CacheSettings         `json:"cache,omitempty"`
+	SchemaCatalog       SchemaCatalogSettings `json:"schemaCatalog,omitempty"`
+	ItemEditing         ItemEditSettings      `json:"itemEditing,omitempty"`
+	Targets             TargetSettings        `json:"targets,omitempty"` // regions and roles queries may switch to
+	Retry               RetrySettings         `json:"retry"`
+	// Per-datasource rate limits in capacity units per second; 0 means unlimited
+	ReadCapacityUnitsPerSecond  float64 `json:"readCapacityUnitsPerSecond,omitempty"`
+	WriteCapacityUnitsPerSecond float64 `json:"writeCapacityUnitsPerSecond,omitempty"`
 }
 
 type UploadPreset struct {
-	ID               string          `json:"id"`
-	Name             string          `json:"name"`
-	Description      string          `json:"description,omitempty"`
-	Table            string          `json:"table"`
-	Index            string          `json:"index,omitempty"`
-	Operation        UploadOperation `json:"operation"`
-	Schema           []UploadField   `json:"schema,omitempty"`
-	PartiQLTemplate  string          `json:"partiqlTemplate,omitempty"`
-	AllowAdHocFields bool            `json:"allowAdHocFields,omitempty"`
-	AllowDryRun      bool            `json:"allowDryRun,omitempty"`
-	MaxPayloadKB     int64           `json:"maxPayloadKB,omitempty"`
-	ResponsePreview  bool            `json:"responsePreview,omitempty"`
-	HelpText         string          `json:"helpText,omitempty"`
-	Category         string          `json:"category,omitempty"`
+	ID               string              `json:"id"`
+	Name             string              `json:"name"`
+	Description      string              `json:"description,omitempty"`
+	Table            string              `json:"table"`
+	Index            string              `json:"index,omitempty"`
+	Operation        UploadOperation     `json:"operation"`
+	Schema           []UploadField       `json:"schema,omitempty"`
+	PartiQLTemplate  string              `json:"partiqlTemplate,omitempty"`
+	AllowAdHocFields bool                `json:"allowAdHocFields,omitempty"`
+	AllowDryRun      bool                `json:"allowDryRun,omitempty"`
+	MaxPayloadKB     int64               `json:"maxPayloadKB,omitempty"`
+	ResponsePreview  bool                `json:"responsePreview,omitempty"`
+	HelpText         string              `json:"helpText,omitempty"`
+	Category         string              `json:"category,omitempty"`
+	Access           *UploadPresetAccess `json:"access,omitempty"`
+	Rules            []UploadRule        `json:"rules,omitempty"`
+	Upsert           *UpsertSettings     `json:"upsert,omitempty"` // upsert presets only
+}
+
+// UpsertSettings decides what an upsert does with items whose key already exists in the table.
+type UpsertSettings struct {
+	DuplicatePolicy    string `json:"duplicatePolicy,omitempty"`    // skip_identical (default), overwrite, fail or keep_newest
+	TimestampAttribute string `json:"timestampAttribute,omitempty"` // keep_newest: attribute compared to pick the newer item
+}
+
+// UploadRule is a preset-level validation rule that spans several fields of an item, the whole
+// upload or another table. Violations are reported per item with Code.
+type UploadRule struct {
+	Type       string            `json:"type"`                 // compare, unique, required_if or reference
+	Code       string            `json:"code,omitempty"`       // violation code; defaults to one per type
+	Message    string            `json:"message,omitempty"`    // replaces the generated message
+	Field      string            `json:"field,omitempty"`      // field the rule checks
+	Operator   string            `json:"operator,omitempty"`   // compare: >, >=, <, <=, == or !=
+	OtherField string            `json:"otherField,omitempty"` // compare: field compared with
+	Value      interface{}       `json:"value,omitempty"`      // compare: constant compared with; required_if: value of When
+	When       string            `json:"when,omitempty"`       // required_if: field that makes Field required
+	Fields     []string          `json:"fields,omitempty"`     // unique: fields that must be unique together
+	Table      string            `json:"table,omitempty"`      // reference: table that must contain the key
+	Keys       map[string]string `json:"keys,omitempty"`       // reference: key attribute -> item field, default {Field: Field}
+}
+
+// UploadPresetAccess restricts who may see and use a preset. A user is allowed when any rule
+// matches; a preset without rules is available to everyone.
+type UploadPresetAccess struct {
+	Roles []string `json:"roles,omitempty"` // Grafana org roles; higher roles are included
+	Teams []string `json:"teams,omitempty"` // team names defined in ExtraPluginSettings.Teams
+	Users []string `json:"users,omitempty"` // logins or emails
 }
 
 type UploadOperation string
@@ -70,6 +143,7 @@ const (
 	UploadOperationUpdate UploadOperation = "update"
 	UploadOperationDelete UploadOperation = "delete"
 	UploadOperationSelect UploadOperation = "select"
+	UploadOperationUpsert UploadOperation = "upsert"
 )
 
 type FieldValidation struct {
@@ -0,0 +1,604 @@
+package plugin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// Audit entry actions.
+const (
+	auditActionExecute = "execute"
+	auditActionJob     = "job"
+	auditActionUndo    = "undo"
+)
+
+const defaultAuditListLimit = 100
+
+// undoConflictCode marks undo results of items changed after the upload.
+const undoConflictCode = "changed_since_upload"
+
+// auditMu serializes audit writes so an entry cannot be undone twice.
+var auditMu sync.Mutex
+
+type auditUser struct {
+	Login string `json:"login"`
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+	Role  string `json:"role,omitempty"`
+}
+
+// auditEntry is the durable record of one upload, job or undo.
+type auditEntry struct {
+	ID            string                   `json:"id"`
+	DatasourceUID string                   `json:"datasourceUid"`
+	Timestamp     time.Time                `json:"timestamp"`
+	User          auditUser                `json:"user"`
+	Action        string                   `json:"action"`
+	PresetID      string                   `json:"presetId"`
+	Table         string                   `json:"table"`
+	Operation     UploadOperation          `json:"operation"`
+	Mode          string                   `json:"mode,omitempty"`
+	JobID         string                   `json:"jobId,omitempty"`
+	ItemCount     int                      `json:"itemCount"`
+	Items         []map[string]interface{} `json:"items,omitempty"`
+	Statements    []string                 `json:"statements,omitempty"`
+	ItemResults   []uploadItemResult       `json:"itemResults,omitempty"`
+	Images        []auditItemImage         `json:"images,omitempty"`
+	Undoable      bool                     `json:"undoable"`
+	UndoneBy      string                   `json:"undoneBy,omitempty"` // ID of the undo entry
+	UndoOf        string                   `json:"undoOf,omitempty"`   // ID of the entry this undo restored
+	Warnings      []string                 `json:"warnings,omitempty"`
+}
+
+// auditItemImage is the state of one item before and after the upload touched it. Prior is nil
+// when the item did not exist, so undo deletes it. After is nil when the upload deleted the
+// item; HasAfter tells it from an image that was never read.
+type auditItemImage struct {
+	Index    int          `json:"index"`
+	Key      AttributeMap `json:"key"`
+	Prior    AttributeMap `json:"prior,omitempty"`
+	After    AttributeMap `json:"after,omitempty"`
+	HasAfter bool         `json:"hasAfter,omitempty"`
+}
+
+// getAuditDir returns the directory where audit entries are stored
+func getAuditDir() string {
+	dataDir := os.Getenv("GF_PATHS_DATA")
+	if dataDir == "" {
+		dataDir = "data"
+	}
+	return filepath.Join(dataDir, "dynamodb-audit")
+}
+
+func newAuditEntry(req *backend.CallResourceRequest, action string, preset *UploadPreset) *auditEntry {
+	entry := &auditEntry{
+		DatasourceUID: req.PluginContext.DataSourceInstanceSettings.UID,
+		Timestamp:     time.Now().UTC(),
+		User:          auditUser{Login: "anonymous"},
+		Action:        action,
+		PresetID:      preset.ID,
+		Table:         preset.Table,
+		Operation:     preset.Operation,
+	}
+	if u := req.PluginContext.User; u != nil {
+		entry.User = auditUser{Login: u.Login, Name: u.Name, Email: u.Email, Role: u.Role}
+	}
+	return entry
+}
+
+// writeAuditEntry persists an entry, assigning its ID on first write. IDs start with the
+// timestamp so entries sort chronologically.
+func writeAuditEntry(entry *auditEntry) error {
+	if entry.ID == "" {
+		b := make([]byte, 4)
+		if _, err := rand.Read(b); err != nil {
+			return fmt.Errorf("failed to generate audit id: %w", err)
+		}
+		entry.ID = entry.Timestamp.Format("20060102T150405.000000000Z") + "-" + hex.EncodeToString(b)
+	}
+
+	if err := os.MkdirAll(getAuditDir(), 0755); err != nil {
+		return fmt.Errorf("failed to create audit directory: %w", err)
+	}
+	raw, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+	return writeFileAtomic(getAuditFilePath(entry.ID), raw)
+}
+
+func getAuditFilePath(id string) string {
+	// Sanitize the ID to prevent path traversal
+	sanitized := strings.ReplaceAll(id, "..", "")
+	sanitized = strings.ReplaceAll(sanitized, "/", "_")
+	sanitized = strings.ReplaceAll(sanitized, "\\", "_")
+	return filepath.Join(getAuditDir(), sanitized+".json")
+}
+
+func readAuditEntry(id string) (*auditEntry, error) {
+	raw, err := os.ReadFile(getAuditFilePath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("audit entry %q not found", id)
+		}
+		return nil, fmt.Errorf("failed to read audit entry: %w", err)
+	}
+	var entry auditEntry
+	if err := json.Unmarshal(raw, &entry); err != nil {
+		return nil, fmt.Errorf("failed to parse audit entry: %w", err)
+	}
+	return &entry, nil
+}
+
+// auditFilter selects the entries listed by GET upload/audit.
+type auditFilter struct {
+	datasourceUID string
+	presetID      string
+	table         string
+	user          string
+	from, to      time.Time
+	limit         int
+	allows        func(entry *auditEntry) bool // access check of the requesting user
+}
+
+func parseAuditFilter(datasourceUID string, query url.Values) (auditFilter, error) {
+	filter := auditFilter{
+		datasourceUID: datasourceUID,
+		presetID:      query.Get("presetId"),
+		table:         query.Get("table"),
+		user:          query.Get("user"),
+		limit:         defaultAuditListLimit,
+	}
+	for name, target := range map[string]*time.Time{"from": &filter.from, "to": &filter.to} {
+		raw := query.Get(name)
+		if raw == "" {
+			continue
+		}
+		if ms, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			*target = time.UnixMilli(ms).UTC()
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return filter, fmt.Errorf("invalid %s %q (expected RFC 3339 or epoch milliseconds)", name, raw)
+		}
+		*target = t
+	}
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 {
+			return filter, fmt.Errorf("invalid limit %q", raw)
+		}
+		filter.limit = limit
+	}
+	return filter, nil
+}
+
+func (f auditFilter) matches(entry *auditEntry) bool {
+	switch {
+	case entry.DatasourceUID != f.datasourceUID:
+		return false
+	case f.presetID != "" && entry.PresetID != f.presetID:
+		return false
+	case f.table != "" && entry.Table != f.table:
+		return false
+	case f.user != "" && !strings.EqualFold(entry.User.Login, f.user):
+		return false
+	case !f.from.IsZero() && entry.Timestamp.Before(f.from):
+		return false
+	case !f.to.IsZero() && entry.Timestamp.After(f.to):
+		return false
+	}
+	return true
+}
+
+// listAuditEntries returns the matching entries, newest first, without their items and images.
+func listAuditEntries(filter auditFilter) ([]auditEntry, error) {
+	files, err := os.ReadDir(getAuditDir())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []auditEntry{}, nil
+		}
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), ".json") {
+			names = append(names, file.Name())
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+
+	entries := []auditEntry{}
+	for _, name := range names {
+		entry, err := readAuditEntry(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			backend.Logger.Warn("Failed to read audit entry", "file", name, "error", err.Error())
+			continue
+		}
+		if !filter.matches(entry) || (filter.allows != nil && !filter.allows(entry)) {
+			continue
+		}
+		entry.Items, entry.Statements, entry.ItemResults, entry.Images = nil, nil, nil, nil
+		entries = append(entries, *entry)
+		if len(entries) >= filter.limit {
+			break
+		}
+	}
+	return entries, nil
+}
+
+// captureItemImages reads the current image of every item an upload is about to change so it
+// can be restored by undo. Inserted items have no prior image; undo deletes them.
+func (d *Datasource) captureItemImages(ctx context.Context, client *dynamodb.DynamoDB, preset *UploadPreset, items []map[string]interface{}) ([]auditItemImage, error) {
+	schema, err := d.getKeySchema(ctx, client, preset.Table, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key schema: %w", err)
+	}
+
+	images := make([]auditItemImage, 0, len(items))
+	for idx, item := range items {
+		key, err := UploadItemKey(*preset, schema.names(), item)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %w", idx+1, err)
+		}
+		image := auditItemImage{Index: idx, Key: key}
+
+		if preset.Operation != UploadOperationInsert {
+			if err := d.readLimiter.Wait(ctx, 1); err != nil {
+				return nil, err
+			}
+			output, err := CallWithRetry(ctx, d.retrySettings, nil, func() (*dynamodb.GetItemOutput, error) {
+				return client.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+					TableName:      aws.String(preset.Table),
+					Key:            key,
+					ConsistentRead: aws.Bool(true),
+				})
+			})
+			if err != nil {
+				return nil, fmt.Errorf("item %d: failed to read prior image: %w", idx+1, err)
+			}
+			d.readLimiter.Settle(1, 1)
+			if len(output.Item) > 0 {
+				image.Prior = output.Item
+			}
+		}
+		images = append(images, image)
+	}
+	return images, nil
+}
+
+// captureAfterImages reads the image the upload left for every succeeded item, so undo can
+// tell whether the item changed since. Items whose image cannot be read are not undone.
+func (d *Datasource) captureAfterImages(ctx context.Context, client *dynamodb.DynamoDB, table string, images []auditItemImage, results []uploadItemResult) error {
+	succeeded := map[int]bool{}
+	for _, r := range results {
+		if r.Status == uploadItemSucceeded {
+			succeeded[r.Index] = true
+		}
+	}
+	for i := range images {
+		if !succeeded[images[i].Index] {
+			continue
+		}
+		after, err := d.getItem(ctx, client, table, images[i].Key)
+		if err != nil {
+			return fmt.Errorf("item %d: failed to read image after upload: %w", images[i].Index+1, err)
+		}
+		images[i].After = after
+		images[i].HasAfter = true
+	}
+	return nil
+}
+
+// UploadItemKey extracts the primary key of the table from an upload item, typed like the write.
+func UploadItemKey(preset UploadPreset, keyNames []string, item map[string]interface{}) (AttributeMap, error) {
+	key := AttributeMap{}
+	for _, name := range keyNames {
+		value, ok := item[name]
+		if !ok || value == nil {
+			return nil, fmt.Errorf("key attribute %q missing", name)
+		}
+		av, err := preset.fieldAttributeValue(name, value)
+		if err != nil {
+			return nil, fmt.Errorf("key attribute %q: %w", name, err)
+		}
+		key[name] = av
+	}
+	return key, nil
+}
+
+// undoCondition returns the condition under which undo may restore an item: it must still hold
+// every attribute the upload left, or still be missing when the upload deleted it. Items
+// changed since the upload fail the condition and are reported as conflicts.
+func undoCondition(image auditItemImage) (string, map[string]*string, map[string]*dynamodb.AttributeValue) {
+	names := map[string]*string{}
+	if image.After == nil {
+		for name := range image.Key {
+			names["#k"] = aws.String(name)
+			break
+		}
+		return "attribute_not_exists(#k)", names, nil
+	}
+
+	attributes := make([]string, 0, len(image.After))
+	for name := range image.After {
+		attributes = append(attributes, name)
+	}
+	sort.Strings(attributes)
+
+	values := make(map[string]*dynamodb.AttributeValue, len(attributes))
+	conditions := make([]string, 0, len(attributes))
+	for i, name := range attributes {
+		names[fmt.Sprintf("#a%d", i)] = aws.String(name)
+		values[fmt.Sprintf(":a%d", i)] = image.After[name]
+		conditions = append(conditions, fmt.Sprintf("#a%d = :a%d", i, i))
+	}
+	return strings.Join(conditions, " AND "), names, values
+}
+
+// undoAuditEntry restores the prior images of the succeeded items of an upload, newest first.
+// Items changed after the upload are left alone and reported as conflicts.
+func (d *Datasource) undoAuditEntry(ctx context.Context, client *dynamodb.DynamoDB, entry *auditEntry) []uploadItemResult {
+	succeeded := map[int]bool{}
+	for _, r := range entry.ItemResults {
+		if r.Status == uploadItemSucceeded {
+			succeeded[r.Index] = true
+		}
+	}
+
+	defer d.resultCache.InvalidateTable(entry.Table)
+
+	var results []uploadItemResult
+	for i := len(entry.Images) - 1; i >= 0; i-- {
+		image := entry.Images[i]
+		if !succeeded[image.Index] {
+			continue
+		}
+		if !image.HasAfter {
+			results = append(results, uploadItemResult{Index: image.Index, Status: uploadItemConflict, Code: undoConflictCode, Error: "image after the upload not recorded, item not restored"})
+			continue
+		}
+
+		condition, names, values := undoCondition(image)
+		err := d.writeLimiter.Wait(ctx, 1)
+		if err == nil {
+			if image.Prior != nil {
+				_, err = CallWithRetry(ctx, d.retrySettings, nil, func() (*dynamodb.PutItemOutput, error) {
+					return client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+						TableName:                 aws.String(entry.Table),
+						Item:                      image.Prior,
+						ConditionExpression:       aws.String(condition),
+						ExpressionAttributeNames:  names,
+						ExpressionAttributeValues: values,
+					})
+				})
+			} else {
+				_, err = CallWithRetry(ctx, d.retrySettings, nil, func() (*dynamodb.DeleteItemOutput, error) {
+					return client.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+						TableName:                 aws.String(entry.Table),
+						Key:                       image.Key,
+						ConditionExpression:       aws.String(condition),
+						ExpressionAttributeNames:  names,
+						ExpressionAttributeValues: values,
+					})
+				})
+			}
+		}
+
+		var conflict *dynamodb.ConditionalCheckFailedException
+		if errors.As(err, &conflict) {
+			backend.Logger.Warn("Undo skipped item changed after the upload", "audit", entry.ID, "index", image.Index)
+			results = append(results, uploadItemResult{Index: image.Index, Status: uploadItemConflict, Code: undoConflictCode, Error: "item changed after the upload, not restored"})
+			continue
+		}
+		if err != nil {
+			backend.Logger.Error("Undo failed for item", "audit", entry.ID, "index", image.Index, "error", err.Error())
+			results = append(results, uploadItemResult{Index: image.Index, Status: uploadItemFailed, Code: awsErrorCode(err), Error: err.Error()})
+			continue
+		}
+		results = append(results, uploadItemResult{Index: image.Index, Status: uploadItemSucceeded})
+	}
+	return results
+}
+
+// handleUploadAudit lists audit entries (GET upload/audit), returns one entry
+// (GET upload/audit/{id}) or undoes an upload (POST upload/audit/{id}/undo).
+func (d *Datasource) handleUploadAudit(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, path string) error {
+	datasourceUID := req.PluginContext.DataSourceInstanceSettings.UID
+
+	if path == "" {
+		if req.Method != http.MethodGet {
+			return sender.Send(&backend.CallResourceResponse{
+				Status: http.StatusMethodNotAllowed,
+				Body:   []byte(`{"error": "only GET supported for audit log"}`),
+			})
+		}
+		parsed, err := url.Parse(req.URL)
+		if err != nil {
+			return sender.Send(&backend.CallResourceResponse{
+				Status: http.StatusBadRequest,
+				Body:   []byte(`{"error": "invalid URL"}`),
+			})
+		}
+		filter, err := parseAuditFilter(datasourceUID, parsed.Query())
+		if err != nil {
+			return sender.Send(&backend.CallResourceResponse{
+				Status: http.StatusBadRequest,
+				Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
+			})
+		}
+		filter.allows = d.newAuditAccess(ctx, req).allows
+		entries, err := listAuditEntries(filter)
+		if err != nil {
+			return sender.Send(&backend.CallResourceResponse{
+				Status: http.StatusInternalServerError,
+				Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
+			})
+		}
+		return sendUploadJSON(sender, http.StatusOK, map[string]interface{}{"entries": entries})
+	}
+
+	id, action, _ := strings.Cut(path, "/")
+	entry, err := readAuditEntry(id)
+	if err != nil || entry.DatasourceUID != datasourceUID {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusNotFound,
+			Body:   []byte(fmt.Sprintf(`{"error": "audit entry %q not found"}`, id)),
+		})
+	}
+
+	// Entries hold the uploaded items and prior images, so reading them requires the same
+	// access to the preset as undoing them
+	if !d.newAuditAccess(ctx, req).allows(entry) {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusForbidden,
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(errUploadAccessDenied))),
+		})
+	}
+
+	switch {
+	case action == "" && req.Method == http.MethodGet:
+		return sendUploadJSON(sender, http.StatusOK, entry)
+	case action == "undo" && req.Method == http.MethodPost:
+		return d.handleUndoUpload(ctx, req, sender, entry)
+	}
+
+	return sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusMethodNotAllowed,
+		Body:   []byte(`{"error": "method not allowed"}`),
+	})
+}
+
+// auditAccess checks the requesting user against the presets of audit entries, resolving each
+// preset once. Entries of presets that no longer exist, such as item edits, are limited to
+// admins.
+type auditAccess struct {
+	d             *Datasource
+	ctx           context.Context
+	req           *backend.CallResourceRequest
+	extraSettings *ExtraPluginSettings
+	presets       map[string]bool
+}
+
+func (d *Datasource) newAuditAccess(ctx context.Context, req *backend.CallResourceRequest) *auditAccess {
+	extraSettings, err := loadExtraPluginSettings(*req.PluginContext.DataSourceInstanceSettings)
+	if err != nil {
+		backend.Logger.Warn("Failed to load settings for audit access", "error", err.Error())
+	}
+	return &auditAccess{d: d, ctx: ctx, req: req, extraSettings: extraSettings, presets: map[string]bool{}}
+}
+
+func (a *auditAccess) allows(entry *auditEntry) bool {
+	if a.extraSettings == nil {
+		return false
+	}
+	if allowed, ok := a.presets[entry.PresetID]; ok {
+		return allowed
+	}
+	allowed := isOrgAdmin(a.req.PluginContext.User)
+	if preset, err := a.d.resolveUploadPreset(a.ctx, a.req, a.extraSettings, entry.PresetID); err == nil {
+		allowed = preset.allows(a.req.PluginContext.User, a.extraSettings.Teams)
+	}
+	a.presets[entry.PresetID] = allowed
+	return allowed
+}
+
+func (d *Datasource) handleUndoUpload(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, entry *auditEntry) error {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	// Re-read under the lock so concurrent undo requests see each other.
+	entry, err := readAuditEntry(entry.ID)
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusInternalServerError,
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
+		})
+	}
+	if !entry.Undoable || entry.UndoneBy != "" {
+		reason := "upload cannot be undone"
+		if entry.UndoneBy != "" {
+			reason = fmt.Sprintf("upload was already undone by %s", entry.UndoneBy)
+		}
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusConflict,
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, reason)),
+		})
+	}
+
+	// A running job, including one being cancelled or interrupted, still writes items; resume
+	// checks UndoneBy under auditMu as well, so an undone job is never restarted.
+	if entry.JobID != "" {
+		if jobs, err := uploadJobs(); err == nil {
+			if status, err := jobs.storedStatus(entry.JobID); err == nil && status == uploadJobRunning {
+				return sender.Send(&backend.CallResourceResponse{
+					Status: http.StatusConflict,
+					Body:   []byte(fmt.Sprintf(`{"error": "upload job %s is still running; cancel it and wait for it to stop before undoing"}`, entry.JobID)),
+				})
+			}
+		}
+	}
+
+	client, err := d.getDynamoDBClient(ctx, req.PluginContext.DataSourceInstanceSettings)
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusInternalServerError,
+			Body:   []byte(fmt.Sprintf(`{"error": "failed to get DynamoDB client: %s"}`, err.Error())),
+		})
+	}
+
+	results := d.undoAuditEntry(ctx, client, entry)
+
+	undo := newAuditEntry(req, auditActionUndo, &UploadPreset{ID: entry.PresetID, Table: entry.Table, Operation: entry.Operation})
+	undo.UndoOf = entry.ID
+	undo.ItemCount = len(results)
+	undo.ItemResults = results
+	if err := writeAuditEntry(undo); err != nil {
+		backend.Logger.Error("Failed to write undo audit entry", "audit", entry.ID, "error", err.Error())
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusInternalServerError,
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
+		})
+	}
+
+	entry.UndoneBy = undo.ID
+	if err := writeAuditEntry(entry); err != nil {
+		backend.Logger.Error("Failed to mark audit entry as undone", "audit", entry.ID, "error", err.Error())
+	}
+
+	failed, conflicts := 0, 0
+	for _, r := range results {
+		switch r.Status {
+		case uploadItemFailed:
+			failed++
+		case uploadItemConflict:
+			conflicts++
+		}
+	}
+	status := http.StatusOK
+	if failed > 0 || conflicts > 0 {
+		status = http.StatusMultiStatus
+	}
+
+	backend.Logger.Info("Upload undone", "audit", entry.ID, "undo", undo.ID, "user", undo.User.Login, "items", len(results), "failed", failed, "conflicts", conflicts)
+	return sendUploadJSON(sender, status, undo)
+}
@@ -0,0 +1,381 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// Upload execution modes selectable through uploadExecuteRequest.Mode.
+const (
+	uploadModeSequential  = "sequential"
+	uploadModeBatch       = "batch"
+	uploadModeTransaction = "transaction"
+)
+
+// Per-item statuses reported in uploadExecuteResponse.ItemResults.
+const (
+	uploadItemSucceeded  = "succeeded"
+	uploadItemFailed     = "failed"
+	uploadItemSkipped    = "skipped"     // not attempted because an earlier item failed
+	uploadItemRolledBack = "rolled_back" // part of a cancelled transaction
+	uploadItemUnchanged  = "unchanged"   // upsert item that needed no write; Code is its class
+	uploadItemConflict   = "conflict"    // undo left an item changed after the upload
+)
+
+const (
+	// maxBatchStatements is the BatchExecuteStatement limit per request.
+	maxBatchStatements = 25
+	// maxTransactionStatements is the ExecuteTransaction limit per request.
+	maxTransactionStatements = 100
+)
+
+type uploadItemResult struct {
+	Index  int    `json:"index"`
+	Status string `json:"status"`
+	Code   string `json:"code,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// uploadExecution collects the outcome of running an upload plan.
+type uploadExecution struct {
+	mode          string
+	itemResults   []uploadItemResult
+	consumed      []*dynamodb.ConsumedCapacity
+	selectResults []map[string]*dynamodb.AttributeValue
+	stats         RetryStats
+}
+
+func (e *uploadExecution) counts() (succeeded int, failed int) {
+	for _, r := range e.itemResults {
+		switch r.Status {
+		case uploadItemSucceeded:
+			succeeded++
+		case uploadItemFailed:
+			failed++
+		}
+	}
+	return succeeded, failed
+}
+
+func (e *uploadExecution) unchangedCount() int {
+	count := 0
+	for _, r := range e.itemResults {
+		if r.Status == uploadItemUnchanged {
+			count++
+		}
+	}
+	return count
+}
+
+// consumedCapacity summarizes the consumed capacity per table and attributes the throttle
+// events observed while executing to the preset table.
+func (e *uploadExecution) consumedCapacity(table string) []consumedCapacitySummary {
+	summary := aggregateConsumedCapacity(e.consumed)
+	throttleEvents, _ := e.stats.Snapshot()
+	if throttleEvents == 0 {
+		return summary
+	}
+	for i := range summary {
+		if summary[i].TableName == table {
+			summary[i].ThrottleEvents += throttleEvents
+			return summary
+		}
+	}
+	return append(summary, consumedCapacitySummary{TableName: table, ThrottleEvents: throttleEvents})
+}
+
+// firstError returns a summary of the first failed item, or "" when every item succeeded.
+func (e *uploadExecution) firstError() string {
+	for _, r := range e.itemResults {
+		if r.Status == uploadItemFailed {
+			return fmt.Sprintf("item %d: %s", r.Index+1, r.Error)
+		}
+	}
+	return ""
+}
+
+func normalizeUploadMode(mode string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "", uploadModeSequential:
+		return uploadModeSequential, nil
+	case uploadModeBatch:
+		return uploadModeBatch, nil
+	case uploadModeTransaction:
+		return uploadModeTransaction, nil
+	default:
+		return "", fmt.Errorf("unsupported upload mode %q (expected sequential, batch or transaction)", mode)
+	}
+}
+
+// executeUploadPlan runs the plan statements using the requested mode and reports the
+// status of every item. Calls are retried on throttling and paced by the datasource's
+// capacity limiter.
+func (d *Datasource) executeUploadPlan(ctx context.Context, client *dynamodb.DynamoDB, preset *UploadPreset, plan *uploadPlan, mode string) (*uploadExecution, error) {
+	mode, err := normalizeUploadMode(mode)
+	if err != nil {
+		return nil, err
+	}
+
+	// Upsert items that need no write are reported without being sent
+	if writes, indexes := plan.withoutSkipped(); writes != nil {
+		exec := &uploadExecution{mode: mode}
+		if len(indexes) > 0 {
+			if exec, err = d.executeUploadPlan(ctx, client, preset, writes, mode); err != nil {
+				return nil, err
+			}
+		}
+		results := make([]uploadItemResult, len(plan.statements))
+		for idx, stmt := range plan.statements {
+			results[idx] = uploadItemResult{Index: idx, Status: uploadItemUnchanged, Code: stmt.skip}
+		}
+		for i, r := range exec.itemResults {
+			r.Index = indexes[i]
+			results[r.Index] = r
+		}
+		exec.itemResults = results
+		return exec, nil
+	}
+
+	limiter := d.writeLimiter
+	if preset.Operation == UploadOperationSelect {
+		limiter = d.readLimiter
+	} else {
+		// Cached reads of the table may be stale once any statement has run
+		defer d.resultCache.InvalidateTable(preset.Table)
+	}
+
+	switch mode {
+	case uploadModeBatch:
+		return d.executeUploadBatch(ctx, client, limiter, preset, plan), nil
+	case uploadModeTransaction:
+		if len(plan.statements) > maxTransactionStatements {
+			return nil, fmt.Errorf("transaction mode supports at most %d items, got %d", maxTransactionStatements, len(plan.statements))
+		}
+		return d.executeUploadTransaction(ctx, client, limiter, preset, plan), nil
+	default:
+		return d.executeUploadSequential(ctx, client, limiter, preset, plan), nil
+	}
+}
+
+// executeUploadSequential runs one statement per item and stops at the first failure.
+func (d *Datasource) executeUploadSequential(ctx context.Context, client *dynamodb.DynamoDB, limiter *CapacityLimiter, preset *UploadPreset, plan *uploadPlan) *uploadExecution {
+	exec := &uploadExecution{mode: uploadModeSequential, itemResults: make([]uploadItemResult, len(plan.statements))}
+
+	failed := false
+	for idx, stmt := range plan.statements {
+		if failed {
+			exec.itemResults[idx] = uploadItemResult{Index: idx, Status: uploadItemSkipped}
+			continue
+		}
+
+		input := &dynamodb.ExecuteStatementInput{
+			Statement:              aws.String(stmt.statement),
+			Parameters:             statementParams(stmt.params),
+			ReturnConsumedCapacity: aws.String(dynamodb.ReturnConsumedCapacityTotal),
+		}
+
+		execErr := limiter.Wait(ctx, 1)
+		var output *dynamodb.ExecuteStatementOutput
+		if execErr == nil {
+			output, execErr = CallWithRetry(ctx, d.retrySettings, &exec.stats, func() (*dynamodb.ExecuteStatementOutput, error) {
+				return client.ExecuteStatementWithContext(ctx, input)
+			})
+		}
+		if output != nil {
+			limiter.Settle(1, capacityUnits(1, output.ConsumedCapacity))
+		}
+		if execErr != nil {
+			backend.Logger.Error("Upload execute failed", "preset", preset.ID, "statementIndex", idx, "error", execErr.Error())
+			exec.itemResults[idx] = uploadItemResult{Index: idx, Status: uploadItemFailed, Code: awsErrorCode(execErr), Error: execErr.Error()}
+			failed = true
+			continue
+		}
+
+		exec.itemResults[idx] = uploadItemResult{Index: idx, Status: uploadItemSucceeded}
+		if output.ConsumedCapacity != nil {
+			exec.consumed = append(exec.consumed, output.ConsumedCapacity)
+		}
+		if preset.Operation == UploadOperationSelect && len(output.Items) > 0 {
+			exec.selectResults = append(exec.selectResults, output.Items...)
+		}
+	}
+
+	return exec
+}
+
+// executeUploadBatch runs the statements through BatchExecuteStatement in chunks. Each
+// statement succeeds or fails on its own; throttled statements are re-submitted with backoff
+// and a failed chunk request marks all its items failed.
+func (d *Datasource) executeUploadBatch(ctx context.Context, client *dynamodb.DynamoDB, limiter *CapacityLimiter, preset *UploadPreset, plan *uploadPlan) *uploadExecution {
+	exec := &uploadExecution{mode: uploadModeBatch, itemResults: make([]uploadItemResult, len(plan.statements))}
+	retry := d.retrySettings.withDefaults()
+
+	for start := 0; start < len(plan.statements); start += maxBatchStatements {
+		end := start + maxBatchStatements
+		if end > len(plan.statements) {
+			end = len(plan.statements)
+		}
+
+		pending := make([]int, 0, end-start)
+		for idx := start; idx < end; idx++ {
+			pending = append(pending, idx)
+		}
+
+		for attempt := 1; len(pending) > 0; attempt++ {
+			requests := make([]*dynamodb.BatchStatementRequest, 0, len(pending))
+			for _, idx := range pending {
+				stmt := plan.statements[idx]
+				requests = append(requests, &dynamodb.BatchStatementRequest{
+					Statement:  aws.String(stmt.statement),
+					Parameters: statementParams(stmt.params),
+				})
+			}
+
+			reserved := float64(len(requests))
+			err := limiter.Wait(ctx, reserved)
+			var output *dynamodb.BatchExecuteStatementOutput
+			if err == nil {
+				output, err = CallWithRetry(ctx, d.retrySettings, &exec.stats, func() (*dynamodb.BatchExecuteStatementOutput, error) {
+					return client.BatchExecuteStatementWithContext(ctx, &dynamodb.BatchExecuteStatementInput{
+						Statements:             requests,
+						ReturnConsumedCapacity: aws.String(dynamodb.ReturnConsumedCapacityTotal),
+					})
+				})
+			}
+			if err != nil {
+				backend.Logger.Error("Upload batch failed", "preset", preset.ID, "firstIndex", pending[0], "error", err.Error())
+				for _, idx := range pending {
+					exec.itemResults[idx] = uploadItemResult{Index: idx, Status: uploadItemFailed, Code: awsErrorCode(err), Error: err.Error()}
+				}
+				break
+			}
+
+			limiter.Settle(reserved, capacityUnits(reserved, output.ConsumedCapacity...))
+			exec.consumed = append(exec.consumed, output.ConsumedCapacity...)
+
+			var throttled []int
+			for i, idx := range pending {
+				if i >= len(output.Responses) || output.Responses[i] == nil {
+					exec.itemResults[idx] = uploadItemResult{Index: idx, Status: uploadItemFailed, Error: "no response returned for statement"}
+					continue
+				}
+				resp := output.Responses[i]
+				if resp.Error != nil {
+					code := aws.StringValue(resp.Error.Code)
+					if isThrottleCode(code) && attempt < retry.MaxAttempts {
+						throttled = append(throttled, idx)
+						continue
+					}
+					exec.itemResults[idx] = uploadItemResult{Index: idx, Status: uploadItemFailed, Code: code, Error: aws.StringValue(resp.Error.Message)}
+					continue
+				}
+				exec.itemResults[idx] = uploadItemResult{Index: idx, Status: uploadItemSucceeded}
+				if preset.Operation == UploadOperationSelect && len(resp.Item) > 0 {
+					exec.selectResults = append(exec.selectResults, resp.Item)
+				}
+			}
+
+			pending = throttled
+			if len(pending) > 0 {
+				exec.stats.record(true)
+				if err := sleepContext(ctx, retry.Backoff(attempt)); err != nil {
+					for _, idx := range pending {
+						exec.itemResults[idx] = uploadItemResult{Index: idx, Status: uploadItemFailed, Code: awsErrorCode(err), Error: err.Error()}
+					}
+					break
+				}
+			}
+		}
+	}
+
+	return exec
+}
+
+// executeUploadTransaction runs all statements in a single ExecuteTransaction call, so either
+// every item is written or none is.
+func (d *Datasource) executeUploadTransaction(ctx context.Context, client *dynamodb.DynamoDB, limiter *CapacityLimiter, preset *UploadPreset, plan *uploadPlan) *uploadExecution {
+	exec := &uploadExecution{mode: uploadModeTransaction, itemResults: make([]uploadItemResult, len(plan.statements))}
+
+	statements := make([]*dynamodb.ParameterizedStatement, 0, len(plan.statements))
+	for _, stmt := range plan.statements {
+		statements = append(statements, &dynamodb.ParameterizedStatement{
+			Statement:  aws.String(stmt.statement),
+			Parameters: statementParams(stmt.params),
+		})
+	}
+
+	// Transactions consume two capacity units per item.
+	reserved := float64(2 * len(statements))
+	err := limiter.Wait(ctx, reserved)
+	var output *dynamodb.ExecuteTransactionOutput
+	if err == nil {
+		output, err = CallWithRetry(ctx, d.retrySettings, &exec.stats, func() (*dynamodb.ExecuteTransactionOutput, error) {
+			return client.ExecuteTransactionWithContext(ctx, &dynamodb.ExecuteTransactionInput{
+				TransactStatements:     statements,
+				ReturnConsumedCapacity: aws.String(dynamodb.ReturnConsumedCapacityTotal),
+			})
+		})
+	}
+	if output != nil {
+		limiter.Settle(reserved, capacityUnits(reserved, output.ConsumedCapacity...))
+	}
+	if err != nil {
+		backend.Logger.Error("Upload transaction failed", "preset", preset.ID, "error", err.Error())
+
+		var cancelled *dynamodb.TransactionCanceledException
+		for idx := range plan.statements {
+			exec.itemResults[idx] = uploadItemResult{Index: idx, Status: uploadItemRolledBack}
+		}
+		if errors.As(err, &cancelled) && len(cancelled.CancellationReasons) > 0 {
+			for idx, reason := range cancelled.CancellationReasons {
+				if idx >= len(exec.itemResults) || reason == nil {
+					continue
+				}
+				code := aws.StringValue(reason.Code)
+				if code == "" || code == "None" {
+					continue
+				}
+				exec.itemResults[idx] = uploadItemResult{Index: idx, Status: uploadItemFailed, Code: code, Error: aws.StringValue(reason.Message)}
+			}
+		} else {
+			// Without per-item reasons the whole transaction is reported as failed.
+			for idx := range exec.itemResults {
+				exec.itemResults[idx] = uploadItemResult{Index: idx, Status: uploadItemFailed, Code: awsErrorCode(err), Error: err.Error()}
+			}
+		}
+		return exec
+	}
+
+	exec.consumed = append(exec.consumed, output.ConsumedCapacity...)
+	for idx := range plan.statements {
+		exec.itemResults[idx] = uploadItemResult{Index: idx, Status: uploadItemSucceeded}
+		if preset.Operation == UploadOperationSelect && idx < len(output.Responses) && output.Responses[idx] != nil && len(output.Responses[idx].Item) > 0 {
+			exec.selectResults = append(exec.selectResults, output.Responses[idx].Item)
+		}
+	}
+
+	return exec
+}
+
+// statementParams returns nil for empty parameter lists, which the API rejects.
+func statementParams(params []*dynamodb.AttributeValue) []*dynamodb.AttributeValue {
+	if len(params) == 0 {
+		return nil
+	}
+	return params
+}
+
+func awsErrorCode(err error) string {
+	var aerr awserr.Error
+	if errors.As(err, &aerr) {
+		return aerr.Code()
+	}
+	return ""
+}
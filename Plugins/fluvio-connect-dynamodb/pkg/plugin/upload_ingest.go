@@ -0,0 +1,483 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// Body formats accepted by the upload endpoints. JSON bodies carry an uploadExecuteRequest;
+// the other formats carry the raw file and take their options from the query string.
+const (
+	uploadFormatJSON   = "json"
+	uploadFormatCSV    = "csv"
+	uploadFormatTSV    = "tsv"
+	uploadFormatNDJSON = "ndjson"
+	uploadFormatXLSX   = "xlsx"
+)
+
+// maxUploadRowErrors caps the row errors collected for one file.
+const maxUploadRowErrors = 1000
+
+// maxNDJSONLineBytes is the longest NDJSON line accepted.
+const maxNDJSONLineBytes = 16 * 1024 * 1024
+
+var uploadContentTypes = map[string]string{
+	"application/json":          uploadFormatJSON,
+	"text/csv":                  uploadFormatCSV,
+	"application/csv":           uploadFormatCSV,
+	"text/tab-separated-values": uploadFormatTSV,
+	"application/x-ndjson":      uploadFormatNDJSON,
+	"application/ndjson":        uploadFormatNDJSON,
+	"application/jsonl":         uploadFormatNDJSON,
+	"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet": uploadFormatXLSX,
+}
+
+// uploadRowError describes a source row that was not turned into an upload item.
+type uploadRowError struct {
+	Row    int    `json:"row"` // 1-based row (line for NDJSON) in the source file
+	Column string `json:"column,omitempty"`
+	Error  string `json:"error"`
+}
+
+// uploadFileOptions are the query string options of a raw file upload.
+type uploadFileOptions struct {
+	format    string
+	delimiter rune
+	encoding  string
+	header    string // auto, true or false
+	sheet     string
+	mapping   map[string]string // source column (header or 1-based position) -> preset field
+
+	maxEntryBytes int64 // uncompressed size limit of each XLSX entry
+}
+
+// xlsxExpansionFactor bounds the uncompressed size of every XLSX entry to a multiple of the
+// payload limit of the endpoint, since worksheet XML is several times larger than its items.
+const xlsxExpansionFactor = 8
+
+// uploadBodyFormat returns the format of the request body, from the format query parameter
+// or the Content-Type header. JSON is assumed when neither is set.
+func uploadBodyFormat(req *backend.CallResourceRequest, query url.Values) (string, error) {
+	if format := strings.ToLower(strings.TrimSpace(query.Get("format"))); format != "" {
+		switch format {
+		case uploadFormatJSON, uploadFormatCSV, uploadFormatTSV, uploadFormatNDJSON, uploadFormatXLSX:
+			return format, nil
+		case "jsonl":
+			return uploadFormatNDJSON, nil
+		}
+		return "", fmt.Errorf("unsupported upload format %q (expected json, csv, tsv, ndjson or xlsx)", format)
+	}
+
+	for name, values := range req.Headers {
+		if !strings.EqualFold(name, "Content-Type") || len(values) == 0 {
+			continue
+		}
+		mediaType, _, err := mime.ParseMediaType(values[0])
+		if err != nil {
+			break
+		}
+		if format, ok := uploadContentTypes[mediaType]; ok {
+			return format, nil
+		}
+	}
+	return uploadFormatJSON, nil
+}
+
+func parseUploadFileOptions(format string, query url.Values) (uploadFileOptions, error) {
+	opts := uploadFileOptions{
+		format:   format,
+		encoding: strings.TrimSpace(query.Get("encoding")),
+		header:   strings.ToLower(strings.TrimSpace(query.Get("header"))),
+		sheet:    query.Get("sheet"),
+	}
+
+	switch opts.header {
+	case "":
+		opts.header = "auto"
+	case "auto", "true", "false":
+	default:
+		return opts, fmt.Errorf("invalid header option %q (expected auto, true or false)", opts.header)
+	}
+
+	opts.delimiter = ','
+	if format == uploadFormatTSV {
+		opts.delimiter = '\t'
+	}
+	if raw := query.Get("delimiter"); raw != "" {
+		switch strings.ToLower(raw) {
+		case `\t`, "tab":
+			opts.delimiter = '\t'
+		default:
+			runes := []rune(raw)
+			if len(runes) != 1 || runes[0] == '"' || runes[0] == '\r' || runes[0] == '\n' {
+				return opts, fmt.Errorf("invalid delimiter %q", raw)
+			}
+			opts.delimiter = runes[0]
+		}
+	}
+
+	if raw := query.Get("mapping"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &opts.mapping); err != nil {
+			return opts, fmt.Errorf("invalid mapping: %w", err)
+		}
+	}
+
+	return opts, nil
+}
+
+// uploadRequestFromQuery reads the request fields of a raw file upload from the query string.
+func uploadRequestFromQuery(query url.Values) (uploadExecuteRequest, error) {
+	request := uploadExecuteRequest{
+		PresetID: query.Get("presetId"),
+		Mode:     query.Get("mode"),
+	}
+	if raw := query.Get("dryRun"); raw != "" {
+		dryRun, err := strconv.ParseBool(raw)
+		if err != nil {
+			return request, fmt.Errorf("invalid dryRun %q", raw)
+		}
+		request.DryRun = dryRun
+	}
+	return request, nil
+}
+
+// parseUploadFile turns a raw CSV, TSV, NDJSON or XLSX body into upload items. Rows that
+// cannot be parsed, converted or validated against the preset are skipped and reported.
+func parseUploadFile(body []byte, opts uploadFileOptions, preset UploadPreset) ([]map[string]interface{}, []int, []uploadRowError, error) {
+	ingest := newUploadIngest(preset, opts)
+
+	var err error
+	switch opts.format {
+	case uploadFormatCSV, uploadFormatTSV:
+		err = ingest.readDelimited(body)
+	case uploadFormatNDJSON:
+		err = ingest.readNDJSON(body)
+	case uploadFormatXLSX:
+		err = readXLSX(body, opts.sheet, opts.maxEntryBytes, ingest.addRecord)
+	default:
+		err = fmt.Errorf("unsupported upload format %q", opts.format)
+	}
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ingest.flush()
+
+	if len(ingest.items) == 0 && len(ingest.rowErrors) > 0 {
+		first := ingest.rowErrors[0]
+		return nil, nil, ingest.rowErrors, fmt.Errorf("no valid rows, row %d: %s", first.Row, first.Error)
+	}
+
+	backend.Logger.Info("Parsed upload file", "format", opts.format, "items", len(ingest.items), "rowErrors", len(ingest.rowErrors))
+	return ingest.items, ingest.itemRows, ingest.rowErrors, nil
+}
+
+// uploadIngest converts source rows into upload items one at a time.
+type uploadIngest struct {
+	preset    UploadPreset
+	opts      uploadFileOptions
+	fields    map[string]UploadField // lower-cased field name -> field
+	columns   []string               // target field per column, "" when ignored
+	resolved  bool
+	pending   []string // first row held back while detecting the header
+	pendingAt int
+	items     []map[string]interface{}
+	itemRows  []int // source row of each item
+	rowErrors []uploadRowError
+}
+
+func newUploadIngest(preset UploadPreset, opts uploadFileOptions) *uploadIngest {
+	fields := make(map[string]UploadField, len(preset.Schema))
+	for _, field := range preset.Schema {
+		if field.Name != "" {
+			fields[strings.ToLower(field.Name)] = field
+		}
+	}
+	return &uploadIngest{preset: preset, opts: opts, fields: fields}
+}
+
+func (in *uploadIngest) addRowError(row int, column string, err error) {
+	if len(in.rowErrors) < maxUploadRowErrors {
+		in.rowErrors = append(in.rowErrors, uploadRowError{Row: row, Column: column, Error: err.Error()})
+	}
+}
+
+// decodedReader applies the requested text encoding. Without one, UTF-8 is assumed and a
+// UTF-8 or UTF-16 byte order mark is honoured.
+func (in *uploadIngest) decodedReader(body []byte) (io.Reader, error) {
+	if in.opts.encoding == "" {
+		return transform.NewReader(bytes.NewReader(body), unicode.BOMOverride(unicode.UTF8.NewDecoder())), nil
+	}
+	enc, err := htmlindex.Get(in.opts.encoding)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported encoding %q", in.opts.encoding)
+	}
+	return transform.NewReader(bytes.NewReader(body), unicode.BOMOverride(enc.NewDecoder())), nil
+}
+
+func (in *uploadIngest) readDelimited(body []byte) error {
+	reader, err := in.decodedReader(body)
+	if err != nil {
+		return err
+	}
+
+	r := csv.NewReader(reader)
+	r.Comma = in.opts.delimiter
+	r.FieldsPerRecord = -1
+	r.LazyQuotes = true
+
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			in.addRowError(parseErr.StartLine, "", parseErr.Err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read file: %w", err)
+		}
+		line, _ := r.FieldPos(0)
+		if err := in.addRecord(line, record); err != nil {
+			return err
+		}
+	}
+}
+
+func (in *uploadIngest) readNDJSON(body []byte) error {
+	reader, err := in.decodedReader(body)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxNDJSONLineBytes)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+
+		var raw map[string]interface{}
+		if err := json.Unmarshal(text, &raw); err != nil {
+			in.addRowError(line, "", fmt.Errorf("invalid JSON: %w", err))
+			continue
+		}
+
+		item := raw
+		if len(in.opts.mapping) > 0 {
+			item = make(map[string]interface{}, len(raw))
+			for key, value := range raw {
+				if field, ok := in.opts.mapping[key]; ok && field != "" {
+					item[field] = value
+				}
+			}
+		}
+		in.addItem(line, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read line %d: %w", line+1, err)
+	}
+	return nil
+}
+
+// addRecord handles one tabular row. The first row decides the column layout.
+func (in *uploadIngest) addRecord(row int, record []string) error {
+	if isBlankRecord(record) {
+		return nil
+	}
+
+	if !in.resolved {
+		switch {
+		case in.opts.header == "true":
+			in.resolveColumns(record)
+			return nil
+		case in.opts.header == "false":
+			in.resolveColumns(nil)
+		case in.pending != nil:
+			// Auto detection: an all-text first row followed by a row with numbers is a header.
+			if hasNumericCell(record) {
+				in.resolveColumns(in.pending)
+			} else {
+				in.resolveColumns(nil)
+				in.convertRecord(in.pendingAt, in.pending)
+			}
+			in.pending = nil
+		case in.matchesKnownColumn(record):
+			in.resolveColumns(record)
+			return nil
+		case hasNumericCell(record):
+			in.resolveColumns(nil)
+		default:
+			in.pending, in.pendingAt = record, row
+			return nil
+		}
+	}
+
+	in.convertRecord(row, record)
+	return nil
+}
+
+// flush processes a row still held back by header detection.
+func (in *uploadIngest) flush() {
+	if in.pending != nil {
+		in.resolveColumns(nil)
+		in.convertRecord(in.pendingAt, in.pending)
+		in.pending = nil
+	}
+}
+
+func (in *uploadIngest) matchesKnownColumn(record []string) bool {
+	for _, cell := range record {
+		cell = strings.TrimSpace(cell)
+		if _, ok := in.opts.mapping[cell]; ok {
+			return true
+		}
+		if _, ok := in.fields[strings.ToLower(cell)]; ok && cell != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveColumns maps source columns to preset fields. header is nil when the file has no
+// header row; columns are then addressed by their 1-based position or follow the schema order.
+func (in *uploadIngest) resolveColumns(header []string) {
+	in.resolved = true
+	if header == nil {
+		return
+	}
+
+	in.columns = make([]string, len(header))
+	for i, name := range header {
+		in.columns[i] = in.targetField(strings.TrimSpace(name), i)
+	}
+}
+
+func (in *uploadIngest) targetField(header string, position int) string {
+	if len(in.opts.mapping) > 0 {
+		if field, ok := in.opts.mapping[header]; ok && header != "" {
+			return field
+		}
+		return in.opts.mapping[strconv.Itoa(position+1)]
+	}
+	if header == "" {
+		if position < len(in.preset.Schema) {
+			return in.preset.Schema[position].Name
+		}
+		return ""
+	}
+	if field, ok := in.fields[strings.ToLower(header)]; ok {
+		return field.Name
+	}
+	return header
+}
+
+func (in *uploadIngest) convertRecord(row int, record []string) {
+	item := make(map[string]interface{}, len(record))
+	for i, cell := range record {
+		var name string
+		if in.columns != nil {
+			if i >= len(in.columns) {
+				in.addRowError(row, strconv.Itoa(i+1), fmt.Errorf("row has %d columns, header has %d", len(record), len(in.columns)))
+				return
+			}
+			name = in.columns[i]
+		} else {
+			name = in.targetField("", i)
+		}
+		if name == "" {
+			continue
+		}
+
+		cell = strings.TrimSpace(cell)
+		if cell == "" {
+			continue
+		}
+
+		value, err := convertCellValue(in.fields[strings.ToLower(name)], cell)
+		if err != nil {
+			in.addRowError(row, name, err)
+			return
+		}
+		item[name] = value
+	}
+	in.addItem(row, item)
+}
+
+// addItem keeps the item when it passes the preset transformations and validation, so a
+// bad row is reported on its own instead of failing the whole upload.
+func (in *uploadIngest) addItem(row int, item map[string]interface{}) {
+	if len(item) == 0 {
+		return
+	}
+	transformed, err := applyFieldTransformations(in.preset, item)
+	if err == nil {
+		err = validateItemAgainstPreset(in.preset, transformed)
+	}
+	if err != nil {
+		in.addRowError(row, "", err)
+		return
+	}
+	in.items = append(in.items, item)
+	in.itemRows = append(in.itemRows, row)
+}
+
+// convertCellValue converts a text cell to the declared field type. Fields with a
+// transformation receive the text unchanged; the transformation does the conversion.
+func convertCellValue(field UploadField, cell string) (interface{}, error) {
+	if field.Transformation != nil {
+		return cell, nil
+	}
+	switch strings.ToLower(field.Type) {
+	case "number":
+		f, err := strconv.ParseFloat(cell, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", cell)
+		}
+		return f, nil
+	case "boolean", "bool":
+		b, err := strconv.ParseBool(strings.ToLower(cell))
+		if err != nil {
+			return nil, fmt.Errorf("invalid boolean %q", cell)
+		}
+		return b, nil
+	default:
+		return cell, nil
+	}
+}
+
+func isBlankRecord(record []string) bool {
+	for _, cell := range record {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+func hasNumericCell(record []string) bool {
+	for _, cell := range record {
+		if _, err := strconv.ParseFloat(strings.TrimSpace(cell), 64); err == nil {
+			return true
+		}
+	}
+	return false
+}
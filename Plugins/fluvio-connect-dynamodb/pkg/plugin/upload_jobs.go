@@ -0,0 +1,815 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// Upload job statuses.
+const (
+	uploadJobRunning   = "running"
+	uploadJobSucceeded = "succeeded"
+	uploadJobFailed    = "failed"
+	uploadJobCancelled = "cancelled"
+)
+
+// defaultMaxJobPayloadKB is the payload limit of background upload jobs when the datasource
+// does not configure maxJobPayloadKB.
+const defaultMaxJobPayloadKB = 50 * 1024
+
+var (
+	errUploadJobCancelled   = errors.New("cancelled by user")
+	errUploadJobInterrupted = errors.New("interrupted because the datasource was reloaded")
+)
+
+// uploadJob is the persisted state of a background upload. Items before NextIndex have been
+// attempted; FailedItems are retried first when the job is resumed.
+type uploadJob struct {
+	ID               string                    `json:"id"`
+	DatasourceUID    string                    `json:"datasourceUid"`
+	PresetID         string                    `json:"presetId"`
+	Mode             string                    `json:"mode"`
+	Status           string                    `json:"status"`
+	ItemCount        int                       `json:"itemCount"`
+	SucceededCount   int                       `json:"succeededCount"`
+	FailedCount      int                       `json:"failedCount"`
+	UnchangedCount   int                       `json:"unchangedCount,omitempty"` // upsert items that needed no write
+	NextIndex        int                       `json:"nextIndex"`
+	FailedItems      []uploadItemResult        `json:"failedItems,omitempty"`
+	ConsumedCapacity []consumedCapacitySummary `json:"consumedCapacity,omitempty"`
+	RowErrors        []uploadRowError          `json:"rowErrors,omitempty"` // rows of the source file that were not queued
+	Error            string                    `json:"error,omitempty"`
+	AuditID          string                    `json:"auditId,omitempty"`
+	Access           *UploadPresetAccess       `json:"access,omitempty"`           // preset access when the job was created; nil for older jobs
+	ImagesIncomplete bool                      `json:"imagesIncomplete,omitempty"` // prior images of some items were not captured, so the job cannot be undone
+	CreatedAt        time.Time                 `json:"createdAt"`
+	UpdatedAt        time.Time                 `json:"updatedAt"`
+	FinishedAt       *time.Time                `json:"finishedAt,omitempty"`
+}
+
+// uploadJobPayload is stored next to the job state so the job can be resumed with the exact
+// preset and items it was created with.
+type uploadJobPayload struct {
+	Preset UploadPreset             `json:"preset"`
+	Items  []map[string]interface{} `json:"items"`
+}
+
+func (j *uploadJob) clone() uploadJob {
+	c := *j
+	c.FailedItems = append([]uploadItemResult(nil), j.FailedItems...)
+	c.ConsumedCapacity = append([]consumedCapacitySummary(nil), j.ConsumedCapacity...)
+	c.RowErrors = append([]uploadRowError(nil), j.RowErrors...)
+	return c
+}
+
+func (j *uploadJob) resumable() bool {
+	return (j.Status == uploadJobFailed || j.Status == uploadJobCancelled) &&
+		(len(j.FailedItems) > 0 || j.NextIndex < j.ItemCount)
+}
+
+// runningUploadJob tracks a job executing in this process.
+type runningUploadJob struct {
+	owner  *Datasource
+	cancel context.CancelCauseFunc
+}
+
+// uploadJobManager owns the jobs stored in one directory. There is a single manager per
+// directory so datasource instances replaced after a settings change share the same state.
+type uploadJobManager struct {
+	mu      sync.Mutex
+	dir     string
+	jobs    map[string]*uploadJob
+	running map[string]*runningUploadJob
+}
+
+var (
+	uploadJobManagersMu sync.Mutex
+	uploadJobManagers   = map[string]*uploadJobManager{}
+)
+
+// getUploadJobsDir returns the directory where upload jobs are stored
+func getUploadJobsDir() string {
+	dataDir := os.Getenv("GF_PATHS_DATA")
+	if dataDir == "" {
+		dataDir = "data"
+	}
+	return filepath.Join(dataDir, "dynamodb-upload-jobs")
+}
+
+// uploadJobs returns the job manager for the current data directory, loading the persisted
+// jobs on first use.
+func uploadJobs() (*uploadJobManager, error) {
+	dir := getUploadJobsDir()
+
+	uploadJobManagersMu.Lock()
+	defer uploadJobManagersMu.Unlock()
+
+	if m, ok := uploadJobManagers[dir]; ok {
+		return m, nil
+	}
+
+	m := &uploadJobManager{dir: dir, jobs: map[string]*uploadJob{}, running: map[string]*runningUploadJob{}}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	uploadJobManagers[dir] = m
+	return m, nil
+}
+
+// load reads the persisted jobs. Jobs that were running when the plugin stopped are marked
+// failed so they can be resumed.
+func (m *uploadJobManager) load() error {
+	if err := os.MkdirAll(m.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create upload jobs directory: %w", err)
+	}
+
+	files, err := os.ReadDir(m.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read upload jobs: %w", err)
+	}
+
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".items.json") {
+			continue
+		}
+
+		raw, err := os.ReadFile(filepath.Join(m.dir, name))
+		if err != nil {
+			backend.Logger.Warn("Failed to read upload job", "file", name, "error", err.Error())
+			continue
+		}
+		var job uploadJob
+		if err := json.Unmarshal(raw, &job); err != nil || job.ID == "" {
+			backend.Logger.Warn("Failed to parse upload job", "file", name)
+			continue
+		}
+
+		if job.Status == uploadJobRunning {
+			job.Status = uploadJobFailed
+			job.Error = "interrupted by a plugin restart"
+			job.UpdatedAt = time.Now().UTC()
+			if err := m.writeJob(&job); err != nil {
+				backend.Logger.Warn("Failed to persist interrupted upload job", "job", job.ID, "error", err.Error())
+			}
+		}
+		m.jobs[job.ID] = &job
+	}
+
+	return nil
+}
+
+func (m *uploadJobManager) jobPath(id string) string {
+	return filepath.Join(m.dir, id+".json")
+}
+
+func (m *uploadJobManager) payloadPath(id string) string {
+	return filepath.Join(m.dir, id+".items.json")
+}
+
+// imagesPath, afterPath and resultsPath hold the prior images, the images after writing and
+// the item results of a job, one JSON value per line, appended chunk by chunk and folded into
+// the audit entry when it finishes.
+func (m *uploadJobManager) imagesPath(id string) string {
+	return filepath.Join(m.dir, id+".images.ndjson")
+}
+
+func (m *uploadJobManager) afterPath(id string) string {
+	return filepath.Join(m.dir, id+".after.ndjson")
+}
+
+func (m *uploadJobManager) resultsPath(id string) string {
+	return filepath.Join(m.dir, id+".results.ndjson")
+}
+
+// writeJob persists the job state atomically.
+func (m *uploadJobManager) writeJob(job *uploadJob) error {
+	raw, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(m.jobPath(job.ID), raw)
+}
+
+func writeFileAtomic(path string, raw []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, raw, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (m *uploadJobManager) readPayload(id string) (*uploadJobPayload, error) {
+	raw, err := os.ReadFile(m.payloadPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read job items: %w", err)
+	}
+	var payload uploadJobPayload
+	if err := json.Unmarshal(raw, &payload); err != nil {
+		return nil, fmt.Errorf("failed to parse job items: %w", err)
+	}
+	return &payload, nil
+}
+
+// storedStatus returns the persisted status of a job without taking m.mu, so it can be read
+// while auditMu is held. Every status change is written before the job manager releases m.mu.
+func (m *uploadJobManager) storedStatus(id string) (string, error) {
+	raw, err := os.ReadFile(m.jobPath(id))
+	if err != nil {
+		return "", err
+	}
+	var job uploadJob
+	if err := json.Unmarshal(raw, &job); err != nil {
+		return "", err
+	}
+	return job.Status, nil
+}
+
+// get returns a copy of the job, or false when it does not exist.
+func (m *uploadJobManager) get(id string) (uploadJob, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job, ok := m.jobs[id]
+	if !ok {
+		return uploadJob{}, false
+	}
+	return job.clone(), true
+}
+
+// list returns the jobs of a datasource, newest first.
+func (m *uploadJobManager) list(datasourceUID string) []uploadJob {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jobs := make([]uploadJob, 0, len(m.jobs))
+	for _, job := range m.jobs {
+		if job.DatasourceUID == datasourceUID {
+			jobs = append(jobs, job.clone())
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+	})
+	return jobs
+}
+
+// create persists a new job with its payload and audit entry and starts it.
+func (m *uploadJobManager) create(d *Datasource, client *dynamodb.DynamoDB, datasourceUID string, preset UploadPreset, plan *uploadPlan, request uploadExecuteRequest, mode string, audit *auditEntry) (uploadJob, error) {
+	id, err := newUploadJobID()
+	if err != nil {
+		return uploadJob{}, err
+	}
+
+	raw, err := json.Marshal(uploadJobPayload{Preset: preset, Items: request.Items})
+	if err != nil {
+		return uploadJob{}, fmt.Errorf("failed to marshal job items: %w", err)
+	}
+	if err := writeFileAtomic(m.payloadPath(id), raw); err != nil {
+		return uploadJob{}, fmt.Errorf("failed to store job items: %w", err)
+	}
+
+	now := time.Now().UTC()
+	job := &uploadJob{
+		ID:            id,
+		DatasourceUID: datasourceUID,
+		PresetID:      preset.ID,
+		Mode:          mode,
+		Status:        uploadJobRunning,
+		ItemCount:     len(plan.statements),
+		RowErrors:     request.rowErrors,
+		Access:        &UploadPresetAccess{},
+		CreatedAt:     now,
+		UpdatedAt:     now,
+	}
+	if preset.Access != nil {
+		access := *preset.Access
+		job.Access = &access
+	}
+
+	audit.JobID = id
+	audit.Mode = mode
+	audit.ItemCount = len(plan.statements)
+	audit.Items = plan.items
+	audit.Statements = plan.statementPreviews
+	if err := writeAuditEntry(audit); err != nil {
+		backend.Logger.Error("Failed to write upload job audit entry", "job", id, "error", err.Error())
+	} else {
+		job.AuditID = audit.ID
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if err := m.writeJob(job); err != nil {
+		return uploadJob{}, fmt.Errorf("failed to store job: %w", err)
+	}
+	m.jobs[id] = job
+	m.start(d, client, job, preset, plan)
+	return job.clone(), nil
+}
+
+// resume restarts a failed or cancelled job from the last successful item. Upsert items are
+// compared with the stored items again, since the table may have changed in the meantime.
+func (m *uploadJobManager) resume(ctx context.Context, d *Datasource, client *dynamodb.DynamoDB, id string, maxPayloadKB int64) (uploadJob, error) {
+	payload, err := m.readPayload(id)
+	if err != nil {
+		return uploadJob{}, err
+	}
+	plan, err := buildJobUploadPlan(payload.Preset, maxPayloadKB, payload.Items)
+	if err == nil {
+		err = d.planUpsert(ctx, func() (*dynamodb.DynamoDB, error) { return client, nil }, payload.Preset, plan, nil)
+	}
+	if err != nil {
+		return uploadJob{}, err
+	}
+	if len(plan.violations) > 0 {
+		return uploadJob{}, fmt.Errorf("%d item(s) conflict with stored items: %s", len(plan.violations), plan.violations[0].Error)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	job, ok := m.jobs[id]
+	if !ok {
+		return uploadJob{}, fmt.Errorf("upload job %q not found", id)
+	}
+	if _, running := m.running[id]; running || !job.resumable() {
+		return uploadJob{}, fmt.Errorf("upload job %q is %s and cannot be resumed", id, job.Status)
+	}
+
+	// The undo check and the running status are written under auditMu, so an undo either
+	// completes first and blocks the resume or sees the job running and refuses.
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	if job.AuditID != "" {
+		if entry, err := readAuditEntry(job.AuditID); err == nil && entry.UndoneBy != "" {
+			return uploadJob{}, fmt.Errorf("upload job %q was undone and cannot be resumed", id)
+		}
+	}
+
+	job.Status = uploadJobRunning
+	job.Error = ""
+	job.FinishedAt = nil
+	job.UpdatedAt = time.Now().UTC()
+	if err := m.writeJob(job); err != nil {
+		return uploadJob{}, fmt.Errorf("failed to store job: %w", err)
+	}
+	m.start(d, client, job, payload.Preset, plan)
+	return job.clone(), nil
+}
+
+// cancel stops a running job. It returns false when the job is not running.
+func (m *uploadJobManager) cancel(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	run, ok := m.running[id]
+	if !ok {
+		return false
+	}
+	run.cancel(errUploadJobCancelled)
+	return true
+}
+
+// interrupt stops the jobs started by a datasource instance that is being disposed.
+func (m *uploadJobManager) interrupt(owner *Datasource) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, run := range m.running {
+		if run.owner == owner {
+			run.cancel(errUploadJobInterrupted)
+		}
+	}
+}
+
+// start runs the job in the background. m.mu must be held.
+func (m *uploadJobManager) start(d *Datasource, client *dynamodb.DynamoDB, job *uploadJob, preset UploadPreset, plan *uploadPlan) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	m.running[job.ID] = &runningUploadJob{owner: d, cancel: cancel}
+
+	// Failed items are retried before continuing with the items never attempted.
+	queue := make([]int, 0, len(job.FailedItems)+job.ItemCount-job.NextIndex)
+	for _, r := range job.FailedItems {
+		queue = append(queue, r.Index)
+	}
+	for idx := job.NextIndex; idx < job.ItemCount; idx++ {
+		queue = append(queue, idx)
+	}
+
+	go m.run(ctx, d, client, job.ID, preset, plan, queue)
+}
+
+func (m *uploadJobManager) run(ctx context.Context, d *Datasource, client *dynamodb.DynamoDB, id string, preset UploadPreset, plan *uploadPlan, queue []int) {
+	defer func() {
+		m.mu.Lock()
+		if run, ok := m.running[id]; ok {
+			run.cancel(nil)
+			delete(m.running, id)
+		}
+		m.mu.Unlock()
+	}()
+
+	m.mu.Lock()
+	job := m.jobs[id]
+	mode := job.Mode
+	previous := map[int]uploadItemResult{}
+	for _, r := range job.FailedItems {
+		previous[r.Index] = r
+	}
+	job.FailedItems = nil
+	audited := job.AuditID != ""
+	m.mu.Unlock()
+
+	chunkSize := maxBatchStatements
+	if mode == uploadModeTransaction {
+		chunkSize = maxTransactionStatements
+	}
+
+	backend.Logger.Info("Upload job started", "job", id, "preset", preset.ID, "mode", mode, "items", len(queue))
+
+	stopped := false
+	for start := 0; start < len(queue); start += chunkSize {
+		end := start + chunkSize
+		if end > len(queue) {
+			end = len(queue)
+		}
+		chunk := queue[start:end]
+
+		if ctx.Err() != nil {
+			m.keepUnattempted(id, chunk, previous)
+			stopped = true
+			break
+		}
+
+		var images []auditItemImage
+		if audited {
+			images = m.captureChunkImages(ctx, d, client, id, &preset, plan, chunk)
+		}
+
+		sub := &uploadPlan{statements: make([]uploadStatement, 0, len(chunk))}
+		for _, idx := range chunk {
+			sub.statements = append(sub.statements, plan.statements[idx])
+		}
+
+		exec, err := d.executeUploadPlan(ctx, client, &preset, sub, mode)
+		if err != nil {
+			m.finish(id, uploadJobFailed, err.Error())
+			return
+		}
+
+		if images != nil {
+			m.captureChunkAfterImages(ctx, d, client, id, preset.Table, images, chunk, exec)
+		}
+
+		if failed := m.recordChunk(id, preset.Table, chunk, exec, previous); failed && mode != uploadModeBatch {
+			// Sequential and transaction jobs stop at the first failure so they can be
+			// resumed from the failed item.
+			m.keepUnattempted(id, queue[end:], previous)
+			stopped = true
+			break
+		}
+	}
+
+	switch cause := context.Cause(ctx); {
+	case errors.Is(cause, errUploadJobCancelled):
+		m.finish(id, uploadJobCancelled, "")
+	case cause != nil:
+		m.finish(id, uploadJobFailed, cause.Error())
+	case stopped:
+		m.finish(id, uploadJobFailed, "")
+	default:
+		m.finish(id, "", "")
+	}
+}
+
+// captureChunkImages stores the prior images of the items of a chunk before it is written, so
+// the job can be undone like a synchronous upload. Once a chunk fails to be captured the job
+// is no longer undoable and later chunks are not read. It returns the captured images.
+func (m *uploadJobManager) captureChunkImages(ctx context.Context, d *Datasource, client *dynamodb.DynamoDB, id string, preset *UploadPreset, plan *uploadPlan, chunk []int) []auditItemImage {
+	m.mu.Lock()
+	incomplete := m.jobs[id].ImagesIncomplete
+	m.mu.Unlock()
+	if incomplete {
+		return nil
+	}
+
+	items := make([]map[string]interface{}, len(chunk))
+	for i, idx := range chunk {
+		items[i] = plan.items[idx]
+	}
+	images, err := d.captureItemImages(ctx, client, preset, items)
+	if err == nil {
+		for i := range images {
+			images[i].Index = chunk[images[i].Index]
+		}
+		err = appendJSONLines(m.imagesPath(id), images)
+	}
+	if err == nil {
+		return images
+	}
+	if ctx.Err() == nil {
+		backend.Logger.Warn("Prior item images not captured, upload job cannot be undone", "job", id, "error", err.Error())
+		m.markImagesIncomplete(id)
+	}
+	return nil
+}
+
+// captureChunkAfterImages stores the images the chunk left for its succeeded items, which undo
+// requires the items to still match.
+func (m *uploadJobManager) captureChunkAfterImages(ctx context.Context, d *Datasource, client *dynamodb.DynamoDB, id string, table string, images []auditItemImage, chunk []int, exec *uploadExecution) {
+	results := make([]uploadItemResult, len(exec.itemResults))
+	for i, r := range exec.itemResults {
+		r.Index = chunk[i]
+		results[i] = r
+	}
+	err := d.captureAfterImages(ctx, client, table, images, results)
+	if err == nil {
+		written := make([]auditItemImage, 0, len(images))
+		for _, image := range images {
+			if image.HasAfter {
+				written = append(written, auditItemImage{Index: image.Index, Key: image.Key, After: image.After, HasAfter: true})
+			}
+		}
+		err = appendJSONLines(m.afterPath(id), written)
+	}
+	if err != nil && ctx.Err() == nil {
+		backend.Logger.Warn("Item images after upload not captured, upload job cannot be undone", "job", id, "error", err.Error())
+		m.markImagesIncomplete(id)
+	}
+}
+
+func (m *uploadJobManager) markImagesIncomplete(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job := m.jobs[id]
+	job.ImagesIncomplete = true
+	if err := m.writeJob(job); err != nil {
+		backend.Logger.Error("Failed to persist upload job", "job", id, "error", err.Error())
+	}
+}
+
+// recordChunk folds the results of one chunk into the job and persists it. It reports
+// whether any item of the chunk failed.
+func (m *uploadJobManager) recordChunk(id string, table string, chunk []int, exec *uploadExecution, previous map[int]uploadItemResult) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job := m.jobs[id]
+
+	anyFailed := false
+	attempted := make([]uploadItemResult, 0, len(chunk))
+	for i, idx := range chunk {
+		r := exec.itemResults[i]
+		r.Index = idx
+		if r.Status != uploadItemSkipped {
+			attempted = append(attempted, r)
+		}
+		switch r.Status {
+		case uploadItemSucceeded:
+			job.SucceededCount++
+		case uploadItemUnchanged:
+			job.UnchangedCount++
+		case uploadItemSkipped:
+			if prev, ok := previous[idx]; ok {
+				job.FailedItems = append(job.FailedItems, prev)
+			}
+			continue
+		default:
+			anyFailed = true
+			job.FailedItems = append(job.FailedItems, r)
+		}
+		if idx >= job.NextIndex {
+			job.NextIndex = idx + 1
+		}
+	}
+
+	job.FailedCount = len(job.FailedItems)
+	job.ConsumedCapacity = mergeConsumedCapacity(job.ConsumedCapacity, exec.consumedCapacity(table))
+	job.UpdatedAt = time.Now().UTC()
+	if err := m.writeJob(job); err != nil {
+		backend.Logger.Error("Failed to persist upload job progress", "job", id, "error", err.Error())
+	}
+	if job.AuditID != "" {
+		if err := appendJSONLines(m.resultsPath(id), attempted); err != nil {
+			backend.Logger.Error("Failed to record upload job item results", "job", id, "error", err.Error())
+		}
+	}
+	return anyFailed
+}
+
+// keepUnattempted carries the previous failures of queued items that were not attempted.
+func (m *uploadJobManager) keepUnattempted(id string, indexes []int, previous map[int]uploadItemResult) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job := m.jobs[id]
+	for _, idx := range indexes {
+		if prev, ok := previous[idx]; ok {
+			job.FailedItems = append(job.FailedItems, prev)
+		}
+	}
+	job.FailedCount = len(job.FailedItems)
+}
+
+// finish records the final status of a job. An empty status is derived from the failures.
+func (m *uploadJobManager) finish(id string, status string, message string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	job := m.jobs[id]
+
+	sort.Slice(job.FailedItems, func(i, j int) bool {
+		return job.FailedItems[i].Index < job.FailedItems[j].Index
+	})
+	if status == "" {
+		status = uploadJobSucceeded
+		if len(job.FailedItems) > 0 {
+			status = uploadJobFailed
+		}
+	}
+	if message == "" && status == uploadJobFailed && len(job.FailedItems) > 0 {
+		first := job.FailedItems[0]
+		message = fmt.Sprintf("item %d: %s", first.Index+1, first.Error)
+	}
+
+	now := time.Now().UTC()
+	job.Status = status
+	job.Error = message
+	job.UpdatedAt = now
+	job.FinishedAt = &now
+	if err := m.writeJob(job); err != nil {
+		backend.Logger.Error("Failed to persist upload job", "job", id, "error", err.Error())
+	}
+	m.writeJobAudit(job)
+
+	backend.Logger.Info("Upload job finished", "job", id, "status", status, "succeeded", job.SucceededCount, "failed", job.FailedCount)
+}
+
+// jobImagesWarning marks the audit entry of a job whose prior images are incomplete.
+const jobImagesWarning = "prior images not captured for every item, upload cannot be undone"
+
+// writeJobAudit folds the item results and images of a job into its audit entry. The last
+// result and image after writing of an item win, since resumed jobs retry failed items; the
+// first prior image wins, since it is the state before the job first touched the item. m.mu
+// must be held.
+func (m *uploadJobManager) writeJobAudit(job *uploadJob) {
+	if job.AuditID == "" {
+		return
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	entry, err := readAuditEntry(job.AuditID)
+	if err != nil {
+		backend.Logger.Error("Failed to read upload job audit entry", "job", job.ID, "error", err.Error())
+		return
+	}
+
+	results, err := readJSONLines[uploadItemResult](m.resultsPath(job.ID))
+	if err != nil {
+		backend.Logger.Error("Failed to read upload job item results", "job", job.ID, "error", err.Error())
+	}
+	latest := map[int]uploadItemResult{}
+	for _, r := range results {
+		latest[r.Index] = r
+	}
+	entry.ItemResults = make([]uploadItemResult, 0, len(latest))
+	succeeded := false
+	for _, r := range latest {
+		entry.ItemResults = append(entry.ItemResults, r)
+		succeeded = succeeded || r.Status == uploadItemSucceeded
+	}
+	sort.Slice(entry.ItemResults, func(i, j int) bool {
+		return entry.ItemResults[i].Index < entry.ItemResults[j].Index
+	})
+
+	images, imageErr := readJSONLines[auditItemImage](m.imagesPath(job.ID))
+	afterImages, afterErr := readJSONLines[auditItemImage](m.afterPath(job.ID))
+	after := map[int]auditItemImage{}
+	for _, image := range afterImages {
+		after[image.Index] = image
+	}
+	seen := map[int]bool{}
+	entry.Images = entry.Images[:0]
+	for _, image := range images {
+		if seen[image.Index] {
+			continue
+		}
+		seen[image.Index] = true
+		if a, ok := after[image.Index]; ok {
+			image.After, image.HasAfter = a.After, true
+		}
+		entry.Images = append(entry.Images, image)
+	}
+
+	complete := !job.ImagesIncomplete && imageErr == nil && afterErr == nil
+	if !complete && !slices.Contains(entry.Warnings, jobImagesWarning) {
+		entry.Warnings = append(entry.Warnings, jobImagesWarning)
+	}
+	entry.Undoable = succeeded && complete
+	if err := writeAuditEntry(entry); err != nil {
+		backend.Logger.Error("Failed to write upload job audit entry", "job", job.ID, "error", err.Error())
+	}
+}
+
+// appendJSONLines appends values to a file, one JSON value per line.
+func appendJSONLines[T any](path string, values []T) error {
+	if len(values) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, v := range values {
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readJSONLines reads the values written by appendJSONLines. A missing file holds no values.
+func readJSONLines[T any](path string) ([]T, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var values []T
+	dec := json.NewDecoder(f)
+	for {
+		var v T
+		if err := dec.Decode(&v); err != nil {
+			if errors.Is(err, io.EOF) {
+				return values, nil
+			}
+			return values, err
+		}
+		values = append(values, v)
+	}
+}
+
+// buildJobUploadPlan builds the plan of a job. Jobs are not bound by the synchronous
+// payload limit of the preset, only by the job limit.
+func buildJobUploadPlan(preset UploadPreset, maxPayloadKB int64, items []map[string]interface{}) (*uploadPlan, error) {
+	if preset.Operation == UploadOperationSelect {
+		return nil, errors.New("select presets cannot run as upload jobs")
+	}
+	if maxPayloadKB <= 0 {
+		maxPayloadKB = defaultMaxJobPayloadKB
+	}
+	preset.MaxPayloadKB = 0
+	return buildUploadPlan(preset, maxPayloadKB, items)
+}
+
+// mergeConsumedCapacity adds the summaries of b to a, per table.
+func mergeConsumedCapacity(a, b []consumedCapacitySummary) []consumedCapacitySummary {
+	for _, s := range b {
+		merged := false
+		for i := range a {
+			if a[i].TableName == s.TableName {
+				a[i].CapacityUnits += s.CapacityUnits
+				a[i].ReadUnits += s.ReadUnits
+				a[i].WriteUnits += s.WriteUnits
+				a[i].ThrottleEvents += s.ThrottleEvents
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			a = append(a, s)
+		}
+	}
+	return a
+}
+
+func newUploadJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate job id: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
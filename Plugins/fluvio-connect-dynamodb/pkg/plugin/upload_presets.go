@@ -13,45 +13,64 @@ import (
 )
 
 type uploadPresetSummary struct {
-	ID               string          `json:"id"`
-	Name             string          `json:"name"`
-	Description      string          `json:"description,omitempty"`
-	Table            string          `json:"table"`
-	Index            string          `json:"index,omitempty"`
-	Operation        UploadOperation `json:"operation"`
-	Schema           []UploadField   `json:"schema,omitempty"`
-	AllowAdHocFields bool            `json:"allowAdHocFields,omitempty"`
-	AllowDryRun      bool            `json:"allowDryRun,omitempty"`
-	MaxPayloadKB     int64           `json:"maxPayloadKB,omitempty"`
-	PartiQLTemplate  string          `json:"partiqlTemplate,omitempty"`
-	ResponsePreview  bool            `json:"responsePreview,omitempty"`
-	HelpText         string          `json:"helpText,omitempty"`
-	Category         string          `json:"category,omitempty"`
+	ID               string              `json:"id"`
+	Name             string              `json:"name"`
+	Description      string              `json:"description,omitempty"`
+	Table            string              `json:"table"`
+	Index            string              `json:"index,omitempty"`
+	Operation        UploadOperation     `json:"operation"`
+	Schema           []UploadField       `json:"schema,omitempty"`
+	AllowAdHocFields bool                `json:"allowAdHocFields,omitempty"`
+	AllowDryRun      bool                `json:"allowDryRun,omitempty"`
+	MaxPayloadKB     int64               `json:"maxPayloadKB,omitempty"`
+	PartiQLTemplate  string              `json:"partiqlTemplate,omitempty"`
+	ResponsePreview  bool                `json:"responsePreview,omitempty"`
+	HelpText         string              `json:"helpText,omitempty"`
+	Category         string              `json:"category,omitempty"`
+	Access           *UploadPresetAccess `json:"access,omitempty"`
+	Rules            []UploadRule        `json:"rules,omitempty"`
+	Upsert           *UpsertSettings     `json:"upsert,omitempty"`
 }
 
 type uploadExecuteRequest struct {
 	PresetID string                   `json:"presetId"`
 	Items    []map[string]interface{} `json:"items"`
 	DryRun   bool                     `json:"dryRun,omitempty"`
-	Mode     string                   `json:"mode,omitempty"`
+	Mode     string                   `json:"mode,omitempty"` // sequential (default), batch or transaction
+
+	rowErrors []uploadRowError // rows of a raw file upload that were not turned into items
+	itemRows  []int            // source row of each item of a raw file upload
 }
 
 type uploadPreviewResponse struct {
-	Preset            uploadPresetSummary `json:"preset"`
-	ItemCount         int                 `json:"itemCount"`
-	Statements        []string            `json:"statements"`
-	PayloadSizeBytes  int                 `json:"payloadSizeBytes"`
-	EstimatedCapacity float64             `json:"estimatedCapacityUnits,omitempty"`
+	Preset            uploadPresetSummary      `json:"preset"`
+	ItemCount         int                      `json:"itemCount"`
+	Statements        []string                 `json:"statements"`
+	Items             []map[string]interface{} `json:"items,omitempty"` // items after field transformations
+	PayloadSizeBytes  int                      `json:"payloadSizeBytes"`
+	EstimatedCapacity float64                  `json:"estimatedCapacityUnits,omitempty"`
+	RowErrors         []uploadRowError         `json:"rowErrors,omitempty"`
+	Violations        []uploadViolation        `json:"violations,omitempty"` // items breaking preset rules
+	Upsert            []UpsertRow              `json:"upsert,omitempty"`     // classification of the items of upsert presets
+	Warnings          []string                 `json:"warnings,omitempty"`
 }
 
 type uploadExecuteResponse struct {
 	Preset           uploadPresetSummary       `json:"preset"`
+	Mode             string                    `json:"mode"`
 	ItemCount        int                       `json:"itemCount"`
+	SucceededCount   int                       `json:"succeededCount"`
+	FailedCount      int                       `json:"failedCount"`
+	UnchangedCount   int                       `json:"unchangedCount,omitempty"` // upsert items that needed no write
 	Statements       []string                  `json:"statements"`
 	PayloadSizeBytes int                       `json:"payloadSizeBytes"`
 	ConsumedCapacity []consumedCapacitySummary `json:"consumedCapacity,omitempty"`
+	ItemResults      []uploadItemResult        `json:"itemResults,omitempty"`
+	RowErrors        []uploadRowError          `json:"rowErrors,omitempty"`
 	Results          []map[string]interface{}  `json:"results,omitempty"`
 	Warnings         []string                  `json:"warnings,omitempty"`
+	AuditID          string                    `json:"auditId,omitempty"` // audit entry of the upload, used for undo
+	Error            string                    `json:"error,omitempty"`
 }
 
 type consumedCapacitySummary struct {
@@ -63,14 +82,46 @@ type consumedCapacitySummary struct {
 }
 
 type uploadPlan struct {
+	items             []map[string]interface{} // items after field transformations
 	statements        []uploadStatement
 	payloadSizeBytes  int
 	statementPreviews []string
+	violations        []uploadViolation // items breaking preset rules; set by prepareUploadPlan
+	upsert            []UpsertRow       // classification of the items of upsert presets; set by planUpsert
 }
 
 type uploadStatement struct {
 	statement string
 	params    []*dynamodb.AttributeValue
+	skip      string // upsert class of an item that is not written
+}
+
+// writeCount returns the number of statements that are sent to DynamoDB.
+func (p *uploadPlan) writeCount() int {
+	count := 0
+	for _, stmt := range p.statements {
+		if stmt.skip == "" {
+			count++
+		}
+	}
+	return count
+}
+
+// withoutSkipped returns the plan of the statements that are sent and their indexes in p, or
+// nil when no statement is skipped.
+func (p *uploadPlan) withoutSkipped() (*uploadPlan, []int) {
+	if p.writeCount() == len(p.statements) {
+		return nil, nil
+	}
+	sub := &uploadPlan{}
+	var indexes []int
+	for idx, stmt := range p.statements {
+		if stmt.skip == "" {
+			sub.statements = append(sub.statements, stmt)
+			indexes = append(indexes, idx)
+		}
+	}
+	return sub, indexes
 }
 
 func (settings *ExtraPluginSettings) findPresetByID(id string) (*UploadPreset, error) {
@@ -103,6 +154,9 @@ func (p UploadPreset) summarize() uploadPresetSummary {
 		ResponsePreview:  p.ResponsePreview,
 		HelpText:         p.HelpText,
 		Category:         p.Category,
+		Access:           p.Access,
+		Rules:            p.Rules,
+		Upsert:           p.Upsert,
 	}
 }
 
@@ -134,6 +188,7 @@ func buildUploadPlan(p UploadPreset, defaultMaxKB int64, items []map[string]inte
 	maxBytes := p.effectiveMaxPayloadKB(defaultMaxKB) * 1024
 	statements := make([]uploadStatement, 0, len(items))
 	previews := make([]string, 0, len(items))
+	transformedItems := make([]map[string]interface{}, 0, len(items))
 
 	totalBytes := 0
 	for idx, item := range items {
@@ -141,6 +196,12 @@ func buildUploadPlan(p UploadPreset, defaultMaxKB int64, items []map[string]inte
 			return nil, fmt.Errorf("item %d is empty", idx+1)
 		}
 
+		item, err := applyFieldTransformations(p, item)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %w", idx+1, err)
+		}
+		transformedItems = append(transformedItems, item)
+
 		if err := validateItemAgainstPreset(p, item); err != nil {
 			return nil, fmt.Errorf("item %d: %w", idx+1, err)
 		}
@@ -163,6 +224,7 @@ func buildUploadPlan(p UploadPreset, defaultMaxKB int64, items []map[string]inte
 	}
 
 	return &uploadPlan{
+		items:             transformedItems,
 		statements:        statements,
 		payloadSizeBytes:  totalBytes,
 		statementPreviews: previews,
@@ -208,6 +270,9 @@ func buildStatementForItem(p UploadPreset, item map[string]interface{}) (string,
 		return buildDeleteStatement(p, item)
 	case UploadOperationSelect:
 		return buildSelectStatement(p, item)
+	case UploadOperationUpsert:
+		// Items are inserted until planUpsert has compared them with the stored items
+		return buildInsertStatement(p, item)
 	default:
 		return "", nil, "", fmt.Errorf("operation %q not supported", p.Operation)
 	}
@@ -228,7 +293,7 @@ func buildInsertStatement(p UploadPreset, item map[string]interface{}) (string,
 
 		// Marshal the value to DynamoDB AttributeValue
 		val := item[key]
-		av, err := dynamodbattribute.Marshal(val)
+		av, err := p.fieldAttributeValue(key, val)
 		if err != nil {
 			return "", nil, "", fmt.Errorf("failed to marshal field %q: %w", key, err)
 		}
@@ -292,7 +357,7 @@ func buildUpdateStatement(p UploadPreset, item map[string]interface{}) (string,
 	updateKeys := sortedKeys(updateFields)
 	for _, key := range updateKeys {
 		setClause = append(setClause, fmt.Sprintf("'%s'=?", key))
-		av, err := dynamodbattribute.Marshal(updateFields[key])
+		av, err := p.fieldAttributeValue(key, updateFields[key])
 		if err != nil {
 			return "", nil, "", fmt.Errorf("failed to marshal field %q: %w", key, err)
 		}
@@ -304,7 +369,7 @@ func buildUpdateStatement(p UploadPreset, item map[string]interface{}) (string,
 	keyKeys := sortedKeys(keyFields)
 	for _, key := range keyKeys {
 		whereClause = append(whereClause, fmt.Sprintf("'%s'=?", key))
-		av, err := dynamodbattribute.Marshal(keyFields[key])
+		av, err := p.fieldAttributeValue(key, keyFields[key])
 		if err != nil {
 			return "", nil, "", fmt.Errorf("failed to marshal key field %q: %w", key, err)
 		}
@@ -339,7 +404,7 @@ func buildDeleteStatement(p UploadPreset, item map[string]interface{}) (string,
 	keys := sortedKeys(item)
 	for _, key := range keys {
 		whereClause = append(whereClause, fmt.Sprintf("'%s'=?", key))
-		av, err := dynamodbattribute.Marshal(item[key])
+		av, err := p.fieldAttributeValue(key, item[key])
 		if err != nil {
 			return "", nil, "", fmt.Errorf("failed to marshal field %q: %w", key, err)
 		}
@@ -373,7 +438,7 @@ func buildSelectStatement(p UploadPreset, item map[string]interface{}) (string,
 	keys := sortedKeys(item)
 	for _, key := range keys {
 		whereClause = append(whereClause, fmt.Sprintf("'%s'=?", key))
-		av, err := dynamodbattribute.Marshal(item[key])
+		av, err := p.fieldAttributeValue(key, item[key])
 		if err != nil {
 			return "", nil, "", fmt.Errorf("failed to marshal field %q: %w", key, err)
 		}
@@ -502,6 +567,18 @@ func convertValueToAttributeValue(field UploadField, value interface{}) (*dynamo
 	}
 }
 
+// fieldAttributeValue converts an item value with the type of its schema field, so statements,
+// keys and audit images all hold the attribute the upload writes. Values without a schema
+// field are marshalled as they are.
+func (p UploadPreset) fieldAttributeValue(name string, value interface{}) (*dynamodb.AttributeValue, error) {
+	for _, field := range p.Schema {
+		if field.Name == name {
+			return convertValueToAttributeValue(field, value)
+		}
+	}
+	return dynamodbattribute.Marshal(value)
+}
+
 func sortedKeys(m map[string]interface{}) []string {
 	keys := make([]string, 0, len(m))
 	for k := range m {
@@ -745,9 +822,6 @@ func aggregateConsumedCapacity(outputs []*dynamodb.ConsumedCapacity) []consumedC
 		summary[key].CapacityUnits += aws.Float64Value(cap.CapacityUnits)
 		summary[key].ReadUnits += aws.Float64Value(cap.ReadCapacityUnits)
 		summary[key].WriteUnits += aws.Float64Value(cap.WriteCapacityUnits)
-		if cap.CapacityUnits != nil && *cap.CapacityUnits == 0 && cap.TableName != nil {
-			summary[key].ThrottleEvents++
-		}
 	}
 
 	result := make([]consumedCapacitySummary, 0, len(summary))
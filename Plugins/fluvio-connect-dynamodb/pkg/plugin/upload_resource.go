@@ -3,12 +3,12 @@ package plugin
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
 
-	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 )
@@ -23,7 +23,17 @@ func (d *Datasource) handleUploadResource(ctx context.Context, req *backend.Call
 		return d.handleUploadExecute(ctx, req, sender)
 	case "upload/schema":
 		return d.handleUploadSchema(ctx, req, sender)
+	case "upload/jobs":
+		return d.handleUploadJobs(ctx, req, sender)
+	case "upload/audit":
+		return d.handleUploadAudit(ctx, req, sender, "")
 	default:
+		if strings.HasPrefix(req.Path, "upload/jobs/") {
+			return d.handleUploadJob(ctx, req, sender, strings.TrimPrefix(req.Path, "upload/jobs/"))
+		}
+		if strings.HasPrefix(req.Path, "upload/audit/") {
+			return d.handleUploadAudit(ctx, req, sender, strings.TrimPrefix(req.Path, "upload/audit/"))
+		}
 		return sender.Send(&backend.CallResourceResponse{
 			Status: http.StatusNotFound,
 			Body:   []byte(`{"error": "unknown upload endpoint"}`),
@@ -43,7 +53,9 @@ func (d *Datasource) handleUploadPresets(ctx context.Context, req *backend.CallR
 
 	var presetSummaries []uploadPresetSummary
 	for _, preset := range extraSettings.UploadPresets {
-		presetSummaries = append(presetSummaries, preset.summarize())
+		if preset.allows(req.PluginContext.User, extraSettings.Teams) {
+			presetSummaries = append(presetSummaries, preset.summarize())
+		}
 	}
 
 	responseBody := struct {
@@ -77,7 +89,7 @@ func (d *Datasource) handleUploadPreview(ctx context.Context, req *backend.CallR
 	_, preset, plan, request, err := d.prepareUploadPlan(ctx, req)
 	if err != nil {
 		return sender.Send(&backend.CallResourceResponse{
-			Status: http.StatusBadRequest,
+			Status: uploadErrorStatus(err),
 			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
 		})
 	}
@@ -93,8 +105,13 @@ func (d *Datasource) handleUploadPreview(ctx context.Context, req *backend.CallR
 		Preset:            preset.summarize(),
 		ItemCount:         len(request.Items),
 		Statements:        plan.statementPreviews,
+		Items:             plan.items,
 		PayloadSizeBytes:  plan.payloadSizeBytes,
-		EstimatedCapacity: float64(len(plan.statements)),
+		EstimatedCapacity: float64(plan.writeCount()),
+		RowErrors:         request.rowErrors,
+		Violations:        plan.violations,
+		Upsert:            plan.upsert,
+		Warnings:          preset.transformationWarnings(),
 	}
 
 	body, err := json.Marshal(response)
@@ -125,7 +142,7 @@ func (d *Datasource) handleUploadExecute(ctx context.Context, req *backend.CallR
 	_, preset, plan, request, err := d.prepareUploadPlan(ctx, req)
 	if err != nil {
 		return sender.Send(&backend.CallResourceResponse{
-			Status: http.StatusBadRequest,
+			Status: uploadErrorStatus(err),
 			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
 		})
 	}
@@ -142,8 +159,13 @@ func (d *Datasource) handleUploadExecute(ctx context.Context, req *backend.CallR
 			Preset:            preset.summarize(),
 			ItemCount:         len(request.Items),
 			Statements:        plan.statementPreviews,
+			Items:             plan.items,
 			PayloadSizeBytes:  plan.payloadSizeBytes,
-			EstimatedCapacity: float64(len(plan.statements)),
+			EstimatedCapacity: float64(plan.writeCount()),
+			RowErrors:         request.rowErrors,
+			Violations:        plan.violations,
+			Upsert:            plan.upsert,
+			Warnings:          preset.transformationWarnings(),
 		}
 		body, err := json.Marshal(response)
 		if err != nil {
@@ -158,6 +180,18 @@ func (d *Datasource) handleUploadExecute(ctx context.Context, req *backend.CallR
 		})
 	}
 
+	if len(plan.violations) > 0 {
+		return sendRuleViolations(sender, plan.violations)
+	}
+
+	mode, err := normalizeUploadMode(request.Mode)
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusBadRequest,
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
+		})
+	}
+
 	client, err := d.getDynamoDBClient(ctx, req.PluginContext.DataSourceInstanceSettings)
 	if err != nil {
 		return sender.Send(&backend.CallResourceResponse{
@@ -166,44 +200,46 @@ func (d *Datasource) handleUploadExecute(ctx context.Context, req *backend.CallR
 		})
 	}
 
-	var consumed []*dynamodb.ConsumedCapacity
-	var selectResults []map[string]*dynamodb.AttributeValue
-
-	for idx, stmt := range plan.statements {
-		input := &dynamodb.ExecuteStatementInput{
-			Statement:              aws.String(stmt.statement),
-			Parameters:             stmt.params,
-			ReturnConsumedCapacity: aws.String(dynamodb.ReturnConsumedCapacityTotal),
-		}
-
-		output, execErr := client.ExecuteStatementWithContext(ctx, input)
-		if execErr != nil {
-			backend.Logger.Error("Upload execute failed", "preset", preset.ID, "statementIndex", idx, "error", execErr.Error())
-			return sender.Send(&backend.CallResourceResponse{
-				Status: http.StatusBadRequest,
-				Body:   []byte(fmt.Sprintf(`{"error": "failed to execute statement %d: %s"}`, idx+1, execErr.Error())),
-			})
-		}
-
-		if output.ConsumedCapacity != nil {
-			consumed = append(consumed, output.ConsumedCapacity)
+	// Capture the prior item images before writing so the upload can be undone
+	audit := newAuditEntry(req, auditActionExecute, preset)
+	if preset.Operation != UploadOperationSelect {
+		images, imageErr := d.captureItemImages(ctx, client, preset, plan.items)
+		if imageErr != nil {
+			backend.Logger.Warn("Prior item images not captured, upload cannot be undone", "preset", preset.ID, "error", imageErr.Error())
+			audit.Warnings = append(audit.Warnings, fmt.Sprintf("prior images not captured: %s", imageErr.Error()))
+		} else {
+			audit.Images = images
+			audit.Undoable = true
 		}
+	}
 
-		if preset.Operation == UploadOperationSelect && len(output.Items) > 0 {
-			selectResults = append(selectResults, output.Items...)
-		}
+	exec, err := d.executeUploadPlan(ctx, client, preset, plan, mode)
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusBadRequest,
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
+		})
 	}
 
+	succeeded, failed := exec.counts()
 	response := uploadExecuteResponse{
 		Preset:           preset.summarize(),
+		Mode:             exec.mode,
 		ItemCount:        len(plan.statements),
+		SucceededCount:   succeeded,
+		FailedCount:      failed,
+		UnchangedCount:   exec.unchangedCount(),
 		Statements:       plan.statementPreviews,
 		PayloadSizeBytes: plan.payloadSizeBytes,
-		ConsumedCapacity: aggregateConsumedCapacity(consumed),
+		ConsumedCapacity: exec.consumedCapacity(preset.Table),
+		ItemResults:      exec.itemResults,
+		RowErrors:        request.rowErrors,
+		Warnings:         preset.transformationWarnings(),
+		Error:            exec.firstError(),
 	}
 
 	if preset.Operation == UploadOperationSelect {
-		decoded, decodeErr := decodeResultItems(selectResults)
+		decoded, decodeErr := decodeResultItems(exec.selectResults)
 		if decodeErr != nil {
 			backend.Logger.Error("Failed to decode select results", "error", decodeErr.Error())
 			response.Warnings = append(response.Warnings, fmt.Sprintf("failed to decode select results: %s", decodeErr.Error()))
@@ -212,6 +248,39 @@ func (d *Datasource) handleUploadExecute(ctx context.Context, req *backend.CallR
 		}
 	}
 
+	if preset.Operation != UploadOperationSelect {
+		audit.Mode = exec.mode
+		audit.ItemCount = len(plan.statements)
+		audit.Items = plan.items
+		audit.Statements = plan.statementPreviews
+		audit.ItemResults = exec.itemResults
+		if succeeded == 0 {
+			audit.Undoable = false
+		}
+		if audit.Undoable {
+			if err := d.captureAfterImages(ctx, client, preset.Table, audit.Images, exec.itemResults); err != nil {
+				backend.Logger.Warn("Item images after upload not captured, some items cannot be undone", "preset", preset.ID, "error", err.Error())
+				audit.Warnings = append(audit.Warnings, fmt.Sprintf("images after upload not captured: %s", err.Error()))
+			}
+		}
+		if err := writeAuditEntry(audit); err != nil {
+			backend.Logger.Error("Failed to write upload audit entry", "preset", preset.ID, "error", err.Error())
+			response.Warnings = append(response.Warnings, fmt.Sprintf("audit entry not written: %s", err.Error()))
+		} else {
+			response.AuditID = audit.ID
+		}
+	}
+
+	// Partial success is reported as 207 so callers inspect the per-item results;
+	// when nothing was written the request failed as a whole.
+	status := http.StatusOK
+	if failed > 0 {
+		status = http.StatusMultiStatus
+		if succeeded == 0 {
+			status = http.StatusBadRequest
+		}
+	}
+
 	body, err := json.Marshal(response)
 	if err != nil {
 		return sender.Send(&backend.CallResourceResponse{
@@ -220,10 +289,10 @@ func (d *Datasource) handleUploadExecute(ctx context.Context, req *backend.CallR
 		})
 	}
 
-	backend.Logger.Info("Upload execute completed", "preset", preset.ID, "statements", len(plan.statements))
+	backend.Logger.Info("Upload execute completed", "preset", preset.ID, "mode", exec.mode, "statements", len(plan.statements), "succeeded", succeeded, "failed", failed, "unchanged", response.UnchangedCount)
 
 	return sender.Send(&backend.CallResourceResponse{
-		Status: http.StatusOK,
+		Status: status,
 		Body:   body,
 	})
 }
@@ -260,6 +329,12 @@ func (d *Datasource) handleUploadSchema(ctx context.Context, req *backend.CallRe
 			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
 		})
 	}
+	if !preset.allows(req.PluginContext.User, extraSettings.Teams) {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusForbidden,
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(errUploadAccessDenied))),
+		})
+	}
 
 	response := struct {
 		Preset uploadPresetSummary `json:"preset"`
@@ -281,57 +356,354 @@ func (d *Datasource) handleUploadSchema(ctx context.Context, req *backend.CallRe
 	})
 }
 
+// handleUploadJobs creates a background upload job (POST) or lists the jobs of the datasource (GET).
+func (d *Datasource) handleUploadJobs(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	jobs, err := uploadJobs()
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusInternalServerError,
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
+		})
+	}
+
+	switch req.Method {
+	case http.MethodGet:
+		visible := []uploadJob{}
+		for _, job := range jobs.list(req.PluginContext.DataSourceInstanceSettings.UID) {
+			if d.canUseJobPreset(req, jobs, job) {
+				visible = append(visible, job)
+			}
+		}
+		return sendUploadJSON(sender, http.StatusOK, map[string]interface{}{"jobs": visible})
+	case http.MethodPost:
+	default:
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusMethodNotAllowed,
+			Body:   []byte(`{"error": "only GET and POST supported for upload jobs"}`),
+		})
+	}
+
+	extraSettings, request, preset, err := d.parseUploadRequest(ctx, req)
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: uploadErrorStatus(err),
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
+		})
+	}
+
+	mode, err := normalizeUploadMode(request.Mode)
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusBadRequest,
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
+		})
+	}
+
+	plan, err := buildJobUploadPlan(*preset, extraSettings.MaxJobPayloadKB, request.Items)
+	if err == nil {
+		plan.violations, err = d.checkUploadRules(ctx, d.clientFor(ctx, req), *preset, plan.items, request.itemRows)
+	}
+	if err == nil {
+		err = d.planUpsert(ctx, d.clientFor(ctx, req), *preset, plan, request.itemRows)
+	}
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusBadRequest,
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
+		})
+	}
+	if len(plan.violations) > 0 {
+		return sendRuleViolations(sender, plan.violations)
+	}
+
+	client, err := d.getDynamoDBClient(ctx, req.PluginContext.DataSourceInstanceSettings)
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusInternalServerError,
+			Body:   []byte(fmt.Sprintf(`{"error": "failed to get DynamoDB client: %s"}`, err.Error())),
+		})
+	}
+
+	audit := newAuditEntry(req, auditActionJob, preset)
+	job, err := jobs.create(d, client, req.PluginContext.DataSourceInstanceSettings.UID, *preset, plan, request, mode, audit)
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusInternalServerError,
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
+		})
+	}
+
+	backend.Logger.Info("Upload job created", "job", job.ID, "preset", preset.ID, "mode", mode, "items", job.ItemCount, "user", audit.User.Login)
+	return sendUploadJSON(sender, http.StatusAccepted, job)
+}
+
+// handleUploadJob reports the progress of a job (GET), cancels it (DELETE) or resumes it
+// (POST upload/jobs/{id}/resume).
+func (d *Datasource) handleUploadJob(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender, path string) error {
+	jobs, err := uploadJobs()
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusInternalServerError,
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
+		})
+	}
+
+	jobID, action, _ := strings.Cut(path, "/")
+	job, ok := jobs.get(jobID)
+	if !ok || job.DatasourceUID != req.PluginContext.DataSourceInstanceSettings.UID {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusNotFound,
+			Body:   []byte(fmt.Sprintf(`{"error": "upload job %q not found"}`, jobID)),
+		})
+	}
+
+	// Reading, cancelling and resuming require access to the preset the job runs
+	if !d.canUseJobPreset(req, jobs, job) {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusForbidden,
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(errUploadAccessDenied))),
+		})
+	}
+
+	switch {
+	case action == "" && req.Method == http.MethodGet:
+		return sendUploadJSON(sender, http.StatusOK, job)
+
+	case action == "" && req.Method == http.MethodDelete:
+		if !jobs.cancel(jobID) {
+			return sender.Send(&backend.CallResourceResponse{
+				Status: http.StatusConflict,
+				Body:   []byte(fmt.Sprintf(`{"error": "upload job is %s"}`, job.Status)),
+			})
+		}
+		backend.Logger.Info("Upload job cancelled", "job", jobID)
+		job, _ = jobs.get(jobID)
+		return sendUploadJSON(sender, http.StatusAccepted, job)
+
+	case action == "resume" && req.Method == http.MethodPost:
+		if !job.resumable() {
+			return sender.Send(&backend.CallResourceResponse{
+				Status: http.StatusConflict,
+				Body:   []byte(fmt.Sprintf(`{"error": "upload job is %s and cannot be resumed"}`, job.Status)),
+			})
+		}
+		client, err := d.getDynamoDBClient(ctx, req.PluginContext.DataSourceInstanceSettings)
+		if err != nil {
+			return sender.Send(&backend.CallResourceResponse{
+				Status: http.StatusInternalServerError,
+				Body:   []byte(fmt.Sprintf(`{"error": "failed to get DynamoDB client: %s"}`, err.Error())),
+			})
+		}
+		extraSettings, err := loadExtraPluginSettings(*req.PluginContext.DataSourceInstanceSettings)
+		if err != nil {
+			return sender.Send(&backend.CallResourceResponse{
+				Status: http.StatusInternalServerError,
+				Body:   []byte(fmt.Sprintf(`{"error": "failed to load settings: %s"}`, sanitizeError(err))),
+			})
+		}
+		job, err = jobs.resume(ctx, d, client, jobID, extraSettings.MaxJobPayloadKB)
+		if err != nil {
+			return sender.Send(&backend.CallResourceResponse{
+				Status: http.StatusConflict,
+				Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
+			})
+		}
+		backend.Logger.Info("Upload job resumed", "job", jobID, "nextIndex", job.NextIndex, "failed", job.FailedCount)
+		return sendUploadJSON(sender, http.StatusAccepted, job)
+	}
+
+	return sender.Send(&backend.CallResourceResponse{
+		Status: http.StatusMethodNotAllowed,
+		Body:   []byte(`{"error": "method not allowed"}`),
+	})
+}
+
+// canUseJobPreset checks the requesting user against the preset access recorded with a job.
+// Jobs created before access was recorded use the preset snapshot of their payload; jobs
+// whose snapshot cannot be read are limited to admins.
+func (d *Datasource) canUseJobPreset(req *backend.CallResourceRequest, jobs *uploadJobManager, job uploadJob) bool {
+	extraSettings, err := loadExtraPluginSettings(*req.PluginContext.DataSourceInstanceSettings)
+	if err != nil {
+		return false
+	}
+	if job.Access != nil {
+		return UploadPreset{Access: job.Access}.allows(req.PluginContext.User, extraSettings.Teams)
+	}
+	payload, err := jobs.readPayload(job.ID)
+	if err != nil {
+		return isOrgAdmin(req.PluginContext.User)
+	}
+	return payload.Preset.allows(req.PluginContext.User, extraSettings.Teams)
+}
+
+// clientFor returns a constructor of the DynamoDB client of the request, for checks that only
+// sometimes need one.
+func (d *Datasource) clientFor(ctx context.Context, req *backend.CallResourceRequest) func() (*dynamodb.DynamoDB, error) {
+	return func() (*dynamodb.DynamoDB, error) {
+		return d.getDynamoDBClient(ctx, req.PluginContext.DataSourceInstanceSettings)
+	}
+}
+
+// sendRuleViolations rejects an upload whose items break preset rules; nothing is written.
+func sendRuleViolations(sender backend.CallResourceResponseSender, violations []uploadViolation) error {
+	return sendUploadJSON(sender, http.StatusBadRequest, map[string]interface{}{
+		"error":      fmt.Sprintf("%d item(s) violate preset rules", len(violations)),
+		"violations": violations,
+	})
+}
+
+func sendUploadJSON(sender backend.CallResourceResponseSender, status int, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusInternalServerError,
+			Body:   []byte(fmt.Sprintf(`{"error": "failed to marshal response: %s"}`, sanitizeError(err))),
+		})
+	}
+	return sender.Send(&backend.CallResourceResponse{
+		Status: status,
+		Body:   body,
+	})
+}
+
 func (d *Datasource) prepareUploadPlan(ctx context.Context, req *backend.CallResourceRequest) (*ExtraPluginSettings, *UploadPreset, *uploadPlan, uploadExecuteRequest, error) {
+	extraSettings, request, preset, err := d.parseUploadRequest(ctx, req)
+	if err != nil {
+		return extraSettings, preset, nil, request, err
+	}
+
+	plan, err := buildUploadPlan(*preset, extraSettings.MaxUploadPayloadKB, request.Items)
+	if err != nil {
+		return extraSettings, preset, nil, request, err
+	}
+
+	plan.violations, err = d.checkUploadRules(ctx, d.clientFor(ctx, req), *preset, plan.items, request.itemRows)
+	if err != nil {
+		return extraSettings, preset, nil, request, err
+	}
+
+	if err := d.planUpsert(ctx, d.clientFor(ctx, req), *preset, plan, request.itemRows); err != nil {
+		return extraSettings, preset, nil, request, err
+	}
+
+	return extraSettings, preset, plan, request, nil
+}
+
+// parseUploadRequest decodes an upload request body and resolves its preset.
+func (d *Datasource) parseUploadRequest(ctx context.Context, req *backend.CallResourceRequest) (*ExtraPluginSettings, uploadExecuteRequest, *UploadPreset, error) {
 	extraSettings, err := loadExtraPluginSettings(*req.PluginContext.DataSourceInstanceSettings)
 	if err != nil {
-		return nil, nil, nil, uploadExecuteRequest{}, fmt.Errorf("failed to load settings: %w", err)
+		return nil, uploadExecuteRequest{}, nil, fmt.Errorf("failed to load settings: %w", err)
 	}
 
 	if len(req.Body) == 0 {
-		return extraSettings, nil, nil, uploadExecuteRequest{}, fmt.Errorf("request body is required")
+		return extraSettings, uploadExecuteRequest{}, nil, fmt.Errorf("request body is required")
 	}
 
-	// Sanitize the request body to remove control characters
-	sanitizedBody := sanitizeJSON(req.Body)
-
-	// Log the raw body for debugging
-	backend.Logger.Debug("Received request body", "bodyLength", len(sanitizedBody), "originalLength", len(req.Body))
+	parsedURL, err := url.Parse(req.URL)
+	if err != nil {
+		return extraSettings, uploadExecuteRequest{}, nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	query := parsedURL.Query()
+	format, err := uploadBodyFormat(req, query)
+	if err != nil {
+		return extraSettings, uploadExecuteRequest{}, nil, err
+	}
 
 	var request uploadExecuteRequest
-	if err := json.Unmarshal(sanitizedBody, &request); err != nil {
-		// Log the error with context
-		previewLen := 500
-		if len(sanitizedBody) < previewLen {
-			previewLen = len(sanitizedBody)
+	if format != uploadFormatJSON {
+		// Raw files carry the request fields in the query string
+		request, err = uploadRequestFromQuery(query)
+		if err != nil {
+			return extraSettings, request, nil, err
+		}
+	} else {
+		// Sanitize the request body to remove control characters
+		sanitizedBody := sanitizeJSON(req.Body)
+
+		// Log the raw body for debugging
+		backend.Logger.Debug("Received request body", "bodyLength", len(sanitizedBody), "originalLength", len(req.Body))
+
+		if err := json.Unmarshal(sanitizedBody, &request); err != nil {
+			// Log the error with context
+			previewLen := 500
+			if len(sanitizedBody) < previewLen {
+				previewLen = len(sanitizedBody)
+			}
+			backend.Logger.Error("Failed to unmarshal request body", "error", err.Error(), "bodyPreview", string(sanitizedBody[:previewLen]))
+			return extraSettings, uploadExecuteRequest{}, nil, fmt.Errorf("invalid request payload: %w", err)
 		}
-		backend.Logger.Error("Failed to unmarshal request body", "error", err.Error(), "bodyPreview", string(sanitizedBody[:previewLen]))
-		return extraSettings, nil, nil, uploadExecuteRequest{}, fmt.Errorf("invalid request payload: %w", err)
 	}
 
 	if request.PresetID == "" {
-		return extraSettings, nil, nil, uploadExecuteRequest{}, fmt.Errorf("presetId is required")
+		return extraSettings, request, nil, fmt.Errorf("presetId is required")
 	}
 
-	// Try to load preset from file storage first
 	backend.Logger.Info("Loading preset for upload", "presetId", request.PresetID)
-	preset, err := loadPresetFromFile(request.PresetID)
+	preset, err := d.resolveUploadPreset(ctx, req, extraSettings, request.PresetID)
 	if err != nil {
-		backend.Logger.Warn("Preset not found in file storage, trying datasource config", "presetId", request.PresetID, "error", err.Error())
-		// Fallback to datasource config for backwards compatibility
-		preset, err = extraSettings.findPresetByID(request.PresetID)
+		return extraSettings, request, nil, err
+	}
+
+	if !preset.allows(req.PluginContext.User, extraSettings.Teams) {
+		backend.Logger.Warn("Upload preset access denied", "presetId", preset.ID, "user", userLogin(req.PluginContext.User))
+		return extraSettings, request, preset, fmt.Errorf("%w: preset %q is not available to this user", errUploadAccessDenied, preset.ID)
+	}
+
+	backend.Logger.Info("Preset loaded successfully", "presetId", preset.ID, "table", preset.Table, "operation", preset.Operation)
+
+	if format != uploadFormatJSON {
+		opts, err := parseUploadFileOptions(format, query)
 		if err != nil {
-			return extraSettings, nil, nil, uploadExecuteRequest{}, fmt.Errorf("preset not found: %w", err)
+			return extraSettings, request, preset, err
+		}
+		limitKB := preset.effectiveMaxPayloadKB(extraSettings.MaxUploadPayloadKB)
+		if req.Path == "upload/jobs" {
+			limitKB = extraSettings.MaxJobPayloadKB
+			if limitKB <= 0 {
+				limitKB = defaultMaxJobPayloadKB
+			}
+		}
+		opts.maxEntryBytes = xlsxExpansionFactor * limitKB * 1024
+		request.Items, request.itemRows, request.rowErrors, err = parseUploadFile(req.Body, opts, *preset)
+		if err != nil {
+			return extraSettings, request, preset, err
 		}
 	}
 
-	backend.Logger.Info("Preset loaded successfully", "presetId", preset.ID, "table", preset.Table, "operation", preset.Operation)
+	return extraSettings, request, preset, nil
+}
 
-	plan, err := buildUploadPlan(*preset, extraSettings.MaxUploadPayloadKB, request.Items)
+// resolveUploadPreset loads the current version of a saved preset, falling back to the
+// datasource config.
+func (d *Datasource) resolveUploadPreset(ctx context.Context, req *backend.CallResourceRequest, extraSettings *ExtraPluginSettings, presetID string) (*UploadPreset, error) {
+	store, err := d.presetStoreFor(ctx, req, extraSettings)
 	if err != nil {
-		return extraSettings, preset, nil, request, err
+		return nil, err
+	}
+	stored, err := currentPreset(ctx, store, presetScopeOf(req), presetID)
+	if err == nil {
+		return &stored.Preset, nil
+	}
+	if !errors.Is(err, errPresetNotFound) {
+		return nil, err
 	}
 
-	return extraSettings, preset, plan, request, nil
+	backend.Logger.Debug("Preset not found in preset store, trying datasource config", "presetId", presetID)
+	// Fallback to datasource config for backwards compatibility
+	preset, err := extraSettings.findPresetByID(presetID)
+	if err != nil {
+		return nil, fmt.Errorf("preset not found: %w", err)
+	}
+	return preset, nil
+}
+
+// uploadErrorStatus returns the HTTP status for an error preparing an upload.
+func uploadErrorStatus(err error) int {
+	if errors.Is(err, errUploadAccessDenied) {
+		return http.StatusForbidden
+	}
+	return http.StatusBadRequest
 }
 
 func sanitizeError(err error) string {
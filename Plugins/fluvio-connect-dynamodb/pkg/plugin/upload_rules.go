@@ -0,0 +1,428 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	uploadRuleCompare    = "compare"
+	uploadRuleUnique     = "unique"
+	uploadRuleRequiredIf = "required_if"
+	uploadRuleReference  = "reference"
+)
+
+// defaultRuleCodes are the violation codes of rules that do not set their own.
+var defaultRuleCodes = map[string]string{
+	uploadRuleCompare:    "comparison_failed",
+	uploadRuleUnique:     "duplicate",
+	uploadRuleRequiredIf: "required",
+	uploadRuleReference:  "missing_reference",
+}
+
+// maxBatchGetKeys is the number of keys DynamoDB accepts in one BatchGetItem request.
+const maxBatchGetKeys = 100
+
+// uploadViolation is an item that breaks a preset rule.
+type uploadViolation struct {
+	Item  int    `json:"item"`          // 1-based index of the item in the upload
+	Row   int    `json:"row,omitempty"` // source row of a raw file upload
+	Code  string `json:"code"`
+	Field string `json:"field,omitempty"`
+	Error string `json:"error"`
+}
+
+func (r UploadRule) code() string {
+	if r.Code != "" {
+		return r.Code
+	}
+	return defaultRuleCodes[r.Type]
+}
+
+func (r UploadRule) message(generated string) string {
+	if r.Message != "" {
+		return r.Message
+	}
+	return generated
+}
+
+// referenceKeys returns the key attributes of the referenced table mapped to item fields.
+func (r UploadRule) referenceKeys() map[string]string {
+	if len(r.Keys) > 0 {
+		return r.Keys
+	}
+	return map[string]string{r.Field: r.Field}
+}
+
+// validate reports configuration errors of a rule, so a broken preset fails loudly instead of
+// letting every item through.
+func (r UploadRule) validate() error {
+	switch r.Type {
+	case uploadRuleCompare:
+		if r.Field == "" || (r.OtherField == "" && r.Value == nil) {
+			return errors.New("compare rules need a field and an otherField or value")
+		}
+		if _, ok := compareOperators[r.Operator]; !ok {
+			return fmt.Errorf("unsupported compare operator %q", r.Operator)
+		}
+	case uploadRuleUnique:
+		if len(r.Fields) == 0 && r.Field == "" {
+			return errors.New("unique rules need fields")
+		}
+	case uploadRuleRequiredIf:
+		if r.Field == "" || r.When == "" {
+			return errors.New("required_if rules need a field and a when field")
+		}
+	case uploadRuleReference:
+		if r.Table == "" || (r.Field == "" && len(r.Keys) == 0) {
+			return errors.New("reference rules need a table and a field or keys")
+		}
+	default:
+		return fmt.Errorf("unknown rule type %q", r.Type)
+	}
+	return nil
+}
+
+var compareOperators = map[string]func(c int) bool{
+	">":  func(c int) bool { return c > 0 },
+	">=": func(c int) bool { return c >= 0 },
+	"<":  func(c int) bool { return c < 0 },
+	"<=": func(c int) bool { return c <= 0 },
+	"==": func(c int) bool { return c == 0 },
+	"!=": func(c int) bool { return c != 0 },
+}
+
+// checkUploadRules evaluates the preset rules against the transformed items. rows maps items
+// to their source rows for raw file uploads and may be nil. A client is only created when a
+// reference rule needs to read another table.
+func (d *Datasource) checkUploadRules(ctx context.Context, newClient func() (*dynamodb.DynamoDB, error), preset UploadPreset, items []map[string]interface{}, rows []int) ([]uploadViolation, error) {
+	var violations []uploadViolation
+	add := func(idx int, rule UploadRule, field string, generated string) {
+		v := uploadViolation{Item: idx + 1, Code: rule.code(), Field: field, Error: rule.message(generated)}
+		if idx < len(rows) {
+			v.Row = rows[idx]
+		}
+		violations = append(violations, v)
+	}
+
+	var client *dynamodb.DynamoDB
+	for i, rule := range preset.Rules {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("preset %q rule %d: %w", preset.ID, i+1, err)
+		}
+
+		switch rule.Type {
+		case uploadRuleCompare:
+			for idx, item := range items {
+				if ok, msg := compareRule(rule, item); !ok {
+					add(idx, rule, rule.Field, msg)
+				}
+			}
+		case uploadRuleRequiredIf:
+			for idx, item := range items {
+				if requiredIfRule(rule, item) {
+					add(idx, rule, rule.Field, fmt.Sprintf("field %q is required when %q is set", rule.Field, rule.When))
+				}
+			}
+		case uploadRuleUnique:
+			fields := rule.Fields
+			if len(fields) == 0 {
+				fields = []string{rule.Field}
+			}
+			seen := map[string]int{}
+			for idx, item := range items {
+				key, ok := ruleKey(item, fields)
+				if !ok {
+					continue
+				}
+				if first, dup := seen[key]; dup {
+					add(idx, rule, strings.Join(fields, ","), fmt.Sprintf("duplicate %s, first used by item %d", strings.Join(fields, ", "), first+1))
+					continue
+				}
+				seen[key] = idx
+			}
+		case uploadRuleReference:
+			if client == nil {
+				var err error
+				if client, err = newClient(); err != nil {
+					return nil, fmt.Errorf("failed to get DynamoDB client: %w", err)
+				}
+			}
+			missing, err := d.missingReferences(ctx, client, preset, rule, items)
+			if err != nil {
+				return nil, err
+			}
+			for _, idx := range missing {
+				add(idx, rule, rule.Field, fmt.Sprintf("no item in table %q matches %s", rule.Table, describeReference(rule, items[idx])))
+			}
+		}
+	}
+
+	sort.SliceStable(violations, func(i, j int) bool { return violations[i].Item < violations[j].Item })
+	return violations, nil
+}
+
+func compareRule(rule UploadRule, item map[string]interface{}) (bool, string) {
+	left, ok := item[rule.Field]
+	if !ok || left == nil {
+		return true, ""
+	}
+	right := rule.Value
+	target := fmt.Sprintf("%v", rule.Value)
+	if rule.OtherField != "" {
+		if right, ok = item[rule.OtherField]; !ok || right == nil {
+			return true, ""
+		}
+		target = fmt.Sprintf("%q (%v)", rule.OtherField, right)
+	}
+
+	if compareOperators[rule.Operator](compareRuleValues(left, right)) {
+		return true, ""
+	}
+	return false, fmt.Sprintf("field %q (%v) must be %s %s", rule.Field, left, rule.Operator, target)
+}
+
+// compareRuleValues compares numbers numerically, dates chronologically and anything else as text.
+func compareRuleValues(a interface{}, b interface{}) int {
+	if x, ok := ruleNumber(a); ok {
+		if y, ok := ruleNumber(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	sa, sb := fmt.Sprintf("%v", a), fmt.Sprintf("%v", b)
+	if x, ok := ruleTime(sa); ok {
+		if y, ok := ruleTime(sb); ok {
+			return x.Compare(y)
+		}
+	}
+	return strings.Compare(sa, sb)
+}
+
+func ruleNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+var ruleTimeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"}
+
+func ruleTime(s string) (time.Time, bool) {
+	for _, layout := range ruleTimeLayouts {
+		if t, err := time.Parse(layout, strings.TrimSpace(s)); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// requiredIfRule reports whether the item lacks Field although the When field is set (to Value,
+// when the rule has one).
+func requiredIfRule(rule UploadRule, item map[string]interface{}) bool {
+	when, ok := item[rule.When]
+	if !ok || when == nil || fmt.Sprintf("%v", when) == "" {
+		return false
+	}
+	if rule.Value != nil && fmt.Sprintf("%v", when) != fmt.Sprintf("%v", rule.Value) {
+		return false
+	}
+	v, ok := item[rule.Field]
+	return !ok || v == nil || fmt.Sprintf("%v", v) == ""
+}
+
+// ruleKey joins the values of fields into a comparable key; ok is false when a field is missing.
+func ruleKey(item map[string]interface{}, fields []string) (string, bool) {
+	values := make([]string, len(fields))
+	for i, field := range fields {
+		v, ok := item[field]
+		if !ok || v == nil {
+			return "", false
+		}
+		values[i] = fmt.Sprintf("%v", v)
+	}
+	raw, _ := json.Marshal(values)
+	return string(raw), true
+}
+
+func describeReference(rule UploadRule, item map[string]interface{}) string {
+	keys := rule.referenceKeys()
+	attrs := make([]string, 0, len(keys))
+	for attr := range keys {
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+	parts := make([]string, 0, len(attrs))
+	for _, attr := range attrs {
+		parts = append(parts, fmt.Sprintf("%s=%v", attr, item[keys[attr]]))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// missingReferences looks up the distinct keys of the items in the referenced table with
+// BatchGetItem and returns the indexes of the items whose key was not found. Items without
+// the key fields are left to the required field checks.
+func (d *Datasource) missingReferences(ctx context.Context, client *dynamodb.DynamoDB, preset UploadPreset, rule UploadRule, items []map[string]interface{}) ([]int, error) {
+	keyFields := rule.referenceKeys()
+	schema := map[string]UploadField{}
+	for _, field := range preset.Schema {
+		schema[field.Name] = field
+	}
+
+	itemKeys := make([]string, len(items))
+	var unique []map[string]*dynamodb.AttributeValue
+	index := map[string]bool{}
+	for idx, item := range items {
+		key := map[string]*dynamodb.AttributeValue{}
+		for attr, field := range keyFields {
+			value, ok := item[field]
+			if !ok || value == nil {
+				key = nil
+				break
+			}
+			av, err := convertValueToAttributeValue(schema[field], value)
+			if err != nil {
+				return nil, fmt.Errorf("item %d: reference key %q: %w", idx+1, field, err)
+			}
+			key[attr] = av
+		}
+		if key == nil {
+			continue
+		}
+		id := attributeKeyString(key)
+		itemKeys[idx] = id
+		if !index[id] {
+			index[id] = true
+			unique = append(unique, key)
+		}
+	}
+
+	attrs := make([]string, 0, len(keyFields))
+	for attr := range keyFields {
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+	found, err := d.batchGetItems(ctx, client, rule.Table, attrs, unique, attrs)
+	if err != nil {
+		return nil, fmt.Errorf("reference lookup in table %q failed: %w", rule.Table, err)
+	}
+
+	var missing []int
+	for idx, id := range itemKeys {
+		if _, ok := found[id]; id != "" && !ok {
+			missing = append(missing, idx)
+		}
+	}
+	return missing, nil
+}
+
+// batchGetItems reads the items with the given keys through BatchGetItem and indexes them by
+// the attributeKeyString of their keyNames attributes. Unprocessed keys are re-submitted with
+// backoff until DynamoDB has answered for all of them. attributes limits the projection; nil
+// reads whole items.
+func (d *Datasource) batchGetItems(ctx context.Context, client *dynamodb.DynamoDB, table string, keyNames []string, keys []map[string]*dynamodb.AttributeValue, attributes []string) (map[string]map[string]*dynamodb.AttributeValue, error) {
+	var projection *string
+	var names map[string]*string
+	if len(attributes) > 0 {
+		placeholders := make([]string, len(attributes))
+		names = make(map[string]*string, len(attributes))
+		for i, attr := range attributes {
+			placeholders[i] = fmt.Sprintf("#k%d", i)
+			names[placeholders[i]] = aws.String(attr)
+		}
+		projection = aws.String(strings.Join(placeholders, ", "))
+	}
+
+	found := map[string]map[string]*dynamodb.AttributeValue{}
+	for start := 0; start < len(keys); start += maxBatchGetKeys {
+		end := start + maxBatchGetKeys
+		if end > len(keys) {
+			end = len(keys)
+		}
+		request := map[string]*dynamodb.KeysAndAttributes{
+			table: {
+				Keys:                     keys[start:end],
+				ProjectionExpression:     projection,
+				ExpressionAttributeNames: names,
+			},
+		}
+
+		for retry := 0; len(request) > 0; retry++ {
+			reserved := float64(len(request[table].Keys))
+			if err := d.readLimiter.Wait(ctx, reserved); err != nil {
+				return nil, err
+			}
+			output, err := CallWithRetry(ctx, d.retrySettings, nil, func() (*dynamodb.BatchGetItemOutput, error) {
+				return client.BatchGetItemWithContext(ctx, &dynamodb.BatchGetItemInput{
+					RequestItems:           request,
+					ReturnConsumedCapacity: aws.String(dynamodb.ReturnConsumedCapacityTotal),
+				})
+			})
+			if err != nil {
+				return nil, err
+			}
+			d.readLimiter.Settle(reserved, capacityUnits(reserved, output.ConsumedCapacity...))
+			for _, got := range output.Responses[table] {
+				key := make(map[string]*dynamodb.AttributeValue, len(keyNames))
+				for _, attr := range keyNames {
+					key[attr] = got[attr]
+				}
+				found[attributeKeyString(key)] = got
+			}
+
+			request = output.UnprocessedKeys
+			if len(request) > 0 {
+				settings := d.retrySettings.withDefaults()
+				if retry+1 >= settings.MaxAttempts {
+					return nil, fmt.Errorf("reads were still throttled after %d attempts", settings.MaxAttempts)
+				}
+				if err := sleepContext(ctx, settings.Backoff(retry)); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return found, nil
+}
+
+// attributeKeyString renders a key in a canonical form for set membership.
+func attributeKeyString(key map[string]*dynamodb.AttributeValue) string {
+	attrs := make([]string, 0, len(key))
+	for attr := range key {
+		attrs = append(attrs, attr)
+	}
+	sort.Strings(attrs)
+	var b strings.Builder
+	for _, attr := range attrs {
+		b.WriteString(attr)
+		b.WriteString("=")
+		b.WriteString(key[attr].String())
+		b.WriteString(";")
+	}
+	return b.String()
+}
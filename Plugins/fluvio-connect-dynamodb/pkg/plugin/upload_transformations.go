@@ -0,0 +1,356 @@
+package plugin
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// fieldTransformer converts a single field value. item holds the (partially transformed)
+// upload item so computed transformations can read other fields.
+type fieldTransformer func(value interface{}, params map[string]string, item map[string]interface{}) (interface{}, error)
+
+// fieldTransformers is the registry of server-side transformations addressable from
+// UploadField.Transformation.Type.
+var fieldTransformers = map[string]fieldTransformer{
+	"trim":            transformTrim,
+	"uppercase":       transformUppercase,
+	"upper":           transformUppercase,
+	"lowercase":       transformLowercase,
+	"lower":           transformLowercase,
+	"unit_conversion": transformUnitConversion,
+	"date_format":     transformDateFormat,
+	"to_number":       transformToNumber,
+	"lookup":          transformLookup,
+	"regex_replace":   transformRegexReplace,
+	"composite_key":   transformCompositeKey,
+}
+
+// computedTransformations are applied after all other transformations and even when the
+// field is missing from the item, because their value is derived from other fields.
+var computedTransformations = map[string]bool{
+	"composite_key": true,
+}
+
+// skippedTransformations remembers the unknown transformations already logged, so a legacy
+// preset does not log once per uploaded item.
+var skippedTransformations sync.Map
+
+// unknownTransformations returns an error for every schema field whose transformation type
+// the backend does not know.
+func (p UploadPreset) unknownTransformations() []error {
+	var unknown []error
+	for _, field := range p.Schema {
+		if field.Transformation == nil || field.Name == "" {
+			continue
+		}
+		if _, ok := fieldTransformers[strings.ToLower(strings.TrimSpace(field.Transformation.Type))]; !ok {
+			unknown = append(unknown, fmt.Errorf("field %q: unknown transformation type %q", field.Name, field.Transformation.Type))
+		}
+	}
+	return unknown
+}
+
+// validateTransformations rejects presets with unknown transformation types, so saved and
+// imported presets never skip a transformation silently.
+func (p UploadPreset) validateTransformations() error {
+	if unknown := p.unknownTransformations(); len(unknown) > 0 {
+		return unknown[0]
+	}
+	return nil
+}
+
+// transformationWarnings describes the unknown transformations of a legacy preset, which
+// upload their fields unchanged.
+func (p UploadPreset) transformationWarnings() []string {
+	var warnings []string
+	for _, err := range p.unknownTransformations() {
+		warnings = append(warnings, err.Error()+"; the value is uploaded unchanged")
+	}
+	return warnings
+}
+
+// applyFieldTransformations returns a copy of item with the transformations declared in the
+// preset schema applied. The original item is left untouched.
+func applyFieldTransformations(p UploadPreset, item map[string]interface{}) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(item))
+	for k, v := range item {
+		result[k] = v
+	}
+
+	for _, computed := range []bool{false, true} {
+		for _, field := range p.Schema {
+			if field.Transformation == nil || field.Name == "" {
+				continue
+			}
+			transformType := strings.ToLower(strings.TrimSpace(field.Transformation.Type))
+			if computedTransformations[transformType] != computed {
+				continue
+			}
+
+			transformer, ok := fieldTransformers[transformType]
+			if !ok {
+				// Presets of the datasource settings may predate the backend transformations and
+				// declare types it does not know, e.g. "custom"; they upload the field unchanged
+				// and previews report them as warnings.
+				key := p.ID + "\x00" + field.Name + "\x00" + transformType
+				if _, logged := skippedTransformations.LoadOrStore(key, true); !logged {
+					backend.Logger.Warn("Skipping unsupported field transformation", "preset", p.ID, "field", field.Name, "type", field.Transformation.Type)
+				}
+				continue
+			}
+
+			value, present := result[field.Name]
+			if !computed && (!present || value == nil) {
+				continue
+			}
+
+			transformed, err := transformer(value, field.Transformation.Params, result)
+			if err != nil {
+				return nil, fmt.Errorf("field %q: %s transformation failed: %w", field.Name, transformType, err)
+			}
+			result[field.Name] = transformed
+		}
+	}
+
+	return result, nil
+}
+
+func transformTrim(value interface{}, _ map[string]string, _ map[string]interface{}) (interface{}, error) {
+	if s, ok := value.(string); ok {
+		return strings.TrimSpace(s), nil
+	}
+	return value, nil
+}
+
+func transformUppercase(value interface{}, _ map[string]string, _ map[string]interface{}) (interface{}, error) {
+	if s, ok := value.(string); ok {
+		return strings.ToUpper(s), nil
+	}
+	return value, nil
+}
+
+func transformLowercase(value interface{}, _ map[string]string, _ map[string]interface{}) (interface{}, error) {
+	if s, ok := value.(string); ok {
+		return strings.ToLower(s), nil
+	}
+	return value, nil
+}
+
+// transformUnitConversion computes value*factor + offset.
+// Params: factor (default 1), offset (default 0).
+func transformUnitConversion(value interface{}, params map[string]string, _ map[string]interface{}) (interface{}, error) {
+	num, err := toNumberString(value)
+	if err != nil {
+		return nil, err
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	factor, err := floatParam(params, "factor", 1)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := floatParam(params, "offset", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return f*factor + offset, nil
+}
+
+// transformDateFormat parses a datetime and re-formats it.
+// Params: inputFormat (Go layout, default RFC 3339), outputFormat (epoch_s, epoch_ms or a Go
+// layout; default epoch_s), timezone (IANA name used when the input has no zone, default UTC).
+func transformDateFormat(value interface{}, params map[string]string, _ map[string]interface{}) (interface{}, error) {
+	str, err := toString(value)
+	if err != nil {
+		return nil, err
+	}
+	str = strings.TrimSpace(str)
+
+	loc := time.UTC
+	if tz := params["timezone"]; tz != "" {
+		loc, err = time.LoadLocation(tz)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %w", tz, err)
+		}
+	}
+
+	var t time.Time
+	switch inputFormat := params["inputFormat"]; inputFormat {
+	case "", "iso":
+		t, err = time.ParseInLocation(time.RFC3339Nano, str, loc)
+	case "epoch_s", "epoch_ms":
+		var n int64
+		n, err = strconv.ParseInt(str, 10, 64)
+		if inputFormat == "epoch_s" {
+			t = time.Unix(n, 0)
+		} else {
+			t = time.UnixMilli(n)
+		}
+	default:
+		t, err = time.ParseInLocation(inputFormat, str, loc)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse %q: %w", str, err)
+	}
+
+	switch outputFormat := params["outputFormat"]; outputFormat {
+	case "", "epoch_s":
+		return t.Unix(), nil
+	case "epoch_ms":
+		return t.UnixMilli(), nil
+	case "iso":
+		return t.UTC().Format(time.RFC3339), nil
+	default:
+		return t.In(loc).Format(outputFormat), nil
+	}
+}
+
+// localeSeparators maps a locale to its decimal and digit group separators.
+var localeSeparators = map[string][2]string{
+	"en": {".", ","},
+	"de": {",", "."},
+	"fr": {",", " "},
+	"es": {",", "."},
+	"it": {",", "."},
+	"nl": {",", "."},
+	"ch": {".", "'"},
+}
+
+// transformToNumber parses a localized number string.
+// Params: locale (e.g. de, fr; default en), or decimalSeparator and groupSeparator explicitly.
+func transformToNumber(value interface{}, params map[string]string, _ map[string]interface{}) (interface{}, error) {
+	str, ok := value.(string)
+	if !ok {
+		num, err := toNumberString(value)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.ParseFloat(num, 64)
+	}
+
+	locale := strings.ToLower(params["locale"])
+	if idx := strings.IndexAny(locale, "-_"); idx > 0 {
+		locale = locale[:idx]
+	}
+	separators, ok := localeSeparators[locale]
+	if !ok {
+		separators = localeSeparators["en"]
+	}
+	if sep, ok := params["decimalSeparator"]; ok {
+		separators[0] = sep
+	}
+	if sep, ok := params["groupSeparator"]; ok {
+		separators[1] = sep
+	}
+
+	str = strings.TrimSpace(str)
+	if separators[1] != "" {
+		str = strings.ReplaceAll(str, separators[1], "")
+	}
+	str = strings.ReplaceAll(str, " ", "")
+	if separators[0] != "." {
+		str = strings.ReplaceAll(str, separators[0], ".")
+	}
+
+	f, err := strconv.ParseFloat(str, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number %q", value)
+	}
+	return f, nil
+}
+
+// transformLookup maps a value through a lookup table.
+// Params: values (JSON object of source to target value), default (used for unknown values),
+// strict ("true" to reject unknown values instead of passing them through).
+func transformLookup(value interface{}, params map[string]string, _ map[string]interface{}) (interface{}, error) {
+	var table map[string]interface{}
+	if err := json.Unmarshal([]byte(params["values"]), &table); err != nil {
+		return nil, fmt.Errorf("invalid lookup values: %w", err)
+	}
+
+	key, err := toString(value)
+	if err != nil {
+		return nil, err
+	}
+	if mapped, ok := table[key]; ok {
+		return mapped, nil
+	}
+	if def, ok := params["default"]; ok {
+		return def, nil
+	}
+	if params["strict"] == "true" {
+		return nil, fmt.Errorf("value %q not found in lookup table", key)
+	}
+	return value, nil
+}
+
+// transformRegexReplace replaces all matches of pattern with replacement ($1 style groups).
+func transformRegexReplace(value interface{}, params map[string]string, _ map[string]interface{}) (interface{}, error) {
+	str, err := toString(value)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(params["pattern"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+	return re.ReplaceAllString(str, params["replacement"]), nil
+}
+
+// transformCompositeKey builds a key from other fields, e.g. STATION#42#2024-01-01.
+// Params: fields (comma separated field names), separator (default #), prefix.
+func transformCompositeKey(_ interface{}, params map[string]string, item map[string]interface{}) (interface{}, error) {
+	fields := strings.Split(params["fields"], ",")
+	separator, ok := params["separator"]
+	if !ok {
+		separator = "#"
+	}
+
+	parts := make([]string, 0, len(fields)+1)
+	if prefix := params["prefix"]; prefix != "" {
+		parts = append(parts, prefix)
+	}
+	for _, name := range fields {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		v, ok := item[name]
+		if !ok || v == nil {
+			return nil, fmt.Errorf("source field %q missing", name)
+		}
+		s, err := toString(v)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, s)
+	}
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("fields parameter is required")
+	}
+
+	return strings.Join(parts, separator), nil
+}
+
+func floatParam(params map[string]string, name string, def float64) (float64, error) {
+	raw, ok := params[name]
+	if !ok || strings.TrimSpace(raw) == "" {
+		return def, nil
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s parameter %q", name, raw)
+	}
+	return f, nil
+}
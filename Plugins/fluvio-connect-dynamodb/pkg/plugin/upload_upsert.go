@@ -0,0 +1,335 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// Duplicate policies of upsert presets, see UpsertSettings.
+const (
+	UpsertSkipIdentical = "skip_identical" // write new and changed items, skip identical ones
+	UpsertOverwrite     = "overwrite"      // write every item, identical ones included
+	UpsertFail          = "fail"           // refuse the upload when an existing item would change
+	UpsertKeepNewest    = "keep_newest"    // change existing items only when the upload is newer
+)
+
+// Classes of upsert rows reported by the preview.
+const (
+	UpsertRowNew         = "new"
+	UpsertRowChanged     = "changed"
+	UpsertRowIdentical   = "identical"
+	UpsertRowConflicting = "conflicting"
+)
+
+// upsertViolationCode is the violation code of items refused by the fail policy.
+const upsertViolationCode = "duplicate_key"
+
+// UpsertRow is the classification of one upload item against the stored item with its key.
+type UpsertRow struct {
+	Item    int          `json:"item"`          // 1-based index of the item in the upload
+	Row     int          `json:"row,omitempty"` // source row of a raw file upload
+	Status  string       `json:"status"`
+	Write   bool         `json:"write"`             // whether executing the upload writes the item
+	Changes []ItemChange `json:"changes,omitempty"` // uploaded attributes that differ from the stored item
+	Reason  string       `json:"reason,omitempty"`  // why a conflicting item is not written
+}
+
+func (s UpsertSettings) withDefaults() UpsertSettings {
+	if s.DuplicatePolicy == "" {
+		s.DuplicatePolicy = UpsertSkipIdentical
+	}
+	return s
+}
+
+func (s UpsertSettings) validate() error {
+	switch s.DuplicatePolicy {
+	case UpsertSkipIdentical, UpsertOverwrite, UpsertFail:
+	case UpsertKeepNewest:
+		if s.TimestampAttribute == "" {
+			return errors.New("the keep_newest duplicate policy needs a timestampAttribute")
+		}
+	default:
+		return fmt.Errorf("unsupported duplicate policy %q", s.DuplicatePolicy)
+	}
+	return nil
+}
+
+// ClassifyUpsertRows compares every upload item with the stored item of the same key, current[i]
+// being nil when the key does not exist yet, and decides which items are written. Only the
+// uploaded attributes are compared; attributes the upload does not carry are left alone. A key
+// repeated within the upload is identical when it matches its first item and conflicting
+// otherwise, and only the first item is ever written.
+func ClassifyUpsertRows(settings UpsertSettings, keyNames []string, items, current []map[string]*dynamodb.AttributeValue) ([]UpsertRow, error) {
+	settings = settings.withDefaults()
+	if err := settings.validate(); err != nil {
+		return nil, err
+	}
+	if len(current) != len(items) {
+		return nil, fmt.Errorf("got %d stored images for %d items", len(current), len(items))
+	}
+
+	rows := make([]UpsertRow, len(items))
+	first := map[string]int{}
+	for idx, item := range items {
+		row := UpsertRow{Item: idx + 1}
+
+		key := make(map[string]*dynamodb.AttributeValue, len(keyNames))
+		for _, name := range keyNames {
+			if item[name] == nil {
+				return nil, fmt.Errorf("item %d: key attribute %q missing", idx+1, name)
+			}
+			key[name] = item[name]
+		}
+		id := attributeKeyString(key)
+		if earlier, repeated := first[id]; repeated {
+			row.Changes = uploadedChanges(items[earlier], item)
+			row.Status = UpsertRowIdentical
+			if len(row.Changes) > 0 {
+				row.Status = UpsertRowConflicting
+				row.Reason = fmt.Sprintf("key repeats item %d with different values", earlier+1)
+			}
+			rows[idx] = row
+			continue
+		}
+		first[id] = idx
+
+		stored := current[idx]
+		if stored == nil {
+			row.Status, row.Write = UpsertRowNew, true
+			rows[idx] = row
+			continue
+		}
+
+		row.Changes = uploadedChanges(stored, item)
+		switch {
+		case len(row.Changes) == 0:
+			row.Status = UpsertRowIdentical
+			// Items made of their key only have nothing to overwrite
+			row.Write = settings.DuplicatePolicy == UpsertOverwrite && len(item) > len(keyNames)
+		case settings.DuplicatePolicy == UpsertFail:
+			row.Status = UpsertRowConflicting
+			row.Reason = "key already exists with different values"
+		case settings.DuplicatePolicy == UpsertKeepNewest:
+			if newer, reason := newerTimestamp(settings.TimestampAttribute, item[settings.TimestampAttribute], stored[settings.TimestampAttribute]); newer {
+				row.Status, row.Write = UpsertRowChanged, true
+			} else {
+				row.Status, row.Reason = UpsertRowConflicting, reason
+			}
+		default:
+			row.Status, row.Write = UpsertRowChanged, true
+		}
+		rows[idx] = row
+	}
+	return rows, nil
+}
+
+// uploadedChanges lists the attributes of item whose value differs from stored.
+func uploadedChanges(stored, item map[string]*dynamodb.AttributeValue) []ItemChange {
+	var changes []ItemChange
+	for _, name := range sortedAttributeNames(item) {
+		if !sameAttributeValue(stored[name], item[name]) {
+			changes = append(changes, ItemChange{Attribute: name, Before: stored[name], After: item[name]})
+		}
+	}
+	return changes
+}
+
+// newerTimestamp reports whether the uploaded timestamp is newer than the stored one. Numbers
+// compare by value, dates by time and other strings lexically. A stored item without the
+// timestamp is always older.
+func newerTimestamp(name string, uploaded, stored *dynamodb.AttributeValue) (bool, string) {
+	if isNullAttribute(uploaded) {
+		return false, fmt.Sprintf("timestamp attribute %q missing", name)
+	}
+	if isNullAttribute(stored) {
+		return true, ""
+	}
+
+	var cmp int
+	switch {
+	case uploaded.N != nil && stored.N != nil:
+		x, errX := strconv.ParseFloat(*uploaded.N, 64)
+		y, errY := strconv.ParseFloat(*stored.N, 64)
+		if errX != nil || errY != nil {
+			return false, fmt.Sprintf("timestamp attribute %q is not a number", name)
+		}
+		cmp = compareFloats(x, y)
+	case uploaded.S != nil && stored.S != nil:
+		x, okX := ruleTime(*uploaded.S)
+		y, okY := ruleTime(*stored.S)
+		if okX && okY {
+			cmp = x.Compare(y)
+		} else {
+			cmp = strings.Compare(*uploaded.S, *stored.S)
+		}
+	default:
+		return false, fmt.Sprintf("timestamp attribute %q has a different type than the stored item", name)
+	}
+
+	if cmp <= 0 {
+		return false, fmt.Sprintf("stored item is not older (%s %s, uploaded %s)", name, timestampText(stored), timestampText(uploaded))
+	}
+	return true, ""
+}
+
+// timestampText returns a number or string timestamp as written in the item.
+func timestampText(av *dynamodb.AttributeValue) string {
+	if av.N != nil {
+		return *av.N
+	}
+	return aws.StringValue(av.S)
+}
+
+func compareFloats(x, y float64) int {
+	switch {
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+func isNullAttribute(av *dynamodb.AttributeValue) bool {
+	return av == nil || aws.BoolValue(av.NULL)
+}
+
+// planUpsert classifies the items of an upsert plan against the stored items and rewrites the
+// plan: new items keep their INSERT, written existing items are updated and all others are
+// skipped. Under the fail policy conflicting items become violations so nothing is written.
+// rows maps items to their source rows for raw file uploads and may be nil.
+func (d *Datasource) planUpsert(ctx context.Context, newClient func() (*dynamodb.DynamoDB, error), preset UploadPreset, plan *uploadPlan, rows []int) error {
+	if preset.Operation != UploadOperationUpsert {
+		return nil
+	}
+	settings := UpsertSettings{}
+	if preset.Upsert != nil {
+		settings = *preset.Upsert
+	}
+	settings = settings.withDefaults()
+	if err := settings.validate(); err != nil {
+		return fmt.Errorf("preset %q: %w", preset.ID, err)
+	}
+
+	client, err := newClient()
+	if err != nil {
+		return fmt.Errorf("failed to get DynamoDB client: %w", err)
+	}
+	schema, err := d.getKeySchema(ctx, client, preset.Table, "")
+	if err != nil {
+		return fmt.Errorf("failed to read key schema: %w", err)
+	}
+	keyNames := schema.names()
+
+	images := make([]map[string]*dynamodb.AttributeValue, len(plan.items))
+	itemKeys := make([]string, len(plan.items))
+	var keys []map[string]*dynamodb.AttributeValue
+	seen := map[string]bool{}
+	for idx, item := range plan.items {
+		key, err := UploadItemKey(preset, keyNames, item)
+		if err != nil {
+			return fmt.Errorf("item %d: %w", idx+1, err)
+		}
+		images[idx] = make(map[string]*dynamodb.AttributeValue, len(item))
+		for name, value := range item {
+			if images[idx][name], err = preset.fieldAttributeValue(name, value); err != nil {
+				return fmt.Errorf("item %d: field %q: %w", idx+1, name, err)
+			}
+		}
+		itemKeys[idx] = attributeKeyString(key)
+		if !seen[itemKeys[idx]] {
+			seen[itemKeys[idx]] = true
+			keys = append(keys, key)
+		}
+	}
+
+	stored, err := d.batchGetItems(ctx, client, preset.Table, keyNames, keys, nil)
+	if err != nil {
+		return fmt.Errorf("failed to read existing items: %w", err)
+	}
+	current := make([]map[string]*dynamodb.AttributeValue, len(plan.items))
+	for idx, id := range itemKeys {
+		current[idx] = stored[id]
+	}
+
+	classified, err := ClassifyUpsertRows(settings, keyNames, images, current)
+	if err != nil {
+		return err
+	}
+
+	for idx, row := range classified {
+		if idx < len(rows) {
+			row.Row = rows[idx]
+		}
+		switch {
+		case row.Status == UpsertRowNew:
+			// The INSERT built with the plan fails if the key was created in the meantime
+		case row.Write:
+			var guard *dynamodb.AttributeValue
+			if settings.DuplicatePolicy == UpsertKeepNewest {
+				guard = current[idx][settings.TimestampAttribute]
+			}
+			plan.statements[idx], plan.statementPreviews[idx] = buildUpsertUpdateStatement(preset.Table, keyNames, images[idx], plan.items[idx], settings, guard)
+		default:
+			plan.statements[idx].skip = row.Status
+			plan.statementPreviews[idx] = fmt.Sprintf("-- item %d is %s, not written", row.Item, row.Status)
+		}
+		if row.Status == UpsertRowConflicting && settings.DuplicatePolicy == UpsertFail {
+			plan.violations = append(plan.violations, uploadViolation{Item: row.Item, Row: row.Row, Code: upsertViolationCode, Error: row.Reason})
+		}
+		classified[idx] = row
+	}
+	sort.SliceStable(plan.violations, func(i, j int) bool { return plan.violations[i].Item < plan.violations[j].Item })
+	plan.upsert = classified
+	return nil
+}
+
+// buildUpsertUpdateStatement sets the uploaded attributes of an existing item. The UPDATE fails
+// when the item was deleted in the meantime; keep_newest updates are also guarded by the stored
+// timestamp so a newer concurrent write is not replaced.
+func buildUpsertUpdateStatement(table string, keyNames []string, image map[string]*dynamodb.AttributeValue, item map[string]interface{}, settings UpsertSettings, storedTimestamp *dynamodb.AttributeValue) (uploadStatement, string) {
+	isKey := map[string]bool{}
+	for _, name := range keyNames {
+		isKey[name] = true
+	}
+
+	var set, setPreview, where, wherePreview []string
+	var params, keyParams []*dynamodb.AttributeValue
+	for _, name := range sortedAttributeNames(image) {
+		if isKey[name] {
+			continue
+		}
+		set = append(set, fmt.Sprintf("%s=?", quoteIdentifier(name)))
+		setPreview = append(setPreview, fmt.Sprintf("%s=%s", quoteIdentifier(name), formatValueForPreview(item[name])))
+		params = append(params, image[name])
+	}
+	for _, name := range keyNames {
+		where = append(where, fmt.Sprintf("%s=?", quoteIdentifier(name)))
+		wherePreview = append(wherePreview, fmt.Sprintf("%s=%s", quoteIdentifier(name), formatValueForPreview(item[name])))
+		keyParams = append(keyParams, image[name])
+	}
+	params = append(params, keyParams...)
+
+	if settings.DuplicatePolicy == UpsertKeepNewest {
+		ts := quoteIdentifier(settings.TimestampAttribute)
+		if isNullAttribute(storedTimestamp) {
+			where = append(where, ts+" IS MISSING")
+			wherePreview = append(wherePreview, ts+" IS MISSING")
+		} else {
+			where = append(where, ts+" < ?")
+			wherePreview = append(wherePreview, fmt.Sprintf("%s < %s", ts, formatValueForPreview(item[settings.TimestampAttribute])))
+			params = append(params, image[settings.TimestampAttribute])
+		}
+	}
+
+	statement := fmt.Sprintf("UPDATE %s SET %s WHERE %s", quoteIdentifier(table), strings.Join(set, ", "), strings.Join(where, " AND "))
+	preview := fmt.Sprintf("UPDATE %s SET %s WHERE %s", quoteIdentifier(table), strings.Join(setPreview, ", "), strings.Join(wherePreview, " AND "))
+	return uploadStatement{statement: statement, params: params}, preview
+}
@@ -0,0 +1,465 @@
+package plugin
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errXLSXEntryTooLarge is returned when an archive entry decompresses past the entry limit.
+var errXLSXEntryTooLarge = errors.New("XLSX entry exceeds the maximum uncompressed size")
+
+// xlsxArchive is an opened workbook whose entries are read at most maxEntryBytes each once
+// decompressed, so a small file cannot expand without bound.
+type xlsxArchive struct {
+	files         map[string]*zip.File
+	maxEntryBytes int64
+}
+
+// xlsxWorkbook is the part of xl/workbook.xml needed to find sheets and read dates.
+type xlsxWorkbook struct {
+	Sheets []struct {
+		Name  string     `xml:"name,attr"`
+		Attrs []xml.Attr `xml:",any,attr"`
+	} `xml:"sheets>sheet"`
+	Properties struct {
+		Date1904 string `xml:"date1904,attr"`
+	} `xml:"workbookPr"`
+}
+
+// readXLSX streams the rows of one worksheet of an XLSX workbook to visit. The first sheet is
+// read when sheet is empty. Cells are returned as text; shared and inline strings are resolved
+// and numbers formatted as dates become RFC 3339 timestamps. No entry of the archive may
+// decompress to more than maxEntryBytes (unlimited when not positive).
+func readXLSX(body []byte, sheet string, maxEntryBytes int64, visit func(row int, record []string) error) error {
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return fmt.Errorf("invalid XLSX file: %w", err)
+	}
+
+	archive := &xlsxArchive{files: make(map[string]*zip.File, len(zr.File)), maxEntryBytes: maxEntryBytes}
+	for _, f := range zr.File {
+		archive.files[f.Name] = f
+	}
+
+	var workbook xlsxWorkbook
+	if err := archive.decode("xl/workbook.xml", &workbook); err != nil {
+		return err
+	}
+	sheetPath, err := archive.sheetPath(workbook, sheet)
+	if err != nil {
+		return err
+	}
+
+	var shared []string
+	if _, ok := archive.files["xl/sharedStrings.xml"]; ok {
+		if shared, err = archive.sharedStrings("xl/sharedStrings.xml"); err != nil {
+			return err
+		}
+	}
+
+	dates := xlsxDates{epoch: time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)}
+	if v := workbook.Properties.Date1904; v == "1" || v == "true" {
+		dates.epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if _, ok := archive.files["xl/styles.xml"]; ok {
+		if dates.styles, err = archive.dateStyles("xl/styles.xml"); err != nil {
+			return err
+		}
+	}
+
+	rc, err := archive.open(sheetPath)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return xlsxRows(xml.NewDecoder(rc), shared, dates, visit)
+}
+
+// open returns a reader of an archive entry that fails once the entry decompresses past the
+// entry limit, whatever size its header claims.
+func (a *xlsxArchive) open(name string) (io.ReadCloser, error) {
+	f, ok := a.files[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid XLSX file: %s missing", name)
+	}
+	if a.maxEntryBytes > 0 && f.UncompressedSize64 > uint64(a.maxEntryBytes) {
+		return nil, xlsxEntryTooLarge(name, a.maxEntryBytes)
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, fmt.Errorf("invalid XLSX file: %w", err)
+	}
+	if a.maxEntryBytes <= 0 {
+		return rc, nil
+	}
+	return &xlsxEntryReader{ReadCloser: rc, name: name, limit: a.maxEntryBytes, remaining: a.maxEntryBytes}, nil
+}
+
+func xlsxEntryTooLarge(name string, limit int64) error {
+	return fmt.Errorf("%w of %d KB: %s", errXLSXEntryTooLarge, limit/1024, name)
+}
+
+// xlsxEntryReader stops reading an entry after its remaining bytes.
+type xlsxEntryReader struct {
+	io.ReadCloser
+	name      string
+	limit     int64
+	remaining int64
+}
+
+func (r *xlsxEntryReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		// Reading one more byte tells an entry of exactly the limit from a larger one.
+		var probe [1]byte
+		n, err := r.ReadCloser.Read(probe[:])
+		if n > 0 {
+			return 0, xlsxEntryTooLarge(r.name, r.limit)
+		}
+		return 0, err
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.ReadCloser.Read(p)
+	r.remaining -= int64(n)
+	return n, err
+}
+
+// sheetPath resolves the archive path of the named sheet through the workbook relations.
+func (a *xlsxArchive) sheetPath(workbook xlsxWorkbook, sheet string) (string, error) {
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := a.decode("xl/_rels/workbook.xml.rels", &rels); err != nil {
+		return "", err
+	}
+
+	for i, s := range workbook.Sheets {
+		if sheet != "" && s.Name != sheet {
+			continue
+		}
+		if sheet == "" && i > 0 {
+			break
+		}
+
+		var relID string
+		for _, attr := range s.Attrs {
+			if attr.Name.Local == "id" {
+				relID = attr.Value
+			}
+		}
+		for _, rel := range rels.Relationships {
+			if rel.ID != relID {
+				continue
+			}
+			if strings.HasPrefix(rel.Target, "/") {
+				return strings.TrimPrefix(rel.Target, "/"), nil
+			}
+			return path.Join("xl", rel.Target), nil
+		}
+		return "", fmt.Errorf("invalid XLSX file: no relationship for sheet %q", s.Name)
+	}
+
+	if sheet != "" {
+		return "", fmt.Errorf("sheet %q not found", sheet)
+	}
+	return "", fmt.Errorf("invalid XLSX file: workbook has no sheets")
+}
+
+func (a *xlsxArchive) decode(name string, v interface{}) error {
+	rc, err := a.open(name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	if err := xml.NewDecoder(rc).Decode(v); err != nil {
+		return xlsxReadError(name, err)
+	}
+	return nil
+}
+
+// sharedStrings reads the shared string table. Rich text runs are concatenated and phonetic
+// hints are ignored.
+func (a *xlsxArchive) sharedStrings(name string) ([]string, error) {
+	rc, err := a.open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var (
+		shared   []string
+		current  strings.Builder
+		inText   bool
+		phonetic int
+	)
+	decoder := xml.NewDecoder(rc)
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return shared, nil
+		}
+		if err != nil {
+			return nil, xlsxReadError("shared strings", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "si":
+				current.Reset()
+			case "rPh":
+				phonetic++
+			case "t":
+				inText = phonetic == 0
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "si":
+				shared = append(shared, current.String())
+			case "rPh":
+				phonetic--
+			case "t":
+				inText = false
+			}
+		case xml.CharData:
+			if inText {
+				current.Write(t)
+			}
+		}
+	}
+}
+
+// xlsxReadError wraps an error reading part of the archive; the entry limit is reported as is.
+func xlsxReadError(part string, err error) error {
+	if errors.Is(err, errXLSXEntryTooLarge) {
+		return err
+	}
+	return fmt.Errorf("invalid XLSX file: %s: %w", part, err)
+}
+
+// xlsxDates tells which cell styles format numbers as dates, and the epoch of the workbook's
+// date serial numbers.
+type xlsxDates struct {
+	styles []bool // by cell style index
+	epoch  time.Time
+}
+
+// isDate reports whether the cell style at index s, as written in the cell, is a date format.
+func (d xlsxDates) isDate(s string) bool {
+	idx, err := strconv.Atoi(s)
+	return err == nil && idx >= 0 && idx < len(d.styles) && d.styles[idx]
+}
+
+// timestamp converts a date serial number, days since the epoch with the time of day as the
+// fraction, to RFC 3339. Serials are rounded to the millisecond.
+func (d xlsxDates) timestamp(raw string) (string, bool) {
+	serial, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	if err != nil || serial < 0 {
+		return "", false
+	}
+	ms := int64(math.Round(serial * 24 * 60 * 60 * 1000))
+	t := d.epoch.Add(time.Duration(ms) * time.Millisecond)
+	return t.Format(time.RFC3339Nano), true
+}
+
+// dateStyles reads the cell styles of styles.xml and reports which of them format dates.
+func (a *xlsxArchive) dateStyles(name string) ([]bool, error) {
+	var styles struct {
+		NumFmts []struct {
+			ID   int    `xml:"numFmtId,attr"`
+			Code string `xml:"formatCode,attr"`
+		} `xml:"numFmts>numFmt"`
+		CellXfs []struct {
+			NumFmtID int `xml:"numFmtId,attr"`
+		} `xml:"cellXfs>xf"`
+	}
+	if err := a.decode(name, &styles); err != nil {
+		return nil, err
+	}
+
+	custom := make(map[int]bool, len(styles.NumFmts))
+	for _, f := range styles.NumFmts {
+		custom[f.ID] = isDateFormatCode(f.Code)
+	}
+	dates := make([]bool, len(styles.CellXfs))
+	for i, xf := range styles.CellXfs {
+		if isDate, ok := custom[xf.NumFmtID]; ok {
+			dates[i] = isDate
+		} else {
+			dates[i] = isBuiltinDateFormat(xf.NumFmtID)
+		}
+	}
+	return dates, nil
+}
+
+// isBuiltinDateFormat reports whether a built-in number format shows a date or time,
+// including the locale specific formats of East Asian versions of Excel.
+func isBuiltinDateFormat(id int) bool {
+	return (id >= 14 && id <= 22) || (id >= 27 && id <= 36) || (id >= 45 && id <= 47) || (id >= 50 && id <= 58)
+}
+
+// isDateFormatCode reports whether a custom number format code shows a date or time, i.e. it
+// has day, month, year, hour or second placeholders outside quoted text, escapes and
+// bracketed colors or conditions. Elapsed time such as [h]:mm is treated as a date too.
+func isDateFormatCode(code string) bool {
+	// Only the first section applies to positive numbers.
+	inQuote := false
+	for i := 0; i < len(code); i++ {
+		switch ch := code[i]; {
+		case inQuote:
+			inQuote = ch != '"'
+		case ch == '"':
+			inQuote = true
+		case ch == '\\' || ch == '_' || ch == '*':
+			i++
+		case ch == '[':
+			end := strings.IndexByte(code[i:], ']')
+			if end < 0 {
+				return false
+			}
+			if inner := strings.ToLower(code[i+1 : i+end]); inner == "h" || inner == "hh" || inner == "m" || inner == "mm" || inner == "s" || inner == "ss" {
+				return true
+			}
+			i += end
+		case ch == ';':
+			return false
+		default:
+			switch ch | 0x20 { // lower case
+			case 'd', 'm', 'y', 'h', 's':
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// xlsxRows walks the sheetData of a worksheet one row at a time.
+func xlsxRows(decoder *xml.Decoder, shared []string, dates xlsxDates, visit func(row int, record []string) error) error {
+	var (
+		rowNumber int
+		record    []string
+		cellType  string
+		cellStyle string
+		cellCol   int
+		value     strings.Builder
+		inValue   bool
+	)
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return xlsxReadError("worksheet", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "row":
+				rowNumber++
+				if r := xmlAttr(t, "r"); r != "" {
+					if n, err := strconv.Atoi(r); err == nil {
+						rowNumber = n
+					}
+				}
+				record = record[:0]
+			case "c":
+				cellType = xmlAttr(t, "t")
+				cellStyle = xmlAttr(t, "s")
+				cellCol = len(record)
+				if ref := xmlAttr(t, "r"); ref != "" {
+					if col, ok := xlsxColumnIndex(ref); ok {
+						cellCol = col
+					}
+				}
+				value.Reset()
+			case "v", "t":
+				inValue = true
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "v", "t":
+				inValue = false
+			case "c":
+				text, err := xlsxCellText(cellType, value.String(), shared)
+				if err != nil {
+					return fmt.Errorf("invalid XLSX file: row %d: %w", rowNumber, err)
+				}
+				if (cellType == "" || cellType == "n") && dates.isDate(cellStyle) {
+					if ts, ok := dates.timestamp(text); ok {
+						text = ts
+					}
+				}
+				for len(record) < cellCol {
+					record = append(record, "")
+				}
+				record = append(record, text)
+			case "row":
+				if err := visit(rowNumber, append([]string(nil), record...)); err != nil {
+					return err
+				}
+			}
+		case xml.CharData:
+			if inValue {
+				value.Write(t)
+			}
+		}
+	}
+}
+
+func xlsxCellText(cellType string, raw string, shared []string) (string, error) {
+	switch cellType {
+	case "s":
+		idx, err := strconv.Atoi(strings.TrimSpace(raw))
+		if err != nil || idx < 0 || idx >= len(shared) {
+			return "", fmt.Errorf("invalid shared string index %q", raw)
+		}
+		return shared[idx], nil
+	case "b":
+		if strings.TrimSpace(raw) == "1" {
+			return "true", nil
+		}
+		return "false", nil
+	default:
+		return raw, nil
+	}
+}
+
+// xlsxColumnIndex returns the 0-based column of a cell reference such as "AB12".
+func xlsxColumnIndex(ref string) (int, bool) {
+	col := 0
+	n := 0
+	for _, ch := range ref {
+		if ch < 'A' || ch > 'Z' {
+			break
+		}
+		col = col*26 + int(ch-'A'+1)
+		n++
+	}
+	return col - 1, n > 0
+}
+
+func xmlAttr(el xml.StartElement, name string) string {
+	for _, attr := range el.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,483 @@
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// QueryTypeVariables marks a query issued by a dashboard variable.
+const QueryTypeVariables = "variables"
+
+const (
+	VariableTables        = "tables"
+	VariableDistinct      = "distinct"
+	VariablePartitionKeys = "partitionKeys"
+	VariablePairs         = "pairs"
+)
+
+const (
+	defaultVariableLimit = 1000
+	maxVariableLimit     = 10000
+	maxVariableItems     = 100000 // items read before the value list is reported as truncated
+	maxVariablePages     = 100
+	variableCacheTTL     = time.Minute
+)
+
+// VariableQueryModel describes a dashboard variable query. Distinct values are read with a
+// projection of only the needed attributes, so a KEYS_ONLY index keeps the read small.
+type VariableQueryModel struct {
+	Type           string           `json:"type"` // tables, distinct, partitionKeys or pairs
+	Table          string           `json:"table,omitempty"`
+	Index          string           `json:"index,omitempty"`
+	Attribute      string           `json:"attribute,omitempty"`      // distinct; partitionKeys defaults to the key of the table or index
+	TextAttribute  string           `json:"textAttribute,omitempty"`  // pairs
+	ValueAttribute string           `json:"valueAttribute,omitempty"` // pairs
+	Filters        []VariableFilter `json:"filters,omitempty"`        // restrict values by other variables
+	Limit          int              `json:"limit,omitempty"`          // distinct values returned; defaults to 1000
+}
+
+// VariableFilter restricts a variable to items whose attribute matches one of the values,
+// typically the current selection of a parent variable. A filter without values, or with
+// the "$__all" or "*" value, matches everything.
+type VariableFilter struct {
+	Attribute string   `json:"attribute"`
+	Values    []string `json:"values"`
+	Type      string   `json:"type,omitempty"` // "S" (default) or "N"
+}
+
+// VariableValue is one option of a variable.
+type VariableValue struct {
+	Text  string `json:"text"`
+	Value string `json:"value"`
+}
+
+// VariableResult is the response of a variable query.
+type VariableResult struct {
+	Values    []VariableValue `json:"values"`
+	Truncated bool            `json:"truncated"`
+}
+
+func (v VariableQueryModel) limit() int {
+	switch {
+	case v.Limit <= 0:
+		return defaultVariableLimit
+	case v.Limit > maxVariableLimit:
+		return maxVariableLimit
+	}
+	return v.Limit
+}
+
+func (v VariableQueryModel) validate() error {
+	switch v.Type {
+	case VariableTables:
+		return nil
+	case VariableDistinct:
+		if v.Attribute == "" {
+			return fmt.Errorf("distinct variables require an attribute")
+		}
+	case VariablePartitionKeys:
+	case VariablePairs:
+		if v.TextAttribute == "" || v.ValueAttribute == "" {
+			return fmt.Errorf("pairs variables require textAttribute and valueAttribute")
+		}
+	default:
+		return fmt.Errorf("unsupported variable type %q", v.Type)
+	}
+	if v.Table == "" {
+		return fmt.Errorf("%s variables require a table", v.Type)
+	}
+	for _, f := range v.Filters {
+		if f.Attribute == "" {
+			return fmt.Errorf("variable filters require an attribute")
+		}
+		if f.Type != "" && f.Type != "S" && f.Type != "N" {
+			return fmt.Errorf("unsupported filter type %q for %s", f.Type, f.Attribute)
+		}
+		if f.Type == "N" {
+			for _, value := range f.Values {
+				if _, err := strconv.ParseFloat(value, 64); err != nil && !matchesAllValues([]string{value}) {
+					return fmt.Errorf("filter value %q for %s is not a number", value, f.Attribute)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// attributes returns the attributes a variable reads; for pairs the value comes first.
+func (v VariableQueryModel) attributes() []string {
+	if v.Type == VariablePairs {
+		if v.TextAttribute == v.ValueAttribute {
+			return []string{v.ValueAttribute}
+		}
+		return []string{v.ValueAttribute, v.TextAttribute}
+	}
+	return []string{v.Attribute}
+}
+
+func matchesAllValues(values []string) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, value := range values {
+		if value == "$__all" || value == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildVariableStatement returns the PartiQL statement that reads the attributes of a
+// variable, with its filters as an equality or IN condition.
+func BuildVariableStatement(v VariableQueryModel) (string, error) {
+	if err := v.validate(); err != nil {
+		return "", err
+	}
+	if v.Type == VariableTables {
+		return "", fmt.Errorf("tables variables do not run a statement")
+	}
+	if v.Attribute == "" && v.Type == VariablePartitionKeys {
+		return "", fmt.Errorf("partition key attribute is not resolved")
+	}
+
+	projection := make([]string, 0, 2)
+	for _, name := range v.attributes() {
+		projection = append(projection, quoteIdentifier(name))
+	}
+	source := quoteIdentifier(v.Table)
+	if v.Index != "" {
+		source += "." + quoteIdentifier(v.Index)
+	}
+	statement := fmt.Sprintf("SELECT %s FROM %s", strings.Join(projection, ", "), source)
+
+	conditions := make([]string, 0, len(v.Filters))
+	for _, f := range v.Filters {
+		if matchesAllValues(f.Values) {
+			continue
+		}
+		literals := make([]string, len(f.Values))
+		for i, value := range f.Values {
+			if f.Type == "N" {
+				literals[i] = value
+			} else {
+				literals[i] = quoteStringLiteral(value)
+			}
+		}
+		if len(literals) == 1 {
+			conditions = append(conditions, fmt.Sprintf("%s = %s", quoteIdentifier(f.Attribute), literals[0]))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("%s IN [%s]", quoteIdentifier(f.Attribute), strings.Join(literals, ", ")))
+		}
+	}
+	if len(conditions) > 0 {
+		statement += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	return statement, nil
+}
+
+// CollectVariableValues returns the distinct values found in items, sorted numerically when
+// every value is a number and alphabetically otherwise. Pairs take the text of the first item
+// carrying each value. Truncated is set when more than the limit were found.
+func CollectVariableValues(items []map[string]*dynamodb.AttributeValue, v VariableQueryModel) VariableResult {
+	valueAttribute, textAttribute := v.Attribute, v.Attribute
+	if v.Type == VariablePairs {
+		valueAttribute, textAttribute = v.ValueAttribute, v.TextAttribute
+	}
+
+	result := VariableResult{Values: make([]VariableValue, 0)}
+	seen := map[string]bool{}
+	for _, item := range items {
+		for _, value := range variableScalars(item[valueAttribute]) {
+			if seen[value] {
+				continue
+			}
+			seen[value] = true
+			text := value
+			if textAttribute != valueAttribute {
+				if texts := variableScalars(item[textAttribute]); len(texts) > 0 {
+					text = texts[0]
+				}
+			}
+			result.Values = append(result.Values, VariableValue{Text: text, Value: value})
+		}
+	}
+	sortVariableValues(result.Values, v.Type == VariablePairs)
+
+	if limit := v.limit(); len(result.Values) > limit {
+		result.Values = result.Values[:limit]
+		result.Truncated = true
+	}
+	return result
+}
+
+// variableScalars returns the values an attribute contributes: one for scalars and one per
+// element for sets.
+func variableScalars(av *dynamodb.AttributeValue) []string {
+	if av == nil {
+		return nil
+	}
+	switch {
+	case av.S != nil, av.N != nil, av.BOOL != nil:
+		return []string{attributeValueToLabel(av)}
+	case av.SS != nil:
+		return aws.StringValueSlice(av.SS)
+	case av.NS != nil:
+		return aws.StringValueSlice(av.NS)
+	}
+	return nil
+}
+
+func sortVariableValues(values []VariableValue, byText bool) {
+	key := func(v VariableValue) string {
+		if byText {
+			return v.Text
+		}
+		return v.Value
+	}
+	numeric := true
+	for _, v := range values {
+		if _, err := strconv.ParseFloat(key(v), 64); err != nil {
+			numeric = false
+			break
+		}
+	}
+	sort.SliceStable(values, func(i, j int) bool {
+		a, b := key(values[i]), key(values[j])
+		if numeric {
+			fa, _ := strconv.ParseFloat(a, 64)
+			fb, _ := strconv.ParseFloat(b, 64)
+			return fa < fb
+		}
+		return a < b
+	})
+}
+
+type cachedVariableResult struct {
+	result    VariableResult
+	fetchedAt time.Time
+}
+
+// variableCache holds variable results per datasource and query for a short TTL, so a
+// dashboard load with many panels or chained variables reads each list once.
+var variableCache sync.Map
+
+var errReadBudgetExhausted = errors.New("read budget exhausted")
+
+func (d *Datasource) variableCacheKey(uid string, target AWSTarget, v VariableQueryModel) string {
+	raw, _ := json.Marshal(v)
+	return uid + "|" + d.targetIdentity(target) + "|" + string(raw)
+}
+
+func pruneVariableCache() {
+	variableCache.Range(func(key, value any) bool {
+		if time.Since(value.(cachedVariableResult).fetchedAt) >= variableCacheTTL {
+			variableCache.Delete(key)
+		}
+		return true
+	})
+}
+
+// variableValues runs a variable query, answering from the cache when possible.
+func (d *Datasource) variableValues(ctx context.Context, client *dynamodb.DynamoDB, target AWSTarget, orgID int64, uid string, v VariableQueryModel) (VariableResult, error) {
+	if err := v.validate(); err != nil {
+		return VariableResult{}, err
+	}
+
+	key := d.variableCacheKey(uid, target, v)
+	if cached, ok := variableCache.Load(key); ok {
+		if c := cached.(cachedVariableResult); time.Since(c.fetchedAt) < variableCacheTTL {
+			return c.result, nil
+		}
+	}
+
+	var result VariableResult
+	if v.Type == VariableTables {
+		tables, err := d.listTableNames(ctx, client)
+		if err != nil {
+			return VariableResult{}, err
+		}
+		result.Values = make([]VariableValue, 0, len(tables))
+		for _, table := range tables {
+			result.Values = append(result.Values, VariableValue{Text: table, Value: table})
+		}
+	} else {
+		if v.Type == VariablePartitionKeys && v.Attribute == "" {
+			attribute, err := d.partitionKeyOf(ctx, client, target, v.Table, v.Index)
+			if err != nil {
+				return VariableResult{}, err
+			}
+			v.Attribute = attribute
+		}
+		items, truncated, err := d.readVariableItems(ctx, client, orgID, v)
+		if err != nil {
+			return VariableResult{}, err
+		}
+		result = CollectVariableValues(items, v)
+		result.Truncated = result.Truncated || truncated
+	}
+
+	pruneVariableCache()
+	variableCache.Store(key, cachedVariableResult{result: result, fetchedAt: time.Now()})
+	return result, nil
+}
+
+// partitionKeyOf returns the partition key of a table or one of its indexes.
+func (d *Datasource) partitionKeyOf(ctx context.Context, client *dynamodb.DynamoDB, target AWSTarget, table string, index string) (string, error) {
+	meta, err := d.tableMetadata(ctx, client, target, table)
+	if err != nil {
+		return "", err
+	}
+	if index == "" {
+		return meta.PartitionKey, nil
+	}
+	for _, idx := range meta.Indexes {
+		if idx.Name == index {
+			return idx.PartitionKey, nil
+		}
+	}
+	return "", fmt.Errorf("index %s not found on table %s", index, table)
+}
+
+// readVariableItems reads the projected attributes page by page until the items, pages or
+// distinct value limits are reached; truncated reports an incomplete read.
+func (d *Datasource) readVariableItems(ctx context.Context, client *dynamodb.DynamoDB, orgID int64, v VariableQueryModel) ([]map[string]*dynamodb.AttributeValue, bool, error) {
+	statement, err := BuildVariableStatement(v)
+	if err != nil {
+		return nil, false, err
+	}
+	backend.Logger.Debug("Reading variable values", "statement", statement)
+
+	input := &dynamodb.ExecuteStatementInput{
+		Statement:              aws.String(statement),
+		ReturnConsumedCapacity: aws.String(dynamodb.ReturnConsumedCapacityTotal),
+	}
+	valueAttribute := v.attributes()[0]
+	distinct := map[string]bool{}
+	var items []map[string]*dynamodb.AttributeValue
+
+	for page := 1; ; page++ {
+		// Variable reads count against the org's read budget like queries: an exhausted budget
+		// fails the first page and truncates the values after that.
+		if d.readBudget.Exhausted(orgID) {
+			if page == 1 {
+				return nil, false, fmt.Errorf("%w: %s", errReadBudgetExhausted, d.readBudget.describe(orgID))
+			}
+			backend.Logger.Warn("Variable values truncated by the read budget", "orgID", orgID, "table", v.Table, "pages", page-1)
+			return items, true, nil
+		}
+		if err := d.readLimiter.Wait(ctx, 1); err != nil {
+			return nil, false, err
+		}
+		output, err := CallWithRetry(ctx, d.retrySettings, nil, func() (*dynamodb.ExecuteStatementOutput, error) {
+			return client.ExecuteStatementWithContext(ctx, input)
+		})
+		if output != nil {
+			units := capacityUnits(1, output.ConsumedCapacity)
+			d.readLimiter.Settle(1, units)
+			d.readBudget.Record(orgID, units)
+		}
+		if err != nil {
+			return nil, false, err
+		}
+
+		items = append(items, output.Items...)
+		for _, item := range output.Items {
+			for _, value := range variableScalars(item[valueAttribute]) {
+				distinct[value] = true
+			}
+		}
+
+		if output.NextToken == nil {
+			return items, false, nil
+		}
+		if len(distinct) > v.limit() || len(items) >= maxVariableItems || page >= maxVariablePages {
+			backend.Logger.Warn("Variable values truncated", "table", v.Table, "items", len(items), "values", len(distinct), "pages", page)
+			return items, true, nil
+		}
+		input.NextToken = output.NextToken
+	}
+}
+
+// variableQuery answers a variable query from QueryData with a text/value frame.
+func (d *Datasource) variableQuery(ctx context.Context, client *dynamodb.DynamoDB, target AWSTarget, orgID int64, uid string, refID string, v VariableQueryModel) backend.DataResponse {
+	result, err := d.variableValues(ctx, client, target, orgID, uid, v)
+	if errors.Is(err, errReadBudgetExhausted) {
+		return backend.ErrDataResponse(backend.StatusTooManyRequests, err.Error())
+	}
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusBadRequest, fmt.Sprintf("variable: %v", err.Error()))
+	}
+
+	texts := make([]string, len(result.Values))
+	values := make([]string, len(result.Values))
+	for i, value := range result.Values {
+		texts[i] = value.Text
+		values[i] = value.Value
+	}
+	frame := data.NewFrame(refID, data.NewField("text", nil, texts), data.NewField("value", nil, values))
+	if result.Truncated {
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("Variable values were truncated to %d; add filters or raise the limit", len(result.Values)),
+		})
+	}
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
+
+// handleVariables answers variable queries for the frontend. Body: a VariableQueryModel.
+func (d *Datasource) handleVariables(ctx context.Context, req *backend.CallResourceRequest, sender backend.CallResourceResponseSender) error {
+	if req.Method != http.MethodPost {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusMethodNotAllowed,
+			Body:   []byte(`{"error": "method not allowed"}`),
+		})
+	}
+
+	var v VariableQueryModel
+	if err := json.Unmarshal(req.Body, &v); err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusBadRequest,
+			Body:   []byte(fmt.Sprintf(`{"error": "invalid variable query: %s"}`, sanitizeError(err))),
+		})
+	}
+	if err := v.validate(); err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusBadRequest,
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
+		})
+	}
+
+	client, err := d.getDynamoDBClient(ctx, req.PluginContext.DataSourceInstanceSettings)
+	if err != nil {
+		return sender.Send(&backend.CallResourceResponse{
+			Status: http.StatusInternalServerError,
+			Body:   []byte(fmt.Sprintf(`{"error": "failed to get DynamoDB client: %s"}`, sanitizeError(err))),
+		})
+	}
+
+	result, err := d.variableValues(ctx, client, AWSTarget{}, req.PluginContext.OrgID, req.PluginContext.DataSourceInstanceSettings.UID, v)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, errReadBudgetExhausted) {
+			status = http.StatusTooManyRequests
+		}
+		return sender.Send(&backend.CallResourceResponse{
+			Status: status,
+			Body:   []byte(fmt.Sprintf(`{"error": "%s"}`, sanitizeError(err))),
+		})
+	}
+	return sendUploadJSON(sender, http.StatusOK, result)
+}
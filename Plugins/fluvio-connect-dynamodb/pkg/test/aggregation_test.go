@@ -0,0 +1,81 @@
+package test
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/fluvio/fluvio-connect-dynamodb/pkg/plugin"
+)
+
+func reading(station string, ts int64, level string) map[string]*dynamodb.AttributeValue {
+	return map[string]*dynamodb.AttributeValue{
+		"station_id": {S: aws.String(station)},
+		"ts":         {N: aws.String(strconv.FormatInt(ts, 10))},
+		"level":      {N: aws.String(level)},
+	}
+}
+
+func TestAggregateItems(t *testing.T) {
+	items := []map[string]*dynamodb.AttributeValue{
+		reading("A", 0, "1"),
+		reading("A", 30, "3"),
+		reading("A", 60, "5"),
+		reading("B", 10, "10"),
+	}
+
+	agg := plugin.AggregationModel{
+		TimeAttribute: "ts",
+		Interval:      "1m",
+		GroupBy:       []string{"station_id"},
+		Metrics: []plugin.AggregationMetric{
+			{Attribute: "level", Reducer: plugin.ReducerAvg},
+			{Attribute: "level", Reducer: plugin.ReducerLast},
+			{Reducer: plugin.ReducerCount},
+		},
+	}
+
+	t.Run("one frame per group", func(t *testing.T) {
+		frames, err := plugin.AggregateItems("A", items, agg, 0, map[string]string{"ts": plugin.UnixTimestampSeconds})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, len(frames), 2)
+
+		a := frames[0]
+		assertEqual(t, a.Rows(), 2)
+		assertEqual(t, a.Fields[1].Name, "avg(level)")
+		assertEqual(t, a.Fields[1].Labels["station_id"], "A")
+		assertEqual(t, getFieldValue[float64](t, a.Fields[1], 0), float64(2))
+		assertEqual(t, getFieldValue[float64](t, a.Fields[2], 0), float64(3))
+		assertEqual(t, getFieldValue[float64](t, a.Fields[3], 0), float64(2))
+		assertEqual(t, getFieldValue[float64](t, a.Fields[1], 1), float64(5))
+
+		b := frames[1]
+		assertEqual(t, b.Rows(), 1)
+		assertEqual(t, b.Fields[1].Labels["station_id"], "B")
+	})
+
+	t.Run("interval from query", func(t *testing.T) {
+		agg := agg
+		agg.Interval = "$__interval"
+		agg.GroupBy = nil
+		frames, err := plugin.AggregateItems("A", items, agg, 2*time.Minute, map[string]string{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, len(frames), 1)
+		assertEqual(t, frames[0].Rows(), 1)
+		assertEqual(t, getFieldValue[float64](t, frames[0].Fields[3], 0), float64(4))
+	})
+
+	t.Run("unsupported reducer", func(t *testing.T) {
+		agg := agg
+		agg.Metrics = []plugin.AggregationMetric{{Attribute: "level", Reducer: "median"}}
+		if _, err := plugin.AggregateItems("A", items, agg, 0, nil); err == nil {
+			t.Fatal("expected error for unsupported reducer")
+		}
+	})
+}
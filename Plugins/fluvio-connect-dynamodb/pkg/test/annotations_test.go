@@ -0,0 +1,77 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/fluvio/fluvio-connect-dynamodb/pkg/plugin"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func maintenanceEvent(start, end string, text string, tags ...string) map[string]*dynamodb.AttributeValue {
+	item := map[string]*dynamodb.AttributeValue{
+		"station_id": {S: aws.String("A")},
+		"started_at": {S: aws.String(start)},
+		"note":       {S: aws.String(text)},
+		"kinds":      {SS: aws.StringSlice(tags)},
+	}
+	if end != "" {
+		item["ended_at"] = &dynamodb.AttributeValue{S: aws.String(end)}
+	}
+	return item
+}
+
+func TestItemsToAnnotations(t *testing.T) {
+	items := []map[string]*dynamodb.AttributeValue{
+		maintenanceEvent("2024-05-01T12:00:00Z", "2024-05-01T14:00:00Z", "pump swap", "pump"),
+		maintenanceEvent("2024-05-01T09:00:00Z", "", "inspection", "visit", "visit"),
+		maintenanceEvent("2024-04-30T22:00:00Z", "2024-05-01T01:00:00Z", "overnight outage", "power"),
+		maintenanceEvent("2024-05-03T00:00:00Z", "", "outside", "visit"),
+		{"note": {S: aws.String("no time")}},
+	}
+	timeRange := backend.TimeRange{
+		From: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC),
+	}
+	model := plugin.AnnotationModel{
+		TimeAttribute:    "started_at",
+		TimeEndAttribute: "ended_at",
+		TextAttribute:    "note",
+		TagAttributes:    []string{"kinds", "station_id"},
+		Tags:             []string{"maintenance"},
+	}
+
+	frame, err := plugin.ItemsToAnnotations("A", items, model, timeRange, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertEqual(t, frame.Rows(), 3)
+	names := make([]string, 0, len(frame.Fields))
+	for _, f := range frame.Fields {
+		names = append(names, f.Name)
+	}
+	assertEqual(t, names, []string{"time", "timeEnd", "text", "tags"})
+
+	// The region that started before the range overlaps it and is kept
+	assertEqual(t, frame.Fields[0].At(0), time.Date(2024, 4, 30, 22, 0, 0, 0, time.UTC))
+	assertEqual(t, frame.Fields[2].At(0), "overnight outage")
+	assertEqual(t, frame.Fields[1].At(1), (*time.Time)(nil))
+	assertEqual(t, frame.Fields[2].At(2), "pump swap")
+	assertEqual(t, *frame.Fields[1].At(2).(*time.Time), time.Date(2024, 5, 1, 14, 0, 0, 0, time.UTC))
+
+	var tags []string
+	if err := json.Unmarshal(frame.Fields[3].At(1).(json.RawMessage), &tags); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, tags, []string{"maintenance", "visit", "A"})
+
+	t.Run("time attribute is required", func(t *testing.T) {
+		if _, err := plugin.ItemsToAnnotations("A", items, plugin.AnnotationModel{}, timeRange, nil); err == nil {
+			t.Fatal("expected error without time attribute")
+		}
+	})
+}
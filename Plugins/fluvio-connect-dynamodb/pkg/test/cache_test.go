@@ -0,0 +1,113 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/fluvio/fluvio-connect-dynamodb/pkg/plugin"
+)
+
+func cachedItems(ids ...string) []map[string]*dynamodb.AttributeValue {
+	items := make([]map[string]*dynamodb.AttributeValue, len(ids))
+	for i, id := range ids {
+		items[i] = map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}}
+	}
+	return items
+}
+
+func TestResultCache(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+
+	t.Run("disabled cache stores nothing", func(t *testing.T) {
+		cache := plugin.NewResultCache(plugin.CacheSettings{}, clock)
+		cache.Put("k", "readings", cachedItems("a"), time.Minute)
+		_, ok := cache.Get("k")
+		assertEqual(t, ok, false)
+		_, cacheable := cache.TTLFor(now, 0)
+		assertEqual(t, cacheable, false)
+	})
+
+	t.Run("entries expire after their TTL", func(t *testing.T) {
+		cache := plugin.NewResultCache(plugin.CacheSettings{Enabled: true}, clock)
+		ttl, ok := cache.TTLFor(now, 0)
+		assertEqual(t, ok, true)
+		assertEqual(t, ttl, 60*time.Second)
+
+		cache.Put("k", "readings", cachedItems("a", "b"), ttl)
+		items, ok := cache.Get("k")
+		assertEqual(t, ok, true)
+		assertEqual(t, len(items), 2)
+
+		now = now.Add(61 * time.Second)
+		_, ok = cache.Get("k")
+		assertEqual(t, ok, false)
+		assertEqual(t, cache.Len(), 0)
+	})
+
+	t.Run("query TTL overrides and bypasses", func(t *testing.T) {
+		cache := plugin.NewResultCache(plugin.CacheSettings{Enabled: true, TTLSeconds: 30}, clock)
+		ttl, ok := cache.TTLFor(now, 300)
+		assertEqual(t, ok, true)
+		assertEqual(t, ttl, 5*time.Minute)
+		_, ok = cache.TTLFor(now, -1)
+		assertEqual(t, ok, false)
+	})
+
+	t.Run("historic ranges are kept until evicted", func(t *testing.T) {
+		cache := plugin.NewResultCache(plugin.CacheSettings{Enabled: true, HistoricAfterHours: 24}, clock)
+		ttl, ok := cache.TTLFor(now.Add(-48*time.Hour), 0)
+		assertEqual(t, ok, true)
+		assertEqual(t, ttl, time.Duration(0))
+		ttl, _ = cache.TTLFor(now.Add(-time.Hour), 0)
+		assertEqual(t, ttl, 60*time.Second)
+
+		cache.Put("old", "readings", cachedItems("a"), 0)
+		now = now.Add(365 * 24 * time.Hour)
+		_, ok = cache.Get("old")
+		assertEqual(t, ok, true)
+	})
+
+	t.Run("least recently used entries are evicted first", func(t *testing.T) {
+		cache := plugin.NewResultCache(plugin.CacheSettings{Enabled: true, MaxEntries: 2}, clock)
+		cache.Put("a", "readings", cachedItems("a"), time.Minute)
+		cache.Put("b", "readings", cachedItems("b"), time.Minute)
+		_, _ = cache.Get("a")
+		cache.Put("c", "readings", cachedItems("c"), time.Minute)
+
+		assertEqual(t, cache.Len(), 2)
+		_, ok := cache.Get("b")
+		assertEqual(t, ok, false)
+		_, ok = cache.Get("a")
+		assertEqual(t, ok, true)
+		_, ok = cache.Get("c")
+		assertEqual(t, ok, true)
+	})
+
+	t.Run("writes invalidate only the written table", func(t *testing.T) {
+		cache := plugin.NewResultCache(plugin.CacheSettings{Enabled: true}, clock)
+		cache.Put("r1", "readings", cachedItems("a"), time.Minute)
+		cache.Put("r2", "readings", cachedItems("b"), time.Minute)
+		cache.Put("s1", "stations", cachedItems("c"), time.Minute)
+
+		assertEqual(t, cache.InvalidateTable("readings"), 2)
+		_, ok := cache.Get("r1")
+		assertEqual(t, ok, false)
+		_, ok = cache.Get("s1")
+		assertEqual(t, ok, true)
+	})
+
+	t.Run("callers cannot reorder cached results", func(t *testing.T) {
+		cache := plugin.NewResultCache(plugin.CacheSettings{Enabled: true}, clock)
+		items := cachedItems("a", "b")
+		cache.Put("k", "readings", items, time.Minute)
+		items[0], items[1] = items[1], items[0]
+
+		got, _ := cache.Get("k")
+		got[0] = nil
+		again, _ := cache.Get("k")
+		assertEqual(t, aws.StringValue(again[0]["id"].S), "a")
+	})
+}
@@ -384,3 +384,21 @@ func TestQueryResultToDataFrame(t *testing.T) {
 	})
 
 }
+
+func TestQueryResultToDataFrameFieldOrder(t *testing.T) {
+	output := &dynamodb.ExecuteStatementOutput{Items: []map[string]*dynamodb.AttributeValue{
+		{"z": {N: aws.String("1")}, "b": {N: aws.String("2")}, "m": {N: aws.String("3")}},
+		{"a": {N: aws.String("4")}, "z": {N: aws.String("5")}},
+	}}
+	for i := 0; i < 5; i++ {
+		frame, err := plugin.QueryResultToDataFrame("A", output, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := make([]string, 0, len(frame.Fields))
+		for _, f := range frame.Fields {
+			names = append(names, f.Name)
+		}
+		assertEqual(t, names, []string{"b", "m", "z", "a"})
+	}
+}
@@ -0,0 +1,87 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/fluvio/fluvio-connect-dynamodb/pkg/plugin"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestReshapeItems(t *testing.T) {
+	items := []map[string]*dynamodb.AttributeValue{
+		{
+			"station_id": {S: aws.String("A")},
+			"readings": {M: map[string]*dynamodb.AttributeValue{
+				"level": {N: aws.String("1.5")},
+				"meta":  {M: map[string]*dynamodb.AttributeValue{"unit": {S: aws.String("m")}}},
+			}},
+			"sensors": {L: []*dynamodb.AttributeValue{
+				{M: map[string]*dynamodb.AttributeValue{"temp": {N: aws.String("20")}}},
+			}},
+		},
+		{
+			"station_id": {S: aws.String("B")},
+			"readings":   {M: map[string]*dynamodb.AttributeValue{"level": {N: aws.String("2")}}},
+		},
+	}
+
+	t.Run("flatten maps to dotted columns", func(t *testing.T) {
+		out, err := plugin.ReshapeItems(items, &plugin.FlattenModel{MaxDepth: 1}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, *out[0]["readings.level"].N, "1.5")
+		assertEqual(t, out[0]["readings.meta"].M != nil, true)
+		assertEqual(t, out[0]["sensors"].L != nil, true)
+		assertEqual(t, out[0]["readings"], (*dynamodb.AttributeValue)(nil))
+
+		frame, err := plugin.QueryResultToDataFrame("A", &dynamodb.ExecuteStatementOutput{Items: out}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		field, _ := frame.FieldByName("readings.level")
+		assertEqual(t, field.Type(), data.FieldTypeNullableFloat64)
+		assertEqual(t, getFieldValue[float64](t, field, 1), float64(2))
+	})
+
+	t.Run("flatten arrays with indexes", func(t *testing.T) {
+		out, err := plugin.ReshapeItems(items, &plugin.FlattenModel{Arrays: true}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, *out[0]["sensors[0].temp"].N, "20")
+		assertEqual(t, *out[0]["readings.meta.unit"].S, "m")
+	})
+
+	t.Run("projections", func(t *testing.T) {
+		out, err := plugin.ReshapeItems(items, nil, []plugin.Projection{
+			{Path: "$.readings.level"},
+			{Path: "$['sensors'][0].temp", Alias: "temp"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, *out[0]["readings.level"].N, "1.5")
+		assertEqual(t, *out[0]["temp"].N, "20")
+		assertEqual(t, out[0]["readings"].M != nil, true)
+		assertEqual(t, out[1]["temp"], (*dynamodb.AttributeValue)(nil))
+
+		frame, err := plugin.QueryResultToDataFrame("A", &dynamodb.ExecuteStatementOutput{Items: out}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		field, _ := frame.FieldByName("temp")
+		assertEqual(t, field.Type(), data.FieldTypeNullableInt64)
+		assertEqual(t, field.At(1), (*int64)(nil))
+	})
+
+	t.Run("unsupported paths", func(t *testing.T) {
+		for _, path := range []string{"$.readings[*]", "$..level", "$", "$[0]"} {
+			if _, err := plugin.ReshapeItems(items, nil, []plugin.Projection{{Path: path}}); err == nil {
+				t.Fatalf("expected error for %q", path)
+			}
+		}
+	})
+}
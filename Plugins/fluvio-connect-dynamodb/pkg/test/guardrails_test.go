@@ -0,0 +1,45 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fluvio/fluvio-connect-dynamodb/pkg/plugin"
+)
+
+func TestEffectiveQueryLimits(t *testing.T) {
+	assertEqual(t, plugin.EffectiveQueryLimits(plugin.QueryLimits{}, nil), plugin.QueryLimits{
+		MaxPages: 1000, MaxItems: 1000000, MaxDurationSeconds: 60,
+	})
+
+	admin := plugin.QueryLimits{MaxPages: 50, MaxItems: 10000}
+	limits := plugin.EffectiveQueryLimits(admin, &plugin.QueryLimits{MaxPages: 500, MaxItems: 100, MaxDurationSeconds: 10})
+	assertEqual(t, limits, plugin.QueryLimits{MaxPages: 50, MaxItems: 100, MaxDurationSeconds: 10})
+
+	limits = plugin.EffectiveQueryLimits(admin, &plugin.QueryLimits{MaxDurationSeconds: 600})
+	assertEqual(t, limits.MaxDurationSeconds, int64(60))
+}
+
+func TestReadBudget(t *testing.T) {
+	assertEqual(t, plugin.NewReadBudget(plugin.ReadBudgetSettings{}, nil), (*plugin.ReadBudget)(nil))
+	var unlimited *plugin.ReadBudget
+	unlimited.Record(1, 100)
+	assertEqual(t, unlimited.Exhausted(1), false)
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	budget := plugin.NewReadBudget(plugin.ReadBudgetSettings{CapacityUnits: 100, WindowMinutes: 10}, func() time.Time { return now })
+
+	budget.Record(1, 60)
+	now = now.Add(5 * time.Minute)
+	budget.Record(1, 40)
+	assertEqual(t, budget.Exhausted(1), true)
+	assertEqual(t, budget.Remaining(2), float64(100))
+
+	// The first entry leaves the rolling window
+	now = now.Add(6 * time.Minute)
+	assertEqual(t, budget.Remaining(1), float64(60))
+	assertEqual(t, budget.Exhausted(1), false)
+
+	now = now.Add(10 * time.Minute)
+	assertEqual(t, budget.Remaining(1), float64(100))
+}
@@ -0,0 +1,193 @@
+package test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/fluvio/fluvio-connect-dynamodb/pkg/plugin"
+)
+
+func TestBuildItemWrite(t *testing.T) {
+	str := func(v string) *dynamodb.AttributeValue { return &dynamodb.AttributeValue{S: aws.String(v)} }
+	num := func(v string) *dynamodb.AttributeValue { return &dynamodb.AttributeValue{N: aws.String(v)} }
+	stationKey := plugin.AttributeMap{"PK": str("STATION#1"), "SK": str("META")}
+	versioned := plugin.ItemEditTable{Table: "stations", VersionAttribute: "version"}
+	unversioned := plugin.ItemEditTable{Table: "ratings"}
+	station := func(version string, name string) map[string]*dynamodb.AttributeValue {
+		item := map[string]*dynamodb.AttributeValue{
+			"PK":   {S: aws.String("STATION#1")},
+			"SK":   {S: aws.String("META")},
+			"name": {S: aws.String(name)},
+		}
+		if version != "" {
+			item["version"] = &dynamodb.AttributeValue{N: aws.String(version)}
+		}
+		return item
+	}
+
+	t.Run("edits must be guarded", func(t *testing.T) {
+		edit := plugin.ItemEdit{Table: "stations", Operation: plugin.ItemEditDelete, Key: stationKey}
+		_, err := plugin.BuildItemWrite(edit, versioned, "PK", "SK")
+		assertEqual(t, err != nil, true)
+		_, err = plugin.BuildItemWrite(edit, unversioned, "PK", "SK")
+		assertEqual(t, err != nil, true)
+	})
+
+	t.Run("versioned update", func(t *testing.T) {
+		edit := plugin.ItemEdit{
+			Table:           "stations",
+			Operation:       plugin.ItemEditUpdate,
+			Key:             stationKey,
+			Set:             plugin.AttributeMap{"name": str("North"), "elevation": num("120")},
+			Remove:          []string{"note"},
+			ExpectedVersion: aws.Int64(3),
+		}
+		write, err := plugin.BuildItemWrite(edit, versioned, "PK", "SK")
+		assertEqual(t, err, nil)
+		assertEqual(t, aws.StringValue(write.Update.ConditionExpression), "#v = :v")
+		assertEqual(t, aws.StringValue(write.Update.UpdateExpression), "SET #s0 = :s0, #s1 = :s1, #v = :nv REMOVE #r0")
+		assertEqual(t, aws.StringValue(write.Update.ExpressionAttributeValues[":nv"].N), "4")
+		assertEqual(t, aws.StringValue(write.Update.ReturnValuesOnConditionCheckFailure), dynamodb.ReturnValuesOnConditionCheckFailureAllOld)
+
+		assertEqual(t, write.Check(station("3", "South")), true)
+		conflicts := write.Conflicts(station("4", "South"))
+		assertEqual(t, conflicts, []plugin.ItemChange{{Attribute: "version", Before: num("3"), After: num("4")}})
+
+		after := write.After(station("3", "South"))
+		assertEqual(t, aws.StringValue(after["version"].N), "4")
+		changes := plugin.DiffItems(station("3", "South"), after)
+		assertEqual(t, changes, []plugin.ItemChange{
+			{Attribute: "elevation", Before: nil, After: num("120")},
+			{Attribute: "name", Before: str("South"), After: str("North")},
+			{Attribute: "version", Before: num("3"), After: num("4")},
+		})
+	})
+
+	t.Run("updates cannot change keys or the version", func(t *testing.T) {
+		edit := plugin.ItemEdit{
+			Table:           "stations",
+			Operation:       plugin.ItemEditUpdate,
+			Key:             stationKey,
+			Set:             plugin.AttributeMap{"version": num("9")},
+			ExpectedVersion: aws.Int64(3),
+		}
+		_, err := plugin.BuildItemWrite(edit, versioned, "PK", "SK")
+		assertEqual(t, err != nil, true)
+	})
+
+	t.Run("put of a new versioned item", func(t *testing.T) {
+		edit := plugin.ItemEdit{
+			Table:           "stations",
+			Operation:       plugin.ItemEditPut,
+			Item:            plugin.AttributeMap{"PK": str("STATION#2"), "SK": str("META"), "name": str("East")},
+			ExpectedVersion: aws.Int64(0),
+		}
+		write, err := plugin.BuildItemWrite(edit, versioned, "PK", "SK")
+		assertEqual(t, err, nil)
+		assertEqual(t, aws.StringValue(write.Put.ConditionExpression), "attribute_not_exists(#v)")
+		assertEqual(t, aws.StringValue(write.Put.Item["version"].N), "1")
+		assertEqual(t, write.Check(nil), true)
+	})
+
+	t.Run("expected prior image", func(t *testing.T) {
+		edit := plugin.ItemEdit{
+			Table:     "ratings",
+			Operation: plugin.ItemEditDelete,
+			Key:       stationKey,
+			Expected:  plugin.AttributeMap{"name": str("South"), "note": nil},
+		}
+		write, err := plugin.BuildItemWrite(edit, unversioned, "PK", "SK")
+		assertEqual(t, err, nil)
+		assertEqual(t, aws.StringValue(write.Delete.ConditionExpression), "attribute_exists(#pk) AND #e0 = :e0 AND attribute_not_exists(#e1)")
+		assertEqual(t, write.Check(station("", "South")), true)
+		assertEqual(t, write.Conflicts(station("", "West")), []plugin.ItemChange{{Attribute: "name", Before: str("South"), After: str("West")}})
+		assertEqual(t, write.Check(nil), false)
+		assertEqual(t, write.After(station("", "South")) == nil, true)
+	})
+
+	t.Run("empty expected image creates only", func(t *testing.T) {
+		edit := plugin.ItemEdit{
+			Table:     "ratings",
+			Operation: plugin.ItemEditPut,
+			Item:      plugin.AttributeMap{"PK": str("STATION#1"), "SK": str("META"), "name": str("South")},
+			Expected:  plugin.AttributeMap{},
+		}
+		write, err := plugin.BuildItemWrite(edit, unversioned, "PK", "SK")
+		assertEqual(t, err, nil)
+		assertEqual(t, aws.StringValue(write.Put.ConditionExpression), "attribute_not_exists(#pk)")
+		assertEqual(t, write.Check(nil), true)
+		assertEqual(t, write.Check(station("", "South")), false)
+	})
+
+	t.Run("typed values keep their stored types", func(t *testing.T) {
+		var edit plugin.ItemEdit
+		body := `{"table": "ratings", "operation": "update",
+			"key": {"PK": {"S": "STATION#1"}, "SK": {"S": "META"}},
+			"set": {"tags": {"SS": ["north", "river"]}, "serial": {"N": "123456789012345678901234567890"}, "logo": {"B": "AQID"}},
+			"expected": {"tags": {"SS": ["river", "north"]}, "serial": {"N": "123456789012345678901234567889"}}}`
+		if err := json.Unmarshal([]byte(body), &edit); err != nil {
+			t.Fatal(err)
+		}
+		write, err := plugin.BuildItemWrite(edit, unversioned, "PK", "SK")
+		assertEqual(t, err, nil)
+		values := write.Update.ExpressionAttributeValues
+		assertEqual(t, values[":s0"].B, []byte{1, 2, 3})
+		assertEqual(t, aws.StringValue(values[":s1"].N), "123456789012345678901234567890")
+		assertEqual(t, aws.StringValueSlice(values[":s2"].SS), []string{"north", "river"})
+		assertEqual(t, aws.StringValue(values[":e0"].N), "123456789012345678901234567889")
+
+		// Sets match in any order, and numbers beyond float64 precision are compared exactly
+		current := map[string]*dynamodb.AttributeValue{
+			"PK":     str("STATION#1"),
+			"SK":     str("META"),
+			"tags":   {SS: aws.StringSlice([]string{"north", "river"})},
+			"serial": num("123456789012345678901234567890"),
+		}
+		assertEqual(t, write.Conflicts(current), []plugin.ItemChange{{Attribute: "serial", Before: num("123456789012345678901234567889"), After: num("123456789012345678901234567890")}})
+		current["serial"] = num("1.23456789012345678901234567889E29")
+		assertEqual(t, write.Check(current), true)
+
+		encoded, err := json.Marshal(plugin.DiffItems(current, write.After(current)))
+		assertEqual(t, err, nil)
+		assertEqual(t, string(encoded), `[{"attribute":"logo","before":null,"after":{"B":"AQID"}},{"attribute":"serial","before":{"N":"1.23456789012345678901234567889E29"},"after":{"N":"123456789012345678901234567890"}}]`)
+	})
+
+	t.Run("plain JSON values are rejected", func(t *testing.T) {
+		var edit plugin.ItemEdit
+		err := json.Unmarshal([]byte(`{"table": "ratings", "operation": "delete", "key": {"PK": "STATION#1"}}`), &edit)
+		assertEqual(t, err != nil, true)
+	})
+}
+
+func TestAttributeMapJSON(t *testing.T) {
+	raw := `{"B":{"B":"AQID"},"BOOL":{"BOOL":true},"BS":{"BS":["AQ==","Ag=="]},"L":{"L":[{"S":"a"},{"NULL":true}]},"M":{"M":{"n":{"N":"1.50"}}},"N":{"N":"123456789012345678901234567890"},"NS":{"NS":["1","2.5"]},"S":{"S":"x"},"SS":{"SS":["a","b"]},"gone":null}`
+	var item plugin.AttributeMap
+	if err := json.Unmarshal([]byte(raw), &item); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, item["B"].B, []byte{1, 2, 3})
+	assertEqual(t, item["BS"].BS, [][]byte{{1}, {2}})
+	assertEqual(t, aws.StringValue(item["M"].M["n"].N), "1.50")
+	assertEqual(t, aws.BoolValue(item["L"].L[1].NULL), true)
+	assertEqual(t, aws.StringValueSlice(item["NS"].NS), []string{"1", "2.5"})
+	assertEqual(t, item["gone"] == nil, true)
+
+	encoded, err := json.Marshal(item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, string(encoded), raw)
+
+	for _, invalid := range []string{
+		`{"PK": "x"}`,
+		`{"PK": {"S": "x", "N": "1"}}`,
+		`{"PK": {"X": "x"}}`,
+		`{"PK": {"M": {"n": 1}}}`,
+		`{"PK": {"S": null}}`,
+	} {
+		err := json.Unmarshal([]byte(invalid), &item)
+		assertEqual(t, err != nil, true)
+	}
+}
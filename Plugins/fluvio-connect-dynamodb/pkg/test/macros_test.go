@@ -0,0 +1,85 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fluvio/fluvio-connect-dynamodb/pkg/plugin"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestInterpolateMacros(t *testing.T) {
+	timeRange := backend.TimeRange{
+		From: time.Unix(1730238174, 0),
+		To:   time.Unix(1730324262, 500000000),
+	}
+
+	t.Run("timeFilter epoch seconds", func(t *testing.T) {
+		q, err := plugin.InterpolateMacros(`SELECT * FROM test WHERE $__timeFilter(ts)`, timeRange, time.Minute, map[string]string{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, q, `SELECT * FROM test WHERE "ts" BETWEEN 1730238174 AND 1730324262`)
+	})
+
+	t.Run("timeFilter uses datetime attribute format", func(t *testing.T) {
+		q, err := plugin.InterpolateMacros(`SELECT * FROM test WHERE $__timeFilter('ts')`, timeRange, time.Minute, map[string]string{
+			"ts": plugin.UnixTimestampMiniseconds,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, q, `SELECT * FROM test WHERE "ts" BETWEEN 1730238174000 AND 1730324262500`)
+	})
+
+	t.Run("timeFilter custom layout", func(t *testing.T) {
+		q, err := plugin.InterpolateMacros(`SELECT * FROM test WHERE $__timeFilter(ts)`, timeRange, time.Minute, map[string]string{
+			"ts": "2006-01-02T15:04:05Z07:00",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, q, `SELECT * FROM test WHERE "ts" BETWEEN '2024-10-29T21:42:54Z' AND '2024-10-30T21:37:42Z'`)
+	})
+
+	t.Run("timeFrom timeTo and interval", func(t *testing.T) {
+		q, err := plugin.InterpolateMacros(`$__timeFrom() $__timeTo(ms) $__timeFrom(iso) $__interval $__interval_ms $from $to`, timeRange, 30*time.Second, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, q, `1730238174 1730324262500 '2024-10-29T21:42:54Z' 30s 30000 1730238174 1730324262`)
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		_, err := plugin.InterpolateMacros(`$__timeFilter(ts, bogus)`, timeRange, time.Minute, nil)
+		if err == nil {
+			t.Fatal("expected error for unsupported format")
+		}
+	})
+}
+
+func TestDayjsLayout(t *testing.T) {
+	for _, tc := range []struct {
+		format, layout, value string
+		unixMilli             int64
+	}{
+		{"YYYY-MM-DDTHH:mm:ssZ", "2006-01-02T15:04:05-07:00", "2024-10-31T22:04:29+01:00", 1730408669000},
+		{"YYYY-MM-DDTHH:mm:ss.SSS[Z]", "2006-01-02T15:04:05.000Z", "2024-10-31T21:04:29.123Z", 1730408669123},
+		{"ddd, DD MMM YYYY HH:mm:ss z", "Mon, 02 Jan 2006 15:04:05 MST", "Thu, 31 Oct 2024 21:04:29 GMT", 1730408669000},
+		{"2006-01-02T15:04:05Z07:00", "2006-01-02T15:04:05Z07:00", "2024-10-31T21:04:29Z", 1730408669000},
+	} {
+		layout, err := plugin.DayjsLayout(tc.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, layout, tc.layout)
+		parsed, err := time.Parse(layout, tc.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, parsed.UnixMilli(), tc.unixMilli)
+	}
+
+	_, err := plugin.DayjsLayout("Do MMMM YYYY")
+	assertEqual(t, err != nil, true)
+}
@@ -0,0 +1,150 @@
+package test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/fluvio/fluvio-connect-dynamodb/pkg/plugin"
+)
+
+func TestBuildNativeRequest(t *testing.T) {
+	t.Run("partition and sort key build a query", func(t *testing.T) {
+		request, err := plugin.BuildNativeRequest(plugin.NativeQueryModel{
+			Table:        "readings",
+			Index:        "byStation",
+			PartitionKey: &plugin.NativeCondition{Attribute: "station_id", Values: []string{"st-1"}},
+			SortKey:      &plugin.NativeCondition{Attribute: "ts", Operator: "between", Values: []string{"10", "20"}, Type: "N"},
+			Filters: []plugin.NativeCondition{
+				{Attribute: "level", Operator: ">=", Values: []string{"2.5"}, Type: "N"},
+				{Attribute: "status", Operator: "in", Values: []string{"ok", "warn"}},
+				{Attribute: "note", Operator: "not_exists"},
+			},
+			Projection: []string{"station_id", "ts", "level"},
+		}, aws.Bool(false), 50)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if request.Scan != nil || request.Query == nil {
+			t.Fatalf("expected a query, got %+v", request)
+		}
+		q := request.Query
+		assertEqual(t, aws.StringValue(q.TableName), "readings")
+		assertEqual(t, aws.StringValue(q.IndexName), "byStation")
+		assertEqual(t, aws.StringValue(q.KeyConditionExpression), "#n0 = :v0 AND #n1 BETWEEN :v1 AND :v2")
+		assertEqual(t, aws.StringValue(q.FilterExpression), "#n2 >= :v3 AND #n3 IN (:v4, :v5) AND attribute_not_exists(#n4)")
+		assertEqual(t, aws.StringValue(q.ProjectionExpression), "#n0, #n1, #n2")
+		assertEqual(t, aws.StringValue(q.ExpressionAttributeNames["#n3"]), "status")
+		assertEqual(t, aws.StringValue(q.ExpressionAttributeValues[":v1"].N), "10")
+		assertEqual(t, aws.StringValue(q.ExpressionAttributeValues[":v4"].S), "ok")
+		assertEqual(t, aws.BoolValue(q.ScanIndexForward), false)
+		assertEqual(t, aws.Int64Value(q.Limit), int64(50))
+	})
+
+	t.Run("no partition key builds a scan", func(t *testing.T) {
+		request, err := plugin.BuildNativeRequest(plugin.NativeQueryModel{
+			Table:             "readings",
+			Filters:           []plugin.NativeCondition{{Attribute: "name", Operator: "begins_with", Values: []string{"north"}}},
+			ExclusiveStartKey: plugin.AttributeMap{"PK": {S: aws.String("STATION#1")}, "ts": {N: aws.String("15")}},
+		}, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if request.Scan == nil || request.Query != nil {
+			t.Fatalf("expected a scan, got %+v", request)
+		}
+		s := request.Scan
+		assertEqual(t, aws.StringValue(s.FilterExpression), "begins_with(#n0, :v0)")
+		assertEqual(t, s.Limit == nil, true)
+		assertEqual(t, s.ProjectionExpression == nil, true)
+		assertEqual(t, aws.StringValue(s.ExclusiveStartKey["PK"].S), "STATION#1")
+		assertEqual(t, aws.StringValue(s.ExclusiveStartKey["ts"].N), "15")
+	})
+
+	t.Run("exclusive start key keeps DynamoDB JSON types", func(t *testing.T) {
+		var model plugin.NativeQueryModel
+		raw := `{"table": "blobs", "exclusiveStartKey": {"PK": {"B": "AQID"}, "seq": {"N": "12345678901234567890"}}}`
+		if err := json.Unmarshal([]byte(raw), &model); err != nil {
+			t.Fatal(err)
+		}
+		request, err := plugin.BuildNativeRequest(model, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := request.Scan.ExclusiveStartKey
+		assertEqual(t, key["PK"].B, []byte{1, 2, 3})
+		assertEqual(t, aws.StringValue(key["seq"].N), "12345678901234567890")
+
+		encoded, err := json.Marshal(plugin.AttributeMap(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, string(encoded), `{"PK":{"B":"AQID"},"seq":{"N":"12345678901234567890"}}`)
+
+		err = json.Unmarshal([]byte(`{"table": "blobs", "exclusiveStartKey": {"PK": "STATION#1"}}`), &model)
+		assertEqual(t, err != nil, true)
+	})
+
+	t.Run("attributes reused across conditions share a placeholder", func(t *testing.T) {
+		request, err := plugin.BuildNativeRequest(plugin.NativeQueryModel{
+			Table:        "readings",
+			PartitionKey: &plugin.NativeCondition{Attribute: "PK", Values: []string{"a"}},
+			Filters: []plugin.NativeCondition{
+				{Attribute: "level", Operator: ">", Values: []string{"1"}, Type: "N"},
+				{Attribute: "level", Operator: "<", Values: []string{"5"}, Type: "N"},
+			},
+		}, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, aws.StringValue(request.Query.FilterExpression), "#n1 > :v1 AND #n1 < :v2")
+		assertEqual(t, len(request.Query.ExpressionAttributeNames), 2)
+		assertEqual(t, aws.BoolValue(request.Query.ScanIndexForward), true)
+	})
+
+	for name, tc := range map[string]struct {
+		model plugin.NativeQueryModel
+		want  string
+	}{
+		"missing table": {
+			model: plugin.NativeQueryModel{},
+			want:  "native.table is required",
+		},
+		"sort key without partition key": {
+			model: plugin.NativeQueryModel{Table: "t", SortKey: &plugin.NativeCondition{Attribute: "ts", Values: []string{"1"}}},
+			want:  "requires a partitionKey",
+		},
+		"partition key range": {
+			model: plugin.NativeQueryModel{Table: "t", PartitionKey: &plugin.NativeCondition{Attribute: "PK", Operator: ">", Values: []string{"1"}}},
+			want:  "only supports =",
+		},
+		"sort key contains": {
+			model: plugin.NativeQueryModel{
+				Table:        "t",
+				PartitionKey: &plugin.NativeCondition{Attribute: "PK", Values: []string{"a"}},
+				SortKey:      &plugin.NativeCondition{Attribute: "ts", Operator: "contains", Values: []string{"1"}},
+			},
+			want: `does not support "contains"`,
+		},
+		"between with one value": {
+			model: plugin.NativeQueryModel{Table: "t", Filters: []plugin.NativeCondition{{Attribute: "ts", Operator: "between", Values: []string{"1"}}}},
+			want:  "needs 2 value(s), got 1",
+		},
+		"invalid number": {
+			model: plugin.NativeQueryModel{Table: "t", Filters: []plugin.NativeCondition{{Attribute: "ts", Values: []string{"soon"}, Type: "N"}}},
+			want:  `"soon" is not a number`,
+		},
+		"unknown operator": {
+			model: plugin.NativeQueryModel{Table: "t", Filters: []plugin.NativeCondition{{Attribute: "ts", Operator: "like", Values: []string{"1"}}}},
+			want:  `unsupported operator "like"`,
+		},
+	} {
+		t.Run(name+" is rejected", func(t *testing.T) {
+			_, err := plugin.BuildNativeRequest(tc.model, nil, 0)
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("expected error containing %q, got %v", tc.want, err)
+			}
+		})
+	}
+}
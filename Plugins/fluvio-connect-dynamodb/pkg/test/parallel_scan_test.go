@@ -0,0 +1,49 @@
+package test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/fluvio/fluvio-connect-dynamodb/pkg/plugin"
+)
+
+func TestParseFullTableRead(t *testing.T) {
+	t.Run("star from a quoted table", func(t *testing.T) {
+		read, ok := plugin.ParseFullTableRead(`SELECT * FROM "readings"`)
+		assertEqual(t, ok, true)
+		assertEqual(t, read.Table, "readings")
+		assertEqual(t, read.Index, "")
+		assertEqual(t, len(read.Attributes), 0)
+	})
+
+	t.Run("attribute list from an index", func(t *testing.T) {
+		read, ok := plugin.ParseFullTableRead("select station, \"level\"\nfrom \"readings\".\"byStation\";")
+		assertEqual(t, ok, true)
+		assertEqual(t, read.Table, "readings")
+		assertEqual(t, read.Index, "byStation")
+		if !reflect.DeepEqual(read.Attributes, []string{"station", "level"}) {
+			t.Errorf("attributes = %v", read.Attributes)
+		}
+	})
+
+	t.Run("unquoted table", func(t *testing.T) {
+		read, ok := plugin.ParseFullTableRead(`SELECT * FROM readings-2024`)
+		assertEqual(t, ok, true)
+		assertEqual(t, read.Table, "readings-2024")
+	})
+
+	for name, statement := range map[string]string{
+		"where clause":     `SELECT * FROM "readings" WHERE PK = 'a'`,
+		"order by":         `SELECT * FROM "readings" ORDER BY ts`,
+		"nested path":      `SELECT info.level FROM "readings"`,
+		"function":         `SELECT size(tags) FROM "readings"`,
+		"not a select":     `DELETE FROM "readings"`,
+		"macro left over":  `SELECT * FROM "readings" WHERE $__timeFilter(ts)`,
+		"existence filter": `EXISTS(SELECT * FROM "readings")`,
+	} {
+		t.Run(name+" is not a full-table read", func(t *testing.T) {
+			_, ok := plugin.ParseFullTableRead(statement)
+			assertEqual(t, ok, false)
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fluvio/fluvio-connect-dynamodb/pkg/plugin"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestPresetStore(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv("GF_PATHS_DATA", dataDir)
+	ds := plugin.CreateTestDatasource(context.Background())
+
+	admin := &backend.User{Login: "admin", Role: "Admin"}
+	call := func(orgID int64, uid string, method string, path string, body string) (int, map[string]interface{}) {
+		status, raw := sendResourceRequest(t, ds, &backend.CallResourceRequest{
+			PluginContext: backend.PluginContext{
+				OrgID:                      orgID,
+				User:                       admin,
+				DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: uid, JSONData: []byte(`{}`)},
+			},
+			Path:   path,
+			Method: method,
+			URL:    path,
+			Body:   []byte(body),
+		})
+		var resp map[string]interface{}
+		if err := json.Unmarshal(raw, &resp); err != nil {
+			t.Fatalf("%s %s: %v: %s", method, path, err, raw)
+		}
+		return status, resp
+	}
+
+	t.Run("saves are versioned with optimistic concurrency", func(t *testing.T) {
+		status, resp := call(1, "ds-a", http.MethodPost, "presets", `{"id": "readings", "name": "Readings", "table": "readings"}`)
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, resp["version"], float64(1))
+
+		// Without a version an existing preset is not overwritten
+		status, _ = call(1, "ds-a", http.MethodPost, "presets", `{"id": "readings", "name": "Other", "table": "readings"}`)
+		assertEqual(t, status, http.StatusConflict)
+
+		status, resp = call(1, "ds-a", http.MethodPost, "presets", `{"id": "readings", "name": "Readings v2", "table": "readings", "version": 1}`)
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, resp["version"], float64(2))
+
+		status, _ = call(1, "ds-a", http.MethodPost, "presets", `{"id": "readings", "name": "Stale", "table": "readings", "version": 1}`)
+		assertEqual(t, status, http.StatusConflict)
+
+		status, resp = call(1, "ds-a", http.MethodGet, "presets/readings", "")
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, resp["name"], "Readings v2")
+		assertEqual(t, resp["version"], float64(2))
+		assertEqual(t, resp["updatedBy"], "admin")
+	})
+
+	t.Run("presets are scoped by org and datasource", func(t *testing.T) {
+		status, _ := call(1, "ds-b", http.MethodGet, "presets/readings", "")
+		assertEqual(t, status, http.StatusNotFound)
+		status, _ = call(2, "ds-a", http.MethodGet, "presets/readings", "")
+		assertEqual(t, status, http.StatusNotFound)
+
+		_, resp := call(1, "ds-b", http.MethodGet, "presets", "")
+		assertEqual(t, resp["presets"], []interface{}{})
+	})
+
+	t.Run("history, diff and rollback", func(t *testing.T) {
+		status, resp := call(1, "ds-a", http.MethodGet, "presets/readings/versions", "")
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, len(resp["versions"].([]interface{})), 2)
+
+		status, resp = call(1, "ds-a", http.MethodGet, "presets/readings/diff", "")
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, resp["changes"], []interface{}{
+			map[string]interface{}{"path": "name", "from": "Readings", "to": "Readings v2"},
+		})
+
+		status, _ = call(1, "ds-a", http.MethodPost, "presets/readings/rollback", `{"toVersion": 1, "version": 1}`)
+		assertEqual(t, status, http.StatusConflict)
+
+		status, resp = call(1, "ds-a", http.MethodPost, "presets/readings/rollback", `{"toVersion": 1, "version": 2}`)
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, resp["version"], float64(3))
+
+		_, resp = call(1, "ds-a", http.MethodGet, "presets/readings", "")
+		assertEqual(t, resp["name"], "Readings")
+		assertEqual(t, resp["version"], float64(3))
+
+		_, resp = call(1, "ds-a", http.MethodGet, "presets/readings/versions/2", "")
+		assertEqual(t, resp["preset"].(map[string]interface{})["name"], "Readings v2")
+	})
+
+	t.Run("deletion keeps the history", func(t *testing.T) {
+		status, _ := call(1, "ds-a", http.MethodDelete, "presets/readings", "")
+		assertEqual(t, status, http.StatusOK)
+
+		status, _ = call(1, "ds-a", http.MethodGet, "presets/readings", "")
+		assertEqual(t, status, http.StatusNotFound)
+
+		_, resp := call(1, "ds-a", http.MethodGet, "presets/readings/versions", "")
+		assertEqual(t, len(resp["versions"].([]interface{})), 4)
+
+		status, resp = call(1, "ds-a", http.MethodPost, "presets", `{"id": "readings", "name": "Readings", "table": "readings"}`)
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, resp["version"], float64(5))
+	})
+
+	t.Run("invalid IDs are rejected", func(t *testing.T) {
+		status, _ := call(1, "ds-a", http.MethodPost, "presets", `{"id": "../escape", "name": "X", "table": "t"}`)
+		assertEqual(t, status, http.StatusBadRequest)
+		status, _ = call(1, "ds-a", http.MethodGet, "presets/..%2Fescape", "")
+		assertEqual(t, status, http.StatusNotFound)
+	})
+
+	t.Run("unknown transformations are rejected", func(t *testing.T) {
+		status, resp := call(1, "ds-a", http.MethodPost, "presets", `{"id": "notes", "name": "Notes", "table": "notes",
+			"schema": [{"name": "note", "type": "string", "transformation": {"type": "custom"}}]}`)
+		assertEqual(t, status, http.StatusBadRequest)
+		assertEqual(t, resp["error"], "field 'note': unknown transformation type 'custom'")
+	})
+
+	t.Run("legacy preset files are imported into one datasource", func(t *testing.T) {
+		legacy := `{"id": "legacy", "name": "Legacy", "table": "readings"}`
+		if err := os.WriteFile(filepath.Join(dataDir, "dynamodb-presets", "legacy.json"), []byte(legacy), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		status, _ := call(3, "ds-c", http.MethodGet, "presets/legacy", "")
+		assertEqual(t, status, http.StatusNotFound)
+
+		status, resp := call(3, "ds-c", http.MethodPost, "presets/_legacy/import", "")
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, resp["imported"], []interface{}{"legacy"})
+		assertEqual(t, resp["rejected"], map[string]interface{}{})
+
+		_, resp = call(3, "ds-c", http.MethodGet, "presets/legacy", "")
+		assertEqual(t, resp["name"], "Legacy")
+		assertEqual(t, resp["version"], float64(1))
+		assertEqual(t, resp["updatedBy"], "admin")
+
+		// The file was consumed, so other datasources do not receive a copy
+		_, resp = call(3, "ds-d", http.MethodPost, "presets/_legacy/import", "")
+		assertEqual(t, resp["imported"], []interface{}{})
+		status, _ = call(3, "ds-d", http.MethodGet, "presets/legacy", "")
+		assertEqual(t, status, http.StatusNotFound)
+		// Presets with unknown transformations are left in place
+		custom := `{"id": "custom", "name": "Custom", "table": "readings", "schema": [{"name": "note", "transformation": {"type": "custom"}}]}`
+		if err := os.WriteFile(filepath.Join(dataDir, "dynamodb-presets", "custom.json"), []byte(custom), 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, resp = call(3, "ds-d", http.MethodPost, "presets/_legacy/import", "")
+		assertEqual(t, resp["imported"], []interface{}{})
+		assertEqual(t, resp["rejected"], map[string]interface{}{"custom": `field "note": unknown transformation type "custom"`})
+		_, err := os.Stat(filepath.Join(dataDir, "dynamodb-presets", "custom.json"))
+		assertEqual(t, err, nil)
+	})
+}
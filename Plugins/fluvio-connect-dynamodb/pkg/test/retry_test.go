@@ -0,0 +1,103 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/fluvio/fluvio-connect-dynamodb/pkg/plugin"
+)
+
+func TestCallWithRetry(t *testing.T) {
+	settings := plugin.RetrySettings{MaxAttempts: 4, BaseDelayMs: 1, MaxDelayMs: 2}
+	throttle := awserr.New(dynamodb.ErrCodeProvisionedThroughputExceededException, "throttled", nil)
+
+	failing := func(failures int, err error) (*int, func() (string, error)) {
+		calls := 0
+		return &calls, func() (string, error) {
+			calls++
+			if calls <= failures {
+				return "", err
+			}
+			return "ok", nil
+		}
+	}
+
+	t.Run("throttles are retried", func(t *testing.T) {
+		stats := &plugin.RetryStats{}
+		calls, call := failing(2, throttle)
+		result, err := plugin.CallWithRetry(context.Background(), settings, stats, call)
+		assertEqual(t, err, nil)
+		assertEqual(t, result, "ok")
+		assertEqual(t, *calls, 3)
+		throttleEvents, retries := stats.Snapshot()
+		assertEqual(t, throttleEvents, int64(2))
+		assertEqual(t, retries, int64(2))
+	})
+
+	t.Run("non-retryable errors fail at once", func(t *testing.T) {
+		stats := &plugin.RetryStats{}
+		calls, call := failing(1, awserr.New("ValidationException", "bad statement", nil))
+		_, err := plugin.CallWithRetry(context.Background(), settings, stats, call)
+		assertEqual(t, err != nil, true)
+		assertEqual(t, *calls, 1)
+		_, retries := stats.Snapshot()
+		assertEqual(t, retries, int64(0))
+	})
+
+	t.Run("attempts are capped", func(t *testing.T) {
+		calls, call := failing(10, throttle)
+		_, err := plugin.CallWithRetry(context.Background(), settings, nil, call)
+		assertEqual(t, errors.Is(err, throttle), true)
+		assertEqual(t, *calls, 4)
+	})
+
+	t.Run("a cancelled context stops retrying", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		calls, call := failing(10, throttle)
+		_, err := plugin.CallWithRetry(ctx, plugin.RetrySettings{BaseDelayMs: 1000, MaxDelayMs: 1000}, nil, call)
+		assertEqual(t, errors.Is(err, context.Canceled), true)
+		assertEqual(t, *calls, 1)
+	})
+}
+
+func TestRetryBackoff(t *testing.T) {
+	settings := plugin.RetrySettings{BaseDelayMs: 10, MaxDelayMs: 100}
+	for retry, ceiling := range map[int]time.Duration{1: 10, 2: 20, 3: 40, 4: 80, 5: 100, 12: 100} {
+		for i := 0; i < 200; i++ {
+			delay := settings.Backoff(retry)
+			if delay < 0 || delay > ceiling*time.Millisecond {
+				t.Fatalf("retry %d: delay %v outside [0, %v]", retry, delay, ceiling*time.Millisecond)
+			}
+		}
+	}
+}
+
+func TestCapacityLimiter(t *testing.T) {
+	unlimited := plugin.NewCapacityLimiter(0)
+	assertEqual(t, unlimited == nil, true)
+	assertEqual(t, unlimited.Wait(context.Background(), 1000), nil)
+	unlimited.Settle(1, 1000)
+
+	limiter := plugin.NewCapacityLimiter(10)
+	ctx := context.Background()
+	start := time.Now()
+
+	// The bucket starts full, and settling a smaller consumption refunds the difference.
+	assertEqual(t, limiter.Wait(ctx, 10), nil)
+	limiter.Settle(10, 2)
+	assertEqual(t, limiter.Wait(ctx, 5), nil)
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("reserved capacity waited %v", elapsed)
+	}
+
+	// Consuming more than reserved puts the bucket into debt, which delays the next request.
+	limiter.Settle(5, 15)
+	timeout, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	assertEqual(t, errors.Is(limiter.Wait(timeout, 1), context.DeadlineExceeded), true)
+}
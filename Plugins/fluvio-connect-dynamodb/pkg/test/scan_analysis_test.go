@@ -0,0 +1,71 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/fluvio/fluvio-connect-dynamodb/pkg/plugin"
+)
+
+func TestAnalyzeStatement(t *testing.T) {
+	readings := plugin.TableMetadata{
+		Name:         "readings",
+		PartitionKey: "PK",
+		SortKey:      "ts",
+		ItemCount:    200000000,
+		SizeBytes:    50 * 1024 * 1024 * 1024,
+		Indexes: []plugin.IndexMetadata{
+			{Name: "byStation", Global: true, PartitionKey: "station_id", SortKey: "ts", Projection: "ALL", SizeBytes: 1024},
+			{Name: "byStatus", Global: true, PartitionKey: "status", Projection: "KEYS_ONLY"},
+		},
+	}
+
+	t.Run("partition key equality is a query", func(t *testing.T) {
+		a := plugin.AnalyzeStatement(`SELECT * FROM "readings" WHERE "PK" = 'STATION#1' AND ts > 10`, readings)
+		assertEqual(t, a.Access, plugin.AccessQuery)
+		assertEqual(t, a.EstimatedReadUnits, float64(0))
+	})
+
+	t.Run("partition key IN is a query", func(t *testing.T) {
+		a := plugin.AnalyzeStatement(`SELECT * FROM readings WHERE PK IN ['a', 'b']`, readings)
+		assertEqual(t, a.Access, plugin.AccessQuery)
+	})
+
+	t.Run("missing WHERE clause scans the table", func(t *testing.T) {
+		a := plugin.AnalyzeStatement(`SELECT * FROM readings`, readings)
+		assertEqual(t, a.Access, plugin.AccessScan)
+		assertEqual(t, a.Reason, "statement has no WHERE clause")
+		assertEqual(t, a.EstimatedReadUnits, float64(6553600))
+		assertEqual(t, a.SuggestedIndex, "")
+	})
+
+	t.Run("matching index is suggested", func(t *testing.T) {
+		a := plugin.AnalyzeStatement(`SELECT * FROM "readings" WHERE station_id = 'st-1' AND ts BETWEEN 1 AND 2`, readings)
+		assertEqual(t, a.Access, plugin.AccessScan)
+		assertEqual(t, a.SuggestedIndex, "byStation")
+		assertEqual(t, a.SuggestedQuery, `SELECT * FROM "readings"."byStation" WHERE station_id = 'st-1' AND ts BETWEEN 1 AND 2`)
+
+		rewritten := plugin.AnalyzeStatement(a.SuggestedQuery, readings)
+		assertEqual(t, rewritten.Access, plugin.AccessQuery)
+		assertEqual(t, rewritten.Index, "byStation")
+	})
+
+	t.Run("index without the sort key condition is only suggested", func(t *testing.T) {
+		a := plugin.AnalyzeStatement(`SELECT * FROM readings WHERE station_id = 'st-1'`, readings)
+		assertEqual(t, a.SuggestedIndex, "byStation")
+		assertEqual(t, a.SuggestedQuery, "")
+	})
+
+	t.Run("partial projection is not rewritten", func(t *testing.T) {
+		a := plugin.AnalyzeStatement(`SELECT * FROM readings WHERE status = 'fault'`, readings)
+		assertEqual(t, a.SuggestedIndex, "byStatus")
+		assertEqual(t, a.SuggestedQuery, "")
+	})
+
+	t.Run("scan of an index reports its size", func(t *testing.T) {
+		a := plugin.AnalyzeStatement(`SELECT * FROM "readings"."byStation" WHERE ts > 5`, readings)
+		assertEqual(t, a.Access, plugin.AccessScan)
+		assertEqual(t, a.Index, "byStation")
+		assertEqual(t, a.SizeBytes, int64(1024))
+		assertEqual(t, a.EstimatedReadUnits, 0.5)
+	})
+}
@@ -0,0 +1,98 @@
+package test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/fluvio/fluvio-connect-dynamodb/pkg/plugin"
+)
+
+func TestBuildTableSchema(t *testing.T) {
+	sampledAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	meta := plugin.TableMetadata{
+		Name:         "readings",
+		PartitionKey: "device",
+		SortKey:      "ts",
+		ItemCount:    1000,
+		Indexes:      []plugin.IndexMetadata{{Name: "by-site", PartitionKey: "site"}},
+		KeyTypes:     map[string]string{"device": "S", "ts": "N", "site": "S"},
+	}
+	items := []map[string]*dynamodb.AttributeValue{
+		{"device": {S: aws.String("a")}, "ts": {N: aws.String("9")}, "temp": {N: aws.String("21.5")}},
+		{"device": {S: aws.String("b")}, "ts": {N: aws.String("10")}, "temp": {NULL: aws.Bool(true)}},
+		{"device": {S: aws.String("a")}, "ts": {N: aws.String("11")}, "temp": {S: aws.String("n/a")}, "tags": {SS: []*string{aws.String("x")}}},
+		{"device": {S: aws.String("c")}, "ts": {N: aws.String("12")}, "temp": {N: aws.String("-3")}},
+	}
+
+	schema := plugin.BuildTableSchema(meta, items, sampledAt)
+	assertEqual(t, schema.SampledItems, 4)
+	assertEqual(t, schema.SampledAt, sampledAt)
+
+	names := make([]string, len(schema.Attributes))
+	byName := map[string]plugin.AttributeSchema{}
+	for i, attribute := range schema.Attributes {
+		names[i] = attribute.Name
+		byName[attribute.Name] = attribute
+	}
+	assertEqual(t, names, []string{"device", "ts", "site", "tags", "temp"})
+
+	t.Run("keys", func(t *testing.T) {
+		assertEqual(t, byName["device"].Key, "partition")
+		assertEqual(t, byName["ts"].Key, "sort")
+		// Index keys missing from the sample keep their declared type
+		assertEqual(t, byName["site"].Key, "index")
+		assertEqual(t, byName["site"].Type, "S")
+		assertEqual(t, byName["site"].NullRatio, 1.0)
+	})
+
+	t.Run("numeric min and max", func(t *testing.T) {
+		ts := byName["ts"]
+		assertEqual(t, ts.Type, "N")
+		assertEqual(t, ts.Min, "9")
+		assertEqual(t, ts.Max, "12")
+		assertEqual(t, ts.NullRatio, 0.0)
+	})
+
+	t.Run("mixed types and nulls", func(t *testing.T) {
+		temp := byName["temp"]
+		assertEqual(t, temp.Type, "N")
+		assertEqual(t, temp.Types, map[string]int{"N": 2, "NULL": 1, "S": 1})
+		assertEqual(t, temp.NullRatio, 0.25)
+		assertEqual(t, temp.Min, "-3")
+		assertEqual(t, temp.Max, "21.5")
+		assertEqual(t, byName["tags"].NullRatio, 0.75)
+	})
+
+	t.Run("distinct examples", func(t *testing.T) {
+		assertEqual(t, byName["device"].Examples, []string{"a", "b", "c"})
+	})
+}
+
+func TestSchemaCatalog(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	clock := func() time.Time { return now }
+	path := filepath.Join(t.TempDir(), "catalog.json")
+
+	assertEqual(t, plugin.NewSchemaCatalog(plugin.SchemaCatalogSettings{Disabled: true}, path, clock) == nil, true)
+
+	catalog := plugin.NewSchemaCatalog(plugin.SchemaCatalogSettings{RefreshMinutes: 10}, path, clock)
+	catalog.Put(plugin.BuildTableSchema(plugin.TableMetadata{Name: "readings", PartitionKey: "device"}, nil, now))
+
+	_, ok, stale := catalog.Get("readings")
+	assertEqual(t, ok, true)
+	assertEqual(t, stale, false)
+
+	now = now.Add(10 * time.Minute)
+	_, _, stale = catalog.Get("readings")
+	assertEqual(t, stale, true)
+
+	// A new instance loads the persisted schemas
+	reloaded := plugin.NewSchemaCatalog(plugin.SchemaCatalogSettings{}, path, clock)
+	tables := reloaded.Tables()
+	assertEqual(t, len(tables), 1)
+	assertEqual(t, tables[0].Table, "readings")
+	assertEqual(t, tables[0].PartitionKey, "device")
+}
@@ -0,0 +1,203 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodbstreams"
+	"github.com/fluvio/fluvio-connect-dynamodb/pkg/plugin"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func streamRecord(event string, at time.Time, image map[string]*dynamodb.AttributeValue) *dynamodbstreams.Record {
+	return &dynamodbstreams.Record{
+		EventName: aws.String(event),
+		Dynamodb: &dynamodbstreams.StreamRecord{
+			ApproximateCreationDateTime: aws.Time(at),
+			NewImage:                    image,
+		},
+	}
+}
+
+func TestStreamRecordsToFrame(t *testing.T) {
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	records := []*dynamodbstreams.Record{
+		streamRecord("INSERT", at, map[string]*dynamodb.AttributeValue{
+			"station_id": {S: aws.String("A")},
+			"ts":         {N: aws.String("1714564800")},
+			"readings":   {M: map[string]*dynamodb.AttributeValue{"level": {N: aws.String("1.5")}}},
+		}),
+		{
+			EventName: aws.String("REMOVE"),
+			Dynamodb: &dynamodbstreams.StreamRecord{
+				ApproximateCreationDateTime: aws.Time(at),
+				Keys:                        map[string]*dynamodb.AttributeValue{"station_id": {S: aws.String("B")}},
+			},
+		},
+	}
+	m := plugin.StreamQueryModel{
+		Table:              "readings",
+		DatetimeAttributes: []plugin.DatetimeAttribute{{Name: "ts", Format: plugin.UnixTimestampSeconds}},
+		Flatten:            &plugin.FlattenModel{},
+	}
+
+	frame, err := plugin.StreamRecordsToFrame("readings", records, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, frame.Rows(), 1)
+	level, _ := frame.FieldByName("readings.level")
+	assertEqual(t, getFieldValue[float64](t, level, 0), 1.5)
+	ts, _ := frame.FieldByName("ts")
+	assertEqual(t, ts.Type(), data.FieldTypeNullableTime)
+	eventTime, _ := frame.FieldByName(plugin.StreamEventTimeAttribute)
+	assertEqual(t, getFieldValue[time.Time](t, eventTime, 0).Equal(at), true)
+
+	m.EventTypes = []string{"REMOVE"}
+	frame, err = plugin.StreamRecordsToFrame("readings", records, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, frame.Rows(), 1)
+	event, _ := frame.FieldByName(plugin.StreamEventAttribute)
+	assertEqual(t, getFieldValue[string](t, event, 0), "REMOVE")
+
+	m.EventTypes = []string{"MODIFY"}
+	frame, err = plugin.StreamRecordsToFrame("readings", records, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, frame, (*data.Frame)(nil))
+}
+
+func TestStreamSubscription(t *testing.T) {
+	ds := plugin.CreateTestDatasource(context.Background())
+	m := plugin.StreamQueryModel{Table: "readings", Projections: []plugin.Projection{{Path: "$.readings.level"}}}
+
+	path, err := plugin.StreamPath(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, strings.HasPrefix(path, "stream/"), true)
+	parsed, err := plugin.ParseStreamPath(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, parsed, m)
+
+	for p, status := range map[string]backend.SubscribeStreamStatus{
+		path:              backend.SubscribeStreamStatusOK,
+		"stream/":         backend.SubscribeStreamStatusNotFound,
+		"stream/!!":       backend.SubscribeStreamStatusNotFound,
+		"tables/readings": backend.SubscribeStreamStatusNotFound,
+	} {
+		resp, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: p})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, resp.Status, status)
+	}
+
+	publish, err := ds.PublishStream(context.Background(), &backend.PublishStreamRequest{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, publish.Status, backend.PublishStreamStatusPermissionDenied)
+
+	if _, err := plugin.StreamPath(plugin.StreamQueryModel{Table: "readings", EventTypes: []string{"UPSERT"}}); err == nil {
+		t.Fatal("expected error for unsupported event type")
+	}
+}
+
+type capturedPackets struct {
+	mu      sync.Mutex
+	packets []json.RawMessage
+}
+
+func (c *capturedPackets) Send(p *backend.StreamPacket) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.packets = append(c.packets, p.Data)
+	return nil
+}
+
+func (c *capturedPackets) count() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.packets)
+}
+
+// TestRunStream needs DynamoDB Local (LocalStack) like the other integration tests.
+func TestRunStream(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	client, err := testClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	table := "stream-test"
+	_, _ = client.DeleteTableWithContext(ctx, &dynamodb.DeleteTableInput{TableName: aws.String(table)})
+	_, err = client.CreateTableWithContext(ctx, &dynamodb.CreateTableInput{
+		TableName:            aws.String(table),
+		AttributeDefinitions: []*dynamodb.AttributeDefinition{{AttributeName: aws.String("id"), AttributeType: aws.String("S")}},
+		KeySchema:            []*dynamodb.KeySchemaElement{{AttributeName: aws.String("id"), KeyType: aws.String("HASH")}},
+		BillingMode:          aws.String(dynamodb.BillingModePayPerRequest),
+		StreamSpecification: &dynamodb.StreamSpecification{
+			StreamEnabled:  aws.Bool(true),
+			StreamViewType: aws.String(dynamodb.StreamViewTypeNewImage),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := plugin.StreamPath(plugin.StreamQueryModel{Table: table})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds := plugin.CreateTestDatasource(ctx)
+	packets := &capturedPackets{}
+	done := make(chan error, 1)
+	go func() {
+		done <- ds.RunStream(ctx, &backend.RunStreamRequest{
+			Path:          path,
+			PluginContext: backend.PluginContext{DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{JSONData: []byte(`{}`)}},
+		}, backend.NewStreamSender(packets))
+	}()
+
+	// Give the stream reader time to open its shard iterators before writing
+	time.Sleep(2 * time.Second)
+	_, err = client.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item:      map[string]*dynamodb.AttributeValue{"id": {S: aws.String("a")}, "level": {N: aws.String("3")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for packets.count() == 0 {
+		select {
+		case err := <-done:
+			t.Fatalf("stream stopped: %v", err)
+		case <-ctx.Done():
+			t.Fatal("no stream frame received")
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+	cancel()
+
+	var frame data.Frame
+	if err := json.Unmarshal(packets.packets[0], &frame); err != nil {
+		t.Fatal(err)
+	}
+	id, _ := frame.FieldByName("id")
+	assertEqual(t, getFieldValue[string](t, id, 0), "a")
+}
@@ -0,0 +1,62 @@
+package test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fluvio/fluvio-connect-dynamodb/pkg/plugin"
+	"github.com/grafana/grafana-aws-sdk/pkg/awsds"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestResolveTarget(t *testing.T) {
+	base := awsds.AWSDatasourceSettings{Region: "us-east-1", AssumeRoleARN: "arn:aws:iam::111111111111:role/reader"}
+	allowed := plugin.TargetSettings{
+		Regions:        []string{"eu-west-1", "ap-southeast-2"},
+		AssumeRoleARNs: []string{"arn:aws:iam::222222222222:role/reader"},
+	}
+
+	t.Run("no override keeps the datasource settings", func(t *testing.T) {
+		resolved, err := plugin.ResolveTarget(allowed, base, plugin.AWSTarget{})
+		assertEqual(t, err, nil)
+		assertEqual(t, resolved, base)
+	})
+
+	t.Run("allowed region and role", func(t *testing.T) {
+		resolved, err := plugin.ResolveTarget(allowed, base, plugin.AWSTarget{Region: "EU-WEST-1", AssumeRoleARN: "arn:aws:iam::222222222222:role/reader"})
+		assertEqual(t, err, nil)
+		assertEqual(t, resolved.Region, "eu-west-1")
+		assertEqual(t, resolved.AssumeRoleARN, "arn:aws:iam::222222222222:role/reader")
+	})
+
+	t.Run("the datasource region is always allowed", func(t *testing.T) {
+		resolved, err := plugin.ResolveTarget(plugin.TargetSettings{}, base, plugin.AWSTarget{Region: "us-east-1"})
+		assertEqual(t, err, nil)
+		assertEqual(t, resolved.Region, "us-east-1")
+	})
+
+	t.Run("targets outside the allow list", func(t *testing.T) {
+		_, err := plugin.ResolveTarget(allowed, base, plugin.AWSTarget{Region: "us-west-2"})
+		assertEqual(t, err != nil, true)
+		_, err = plugin.ResolveTarget(allowed, base, plugin.AWSTarget{AssumeRoleARN: "arn:aws:iam::333333333333:role/admin"})
+		assertEqual(t, err != nil, true)
+	})
+}
+
+func TestLabelFrames(t *testing.T) {
+	frame := data.NewFrame("A",
+		data.NewField("time", nil, []time.Time{time.Unix(0, 0)}),
+		data.NewField("temp", data.Labels{"device": "a"}, []float64{21.5}),
+		data.NewField("name", nil, []string{"north"}),
+	)
+	plugin.LabelFrames([]*data.Frame{frame, nil}, plugin.RegionLabel, "eu-west-1")
+
+	assertEqual(t, len(frame.Fields[0].Labels), 0)
+	assertEqual(t, frame.Fields[1].Labels, data.Labels{"device": "a", "region": "eu-west-1"})
+	assertEqual(t, frame.Fields[2].Labels, data.Labels{"region": "eu-west-1"})
+
+	// Without a known region the frames are left unlabelled
+	unlabelled := data.NewFrame("B", data.NewField("name", nil, []string{"south"}))
+	plugin.LabelFrames([]*data.Frame{unlabelled}, plugin.RegionLabel, "")
+	assertEqual(t, len(unlabelled.Fields[0].Labels), 0)
+}
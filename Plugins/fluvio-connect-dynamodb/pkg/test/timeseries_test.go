@@ -0,0 +1,81 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/fluvio/fluvio-connect-dynamodb/pkg/plugin"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+func TestItemsToTimeSeries(t *testing.T) {
+	items := []map[string]*dynamodb.AttributeValue{
+		reading("B", 10, "10"),
+		reading("A", 60, "5"),
+		reading("A", 0, "1"),
+		{"station_id": {S: aws.String("A")}, "ts": {N: aws.String("30")}, "level": {S: aws.String("n/a")}},
+		{"station_id": {S: aws.String("A")}, "level": {N: aws.String("7")}},
+	}
+	formats := map[string]string{"ts": plugin.UnixTimestampSeconds}
+	model := plugin.TimeSeriesModel{
+		TimeAttribute:   "ts",
+		ValueAttributes: []string{"level"},
+		LabelAttributes: []string{"station_id"},
+	}
+
+	t.Run("multi frame per label set", func(t *testing.T) {
+		frames, err := plugin.ItemsToTimeSeries("A", items, model, formats)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, len(frames), 2)
+		assertEqual(t, frames[0].Meta.Type, data.FrameTypeTimeSeriesMulti)
+
+		a := frames[0]
+		assertEqual(t, a.Fields[1].Name, "level")
+		assertEqual(t, a.Fields[1].Labels["station_id"], "A")
+		assertEqual(t, a.Rows(), 3)
+		assertEqual(t, getFieldValue[float64](t, a.Fields[1], 0), float64(1))
+		assertEqual(t, a.Fields[1].At(1), (*float64)(nil))
+		assertEqual(t, getFieldValue[float64](t, a.Fields[1], 2), float64(5))
+
+		assertEqual(t, frames[1].Fields[1].Labels["station_id"], "B")
+	})
+
+	t.Run("wide frame joins timestamps", func(t *testing.T) {
+		model := model
+		model.Format = plugin.TimeSeriesWide
+		frames, err := plugin.ItemsToTimeSeries("A", items, model, formats)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, len(frames), 1)
+		wide := frames[0]
+		assertEqual(t, wide.Meta.Type, data.FrameTypeTimeSeriesWide)
+		assertEqual(t, len(wide.Fields), 3)
+		assertEqual(t, wide.Rows(), 4)
+		assertEqual(t, wide.Fields[1].Labels["station_id"], "A")
+		assertEqual(t, wide.Fields[2].Labels["station_id"], "B")
+		assertEqual(t, wide.Fields[2].At(0), (*float64)(nil))
+		assertEqual(t, getFieldValue[float64](t, wide.Fields[2], 1), float64(10))
+	})
+
+	t.Run("empty multi result", func(t *testing.T) {
+		frames, err := plugin.ItemsToTimeSeries("A", nil, model, formats)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, len(frames), 1)
+		assertEqual(t, len(frames[0].Fields), 0)
+		assertEqual(t, frames[0].Meta.Type, data.FrameTypeTimeSeriesMulti)
+	})
+
+	t.Run("attribute with two roles", func(t *testing.T) {
+		model := model
+		model.LabelAttributes = []string{"level"}
+		if _, err := plugin.ItemsToTimeSeries("A", items, model, formats); err == nil {
+			t.Fatal("expected error for attribute used as value and label")
+		}
+	})
+}
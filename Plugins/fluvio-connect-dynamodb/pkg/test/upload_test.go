@@ -0,0 +1,723 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/fluvio/fluvio-connect-dynamodb/pkg/plugin"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+const transformPresetSettings = `{
+	"uploadPresets": [{
+		"id": "readings",
+		"name": "Readings",
+		"table": "readings",
+		"operation": "insert",
+		"allowDryRun": true,
+		"schema": [
+			{"name": "station_id", "type": "string", "required": true, "transformation": {"type": "upper"}},
+			{"name": "ts", "type": "number", "transformation": {"type": "date_format", "params": {"inputFormat": "2006-01-02 15:04", "outputFormat": "epoch_ms"}}},
+			{"name": "level_cm", "type": "number", "transformation": {"type": "unit_conversion", "params": {"factor": "100"}}},
+			{"name": "flow", "type": "number", "transformation": {"type": "to_number", "params": {"locale": "de"}}},
+			{"name": "status", "type": "string", "transformation": {"type": "lookup", "params": {"values": "{\"1\": \"ok\", \"2\": \"fault\"}"}}},
+			{"name": "note", "type": "string", "transformation": {"type": "custom", "params": {"expression": "value.trim()"}}},
+			{"name": "PK", "type": "string", "transformation": {"type": "composite_key", "params": {"prefix": "STATION", "fields": "station_id,ts"}}}
+		]
+	}]
+}`
+
+func TestUploadTransformations(t *testing.T) {
+	t.Setenv("GF_PATHS_DATA", t.TempDir())
+	ds := plugin.CreateTestDatasource(context.Background())
+
+	t.Run("preview shows transformed values", func(t *testing.T) {
+		status, body := callResource(t, ds, transformPresetSettings, http.MethodPost, "upload/preview", map[string]interface{}{
+			"presetId": "readings",
+			"items": []map[string]interface{}{{
+				"station_id": "st-01",
+				"ts":         "2024-10-31 21:04",
+				"level_cm":   1.25,
+				"flow":       "1.234,5",
+				"status":     "2",
+				"note":       " legacy ",
+			}},
+		})
+		assertEqual(t, status, http.StatusOK)
+
+		var resp struct {
+			Items    []map[string]interface{} `json:"items"`
+			Warnings []string                 `json:"warnings"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, resp.Warnings, []string{`field "note": unknown transformation type "custom"; the value is uploaded unchanged`})
+		item := resp.Items[0]
+		assertEqual(t, item["station_id"], "ST-01")
+		assertEqual(t, item["ts"], float64(1730408640000))
+		assertEqual(t, item["level_cm"], float64(125))
+		assertEqual(t, item["flow"], 1234.5)
+		assertEqual(t, item["status"], "fault")
+		assertEqual(t, item["note"], " legacy ")
+		assertEqual(t, item["PK"], "STATION#ST-01#1730408640000")
+	})
+
+	t.Run("transformation error is reported", func(t *testing.T) {
+		status, _ := callResource(t, ds, transformPresetSettings, http.MethodPost, "upload/preview", map[string]interface{}{
+			"presetId": "readings",
+			"items":    []map[string]interface{}{{"station_id": "st-01", "ts": "yesterday"}},
+		})
+		assertEqual(t, status, http.StatusBadRequest)
+	})
+}
+
+func TestUploadExecuteMode(t *testing.T) {
+	t.Setenv("GF_PATHS_DATA", t.TempDir())
+	ds := plugin.CreateTestDatasource(context.Background())
+
+	status, body := callResource(t, ds, transformPresetSettings, http.MethodPost, "upload/execute", map[string]interface{}{
+		"presetId": "readings",
+		"mode":     "bulk",
+		"items":    []map[string]interface{}{{"station_id": "st-01", "ts": "2024-10-31 21:04"}},
+	})
+	assertEqual(t, status, http.StatusBadRequest)
+	assertEqual(t, string(body), `{"error": "unsupported upload mode 'bulk' (expected sequential, batch or transaction)"}`)
+}
+
+func TestUploadJobs(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv("GF_PATHS_DATA", dataDir)
+	ds := plugin.CreateTestDatasource(context.Background())
+
+	// Jobs left running by a previous plugin process are reported as failed and resumable.
+	jobsDir := filepath.Join(dataDir, "dynamodb-upload-jobs")
+	if err := os.MkdirAll(jobsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for id, status := range map[string]string{"interrupted": "running", "done": "succeeded"} {
+		state := fmt.Sprintf(`{"id": %q, "presetId": "readings", "mode": "batch", "status": %q, "itemCount": 40, "succeededCount": 25, "nextIndex": 25}`, id, status)
+		if err := os.WriteFile(filepath.Join(jobsDir, id+".json"), []byte(state), 0644); err != nil {
+			t.Fatal(err)
+		}
+		payload := `{"preset": {"id": "readings", "table": "readings", "operation": "insert"}, "items": []}`
+		if err := os.WriteFile(filepath.Join(jobsDir, id+".items.json"), []byte(payload), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	restricted := `{"id": "restricted", "presetId": "station-delete", "mode": "batch", "status": "succeeded", "itemCount": 1, "succeededCount": 1, "nextIndex": 1, "access": {"roles": ["Admin"]}}`
+	if err := os.WriteFile(filepath.Join(jobsDir, "restricted.json"), []byte(restricted), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("interrupted job is failed after restart", func(t *testing.T) {
+		status, body := callResource(t, ds, transformPresetSettings, http.MethodGet, "upload/jobs/interrupted", nil)
+		assertEqual(t, status, http.StatusOK)
+
+		var job struct {
+			Status         string `json:"status"`
+			SucceededCount int    `json:"succeededCount"`
+			NextIndex      int    `json:"nextIndex"`
+		}
+		if err := json.Unmarshal(body, &job); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, job.Status, "failed")
+		assertEqual(t, job.SucceededCount, 25)
+		assertEqual(t, job.NextIndex, 25)
+	})
+
+	t.Run("list jobs", func(t *testing.T) {
+		status, body := callResource(t, ds, transformPresetSettings, http.MethodGet, "upload/jobs", nil)
+		assertEqual(t, status, http.StatusOK)
+
+		var resp struct {
+			Jobs []struct {
+				ID string `json:"id"`
+			} `json:"jobs"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatal(err)
+		}
+		// The restricted job is hidden from users without access to its preset
+		assertEqual(t, len(resp.Jobs), 2)
+	})
+
+	t.Run("restricted job", func(t *testing.T) {
+		status, _ := callResource(t, ds, transformPresetSettings, http.MethodGet, "upload/jobs/restricted", nil)
+		assertEqual(t, status, http.StatusForbidden)
+	})
+
+	t.Run("finished job cannot be cancelled or resumed", func(t *testing.T) {
+		status, _ := callResource(t, ds, transformPresetSettings, http.MethodDelete, "upload/jobs/done", nil)
+		assertEqual(t, status, http.StatusConflict)
+
+		status, _ = callResource(t, ds, transformPresetSettings, http.MethodPost, "upload/jobs/done/resume", nil)
+		assertEqual(t, status, http.StatusConflict)
+	})
+
+	t.Run("unknown job", func(t *testing.T) {
+		status, _ := callResource(t, ds, transformPresetSettings, http.MethodGet, "upload/jobs/missing", nil)
+		assertEqual(t, status, http.StatusNotFound)
+	})
+
+	t.Run("invalid items are rejected before the job starts", func(t *testing.T) {
+		status, _ := callResource(t, ds, transformPresetSettings, http.MethodPost, "upload/jobs", map[string]interface{}{
+			"presetId": "readings",
+			"items":    []map[string]interface{}{{"station_id": "st-01", "ts": "yesterday"}},
+		})
+		assertEqual(t, status, http.StatusBadRequest)
+	})
+}
+
+// previewFile previews a raw file upload against the readings preset.
+func previewFile(t *testing.T, query string, body []byte) (int, uploadFilePreview) {
+	t.Helper()
+	ds := plugin.CreateTestDatasource(context.Background())
+	status, raw := callResourceRaw(t, ds, transformPresetSettings, http.MethodPost, "upload/preview", "presetId=readings&"+query, body)
+
+	var resp uploadFilePreview
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		t.Fatal(err)
+	}
+	return status, resp
+}
+
+type uploadFilePreview struct {
+	ItemCount int                      `json:"itemCount"`
+	Items     []map[string]interface{} `json:"items"`
+	RowErrors []struct {
+		Row    int    `json:"row"`
+		Column string `json:"column"`
+	} `json:"rowErrors"`
+	Error string `json:"error"`
+}
+
+func TestUploadFileIngestion(t *testing.T) {
+	t.Setenv("GF_PATHS_DATA", t.TempDir())
+	ds := plugin.CreateTestDatasource(context.Background())
+
+	t.Run("csv with header, mapping and row errors", func(t *testing.T) {
+		csv := "Station;Time;Level\nst-01;2024-10-31 21:04;1,5\n;2024-10-31 21:05;2\nst-02;2024-10-31 21:06;3\n"
+		mapping := url.QueryEscape(`{"Station": "station_id", "Time": "ts", "Level": "flow"}`)
+		status, resp := previewFile(t, "format=csv&delimiter=%3B&mapping="+mapping, []byte(csv))
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, resp.ItemCount, 2)
+		assertEqual(t, resp.Items[0]["station_id"], "ST-01")
+		assertEqual(t, resp.Items[0]["flow"], 1.5)
+		assertEqual(t, len(resp.RowErrors), 1)
+		assertEqual(t, resp.RowErrors[0].Row, 3)
+	})
+
+	t.Run("tsv without header in latin1", func(t *testing.T) {
+		// Columns follow the schema order; 0xE9 is é in ISO-8859-1.
+		tsv := []byte("st-01\t2024-10-31 21:04\t1.25\t7\tcaf\xe9\n")
+		status, resp := previewFile(t, "format=tsv&encoding=iso-8859-1&header=false&mapping="+url.QueryEscape(`{"1": "station_id", "2": "ts", "3": "level_cm", "5": "status"}`), tsv)
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, resp.Items[0]["level_cm"], float64(125))
+		assertEqual(t, resp.Items[0]["status"], "café")
+	})
+
+	t.Run("ndjson reports invalid lines", func(t *testing.T) {
+		ndjson := "{\"station_id\": \"st-01\", \"ts\": \"2024-10-31 21:04\"}\nnot json\n\n{\"station_id\": \"st-02\", \"ts\": \"2024-10-31 21:05\"}\n"
+		status, resp := previewFile(t, "format=ndjson", []byte(ndjson))
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, resp.ItemCount, 2)
+		assertEqual(t, resp.RowErrors[0].Row, 2)
+	})
+
+	t.Run("xlsx with shared strings", func(t *testing.T) {
+		status, resp := previewFile(t, "format=xlsx", buildXLSX(t, nil))
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, resp.ItemCount, 1)
+		assertEqual(t, resp.Items[0]["station_id"], "ST-07")
+		assertEqual(t, resp.Items[0]["level_cm"], float64(300))
+	})
+
+	t.Run("csv numeric partition key is typed like the write", func(t *testing.T) {
+		settings := `{"uploadPresets": [{"id": "gauges", "name": "Gauges", "table": "gauges", "operation": "insert", "allowDryRun": true,
+			"schema": [{"name": "gauge_id", "dynamoType": "N", "required": true}, {"name": "name", "type": "string"}]}]}`
+		status, raw := callResourceRaw(t, ds, settings, http.MethodPost, "upload/preview", "presetId=gauges&format=csv", []byte("gauge_id,name\n42,north\n"))
+		assertEqual(t, status, http.StatusOK)
+		var resp uploadFilePreview
+		if err := json.Unmarshal(raw, &resp); err != nil {
+			t.Fatal(err)
+		}
+
+		var preset struct {
+			UploadPresets []plugin.UploadPreset `json:"uploadPresets"`
+		}
+		if err := json.Unmarshal([]byte(settings), &preset); err != nil {
+			t.Fatal(err)
+		}
+		key, err := plugin.UploadItemKey(preset.UploadPresets[0], []string{"gauge_id"}, resp.Items[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, key["gauge_id"], &dynamodb.AttributeValue{N: aws.String("42")})
+	})
+
+	t.Run("no valid rows", func(t *testing.T) {
+		status, resp := previewFile(t, "format=csv", []byte("station_id,ts\n,2024-10-31 21:04\n"))
+		assertEqual(t, status, http.StatusBadRequest)
+		assertEqual(t, resp.Error, "no valid rows, row 2: field 'PK': composite_key transformation failed: source field 'station_id' missing")
+	})
+}
+
+const xlsxDatePresetSettings = `{
+	"maxUploadPayloadKB": 1,
+	"uploadPresets": [{
+		"id": "readings",
+		"name": "Readings",
+		"table": "readings",
+		"operation": "insert",
+		"allowDryRun": true,
+		"schema": [
+			{"name": "station_id", "type": "string"},
+			{"name": "ts", "type": "number", "transformation": {"type": "date_format", "params": {"outputFormat": "epoch_ms"}}},
+			{"name": "installed", "type": "string"},
+			{"name": "level_cm", "type": "number"}
+		]
+	}]
+}`
+
+func TestUploadXLSX(t *testing.T) {
+	t.Setenv("GF_PATHS_DATA", t.TempDir())
+	ds := plugin.CreateTestDatasource(context.Background())
+
+	preview := func(t *testing.T, workbook []byte) (int, uploadFilePreview) {
+		t.Helper()
+		status, raw := callResourceRaw(t, ds, xlsxDatePresetSettings, http.MethodPost, "upload/preview", "presetId=readings&format=xlsx", workbook)
+		var resp uploadFilePreview
+		if err := json.Unmarshal(raw, &resp); err != nil {
+			t.Fatal(err)
+		}
+		return status, resp
+	}
+
+	t.Run("date cells become timestamps", func(t *testing.T) {
+		status, resp := preview(t, buildXLSX(t, map[string]string{
+			"xl/styles.xml": `<styleSheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+				`<numFmts count="1"><numFmt numFmtId="164" formatCode="yyyy\-mm\-dd;@"/></numFmts>` +
+				`<cellXfs count="4"><xf numFmtId="0"/><xf numFmtId="22"/><xf numFmtId="164"/><xf numFmtId="2"/></cellXfs></styleSheet>`,
+			"xl/worksheets/sheet1.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` +
+				`<row r="1"><c r="A1" t="inlineStr"><is><t>station_id</t></is></c><c r="B1" t="inlineStr"><is><t>ts</t></is></c>` +
+				`<c r="C1" t="inlineStr"><is><t>installed</t></is></c><c r="D1" t="inlineStr"><is><t>level_cm</t></is></c></row>` +
+				`<row r="2"><c r="A2" t="inlineStr"><is><t>st-07</t></is></c><c r="B2" s="1"><v>45596.87777777778</v></c>` +
+				`<c r="C2" s="2"><v>45598</v></c><c r="D2" s="3"><v>3.5</v></c></row>` +
+				`</sheetData></worksheet>`,
+		}))
+		assertEqual(t, status, http.StatusOK)
+		assertEqual(t, resp.ItemCount, 1)
+		assertEqual(t, resp.Items[0]["ts"], float64(1730408640000))
+		assertEqual(t, resp.Items[0]["installed"], "2024-11-02T00:00:00Z")
+		assertEqual(t, resp.Items[0]["level_cm"], 3.5)
+	})
+
+	t.Run("entries are limited once decompressed", func(t *testing.T) {
+		status, resp := preview(t, buildXLSX(t, map[string]string{
+			"xl/worksheets/sheet1.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` +
+				strings.Repeat("<row/>", 2000) + `</sheetData></worksheet>`,
+		}))
+		assertEqual(t, status, http.StatusBadRequest)
+		assertEqual(t, resp.Error, "XLSX entry exceeds the maximum uncompressed size of 8 KB: xl/worksheets/sheet1.xml")
+	})
+}
+
+// buildXLSX returns a minimal workbook with a header row and one data row. overrides replaces
+// or adds archive entries.
+func buildXLSX(t *testing.T, overrides map[string]string) []byte {
+	t.Helper()
+	files := map[string]string{
+		"xl/workbook.xml": `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">` +
+			`<sheets><sheet name="Readings" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+		"xl/_rels/workbook.xml.rels": `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">` +
+			`<Relationship Id="rId1" Type="worksheet" Target="worksheets/sheet1.xml"/></Relationships>`,
+		"xl/sharedStrings.xml": `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+			`<si><t>station_id</t></si><si><t>ts</t></si><si><t>level_cm</t></si><si><r><t>st-</t></r><r><t>07</t></r></si></sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>` +
+			`<row r="1"><c r="A1" t="s"><v>0</v></c><c r="B1" t="s"><v>1</v></c><c r="C1" t="s"><v>2</v></c></row>` +
+			`<row r="2"><c r="A2" t="s"><v>3</v></c><c r="B2" t="inlineStr"><is><t>2024-10-31 21:04</t></is></c><c r="C2"><v>3</v></c></row>` +
+			`</sheetData></worksheet>`,
+	}
+	for name, content := range overrides {
+		files[name] = content
+	}
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestUploadAudit(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv("GF_PATHS_DATA", dataDir)
+	ds := plugin.CreateTestDatasource(context.Background())
+
+	auditDir := filepath.Join(dataDir, "dynamodb-audit")
+	if err := os.MkdirAll(auditDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string]string{
+		"20241031T210400.000000000Z-0001": `{"id": "20241031T210400.000000000Z-0001", "timestamp": "2024-10-31T21:04:00Z", "user": {"login": "steward"}, "action": "execute",
+			"presetId": "readings", "table": "readings", "operation": "update", "itemCount": 1, "undoable": true, "undoneBy": "20241031T220000.000000000Z-0002",
+			"images": [{"index": 0, "key": {"PK": {"S": "STATION#1"}}, "prior": {"PK": {"S": "STATION#1"}, "level": {"N": "1.5"}, "tags": {"SS": ["a", "b"]}}}]}`,
+		"20241031T220000.000000000Z-0002": `{"id": "20241031T220000.000000000Z-0002", "timestamp": "2024-10-31T22:00:00Z", "user": {"login": "admin"}, "action": "undo",
+			"presetId": "readings", "table": "readings", "operation": "update", "itemCount": 1, "undoOf": "20241031T210400.000000000Z-0001"}`,
+	}
+	for id, content := range entries {
+		if err := os.WriteFile(filepath.Join(auditDir, id+".json"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("list filtered by user", func(t *testing.T) {
+		status, body := callResourceRaw(t, ds, transformPresetSettings, http.MethodGet, "upload/audit", "user=steward", nil)
+		assertEqual(t, status, http.StatusOK)
+
+		var resp struct {
+			Entries []struct {
+				ID     string        `json:"id"`
+				Images []interface{} `json:"images"`
+			} `json:"entries"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, len(resp.Entries), 1)
+		assertEqual(t, resp.Entries[0].ID, "20241031T210400.000000000Z-0001")
+		assertEqual(t, len(resp.Entries[0].Images), 0)
+	})
+
+	t.Run("entry keeps prior images in DynamoDB JSON", func(t *testing.T) {
+		status, body := callResource(t, ds, transformPresetSettings, http.MethodGet, "upload/audit/20241031T210400.000000000Z-0001", nil)
+		assertEqual(t, status, http.StatusOK)
+
+		var resp struct {
+			Images []struct {
+				Prior map[string]map[string]interface{} `json:"prior"`
+			} `json:"images"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, resp.Images[0].Prior["level"]["N"], "1.5")
+		assertEqual(t, resp.Images[0].Prior["tags"]["SS"], []interface{}{"a", "b"})
+	})
+
+	t.Run("undo is refused for undone and undo entries", func(t *testing.T) {
+		status, _ := callResource(t, ds, transformPresetSettings, http.MethodPost, "upload/audit/20241031T210400.000000000Z-0001/undo", nil)
+		assertEqual(t, status, http.StatusConflict)
+
+		status, _ = callResource(t, ds, transformPresetSettings, http.MethodPost, "upload/audit/20241031T220000.000000000Z-0002/undo", nil)
+		assertEqual(t, status, http.StatusConflict)
+	})
+
+	t.Run("invalid time filter", func(t *testing.T) {
+		status, _ := callResourceRaw(t, ds, transformPresetSettings, http.MethodGet, "upload/audit", "from=yesterday", nil)
+		assertEqual(t, status, http.StatusBadRequest)
+	})
+}
+
+const accessPresetSettings = `{
+	"teams": {"field-technicians": ["tech@example.com"]},
+	"uploadPresets": [
+		{"id": "data-input", "name": "Data Input", "table": "readings", "operation": "insert", "allowDryRun": true,
+			"access": {"roles": ["Editor"], "teams": ["field-technicians"]}},
+		{"id": "station-delete", "name": "Station Manager", "table": "stations", "operation": "delete", "allowDryRun": true,
+			"access": {"roles": ["Admin"]}},
+		{"id": "open", "name": "Open", "table": "notes", "operation": "insert", "allowDryRun": true}
+	]
+}`
+
+func TestUploadPresetAccess(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv("GF_PATHS_DATA", dataDir)
+	ds := plugin.CreateTestDatasource(context.Background())
+
+	call := func(user *backend.User, method string, path string, body string) (int, []byte) {
+		return sendResourceRequest(t, ds, &backend.CallResourceRequest{
+			PluginContext: backend.PluginContext{
+				User:                       user,
+				DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{JSONData: []byte(accessPresetSettings)},
+			},
+			Path:   path,
+			Method: method,
+			URL:    path,
+			Body:   []byte(body),
+		})
+	}
+	listed := func(user *backend.User) []string {
+		status, body := call(user, http.MethodGet, "upload/presets", "")
+		assertEqual(t, status, http.StatusOK)
+		var resp struct {
+			Presets []struct {
+				ID string `json:"id"`
+			} `json:"presets"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatal(err)
+		}
+		ids := []string{}
+		for _, p := range resp.Presets {
+			ids = append(ids, p.ID)
+		}
+		return ids
+	}
+
+	technician := &backend.User{Login: "tech", Email: "tech@example.com", Role: "Viewer"}
+	editor := &backend.User{Login: "editor", Role: "Editor"}
+	admin := &backend.User{Login: "admin", Role: "Admin"}
+
+	t.Run("listing is filtered", func(t *testing.T) {
+		assertEqual(t, listed(nil), []string{"open"})
+		assertEqual(t, listed(technician), []string{"data-input", "open"})
+		assertEqual(t, listed(editor), []string{"data-input", "open"})
+		assertEqual(t, listed(admin), []string{"data-input", "station-delete", "open"})
+	})
+
+	t.Run("upload is denied without access", func(t *testing.T) {
+		body := `{"presetId": "station-delete", "items": [{"PK": "STATION#1"}]}`
+		status, _ := call(editor, http.MethodPost, "upload/preview", body)
+		assertEqual(t, status, http.StatusForbidden)
+
+		status, _ = call(admin, http.MethodPost, "upload/preview", body)
+		assertEqual(t, status, http.StatusOK)
+	})
+
+	t.Run("audit entries are filtered", func(t *testing.T) {
+		auditDir := filepath.Join(dataDir, "dynamodb-audit")
+		if err := os.MkdirAll(auditDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		entries := map[string]string{
+			"20241101T080000.000000000Z-0001": `{"id": "20241101T080000.000000000Z-0001", "timestamp": "2024-11-01T08:00:00Z", "user": {"login": "admin"}, "action": "execute",
+				"presetId": "station-delete", "table": "stations", "operation": "delete", "itemCount": 1, "items": [{"PK": "STATION#1"}]}`,
+			"20241101T090000.000000000Z-0002": `{"id": "20241101T090000.000000000Z-0002", "timestamp": "2024-11-01T09:00:00Z", "user": {"login": "editor"}, "action": "execute",
+				"presetId": "open", "table": "notes", "operation": "insert", "itemCount": 1, "items": [{"PK": "NOTE#1"}]}`,
+		}
+		for id, content := range entries {
+			if err := os.WriteFile(filepath.Join(auditDir, id+".json"), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		audited := func(user *backend.User) []string {
+			status, body := call(user, http.MethodGet, "upload/audit", "")
+			assertEqual(t, status, http.StatusOK)
+			var resp struct {
+				Entries []struct {
+					ID string `json:"id"`
+				} `json:"entries"`
+			}
+			if err := json.Unmarshal(body, &resp); err != nil {
+				t.Fatal(err)
+			}
+			ids := []string{}
+			for _, e := range resp.Entries {
+				ids = append(ids, e.ID)
+			}
+			return ids
+		}
+
+		assertEqual(t, audited(editor), []string{"20241101T090000.000000000Z-0002"})
+		assertEqual(t, audited(admin), []string{"20241101T090000.000000000Z-0002", "20241101T080000.000000000Z-0001"})
+
+		status, _ := call(editor, http.MethodGet, "upload/audit/20241101T080000.000000000Z-0001", "")
+		assertEqual(t, status, http.StatusForbidden)
+		status, _ = call(admin, http.MethodGet, "upload/audit/20241101T080000.000000000Z-0001", "")
+		assertEqual(t, status, http.StatusOK)
+	})
+
+	t.Run("undo refuses a running job", func(t *testing.T) {
+		// Load the job manager first, since loading marks running jobs as failed
+		status, _ := call(admin, http.MethodGet, "upload/jobs", "")
+		assertEqual(t, status, http.StatusOK)
+
+		jobsDir := filepath.Join(dataDir, "dynamodb-upload-jobs")
+		job := `{"id": "job-1", "presetId": "open", "mode": "batch", "status": "running", "itemCount": 2, "auditId": "20241101T100000.000000000Z-0003"}`
+		if err := os.WriteFile(filepath.Join(jobsDir, "job-1.json"), []byte(job), 0644); err != nil {
+			t.Fatal(err)
+		}
+		entry := `{"id": "20241101T100000.000000000Z-0003", "timestamp": "2024-11-01T10:00:00Z", "user": {"login": "admin"}, "action": "execute",
+			"presetId": "open", "table": "notes", "operation": "insert", "itemCount": 2, "jobId": "job-1", "undoable": true}`
+		if err := os.WriteFile(filepath.Join(dataDir, "dynamodb-audit", "20241101T100000.000000000Z-0003.json"), []byte(entry), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		status, body := call(admin, http.MethodPost, "upload/audit/20241101T100000.000000000Z-0003/undo", "")
+		assertEqual(t, status, http.StatusConflict)
+		assertEqual(t, strings.Contains(string(body), "still running"), true)
+	})
+
+	t.Run("only admins save presets", func(t *testing.T) {
+		status, _ := call(editor, http.MethodPost, "presets", `{"id": "p", "name": "P", "table": "t"}`)
+		assertEqual(t, status, http.StatusForbidden)
+
+		status, _ = call(admin, http.MethodPost, "presets", `{"id": "p", "name": "P", "table": "t"}`)
+		assertEqual(t, status, http.StatusOK)
+	})
+}
+
+const rulesPresetSettings = `{
+	"uploadPresets": [{
+		"id": "shifts",
+		"name": "Shifts",
+		"table": "shifts",
+		"operation": "insert",
+		"allowDryRun": true,
+		"schema": [
+			{"name": "shift_id", "type": "string", "required": true},
+			{"name": "start_time", "type": "string"},
+			{"name": "end_time", "type": "string"},
+			{"name": "status", "type": "string"},
+			{"name": "reason", "type": "string"}
+		],
+		"rules": [
+			{"type": "compare", "field": "end_time", "operator": ">", "otherField": "start_time", "code": "END_BEFORE_START"},
+			{"type": "unique", "fields": ["shift_id"]},
+			{"type": "required_if", "field": "reason", "when": "status", "value": "cancelled", "message": "cancelled shifts need a reason"}
+		]
+	}]
+}`
+
+func TestUploadRules(t *testing.T) {
+	t.Setenv("GF_PATHS_DATA", t.TempDir())
+	ds := plugin.CreateTestDatasource(context.Background())
+
+	items := []map[string]interface{}{
+		{"shift_id": "a", "start_time": "2024-10-31T08:00:00Z", "end_time": "2024-10-31T16:00:00Z"},
+		{"shift_id": "b", "start_time": "2024-10-31T16:00:00Z", "end_time": "2024-10-31T08:00:00Z"},
+		{"shift_id": "a", "status": "cancelled"},
+		{"shift_id": "c", "status": "cancelled", "reason": "storm"},
+	}
+	type violation struct {
+		Item  int    `json:"item"`
+		Row   int    `json:"row"`
+		Code  string `json:"code"`
+		Field string `json:"field"`
+		Error string `json:"error"`
+	}
+
+	t.Run("preview lists violations per item", func(t *testing.T) {
+		status, body := callResource(t, ds, rulesPresetSettings, http.MethodPost, "upload/preview", map[string]interface{}{
+			"presetId": "shifts",
+			"items":    items,
+		})
+		assertEqual(t, status, http.StatusOK)
+
+		var resp struct {
+			Violations []violation `json:"violations"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, resp.Violations, []violation{
+			{Item: 2, Code: "END_BEFORE_START", Field: "end_time", Error: `field "end_time" (2024-10-31T08:00:00Z) must be > "start_time" (2024-10-31T16:00:00Z)`},
+			{Item: 3, Code: "duplicate", Field: "shift_id", Error: "duplicate shift_id, first used by item 1"},
+			{Item: 3, Code: "required", Field: "reason", Error: "cancelled shifts need a reason"},
+		})
+	})
+
+	t.Run("raw files report source rows", func(t *testing.T) {
+		csv := "shift_id,start_time,end_time\nx,2024-10-31,2024-11-01\nx,2024-10-31,2024-10-30\n"
+		status, body := callResourceRaw(t, ds, rulesPresetSettings, http.MethodPost, "upload/preview", "format=csv&presetId=shifts", []byte(csv))
+		assertEqual(t, status, http.StatusOK)
+
+		var resp struct {
+			Violations []violation `json:"violations"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, len(resp.Violations), 2)
+		assertEqual(t, resp.Violations[0].Row, 3)
+		assertEqual(t, resp.Violations[1].Row, 3)
+	})
+
+	t.Run("execute is rejected", func(t *testing.T) {
+		status, body := callResource(t, ds, rulesPresetSettings, http.MethodPost, "upload/execute", map[string]interface{}{
+			"presetId": "shifts",
+			"items":    items,
+		})
+		assertEqual(t, status, http.StatusBadRequest)
+
+		var resp struct {
+			Error      string      `json:"error"`
+			Violations []violation `json:"violations"`
+		}
+		if err := json.Unmarshal(body, &resp); err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, resp.Error, "3 item(s) violate preset rules")
+		assertEqual(t, len(resp.Violations), 3)
+	})
+
+	t.Run("invalid rule fails the request", func(t *testing.T) {
+		settings := `{"uploadPresets": [{"id": "p", "name": "P", "table": "t", "operation": "insert", "allowDryRun": true,
+			"rules": [{"type": "compare", "field": "a", "operator": "~", "value": 1}]}]}`
+		status, body := callResource(t, ds, settings, http.MethodPost, "upload/preview", map[string]interface{}{
+			"presetId": "p",
+			"items":    []map[string]interface{}{{"a": 1}},
+		})
+		assertEqual(t, status, http.StatusBadRequest)
+		assertEqual(t, string(body), `{"error": "preset 'p' rule 1: unsupported compare operator '~'"}`)
+	})
+}
+
+func TestUploadReferenceRule(t *testing.T) {
+	ctx := context.Background()
+	t.Setenv("GF_PATHS_DATA", t.TempDir())
+	ds := plugin.CreateTestDatasource(ctx)
+
+	if err := createTable(ctx, testTableName); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeItems(ctx, testTableName, []plugin.DataRow{{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	settings := fmt.Sprintf(`{"uploadPresets": [{"id": "readings", "name": "Readings", "table": "readings", "operation": "insert", "allowDryRun": true,
+		"schema": [{"name": "station", "type": "number"}, {"name": "sensor", "type": "number"}],
+		"rules": [{"type": "reference", "table": %q, "keys": {"id": "station", "sid": "sensor"}}]}]}`, testTableName)
+	status, body := callResource(t, ds, settings, http.MethodPost, "upload/preview", map[string]interface{}{
+		"presetId": "readings",
+		"items":    []map[string]interface{}{{"station": 1, "sensor": 1}, {"station": 1, "sensor": 9}},
+	})
+	assertEqual(t, status, http.StatusOK)
+
+	var resp struct {
+		Violations []struct {
+			Item int    `json:"item"`
+			Code string `json:"code"`
+		} `json:"violations"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil {
+		t.Fatal(err)
+	}
+	assertEqual(t, len(resp.Violations), 1)
+	assertEqual(t, resp.Violations[0].Item, 2)
+	assertEqual(t, resp.Violations[0].Code, "missing_reference")
+}
@@ -0,0 +1,91 @@
+package test
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/fluvio/fluvio-connect-dynamodb/pkg/plugin"
+)
+
+func TestClassifyUpsertRows(t *testing.T) {
+	keys := []string{"logger", "ts"}
+	reading := func(logger string, ts string, temp string, updated string) map[string]*dynamodb.AttributeValue {
+		item := map[string]*dynamodb.AttributeValue{
+			"logger": {S: aws.String(logger)},
+			"ts":     {N: aws.String(ts)},
+			"temp":   {N: aws.String(temp)},
+		}
+		if updated != "" {
+			item["updated"] = &dynamodb.AttributeValue{S: aws.String(updated)}
+		}
+		return item
+	}
+
+	items := []map[string]*dynamodb.AttributeValue{
+		reading("L1", "1", "20.5", "2024-06-02T10:00:00Z"), // new
+		reading("L1", "2", "21", "2024-06-02T10:00:00Z"),   // identical, stored as 21.0
+		reading("L1", "3", "22", "2024-06-02T10:00:00Z"),   // changed, stored item older
+		reading("L1", "4", "23", "2024-06-01T10:00:00Z"),   // changed, stored item newer
+		reading("L1", "1", "20.5", "2024-06-02T10:00:00Z"), // repeats item 1
+		reading("L1", "1", "99", "2024-06-02T10:00:00Z"),   // repeats item 1 with other values
+	}
+	current := []map[string]*dynamodb.AttributeValue{
+		nil,
+		reading("L1", "2", "21.0", "2024-06-02T10:00:00Z"),
+		reading("L1", "3", "19", "2024-06-01T10:00:00Z"),
+		reading("L1", "4", "18", "2024-06-03T10:00:00Z"),
+		nil,
+		nil,
+	}
+
+	classify := func(t *testing.T, settings plugin.UpsertSettings) ([]string, []bool, []plugin.UpsertRow) {
+		t.Helper()
+		rows, err := plugin.ClassifyUpsertRows(settings, keys, items, current)
+		assertEqual(t, err, nil)
+		statuses := make([]string, len(rows))
+		writes := make([]bool, len(rows))
+		for i, row := range rows {
+			assertEqual(t, row.Item, i+1)
+			statuses[i] = row.Status
+			writes[i] = row.Write
+		}
+		return statuses, writes, rows
+	}
+
+	t.Run("skip identical by default", func(t *testing.T) {
+		statuses, writes, rows := classify(t, plugin.UpsertSettings{})
+		assertEqual(t, statuses, []string{"new", "identical", "changed", "changed", "identical", "conflicting"})
+		assertEqual(t, writes, []bool{true, false, true, true, false, false})
+		assertEqual(t, rows[2].Changes, []plugin.ItemChange{
+			{Attribute: "temp", Before: &dynamodb.AttributeValue{N: aws.String("19")}, After: &dynamodb.AttributeValue{N: aws.String("22")}},
+			{Attribute: "updated", Before: &dynamodb.AttributeValue{S: aws.String("2024-06-01T10:00:00Z")}, After: &dynamodb.AttributeValue{S: aws.String("2024-06-02T10:00:00Z")}},
+		})
+		assertEqual(t, rows[5].Reason, "key repeats item 1 with different values")
+	})
+
+	t.Run("overwrite writes identical items", func(t *testing.T) {
+		_, writes, _ := classify(t, plugin.UpsertSettings{DuplicatePolicy: plugin.UpsertOverwrite})
+		assertEqual(t, writes, []bool{true, true, true, true, false, false})
+	})
+
+	t.Run("fail marks changed items conflicting", func(t *testing.T) {
+		statuses, writes, _ := classify(t, plugin.UpsertSettings{DuplicatePolicy: plugin.UpsertFail})
+		assertEqual(t, statuses, []string{"new", "identical", "conflicting", "conflicting", "identical", "conflicting"})
+		assertEqual(t, writes, []bool{true, false, false, false, false, false})
+	})
+
+	t.Run("keep newest", func(t *testing.T) {
+		statuses, writes, rows := classify(t, plugin.UpsertSettings{DuplicatePolicy: plugin.UpsertKeepNewest, TimestampAttribute: "updated"})
+		assertEqual(t, statuses, []string{"new", "identical", "changed", "conflicting", "identical", "conflicting"})
+		assertEqual(t, writes, []bool{true, false, true, false, false, false})
+		assertEqual(t, rows[3].Reason != "", true)
+	})
+
+	t.Run("keep newest needs a timestamp attribute", func(t *testing.T) {
+		_, err := plugin.ClassifyUpsertRows(plugin.UpsertSettings{DuplicatePolicy: plugin.UpsertKeepNewest}, keys, items, current)
+		assertEqual(t, err != nil, true)
+		_, err = plugin.ClassifyUpsertRows(plugin.UpsertSettings{DuplicatePolicy: "merge"}, keys, items, current)
+		assertEqual(t, err != nil, true)
+	})
+}
@@ -2,6 +2,7 @@ package test
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"maps"
 	"reflect"
@@ -14,6 +15,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/fluvio/fluvio-connect-dynamodb/pkg/plugin"
 	"github.com/google/go-cmp/cmp"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 )
 
@@ -146,3 +148,56 @@ func assertEqual(t *testing.T, a interface{}, b interface{}) {
 
 	t.Errorf("Received %v (type %v), expected %v (type %v)", reflect.ValueOf(a), reflect.TypeOf(a), reflect.ValueOf(b), reflect.TypeOf(b))
 }
+
+// callResource invokes a datasource resource endpoint with the given datasource JSON settings
+// and returns the response status and body.
+func callResource(t *testing.T, ds *plugin.Datasource, jsonData string, method string, path string, body interface{}) (int, []byte) {
+	t.Helper()
+
+	var rawBody []byte
+	if body != nil {
+		var err error
+		rawBody, err = json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return callResourceRaw(t, ds, jsonData, method, path, "", rawBody)
+}
+
+// callResourceRaw sends body as is, with query appended to the request URL.
+func callResourceRaw(t *testing.T, ds *plugin.Datasource, jsonData string, method string, path string, query string, body []byte) (int, []byte) {
+	t.Helper()
+
+	url := path
+	if query != "" {
+		url += "?" + query
+	}
+
+	return sendResourceRequest(t, ds, &backend.CallResourceRequest{
+		PluginContext: backend.PluginContext{
+			DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{JSONData: []byte(jsonData)},
+		},
+		Path:   path,
+		Method: method,
+		URL:    url,
+		Body:   body,
+	})
+}
+
+func sendResourceRequest(t *testing.T, ds *plugin.Datasource, req *backend.CallResourceRequest) (int, []byte) {
+	t.Helper()
+
+	var resp *backend.CallResourceResponse
+	err := ds.CallResource(context.Background(), req, backend.CallResourceResponseSenderFunc(func(r *backend.CallResourceResponse) error {
+		resp = r
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp == nil {
+		t.Fatal("resource handler did not send a response")
+	}
+	return resp.Status, resp.Body
+}
@@ -0,0 +1,98 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/fluvio/fluvio-connect-dynamodb/pkg/plugin"
+)
+
+func TestVariables(t *testing.T) {
+	t.Run("statement projects the attributes and applies filters", func(t *testing.T) {
+		statement, err := plugin.BuildVariableStatement(plugin.VariableQueryModel{
+			Type:      plugin.VariableDistinct,
+			Table:     "readings",
+			Index:     "byRegion",
+			Attribute: "station_id",
+			Filters: []plugin.VariableFilter{
+				{Attribute: "region", Values: []string{"north", "o'east"}},
+				{Attribute: "depth", Values: []string{"5"}, Type: "N"},
+				{Attribute: "parameter", Values: []string{"$__all"}},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, statement, `SELECT "station_id" FROM "readings"."byRegion" WHERE "region" IN ['north', 'o''east'] AND "depth" = 5`)
+
+		statement, err = plugin.BuildVariableStatement(plugin.VariableQueryModel{
+			Type: plugin.VariablePairs, Table: "stations", TextAttribute: "name", ValueAttribute: "station_id",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertEqual(t, statement, `SELECT "station_id", "name" FROM "stations"`)
+	})
+
+	t.Run("invalid definitions", func(t *testing.T) {
+		for _, v := range []plugin.VariableQueryModel{
+			{Type: plugin.VariableDistinct, Table: "readings"},
+			{Type: plugin.VariablePairs, Table: "readings", TextAttribute: "name"},
+			{Type: "regex", Table: "readings"},
+			{Type: plugin.VariableDistinct, Attribute: "station_id"},
+			{Type: plugin.VariableDistinct, Table: "readings", Attribute: "station_id",
+				Filters: []plugin.VariableFilter{{Attribute: "depth", Values: []string{"1 OR 1=1"}, Type: "N"}}},
+		} {
+			if _, err := plugin.BuildVariableStatement(v); err == nil {
+				t.Fatalf("expected error for %+v", v)
+			}
+		}
+	})
+
+	t.Run("distinct values are sorted and capped", func(t *testing.T) {
+		items := []map[string]*dynamodb.AttributeValue{
+			{"depth": {N: aws.String("10")}},
+			{"depth": {N: aws.String("2")}},
+			{"depth": {N: aws.String("10")}},
+			{"depth": {NS: aws.StringSlice([]string{"7", "1"})}},
+			{"other": {S: aws.String("x")}},
+		}
+		result := plugin.CollectVariableValues(items, plugin.VariableQueryModel{Type: plugin.VariableDistinct, Attribute: "depth"})
+		assertEqual(t, result.Values, []plugin.VariableValue{
+			{Text: "1", Value: "1"}, {Text: "2", Value: "2"}, {Text: "7", Value: "7"}, {Text: "10", Value: "10"},
+		})
+		assertEqual(t, result.Truncated, false)
+
+		result = plugin.CollectVariableValues(items, plugin.VariableQueryModel{Type: plugin.VariableDistinct, Attribute: "depth", Limit: 2})
+		assertEqual(t, len(result.Values), 2)
+		assertEqual(t, result.Truncated, true)
+	})
+
+	t.Run("pairs use the first text per value", func(t *testing.T) {
+		items := []map[string]*dynamodb.AttributeValue{
+			{"station_id": {S: aws.String("st-2")}, "name": {S: aws.String("Harbour")}},
+			{"station_id": {S: aws.String("st-1")}, "name": {S: aws.String("Weir")}},
+			{"station_id": {S: aws.String("st-2")}, "name": {S: aws.String("Harbour (old)")}},
+		}
+		result := plugin.CollectVariableValues(items, plugin.VariableQueryModel{
+			Type: plugin.VariablePairs, TextAttribute: "name", ValueAttribute: "station_id",
+		})
+		assertEqual(t, result.Values, []plugin.VariableValue{
+			{Text: "Harbour", Value: "st-2"}, {Text: "Weir", Value: "st-1"},
+		})
+	})
+
+	t.Run("resource validates the definition", func(t *testing.T) {
+		ds := plugin.CreateTestDatasource(context.Background())
+		status, body := callResource(t, ds, `{}`, http.MethodPost, "variables", map[string]interface{}{"type": "distinct", "table": "readings"})
+		assertEqual(t, status, http.StatusBadRequest)
+		assertEqual(t, strings.Contains(string(body), "require an attribute"), true)
+
+		status, _ = callResource(t, ds, `{}`, http.MethodGet, "variables", nil)
+		assertEqual(t, status, http.StatusMethodNotAllowed)
+	})
+}
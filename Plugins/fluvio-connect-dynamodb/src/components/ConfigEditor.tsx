@@ -143,6 +143,7 @@ export function ConfigEditor(props: Props) {
         <PresetManager
           presets={props.options.jsonData.uploadPresets || []}
           onChange={handlePresetsChange}
+          datasourceUid={props.options.uid}
         />
       ) : (
         <>
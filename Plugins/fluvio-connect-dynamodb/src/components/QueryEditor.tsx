@@ -2,7 +2,7 @@ import React, { useRef, useState, useEffect } from "react";
 import { Button, CodeEditor, Field, IconButton, InlineField, InlineFieldRow, Input, Select, HorizontalGroup, Switch } from "@grafana/ui";
 import { QueryEditorProps, SelectableValue } from "@grafana/data";
 import { DataSource } from "../datasource";
-import { DynamoDBDataSourceOptions, DynamoDBQuery, DatetimeFormat } from "../types";
+import { AttributeSchema, DynamoDBDataSourceOptions, DynamoDBQuery, DatetimeFormat, TableSchema } from "../types";
 import * as monacoType from "monaco-editor/esm/vs/editor/editor.api";
 import "./QueryEditor.css";
 import { Divider } from "@grafana/aws-sdk";
@@ -165,15 +165,17 @@ export function QueryEditor({ query, onChange, onRunQuery, datasource }: Props)
   const fetchTableAttributes = async (tableName: string) => {
     setLoadingAttributes(true);
     try {
-      const response = await getBackendSrv().fetch<{ attributes: string[] }>({
-        url: `/api/datasources/${datasource.id}/resources/table-attributes?table=${encodeURIComponent(tableName)}`,
+      const response = await getBackendSrv().fetch<TableSchema>({
+        url: `/api/datasources/${datasource.id}/resources/schema?table=${encodeURIComponent(tableName)}`,
         method: 'GET',
       }).toPromise();
-      
+
       if (response?.data?.attributes) {
-        const attributeOptions = response.data.attributes.map((attr: string) => ({
-          label: attr,
-          value: attr,
+        // The schema catalog lists keys first and knows the types seen in sampled items
+        const attributeOptions = response.data.attributes.map((attr: AttributeSchema) => ({
+          label: attr.name,
+          value: attr.name,
+          description: attr.key ? `${attr.type} · ${attr.key} key` : attr.type,
         }));
         setTableAttributes(attributeOptions);
       }
@@ -205,6 +207,9 @@ export function QueryEditor({ query, onChange, onRunQuery, datasource }: Props)
     onChange({ ...query, sortKey: value.value || undefined });
   };
 
+  const regionOptions = (datasource.targets.regions || []).map((r) => ({ label: r, value: r }));
+  const roleOptions = (datasource.targets.assumeRoleArns || []).map((arn) => ({ label: arn, value: arn }));
+
   return (
     <>
       <InlineFieldRow>
@@ -219,6 +224,32 @@ export function QueryEditor({ query, onChange, onRunQuery, datasource }: Props)
           />
         </InlineField>
       </InlineFieldRow>
+      {(regionOptions.length > 0 || roleOptions.length > 0) && (
+        <InlineFieldRow>
+          <InlineField label="Region" tooltip="(Optional) Read from another allowed region; results are labelled with it" labelWidth={11}>
+            <Select
+              options={regionOptions}
+              value={query.region}
+              placeholder="Datasource region"
+              onChange={(v) => onChange({ ...query, region: v?.value || undefined })}
+              allowCustomValue
+              isClearable
+              width={25}
+            />
+          </InlineField>
+          <InlineField label="Role" tooltip="(Optional) Assume another allowed IAM role" labelWidth={11}>
+            <Select
+              options={roleOptions}
+              value={query.assumeRoleArn}
+              placeholder="Datasource role"
+              onChange={(v) => onChange({ ...query, assumeRoleArn: v?.value || undefined })}
+              allowCustomValue
+              isClearable
+              width={50}
+            />
+          </InlineField>
+        </InlineFieldRow>
+      )}
       <InlineFieldRow>
         <InlineField label="Limit" tooltip="(Optional) The maximum number of items to evaluate" labelWidth={11}>
           <Input type="number" min={0} value={query.limit} onChange={onLimitChange} aria-label="Limit" width={15} />
@@ -13,7 +13,7 @@ import {
   CodeEditor,
 } from '@grafana/ui';
 import { css } from '@emotion/css';
-import { UploadPreset, UploadOperation } from '../../types';
+import { UploadPreset, UploadOperation, UpsertDuplicatePolicy } from '../../types';
 import { SchemaBuilder } from './SchemaBuilder';
 
 interface PresetEditorProps {
@@ -22,9 +22,17 @@ interface PresetEditorProps {
   existingIds: string[];
   onSave: (preset: UploadPreset) => void;
   onCancel: () => void;
+  datasourceUid?: string;
 }
 
-export const PresetEditor: React.FC<PresetEditorProps> = ({ preset, isNew, existingIds, onSave, onCancel }) => {
+export const PresetEditor: React.FC<PresetEditorProps> = ({
+  preset,
+  isNew,
+  existingIds,
+  onSave,
+  onCancel,
+  datasourceUid,
+}) => {
   const [editedPreset, setEditedPreset] = useState<UploadPreset>(preset);
   const [errors, setErrors] = useState<Record<string, string>>({});
   const [showAdvanced, setShowAdvanced] = useState(false);
@@ -51,6 +59,13 @@ export const PresetEditor: React.FC<PresetEditorProps> = ({ preset, isNew, exist
     if (['update', 'delete', 'select'].includes(editedPreset.operation) && !editedPreset.partiqlTemplate?.trim()) {
       newErrors.partiqlTemplate = `PartiQL template is required for ${editedPreset.operation} operations`;
     }
+    if (
+      editedPreset.operation === 'upsert' &&
+      editedPreset.upsert?.duplicatePolicy === 'keep_newest' &&
+      !editedPreset.upsert.timestampAttribute?.trim()
+    ) {
+      newErrors.timestampAttribute = 'Timestamp attribute is required to keep the newest item';
+    }
 
     setErrors(newErrors);
     return Object.keys(newErrors).length === 0;
@@ -67,6 +82,18 @@ export const PresetEditor: React.FC<PresetEditorProps> = ({ preset, isNew, exist
     { label: 'Update', value: 'update' as UploadOperation, description: 'Modify existing items' },
     { label: 'Delete', value: 'delete' as UploadOperation, description: 'Remove items from the table' },
     { label: 'Select', value: 'select' as UploadOperation, description: 'Query and retrieve items' },
+    { label: 'Upsert', value: 'upsert' as UploadOperation, description: 'Insert new items and update existing ones' },
+  ];
+
+  const duplicatePolicyOptions = [
+    { label: 'Skip identical', value: 'skip_identical' as UpsertDuplicatePolicy, description: 'Write new and changed items only' },
+    { label: 'Overwrite', value: 'overwrite' as UpsertDuplicatePolicy, description: 'Write every item, identical ones included' },
+    { label: 'Fail', value: 'fail' as UpsertDuplicatePolicy, description: 'Refuse the upload when an existing item would change' },
+    {
+      label: 'Keep newest',
+      value: 'keep_newest' as UpsertDuplicatePolicy,
+      description: 'Update existing items only when the uploaded timestamp is newer',
+    },
   ];
 
   return (
@@ -169,6 +196,39 @@ export const PresetEditor: React.FC<PresetEditorProps> = ({ preset, isNew, exist
               />
             </Field>
           )}
+
+          {editedPreset.operation === 'upsert' && (
+            <>
+              <Field label="Duplicate Policy" description="What to do with items whose key already exists">
+                <Select
+                  options={duplicatePolicyOptions}
+                  value={editedPreset.upsert?.duplicatePolicy || 'skip_identical'}
+                  onChange={(option) =>
+                    setEditedPreset({ ...editedPreset, upsert: { ...editedPreset.upsert, duplicatePolicy: option.value! } })
+                  }
+                />
+              </Field>
+              {editedPreset.upsert?.duplicatePolicy === 'keep_newest' && (
+                <Field
+                  label="Timestamp Attribute"
+                  description="Attribute compared to decide which item is newer"
+                  error={errors.timestampAttribute}
+                  invalid={!!errors.timestampAttribute}
+                >
+                  <Input
+                    value={editedPreset.upsert?.timestampAttribute || ''}
+                    onChange={(e) =>
+                      setEditedPreset({
+                        ...editedPreset,
+                        upsert: { ...editedPreset.upsert, timestampAttribute: e.currentTarget.value },
+                      })
+                    }
+                    placeholder="e.g., updatedAt"
+                  />
+                </Field>
+              )}
+            </>
+          )}
         </Card>
 
         {/* Schema Definition */}
@@ -183,6 +243,8 @@ export const PresetEditor: React.FC<PresetEditorProps> = ({ preset, isNew, exist
           <SchemaBuilder
             schema={editedPreset.schema || []}
             onChange={(schema) => setEditedPreset({ ...editedPreset, schema })}
+            datasourceUid={datasourceUid}
+            table={editedPreset.table}
           />
         </Card>
 
@@ -7,9 +7,10 @@ import { PresetEditor } from './PresetEditor';
 interface PresetManagerProps {
   presets: UploadPreset[];
   onChange: (presets: UploadPreset[]) => void;
+  datasourceUid?: string; // enables field suggestions from the schema catalog
 }
 
-export const PresetManager: React.FC<PresetManagerProps> = ({ presets, onChange }) => {
+export const PresetManager: React.FC<PresetManagerProps> = ({ presets, onChange, datasourceUid }) => {
   const [editingPreset, setEditingPreset] = useState<UploadPreset | null>(null);
   const [isCreating, setIsCreating] = useState(false);
   const [deleteConfirm, setDeleteConfirm] = useState<string | null>(null);
@@ -99,6 +100,7 @@ export const PresetManager: React.FC<PresetManagerProps> = ({ presets, onChange
         preset={editingPreset}
         isNew={isCreating}
         existingIds={presets.map((p) => p.id)}
+        datasourceUid={datasourceUid}
         onSave={handleSave}
         onCancel={() => {
           setEditingPreset(null);
@@ -270,6 +272,8 @@ const badgeStyles = (operation: string) => css`
     ? '#1890ff'
     : operation === 'delete'
     ? '#f5222d'
+    : operation === 'upsert'
+    ? '#13c2c2'
     : '#fa8c16'};
   color: white;
 `;
@@ -1,15 +1,77 @@
 import React, { useState } from 'react';
-import { Button, Field, Input, Select, Switch, Icon, HorizontalGroup, IconButton } from '@grafana/ui';
+import { Alert, Button, Field, Input, Select, Switch, Icon, HorizontalGroup, IconButton } from '@grafana/ui';
+import { getBackendSrv } from '@grafana/runtime';
 import { css } from '@emotion/css';
-import { UploadField } from '../../types';
+import { AttributeSchema, TableSchema, UploadField } from '../../types';
 
 interface SchemaBuilderProps {
   schema: UploadField[];
   onChange: (schema: UploadField[]) => void;
+  // Datasource and table whose cataloged schema can be imported as fields
+  datasourceUid?: string;
+  table?: string;
 }
 
-export const SchemaBuilder: React.FC<SchemaBuilderProps> = ({ schema, onChange }) => {
+// catalogFieldType maps a cataloged DynamoDB type to the preset field type that coerces to it.
+const catalogFieldType = (dynamoType: string): string => {
+  switch (dynamoType) {
+    case 'S':
+      return 'string';
+    case 'N':
+      return 'number';
+    case 'BOOL':
+      return 'boolean';
+    case 'M':
+    case 'L':
+      return 'json';
+    default:
+      return 'auto';
+  }
+};
+
+const catalogFieldDescription = (attribute: AttributeSchema): string => {
+  const seen = Math.round((1 - attribute.nullRatio) * 100);
+  const examples = attribute.examples?.length ? `, e.g. ${attribute.examples.join(', ')}` : '';
+  return `Set in ${seen}% of sampled items${examples}`;
+};
+
+export const SchemaBuilder: React.FC<SchemaBuilderProps> = ({ schema, onChange, datasourceUid, table }) => {
   const [expandedFields, setExpandedFields] = useState<Set<number>>(new Set());
+  const [importing, setImporting] = useState(false);
+  const [importError, setImportError] = useState<string | null>(null);
+
+  const handleImportFromCatalog = async () => {
+    if (!datasourceUid || !table) {
+      return;
+    }
+    setImporting(true);
+    setImportError(null);
+    try {
+      const response = await getBackendSrv()
+        .fetch<TableSchema>({
+          url: `/api/datasources/uid/${datasourceUid}/resources/schema?table=${encodeURIComponent(table)}`,
+          method: 'GET',
+        })
+        .toPromise();
+      const existing = new Set(schema.map((field) => field.name));
+      const imported = (response?.data?.attributes || [])
+        .filter((attribute) => !existing.has(attribute.name) && attribute.type !== 'NULL')
+        .map(
+          (attribute): UploadField => ({
+            name: attribute.name,
+            type: catalogFieldType(attribute.type),
+            dynamoType: attribute.type,
+            required: attribute.key === 'partition' || attribute.key === 'sort',
+            description: catalogFieldDescription(attribute),
+          })
+        );
+      onChange([...schema, ...imported]);
+    } catch (error: any) {
+      setImportError(error?.data?.error || error?.message || 'Failed to load the table schema');
+    } finally {
+      setImporting(false);
+    }
+  };
 
   const typeOptions = [
     { label: 'String', value: 'string', description: 'Text value' },
@@ -224,9 +286,28 @@ export const SchemaBuilder: React.FC<SchemaBuilderProps> = ({ schema, onChange }
         </div>
       )}
 
-      <Button icon="plus" onClick={handleAddField}>
-        Add Field
-      </Button>
+      {importError && (
+        <Alert severity="error" title="Schema catalog">
+          {importError}
+        </Alert>
+      )}
+
+      <HorizontalGroup>
+        <Button icon="plus" onClick={handleAddField}>
+          Add Field
+        </Button>
+        {datasourceUid && (
+          <Button
+            icon={importing ? 'fa fa-spinner' : 'import'}
+            variant="secondary"
+            onClick={handleImportFromCatalog}
+            disabled={!table || importing}
+            tooltip={table ? `Add the attributes sampled from ${table}` : 'Set the table first'}
+          >
+            Import from Schema Catalog
+          </Button>
+        )}
+      </HorizontalGroup>
     </div>
   );
 };
@@ -11,13 +11,18 @@ import {
 } from "@grafana/data";
 import { DataSourceWithBackend, getTemplateSrv } from "@grafana/runtime";
 import { Observable, lastValueFrom } from "rxjs";
-import { DynamoDBQuery, DynamoDBDataSourceOptions, DEFAULT_QUERY, DatetimeFormat, DynamoDBVariableQuery } from "./types";
-import { formatRefTime } from "./utils";
+import { AttributeMap, DynamoDBQuery, DynamoDBDataSourceOptions, DEFAULT_QUERY, DynamoDBVariableQuery, ItemEdit, ItemResponse, NativeCondition, TargetSettings } from "./types";
 
 export class DataSource extends DataSourceWithBackend<DynamoDBQuery, DynamoDBDataSourceOptions> {
+  // Regions and roles queries may switch to
+  targets: TargetSettings;
+
   constructor(instanceSettings: DataSourceInstanceSettings<DynamoDBDataSourceOptions>) {
     super(instanceSettings);
+    this.targets = instanceSettings.jsonData.targets ?? {};
     this.variables = new DynamoDBVariableSupport(this);
+    // Annotation queries use the regular query editor; the backend maps attributes to annotation fields
+    this.annotations = {};
     console.log('DynamoDB datasource variables support initialized:', this.variables?.getType?.());
   }
 
@@ -26,82 +31,39 @@ export class DataSource extends DataSourceWithBackend<DynamoDBQuery, DynamoDBDat
   }
 
   applyTemplateVariables(query: DynamoDBQuery, scopedVars: ScopedVars) {
+    const templateSrv = getTemplateSrv();
+    const interpolate = (condition?: NativeCondition) =>
+      condition && { ...condition, values: (condition.values || []).map((v) => templateSrv.replace(v, scopedVars)) };
     return {
       ...query,
-      queryText: getTemplateSrv().replace(query.queryText, scopedVars),
+      queryText: templateSrv.replace(query.queryText, scopedVars),
+      region: query.region && templateSrv.replace(query.region, scopedVars),
+      assumeRoleArn: query.assumeRoleArn && templateSrv.replace(query.assumeRoleArn, scopedVars),
+      native: query.native && {
+        ...query.native,
+        partitionKey: interpolate(query.native.partitionKey),
+        sortKey: interpolate(query.native.sortKey),
+        filters: query.native.filters?.map((f) => interpolate(f)!),
+      },
     };
   }
 
-  filterQuery(query: DynamoDBQuery): boolean {
-    // if no query has been provided, prevent the query from being executed
-    return !!query.queryText;
+  // getItem reads one item of a table enabled for item editing, with its version.
+  getItem(table: string, key: AttributeMap): Promise<ItemResponse> {
+    return this.getResource('items', { table, key: JSON.stringify(key) });
   }
 
-  query(request: DataQueryRequest<DynamoDBQuery>): Observable<DataQueryResponse> {
-    // Validate time range
-    if (!request.range || !request.range.from || !request.range.to) {
-      console.error('Invalid time range in query request');
-      throw new Error('Invalid time range');
-    }
-
-    let fromTime = Math.floor(request.range.from.toDate().getTime() / 1000);
-    let toTime = Math.floor(request.range.to.toDate().getTime() / 1000);
-    
-    // Validate time range values
-    if (isNaN(fromTime) || isNaN(toTime) || fromTime < 0 || toTime < 0) {
-      console.error('Invalid time range values:', { fromTime, toTime });
-      throw new Error('Invalid time range values');
-    }
+  // editItem applies a guarded edit. A stale guard rejects with status 409 and an ItemResponse
+  // holding the current item and the conflicting attributes.
+  editItem(edit: ItemEdit): Promise<ItemResponse> {
+    return this.postResource('items', edit);
+  }
 
-    // Ensure fromTime is always before toTime
-    if (fromTime > toTime) {
-      console.warn('From time is after to time, swapping values');
-      [fromTime, toTime] = [toTime, fromTime];
-    }
-    
-    console.log('Time range debug:', { fromTime, toTime, from: request.range.from.toDate(), to: request.range.to.toDate() });
-    
-    const queries = request.targets.map((query) => {
-      let processedQuery = query.queryText || "";
-      
-      // Skip empty queries (already handled by filterQuery, but double-check)
-      if (!processedQuery.trim()) {
-        console.warn('Skipping empty query');
-        return query;
-      }
-      
-      // Replace Grafana time variables with actual values
-      processedQuery = processedQuery
-        .replace(/\$from/g, fromTime.toString())
-        .replace(/\$to/g, toTime.toString())
-        // Handle $__timeFilter function - convert to proper DynamoDB PartiQL syntax
-        .replace(/\$__timeFilter\(\s*['"`]?(\w+)['"`]?\s*\)/gi, (_m, attr) => `"${attr}" BETWEEN ${fromTime} AND ${toTime}`);
-      
-      console.log('Query transformation:', { original: query.queryText, processed: processedQuery });
-      
-      return {
-        ...query,
-        queryText: processedQuery,
-        datetimeAttributes: (query.datetimeAttributes || []).map(field => {
-          // Validate field has required properties
-          if (!field || !field.name) {
-            console.warn('Invalid datetime attribute field, skipping:', field);
-            return field;
-          }
-          
-          if (field.format !== DatetimeFormat.UnixTimestampSeconds && field.format !== DatetimeFormat.UnixTimestampMiniseconds) {
-            try {
-              return { ...field, format: formatRefTime(field.format) };
-            } catch (error) {
-              console.error('Error formatting datetime attribute:', error);
-              return field;
-            }
-          }
-          return field;
-        })
-      };
-    });
-    return super.query({ ...request, targets: queries });
+  // Time macros ($__timeFilter, $from, $to, ...) and custom datetime formats are expanded by the
+  // backend, so panels, alerts and API calls send the same statement.
+  filterQuery(query: DynamoDBQuery): boolean {
+    // if no query has been provided, prevent the query from being executed
+    return !!query.queryText || !!query.native?.table;
   }
 
   /**
@@ -117,7 +79,8 @@ export class DataSource extends DataSourceWithBackend<DynamoDBQuery, DynamoDBDat
       queryString = query.query || '';
     }
 
-    if (!queryString) {
+    const variable = typeof query === 'string' ? undefined : query.variable;
+    if (!queryString && !variable) {
       return Promise.resolve([]);
     }
 
@@ -130,7 +93,7 @@ export class DataSource extends DataSourceWithBackend<DynamoDBQuery, DynamoDBDat
       // Create a DynamoDB query for the variable
       const dynamoQuery: DynamoDBQuery =
         this.variables instanceof DynamoDBVariableSupport
-          ? this.variables.toDataQuery({ refId: 'variable-query', query: interpolatedQuery })
+          ? this.variables.toDataQuery({ refId: 'variable-query', query: interpolatedQuery, variable } as StandardVariableQuery, options?.scopedVars)
           : {
               queryText: interpolatedQuery,
               datetimeAttributes: [],
@@ -164,7 +127,14 @@ export class DataSource extends DataSourceWithBackend<DynamoDBQuery, DynamoDBDat
       const frame = response.data[0];
       const results: MetricFindValue[] = [];
 
-      if (frame.fields && frame.fields.length > 0) {
+      // Backend variable queries return text/value fields
+      const textField = frame.fields?.find((f: any) => f.name === 'text');
+      const valueField = frame.fields?.find((f: any) => f.name === 'value');
+      if (variable && textField && valueField) {
+        for (let i = 0; i < valueField.values.length; i++) {
+          results.push({ text: String(textField.values.get(i)), value: String(valueField.values.get(i)) });
+        }
+      } else if (frame.fields && frame.fields.length > 0) {
         const field = frame.fields[0]; // Use the first field for variable values
         
         if (field.values) {
@@ -200,13 +170,23 @@ class DynamoDBVariableSupport extends StandardVariableSupport<DataSource, Dynamo
     };
   }
 
-  toDataQuery(query: StandardVariableQuery): DynamoDBQuery {
+  toDataQuery(query: StandardVariableQuery, scopedVars?: ScopedVars): DynamoDBQuery {
+    const variable = (query as StandardVariableQuery & DynamoDBVariableQuery).variable;
     return {
       refId: query.refId ?? "variable-query",
       datasource: this.datasource.getRef(),
-      queryText: query.query ?? "",
+      // The backend ignores the text of variable queries, but empty queries are filtered out
+      queryText: query.query || (variable ? `variable:${variable.type}` : ""),
       datetimeAttributes: [],
       customFilters: [],
+      variable: variable && {
+        ...variable,
+        // Chained variables: expand parent selections, multi-value selections become several values
+        filters: variable.filters?.map((f) => ({
+          ...f,
+          values: f.values.flatMap((v) => getTemplateSrv().replace(v, scopedVars, 'csv').split(',')).filter((v) => v !== ''),
+        })),
+      },
     };
   }
 
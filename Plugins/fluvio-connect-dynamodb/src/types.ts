@@ -10,6 +10,65 @@ export interface DynamoDBQuery extends DataQuery {
   sortDirection?: 'asc' | 'desc';  // Sort direction (client-side)
   sortKey?: string;          // Sort key attribute for DynamoDB native sorting
   scanIndexForward?: boolean;      // DynamoDB native sort order (Query API only)
+  allowFullScan?: boolean;         // Run scans larger than scanGuard.maxScanSizeMB
+  stream?: boolean;                // Follow the table's DynamoDB Stream over Grafana Live
+  cacheTTLSeconds?: number;        // Overrides the datasource cache TTL; negative bypasses the cache
+  limits?: QueryLimits;            // Tighter pagination limits; capped by the datasource's queryLimits
+  timeSeries?: TimeSeriesOptions;  // Labelled time-series output instead of a table
+  flatten?: FlattenOptions;        // Expand nested maps/lists into dotted-path columns
+  projections?: Projection[];      // JSONPath-style columns picked from nested attributes
+  annotation?: AnnotationOptions;  // Attribute mapping for annotation queries
+  variable?: VariableQueryOptions; // Dashboard variable query
+  native?: NativeQueryOptions;     // Structured Query/Scan instead of queryText
+  region?: string;                 // Region override, limited to the datasource's targets
+  assumeRoleArn?: string;          // Assume-role ARN override, limited to the datasource's targets
+}
+
+export interface NativeQueryOptions {
+  table: string;
+  index?: string;
+  partitionKey?: NativeCondition;  // Equality on the partition key; without it the table is scanned
+  sortKey?: NativeCondition;       // =, <, <=, >, >=, between or begins_with
+  filters?: NativeCondition[];
+  projection?: string[];
+  consistentRead?: boolean;
+  exclusiveStartKey?: AttributeMap; // lastEvaluatedKey of a previous result
+}
+
+/** An item or key in DynamoDB JSON, e.g. { PK: { S: 'STATION#1' }, ts: { N: '15' } } */
+export type AttributeMap = Record<string, Record<string, unknown>>;
+
+export interface NativeCondition {
+  attribute: string;
+  operator?: '=' | '<>' | '<' | '<=' | '>' | '>=' | 'between' | 'begins_with' | 'contains' | 'in' | 'exists' | 'not_exists';
+  values?: string[];
+  type?: 'S' | 'N' | 'BOOL';
+}
+
+export interface AnnotationOptions {
+  timeAttribute: string;
+  timeEndAttribute?: string;
+  titleAttribute?: string;
+  textAttribute?: string;
+  tagAttributes?: string[];
+  tags?: string[];
+}
+
+export interface FlattenOptions {
+  maxDepth?: number;
+  arrays?: boolean;
+}
+
+export interface Projection {
+  path: string;
+  alias?: string;
+}
+
+export interface TimeSeriesOptions {
+  timeAttribute: string;
+  valueAttributes: string[];
+  labelAttributes?: string[];
+  format?: 'multi' | 'wide';
 }
 
 export interface CustomFilter {
@@ -28,6 +87,126 @@ export interface DynamoDBDataSourceOptions extends AwsAuthDataSourceJsonData {
   connectionTestTable?: string;
   uploadPresets?: UploadPreset[];
   maxUploadPayloadKB?: number;
+  maxJobPayloadKB?: number;
+  teams?: Record<string, string[]>;
+  presetStore?: PresetStoreSettings;
+  scanGuard?: ScanGuardSettings;
+  queryLimits?: QueryLimits;
+  readBudget?: ReadBudgetSettings;
+  parallelism?: ParallelismSettings;
+  cache?: CacheSettings;
+  schemaCatalog?: SchemaCatalogSettings;
+  itemEditing?: ItemEditSettings;
+  targets?: TargetSettings;
+}
+
+export interface TargetSettings {
+  regions?: string[];         // Regions queries may switch to besides the datasource region
+  assumeRoleArns?: string[];  // Roles queries may assume besides the datasource role
+}
+
+export interface QueryLimits {
+  maxPages?: number;
+  maxItems?: number;
+  maxDurationSeconds?: number;
+}
+
+export interface ReadBudgetSettings {
+  capacityUnits?: number;
+  windowMinutes?: number;
+}
+
+export interface CacheSettings {
+  enabled?: boolean;
+  ttlSeconds?: number;         // Defaults to 60
+  historicAfterHours?: number; // Ranges ending longer ago stay cached until evicted
+  maxEntries?: number;         // Defaults to 500
+  maxSizeMB?: number;          // Defaults to 64
+}
+
+export interface SchemaCatalogSettings {
+  disabled?: boolean;
+  refreshMinutes?: number; // Defaults to 60
+  sampleSize?: number;     // Items read per sample; defaults to 200
+  tables?: string[];       // Sampled on start even before they are requested
+}
+
+// Schema of a table inferred by the schema catalog from a sample of its items
+export interface TableSchema {
+  table: string;
+  partitionKey?: string;
+  sortKey?: string;
+  indexes?: string[];
+  itemCount: number;
+  sampledItems: number;
+  sampledAt: string;
+  attributes: AttributeSchema[];
+}
+
+export interface AttributeSchema {
+  name: string;
+  type: string;                  // Most frequent DynamoDB type
+  types: Record<string, number>; // Sampled items per observed type
+  key?: 'partition' | 'sort' | 'index';
+  nullRatio: number;             // Share of sampled items without a value
+  min?: string;
+  max?: string;
+  examples?: string[];
+}
+
+export interface ItemEditSettings {
+  tables?: ItemEditTable[];
+}
+
+export interface ItemEditTable {
+  table: string;
+  versionAttribute?: string; // Numeric attribute incremented by every edit
+  access?: UploadPresetAccess; // Defaults to the Editor role
+}
+
+// Guarded single-item edit sent to the items resource. Values are in DynamoDB JSON.
+export interface ItemEdit {
+  table: string;
+  operation: 'put' | 'update' | 'delete';
+  key?: AttributeMap;
+  item?: AttributeMap;
+  set?: AttributeMap;
+  remove?: string[];
+  expectedVersion?: number;  // Tables with a version attribute; 0 expects a new item
+  expected?: Record<string, Record<string, unknown> | null>; // Other tables: attributes as read, null when missing; {} expects a new item
+  dryRun?: boolean;
+}
+
+export interface ItemChange {
+  attribute: string;
+  before: Record<string, unknown> | null; // DynamoDB JSON; null when the attribute is missing
+  after: Record<string, unknown> | null;
+}
+
+export interface ItemResponse {
+  item: AttributeMap | null;
+  version?: number;
+  changes?: ItemChange[];
+  conflicts?: ItemChange[]; // Set with status 409
+  dryRun?: boolean;
+  auditId?: string;
+  error?: string;
+}
+
+export interface ParallelismSettings {
+  maxConcurrentQueries?: number; // Queries of one request run at once; defaults to 4
+  scanSegments?: number;         // Segments of full-table scans; defaults to 1, parallel scans need more than 1
+}
+
+export interface ScanGuardSettings {
+  disabled?: boolean;
+  autoSelectIndex?: boolean;
+  maxScanSizeMB?: number;
+}
+
+export interface PresetStoreSettings {
+  type?: 'file' | 'dynamodb';
+  table?: string;
 }
 
 export interface DynamoDBDataSourceSecureJsonData extends AwsAuthDataSourceSecureJsonData { }
@@ -44,9 +223,27 @@ export interface DatetimeAttribute {
 
 export interface DynamoDBVariableQuery {
   query: string;
+  variable?: VariableQueryOptions; // Backend variable query; used instead of query when set
+}
+
+export interface VariableQueryOptions {
+  type: 'tables' | 'distinct' | 'partitionKeys' | 'pairs';
+  table?: string;
+  index?: string;
+  attribute?: string;
+  textAttribute?: string;
+  valueAttribute?: string;
+  filters?: VariableFilter[];  // Values may reference other variables, e.g. "$region"
+  limit?: number;
+}
+
+export interface VariableFilter {
+  attribute: string;
+  values: string[];
+  type?: 'S' | 'N';
 }
 
-export type UploadOperation = 'insert' | 'update' | 'delete' | 'select';
+export type UploadOperation = 'insert' | 'update' | 'delete' | 'select' | 'upsert';
 
 export interface FieldValidation {
   pattern?: string;
@@ -58,7 +255,18 @@ export interface FieldValidation {
 }
 
 export interface FieldTransformation {
-  type: 'uppercase' | 'lowercase' | 'trim' | 'date_format' | 'regex_replace' | 'custom';
+  type:
+    | 'uppercase'
+    | 'lowercase'
+    | 'trim'
+    | 'unit_conversion'
+    | 'date_format'
+    | 'to_number'
+    | 'lookup'
+    | 'regex_replace'
+    | 'composite_key'
+    // Legacy presets only; the backend uploads the field unchanged.
+    | 'custom';
   params?: Record<string, string>;
 }
 
@@ -88,4 +296,43 @@ export interface UploadPreset {
   responsePreview?: boolean;
   helpText?: string;
   category?: string;
+  access?: UploadPresetAccess;
+  rules?: UploadRule[];
+  upsert?: UpsertSettings;
+}
+
+export type UpsertDuplicatePolicy = 'skip_identical' | 'overwrite' | 'fail' | 'keep_newest';
+
+export interface UpsertSettings {
+  duplicatePolicy?: UpsertDuplicatePolicy;
+  timestampAttribute?: string;
+}
+
+export interface UpsertRow {
+  item: number;
+  row?: number;
+  status: 'new' | 'changed' | 'identical' | 'conflicting';
+  write: boolean;
+  changes?: ItemChange[];
+  reason?: string;
+}
+
+export interface UploadRule {
+  type: 'compare' | 'unique' | 'required_if' | 'reference';
+  code?: string;
+  message?: string;
+  field?: string;
+  operator?: '>' | '>=' | '<' | '<=' | '==' | '!=';
+  otherField?: string;
+  value?: string | number | boolean;
+  when?: string;
+  fields?: string[];
+  table?: string;
+  keys?: Record<string, string>;
+}
+
+export interface UploadPresetAccess {
+  roles?: string[];
+  teams?: string[];
+  users?: string[];
 }